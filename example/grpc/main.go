@@ -0,0 +1,159 @@
+// nolint:all // Example code: focus on clarity over style
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/othonhugo/goratchet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	// Step 1: Start the relay server. It only stores and forwards envelopes.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}))
+
+	server.RegisterService(&relayServiceDesc, &mailboxServer{
+		mailboxes: make(map[string][]*Envelope),
+		observed: func(env *Envelope) {
+			log.Printf("Relay sees %s -> %s: %X", env.From, env.To, env.Payload)
+		},
+	})
+
+	go server.Serve(listener)
+	defer server.Stop()
+
+	// Step 2: Establish Double Ratchet sessions between Alice and Bob.
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	aliceSession, err := goratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bobSession, err := goratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Step 3: Connect each client with an interceptor that encrypts outgoing payloads
+	// and decrypts fetched ones, so application code only handles plaintext.
+	alice := dial(listener.Addr().String(), aliceSession)
+	bob := dial(listener.Addr().String(), bobSession)
+
+	ctx := context.Background()
+
+	if _, err := alice.Send(ctx, &Envelope{From: "alice", To: "bob", Payload: []byte("Hello Bob, via the relay!")}); err != nil {
+		log.Fatalf("Send failed: %v", err)
+	}
+
+	inbox, err := bob.Fetch(ctx, &FetchRequest{Recipient: "bob"})
+
+	if err != nil {
+		log.Fatalf("Fetch failed: %v", err)
+	}
+
+	for _, env := range inbox.Envelopes {
+		fmt.Printf("Bob received from %s: %s\n", env.From, env.Payload)
+	}
+
+	if _, err := bob.Send(ctx, &Envelope{From: "bob", To: "alice", Payload: []byte("Hi Alice, the server can't read this.")}); err != nil {
+		log.Fatalf("Send failed: %v", err)
+	}
+
+	inbox, err = alice.Fetch(ctx, &FetchRequest{Recipient: "alice"})
+
+	if err != nil {
+		log.Fatalf("Fetch failed: %v", err)
+	}
+
+	for _, env := range inbox.Envelopes {
+		fmt.Printf("Alice received from %s: %s\n", env.From, env.Payload)
+	}
+}
+
+func dial(addr string, session goratchet.DoubleRatchet) RelayClient {
+	cc, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+		grpc.WithUnaryInterceptor(ratchetInterceptor(session)),
+	)
+
+	if err != nil {
+		log.Fatalf("Failed to dial relay: %v", err)
+	}
+
+	return RelayClient{cc: cc}
+}
+
+// ratchetInterceptor end-to-end encrypts Envelope payloads on Send and decrypts them
+// on Fetch. The routing fields are bound as associated data, so the relay cannot
+// redirect an envelope to another conversation without detection.
+func ratchetInterceptor(session goratchet.DoubleRatchet) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		switch method {
+		case "/relay.Relay/Send":
+			env := req.(*Envelope)
+
+			ciphered, err := session.Send(env.Payload, routingAD(env))
+
+			if err != nil {
+				return fmt.Errorf("encrypt envelope: %w", err)
+			}
+
+			sealed := &Envelope{
+				From:    env.From,
+				To:      env.To,
+				Header:  &Header{DH: ciphered.Header.DH, N: ciphered.Header.N, PN: ciphered.Header.PN},
+				Payload: ciphered.Ciphertext,
+			}
+
+			return invoker(ctx, method, sealed, reply, cc, opts...)
+
+		case "/relay.Relay/Fetch":
+			if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+				return err
+			}
+
+			for _, env := range reply.(*FetchReply).Envelopes {
+				if env.Header == nil {
+					return fmt.Errorf("envelope from %s has no ratchet header", env.From)
+				}
+
+				unciphered, err := session.Receive(goratchet.CipheredMessage{
+					Header:     goratchet.Header{DH: env.Header.DH, N: env.Header.N, PN: env.Header.PN},
+					Ciphertext: env.Payload,
+				}, routingAD(env))
+
+				if err != nil {
+					return fmt.Errorf("decrypt envelope from %s: %w", env.From, err)
+				}
+
+				env.Header = nil
+				env.Payload = unciphered.Plaintext
+			}
+
+			return nil
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func routingAD(env *Envelope) []byte {
+	return []byte(env.From + "\x00" + env.To)
+}
@@ -0,0 +1,385 @@
+// nolint:all // Example code: focus on clarity over style
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/othonhugo/goratchet"
+)
+
+const (
+	headerContentType = "application/x-goratchet-header+json"
+	cipherContentType = "application/x-goratchet-ciphertext"
+)
+
+func main() {
+	dir := flag.String("dir", "mailstore", "Directory holding maildirs and session state")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-dir DIR] COMMAND\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Commands:")
+		fmt.Fprintln(os.Stderr, "  init                    create Alice and Bob's sessions and maildirs")
+		fmt.Fprintln(os.Stderr, "  send FROM TO MESSAGE    encrypt MESSAGE and deliver it to TO's maildir")
+		fmt.Fprintln(os.Stderr, "  receive USER            decrypt USER's new mail in random order")
+		fmt.Fprintln(os.Stderr, "  demo                    run a full scenario, reloading state between steps")
+	}
+	flag.Parse()
+
+	args := flag.Args()
+
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store := mailStore{dir: *dir}
+
+	switch args[0] {
+	case "init":
+		must(store.init())
+	case "send":
+		if len(args) != 4 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		must(store.send(args[1], args[2], args[3]))
+	case "receive":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		must(store.receive(args[1]))
+	case "demo":
+		must(demo(store))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// demo sends a burst of mail, lets it sit in the maildir (a long gap), replies, and then
+// drains both mailboxes in random order. Every step reloads the sessions from disk, just
+// like separate runs of the program would.
+func demo(store mailStore) error {
+	if err := store.init(); err != nil {
+		return err
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := store.send("alice", "bob", fmt.Sprintf("Letter %d from Alice", i)); err != nil {
+			return err
+		}
+	}
+
+	if err := store.send("bob", "alice", "Bob writes before reading anything"); err != nil {
+		return err
+	}
+
+	if err := store.receive("bob"); err != nil {
+		return err
+	}
+
+	if err := store.send("alice", "bob", "Alice's late follow-up"); err != nil {
+		return err
+	}
+
+	if err := store.receive("alice"); err != nil {
+		return err
+	}
+
+	return store.receive("bob")
+}
+
+// mailStore is a mock store-and-forward medium: one maildir per user plus the
+// serialized session of each user.
+type mailStore struct {
+	dir string
+}
+
+func (s mailStore) init() error {
+	alicePri, err := ecdh.P256().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	bobPri, err := ecdh.P256().GenerateKey(rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	alice, err := goratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes())
+
+	if err != nil {
+		return err
+	}
+
+	bob, err := goratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes())
+
+	if err != nil {
+		return err
+	}
+
+	for user, session := range map[string]goratchet.DoubleRatchet{"alice": alice, "bob": bob} {
+		for _, sub := range []string{"tmp", "new", "cur"} {
+			if err := os.MkdirAll(filepath.Join(s.dir, user, sub), 0o700); err != nil {
+				return err
+			}
+		}
+
+		if err := s.save(user, session); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Initialized sessions and maildirs in %s", s.dir)
+
+	return nil
+}
+
+func (s mailStore) load(user string) (goratchet.DoubleRatchet, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, user+".session"))
+
+	if err != nil {
+		return nil, fmt.Errorf("load %s's session (did you run init?): %w", user, err)
+	}
+
+	return goratchet.Deserialize(data)
+}
+
+func (s mailStore) save(user string, session goratchet.DoubleRatchet) error {
+	data, err := session.Serialize()
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(s.dir, user+".session"), data, 0o600)
+}
+
+func (s mailStore) send(from, to, text string) error {
+	session, err := s.load(from)
+
+	if err != nil {
+		return err
+	}
+
+	ciphered, err := session.Send([]byte(text), addressAD(from, to))
+
+	if err != nil {
+		return err
+	}
+
+	raw, err := encodeMIME(from, to, ciphered)
+
+	if err != nil {
+		return err
+	}
+
+	// Maildir delivery: write to tmp, then atomically rename into new.
+	unique := make([]byte, 8)
+
+	if _, err := rand.Read(unique); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.%x", from, unique)
+	tmp := filepath.Join(s.dir, to, "tmp", name)
+
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, to, "new", name)); err != nil {
+		return err
+	}
+
+	log.Printf("%s -> %s: queued %q (%d bytes of MIME)", from, to, text, len(raw))
+
+	return s.save(from, session)
+}
+
+func (s mailStore) receive(user string) error {
+	session, err := s.load(user)
+
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, user, "new"))
+
+	if err != nil {
+		return err
+	}
+
+	shuffle(entries)
+
+	for _, entry := range entries {
+		path := filepath.Join(s.dir, user, "new", entry.Name())
+
+		raw, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		from, ciphered, err := decodeMIME(raw)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		unciphered, err := session.Receive(ciphered, addressAD(from, user))
+
+		if err != nil {
+			return fmt.Errorf("%s: decrypt: %w", entry.Name(), err)
+		}
+
+		log.Printf("%s <- %s: %q", user, from, unciphered.Plaintext)
+
+		if err := os.Rename(path, filepath.Join(s.dir, user, "cur", entry.Name())); err != nil {
+			return err
+		}
+
+		// Persist after every message so a crash never replays a consumed message key.
+		if err := s.save(user, session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addressAD binds the envelope addresses to the ciphertext.
+func addressAD(from, to string) []byte {
+	return []byte("mail:" + from + ">" + to)
+}
+
+// encodeMIME builds a multipart/mixed message carrying the ratchet header as JSON and
+// the ciphertext as a base64 attachment.
+func encodeMIME(from, to string, msg goratchet.CipheredMessage) ([]byte, error) {
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	headerJSON, err := json.Marshal(msg.Header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {headerContentType}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	part.Write(headerJSON)
+
+	part, err = w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {cipherContentType},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := base64.NewEncoder(base64.StdEncoding, part)
+	encoder.Write(msg.Ciphertext)
+	encoder.Close()
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "From: %s\r\n", from)
+	fmt.Fprintf(&out, "To: %s\r\n", to)
+	fmt.Fprintf(&out, "Subject: Encrypted message\r\n")
+	fmt.Fprintf(&out, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&out, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+	out.Write(body.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// decodeMIME is the inverse of encodeMIME.
+func decodeMIME(raw []byte) (string, goratchet.CipheredMessage, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+
+	if err != nil {
+		return "", goratchet.CipheredMessage{}, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return "", goratchet.CipheredMessage{}, fmt.Errorf("not a multipart message")
+	}
+
+	var msg goratchet.CipheredMessage
+
+	r := multipart.NewReader(m.Body, params["boundary"])
+
+	for {
+		part, err := r.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return "", goratchet.CipheredMessage{}, err
+		}
+
+		switch part.Header.Get("Content-Type") {
+		case headerContentType:
+			if err := json.NewDecoder(part).Decode(&msg.Header); err != nil {
+				return "", goratchet.CipheredMessage{}, err
+			}
+		case cipherContentType:
+			if msg.Ciphertext, err = io.ReadAll(base64.NewDecoder(base64.StdEncoding, part)); err != nil {
+				return "", goratchet.CipheredMessage{}, err
+			}
+		}
+	}
+
+	return m.Header.Get("From"), msg, nil
+}
+
+// shuffle randomizes delivery order to simulate out-of-order arrival.
+func shuffle(entries []os.DirEntry) {
+	for i := len(entries) - 1; i > 0; i-- {
+		j, _ := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		entries[i], entries[j.Int64()] = entries[j.Int64()], entries[i]
+	}
+}
+
+func must(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func init() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(0)
+}
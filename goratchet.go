@@ -1,23 +1,439 @@
 // Package goratchet provides a high-level interface for the Double Ratchet algorithm.
 package goratchet
 
-import "github.com/othonhugo/goratchet/pkg/doubleratchet"
+import (
+	"crypto/ecdh"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
 
 // DoubleRatchet represents a Double Ratchet session.
 type DoubleRatchet = doubleratchet.DoubleRatchet
 
+// Sender is the sending half of a DoubleRatchet session.
+type Sender = doubleratchet.Sender
+
+// Receiver is the receiving half of a DoubleRatchet session.
+type Receiver = doubleratchet.Receiver
+
 // CipheredMessage represents an encrypted message.
 type CipheredMessage = doubleratchet.CipheredMessage
 
+// Header contains the message header information for Double Ratchet.
+type Header = doubleratchet.Header
+
 // UncipheredMessage represents a decrypted message.
 type UncipheredMessage = doubleratchet.UncipheredMessage
 
+// Usage counts the messages and plaintext bytes a session has sent and received.
+type Usage = doubleratchet.Usage
+
+// SkippedKeyStats describes the skipped message keys a session holds.
+type SkippedKeyStats = doubleratchet.SkippedKeyStats
+
+// SessionInfo holds non-secret facts about a session for debugging and display.
+type SessionInfo = doubleratchet.SessionInfo
+
+// MessageType discriminates messages that the application handles differently. It is
+// authenticated with the message.
+type MessageType = doubleratchet.MessageType
+
+// Message types.
+const (
+	MessageNormal       = doubleratchet.MessageNormal
+	MessagePreKey       = doubleratchet.MessagePreKey
+	MessageRekeyRequest = doubleratchet.MessageRekeyRequest
+)
+
+// Metadata is an authenticated timestamp and application message ID attached to a
+// message with DoubleRatchet.SendWithMetadata.
+type Metadata = doubleratchet.Metadata
+
+// Snapshot is an in-memory copy of a session's ratchet state, taken with
+// DoubleRatchet.Snapshot and put back with DoubleRatchet.Restore.
+type Snapshot = doubleratchet.Snapshot
+
+// Event is a notification about a change of a session's ratchet keys, delivered to the
+// channels returned by DoubleRatchet.Subscribe.
+type Event = doubleratchet.Event
+
+// Error categories matched with errors.Is by the errors of sessions; see
+// doubleratchet.ErrCrypto.
+var (
+	ErrCrypto   = doubleratchet.ErrCrypto
+	ErrState    = doubleratchet.ErrState
+	ErrProtocol = doubleratchet.ErrProtocol
+)
+
+// Option configures optional behavior of a Double Ratchet session.
+type Option = doubleratchet.Option
+
 // New creates a new DoubleRatchet session.
-func New(localPri, remotePub []byte) (DoubleRatchet, error) {
-	return doubleratchet.New(localPri, remotePub, nil)
+func New(localPri, remotePub []byte, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.New(localPri, remotePub, nil, opts...)
+}
+
+// NewECDH is like New, but takes crypto/ecdh keys. The session uses the curve of the keys
+// unless an option selects one.
+func NewECDH(localPri *ecdh.PrivateKey, remotePub *ecdh.PublicKey, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.NewECDH(localPri, remotePub, nil, opts...)
+}
+
+// InitAlice creates the initiator's side of a session from a shared secret, such as the
+// output of X3DH, and the responder's ratchet public key, as in the Double Ratchet
+// specification. The initiator has only a sending chain until the responder replies.
+func InitAlice(sk, remotePub []byte, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.InitAlice(sk, remotePub, opts...)
+}
+
+// InitBob creates the responder's side of a session from a shared secret and the ratchet
+// private key whose public key the initiator was given. The responder can send once it
+// has received the initiator's first message.
+func InitBob(sk, localPri []byte, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.InitBob(sk, localPri, opts...)
+}
+
+// WithTranscript enables running hashes over all sent and received messages.
+func WithTranscript() Option {
+	return doubleratchet.WithTranscript()
+}
+
+// EscrowAcknowledgement must be passed verbatim to WithEscrow.
+const EscrowAcknowledgement = doubleratchet.EscrowAcknowledgement
+
+// WithEscrow wraps every sent message key to the escrow public key pub, allowing its
+// holder to decrypt the session's messages. It breaks end-to-end encryption and is meant
+// only for regulated deployments; see doubleratchet.WithEscrow.
+func WithEscrow(pub *ecdh.PublicKey, acknowledgement string) Option {
+	return doubleratchet.WithEscrow(pub, acknowledgement)
+}
+
+// OpenEscrow decrypts an escrowed message using the escrow private key.
+func OpenEscrow(pri *ecdh.PrivateKey, msg CipheredMessage, ad []byte) ([]byte, error) {
+	return doubleratchet.OpenEscrow(pri, msg, ad)
+}
+
+// OpenEscrowAEAD is like OpenEscrow, for messages of sessions created with WithAEAD.
+func OpenEscrowAEAD(aead AEAD, pri *ecdh.PrivateKey, msg CipheredMessage, ad []byte) ([]byte, error) {
+	return doubleratchet.OpenEscrowAEAD(aead, pri, msg, ad)
+}
+
+// WithUsageKey protects the usage counters in serialized state with an HMAC under key.
+func WithUsageKey(key []byte) Option {
+	return doubleratchet.WithUsageKey(key)
+}
+
+// Interceptor hooks into Send and Receive and may contribute associated data fragments.
+type Interceptor = doubleratchet.Interceptor
+
+// ADFragment is a named piece of associated data contributed by an Interceptor.
+type ADFragment = doubleratchet.ADFragment
+
+// Store persists session state after every change; see WithStore.
+type Store = doubleratchet.Store
+
+// StoreFunc adapts a callback to a Store.
+type StoreFunc = doubleratchet.StoreFunc
+
+// WithStore saves the session state to store after every Send, Receive and ratchet step,
+// before the call returns.
+func WithStore(store Store) Option {
+	return doubleratchet.WithStore(store)
+}
+
+// SkippedKeyStore keeps skipped message keys outside the session state; see
+// WithSkippedKeyStore.
+type SkippedKeyStore = doubleratchet.SkippedKeyStore
+
+// WithSkippedKeyStore keeps the session's skipped message keys in store instead of its
+// serialized state.
+func WithSkippedKeyStore(store SkippedKeyStore) Option {
+	return doubleratchet.WithSkippedKeyStore(store)
+}
+
+// WithInterceptor adds an interceptor that runs before encryption and after decryption.
+func WithInterceptor(interceptor Interceptor) Option {
+	return doubleratchet.WithInterceptor(interceptor)
+}
+
+// SecureMemory allocates memory for session keys outside the garbage-collected heap; see
+// WithSecureMemory.
+type SecureMemory = doubleratchet.SecureMemory
+
+// WithSecureMemory keeps the session's root key and chain keys in memory allocated from
+// mem.
+func WithSecureMemory(mem SecureMemory) Option {
+	return doubleratchet.WithSecureMemory(mem)
+}
+
+// NewLockedMemory returns a SecureMemory of pages locked into RAM and, on Linux, excluded
+// from core dumps.
+func NewLockedMemory() SecureMemory {
+	return doubleratchet.NewLockedMemory()
+}
+
+// WithIdentityAD binds both parties' identity public keys into the associated data of
+// every message.
+func WithIdentityAD(local, remote []byte) Option {
+	return doubleratchet.WithIdentityAD(local, remote)
+}
+
+// WithReplayWindow makes the session remember the last n messages it decrypted in its
+// serialized state and refuse them as duplicates.
+func WithReplayWindow(n int) Option {
+	return doubleratchet.WithReplayWindow(n)
+}
+
+// WithEraseInput makes Deserialize overwrite its input with zeros after a successful load.
+func WithEraseInput() Option {
+	return doubleratchet.WithEraseInput()
+}
+
+// WithStrictVerification makes Deserialize refuse states that fail consistency checks.
+func WithStrictVerification() Option {
+	return doubleratchet.WithStrictVerification()
+}
+
+// Serializer encodes session state for persistence.
+type Serializer = doubleratchet.Serializer
+
+// JSONSerializer encodes state as JSON. It is the default.
+type JSONSerializer = doubleratchet.JSONSerializer
+
+// GobSerializer encodes state in the binary encoding/gob format.
+type GobSerializer = doubleratchet.GobSerializer
+
+// BinarySerializer encodes state in a compact fixed binary layout, the preferred encoding
+// for frequent checkpoints.
+type BinarySerializer = doubleratchet.BinarySerializer
+
+// ProtoSerializer encodes state as the State message of state.proto, for sessions shared
+// with services in other languages.
+type ProtoSerializer = doubleratchet.ProtoSerializer
+
+// CBORSerializer encodes state as a CBOR map keyed by field name, a compact schema-less
+// encoding for embedded and mobile storage.
+type CBORSerializer = doubleratchet.CBORSerializer
+
+// WithSerializer selects the encoding of serialized state. The default is JSON.
+func WithSerializer(s Serializer) Option {
+	return doubleratchet.WithSerializer(s)
+}
+
+// RegisterSerializer makes a serializer available to Deserialize.
+func RegisterSerializer(s Serializer) error {
+	return doubleratchet.RegisterSerializer(s)
+}
+
+// AEAD encrypts and decrypts message payloads under message keys, for example on a
+// hardware accelerator.
+type AEAD = doubleratchet.AEAD
+
+// AppendAEAD is an AEAD that can append its output to a caller's buffer, which
+// DoubleRatchet.EncryptInto and DecryptInto use to avoid allocations.
+type AppendAEAD = doubleratchet.AppendAEAD
+
+// WithAEAD makes the session encrypt and decrypt messages with aead instead of the
+// built-in AES-256-GCM.
+func WithAEAD(aead AEAD) Option {
+	return doubleratchet.WithAEAD(aead)
+}
+
+// WithoutLegacyAD makes the session refuse messages sent without the header bound into
+// the associated data by versions that predate the binding.
+func WithoutLegacyAD() Option {
+	return doubleratchet.WithoutLegacyAD()
+}
+
+// CBCHMAC is the AES-256-CBC with HMAC-SHA256 encryption recommended by the Double
+// Ratchet specification, for compatibility with Signal-style implementations.
+type CBCHMAC = doubleratchet.CBCHMAC
+
+// WithPrederivation keeps up to k sending message keys derived ahead of time, so that
+// latency-sensitive sends only encrypt.
+func WithPrederivation(k int) Option {
+	return doubleratchet.WithPrederivation(k)
+}
+
+// RekeyPolicy bounds how long a session keeps sending on one chain before it performs a
+// DH ratchet step, which it takes on its turn only.
+type RekeyPolicy = doubleratchet.RekeyPolicy
+
+// WithRekeyPolicy makes the session start a new sending chain under a fresh ratchet key
+// when the policy's limits are reached on its turn, and deliver EventRekeyDue otherwise.
+func WithRekeyPolicy(policy RekeyPolicy) Option {
+	return doubleratchet.WithRekeyPolicy(policy)
+}
+
+// WithoutLocking turns off the session's internal mutex for callers that serialize every
+// call on the session themselves.
+func WithoutLocking() Option {
+	return doubleratchet.WithoutLocking()
+}
+
+// SAS is a short authentication string that two users compare aloud.
+type SAS = doubleratchet.SAS
+
+// SASExchange is one side of the commit-then-reveal exchange that derives a short
+// authentication string.
+type SASExchange = doubleratchet.SASExchange
+
+// NewSASExchange starts a short authentication string exchange for session on the
+// initiator's or the responder's side.
+func NewSASExchange(session DoubleRatchet, initiator bool) (*SASExchange, error) {
+	return doubleratchet.NewSASExchange(session, initiator)
+}
+
+// SafetyNumber returns a 60-digit number derived from both users' identifiers and
+// identity public keys, which they compare out of band to verify each other's keys.
+func SafetyNumber(localID, localKey, remoteID, remoteKey []byte) string {
+	return doubleratchet.SafetyNumber(localID, localKey, remoteID, remoteKey)
+}
+
+// WithHeaderPadding attaches up to max bytes of random authenticated padding to every
+// header the session sends.
+func WithHeaderPadding(max int) Option {
+	return doubleratchet.WithHeaderPadding(max)
+}
+
+// PaddingScheme chooses the size plaintexts are padded to; see WithPlaintextPadding.
+type PaddingScheme = doubleratchet.PaddingScheme
+
+// Padme is the Padmé padding scheme, which leaks O(log log n) bits about a size.
+type Padme = doubleratchet.Padme
+
+// Buckets pads a size to the smallest bucket that holds it.
+type Buckets = doubleratchet.Buckets
+
+// WithPlaintextPadding pads every plaintext the session sends to the size scheme
+// chooses, marking the message with header version 1.
+func WithPlaintextPadding(scheme PaddingScheme) Option {
+	return doubleratchet.WithPlaintextPadding(scheme)
+}
+
+// DH is the Diffie-Hellman function of the DH ratchet. Implement it to plug in key
+// exchange backends such as hardware tokens or other curves.
+type DH = doubleratchet.DH
+
+// PrivateKey is a private key of a DH function.
+type PrivateKey = doubleratchet.PrivateKey
+
+// PublicKey is a public key of a DH function.
+type PublicKey = doubleratchet.PublicKey
+
+// Destroyer is implemented by private keys held outside the process, which the session
+// destroys once a DH ratchet step has replaced them.
+type Destroyer = doubleratchet.Destroyer
+
+// CurveDH is the DH implementation backed by a crypto/ecdh curve.
+type CurveDH = doubleratchet.CurveDH
+
+// WithDH makes the session use dh for the DH ratchet instead of P-256 from crypto/ecdh.
+func WithDH(dh DH) Option {
+	return doubleratchet.WithDH(dh)
+}
+
+// WithCurve makes the session use curve (P-256, P-384, P-521 or X25519) instead of P-256.
+// The curve is recorded in the serialized state.
+func WithCurve(curve ecdh.Curve) Option {
+	return doubleratchet.WithCurve(curve)
+}
+
+// KDFHash names the hash function of a session's key derivation chain.
+type KDFHash = doubleratchet.KDFHash
+
+// Supported KDF hash functions.
+const (
+	KDFSHA256 = doubleratchet.KDFSHA256
+	KDFSHA384 = doubleratchet.KDFSHA384
+	KDFSHA512 = doubleratchet.KDFSHA512
+)
+
+// WithKDFHash makes the session derive its keys with h instead of SHA-256. The choice is
+// recorded in the serialized state.
+func WithKDFHash(h KDFHash) Option {
+	return doubleratchet.WithKDFHash(h)
+}
+
+// WithMaxMessageSize limits the plaintexts Send accepts and the ciphertexts Receive
+// accepts, in bytes. Zero leaves a direction unlimited.
+func WithMaxMessageSize(plaintext, ciphertext int) Option {
+	return doubleratchet.WithMaxMessageSize(plaintext, ciphertext)
+}
+
+// WithMaxSkip limits the message keys a single received message may make the session
+// skip, and the skipped keys it holds in total. Zero keeps a default.
+func WithMaxSkip(perMessage, total int) Option {
+	return doubleratchet.WithMaxSkip(perMessage, total)
+}
+
+// Limiter accounts for the work received messages make a session do; see WithLimiter.
+type Limiter = doubleratchet.Limiter
+
+// WithLimiter makes Receive charge the key derivations each message costs to limiter,
+// and fail with ErrRateLimited for messages it refuses.
+func WithLimiter(limiter Limiter) Option {
+	return doubleratchet.WithLimiter(limiter)
+}
+
+// NewRateLimiter returns a Limiter that allows a cost of at most limit per window.
+func NewRateLimiter(limit uint64, window time.Duration) (Limiter, error) {
+	return doubleratchet.NewRateLimiter(limit, window)
+}
+
+// WithMaxPreviousChains limits the number of previous receiving chains whose skipped
+// message keys the session keeps, deleting the oldest chain's keys first.
+func WithMaxPreviousChains(n int) Option {
+	return doubleratchet.WithMaxPreviousChains(n)
+}
+
+// WithLabel replaces the "DoubleRatchet" prefix of the root and chain key HKDF info
+// strings with a protocol-specific label. The label is recorded in the serialized state.
+func WithLabel(label string) Option {
+	return doubleratchet.WithLabel(label)
+}
+
+// WithHybridPQ mixes an ML-KEM-768 encapsulation into every DH ratchet step, protecting
+// recorded traffic against future quantum attacks. It requires Go 1.24 or later.
+func WithHybridPQ() Option {
+	return doubleratchet.WithHybridPQ()
+}
+
+// WithInitialPQSecret mixes a post-quantum shared secret agreed out of band into the
+// initial root and chain keys. Both peers must give the same secret.
+func WithInitialPQSecret(secret []byte) Option {
+	return doubleratchet.WithInitialPQSecret(secret)
+}
+
+// WithFIPS restricts the session to FIPS-approved algorithms and records the mode in its
+// serialized state.
+func WithFIPS() Option {
+	return doubleratchet.WithFIPS()
 }
 
 // Deserialize restores a session from a byte slice.
-func Deserialize(data []byte) (DoubleRatchet, error) {
-	return doubleratchet.Deserialize(data)
+func Deserialize(data []byte, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.Deserialize(data, opts...)
+}
+
+// DeserializeEncrypted restores a session from a state encrypted by
+// DoubleRatchet.SerializeEncrypted, refusing the state of any session other than sessionID.
+func DeserializeEncrypted(data, kek, sessionID []byte, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.DeserializeEncrypted(data, kek, sessionID, opts...)
+}
+
+// AcceptHandoff restores a session from a token produced by DoubleRatchet.Handoff. claim
+// must fail if the token ID was claimed before; see doubleratchet.AcceptHandoff.
+func AcceptHandoff(token, key []byte, claim func(id []byte) error, opts ...Option) (DoubleRatchet, error) {
+	return doubleratchet.AcceptHandoff(token, key, claim, opts...)
+}
+
+// Divergence reports how two snapshots of the same session relate to each other.
+type Divergence = doubleratchet.Divergence
+
+// CompareStates compares two serialized snapshots of the same session that may have been
+// advanced independently, and reports where they diverged and how to recover.
+func CompareStates(a, b []byte) (Divergence, error) {
+	return doubleratchet.CompareStates(a, b)
 }
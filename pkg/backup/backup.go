@@ -0,0 +1,324 @@
+// Package backup bundles serialized Double Ratchet sessions into a single backup, with a
+// manifest describing its contents and an integrity tag over all of it, and restores such
+// backups only if they arrive complete and unaltered.
+//
+// A backup is laid out as follows, with integers in big-endian order:
+//
+//	magic "GRBK" | version (1 byte) | total size (8 bytes) | manifest size (4 bytes) |
+//	manifest (JSON) | session states, in manifest order | HMAC-SHA256 tag (32 bytes)
+//
+// The tag is computed under a key derived from the backup key and covers every byte
+// before it. Backups are authenticated, not encrypted: the session states are stored as
+// given, so back up states serialized with SerializeEncrypted, or encrypt the backup as a
+// whole, when it leaves trusted storage.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+	"github.com/othonhugo/goratchet/pkg/session"
+)
+
+const (
+	// KeySize is the size of a backup key.
+	KeySize = 32
+
+	// Version is the version of the backup format written by Create.
+	Version = 1
+
+	// prefixSize is the size of the magic, the version and the two sizes.
+	prefixSize = 4 + 1 + 8 + 4
+
+	// tagSize is the size of the integrity tag.
+	tagSize = sha256.Size
+)
+
+// magic starts every backup.
+var magic = []byte("GRBK")
+
+// tagLabel domain-separates the integrity key from other uses of the backup key.
+var tagLabel = []byte("goratchet-backup-integrity")
+
+var (
+	// ErrInvalidKey is returned when a backup key is not KeySize bytes long.
+	ErrInvalidKey = errors.New("backup: key must be 32 bytes")
+
+	// ErrEmptyID is returned by Create for a session with an empty ID.
+	ErrEmptyID = errors.New("backup: empty session ID")
+
+	// ErrDuplicateID is returned by Create when two sessions have the same ID.
+	ErrDuplicateID = errors.New("backup: duplicate session ID")
+
+	// ErrNotBackup is returned by Open for data that does not start like a backup.
+	ErrNotBackup = errors.New("backup: not a backup")
+
+	// ErrUnsupportedVersion is returned by Open for a backup of an unknown version.
+	ErrUnsupportedVersion = errors.New("backup: unsupported backup version")
+
+	// ErrTruncated is returned by Open for a backup shorter than its recorded size.
+	ErrTruncated = errors.New("backup: truncated backup")
+
+	// ErrTampered is returned by Open for a backup whose integrity tag does not verify,
+	// because it was altered or is opened with the wrong key, or whose manifest does not
+	// match its contents.
+	ErrTampered = errors.New("backup: integrity check failed")
+)
+
+// Session is a serialized session in a backup.
+type Session struct {
+	// ID identifies the session, such as the peer ID it is stored under.
+	ID string
+
+	// State is the serialized session.
+	State []byte
+}
+
+// Manifest describes the contents of a backup.
+type Manifest struct {
+	// Version is the backup format version.
+	Version int `json:"version"`
+
+	// CreatedAt is the time the backup was created, in UTC.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Comment is the free-form comment given in Config.
+	Comment string `json:"comment,omitempty"`
+
+	// Sessions lists the sessions in the order their states are stored.
+	Sessions []Entry `json:"sessions"`
+}
+
+// Entry describes one session in a Manifest.
+type Entry struct {
+	ID     string `json:"id"`
+	Size   int    `json:"size"`
+	SHA256 []byte `json:"sha256"`
+}
+
+// Backup is an opened backup.
+type Backup struct {
+	Manifest Manifest
+	Sessions []Session
+}
+
+// Config configures Create.
+type Config struct {
+	// Comment is recorded in the manifest, for example to name the device or the
+	// application version that created the backup.
+	Comment string
+}
+
+// Create bundles sessions into a backup protected by key. A nil config uses the
+// defaults.
+func Create(key []byte, sessions []Session, config *Config) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	manifest := Manifest{
+		Version:   Version,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Sessions:  make([]Entry, 0, len(sessions)),
+	}
+
+	ids := make(map[string]bool, len(sessions))
+	size := prefixSize + tagSize
+
+	for _, s := range sessions {
+		if s.ID == "" {
+			return nil, ErrEmptyID
+		}
+
+		if ids[s.ID] {
+			return nil, ErrDuplicateID
+		}
+
+		ids[s.ID] = true
+		sum := sha256.Sum256(s.State)
+		size += len(s.State)
+
+		manifest.Sessions = append(manifest.Sessions, Entry{ID: s.ID, Size: len(s.State), SHA256: sum[:]})
+	}
+
+	if config != nil {
+		manifest.Comment = config.Comment
+	}
+
+	encoded, err := json.Marshal(manifest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size += len(encoded)
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, magic...)
+	buf = append(buf, Version)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(size))         // #nosec G115 -- sizes are non-negative
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(encoded))) // #nosec G115 -- manifests are far below 4 GiB
+	buf = append(buf, encoded...)
+
+	for _, s := range sessions {
+		buf = append(buf, s.State...)
+	}
+
+	sum, err := tag(key, buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf, sum...), nil
+}
+
+// Open verifies a backup created by Create under key and returns its contents. It
+// refuses a backup that is shorter than its recorded size with ErrTruncated, and one
+// that was altered in any way, or whose manifest does not describe its contents, with
+// ErrTampered.
+func Open(data, key []byte) (*Backup, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(data) < len(magic) || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, ErrNotBackup
+	}
+
+	if len(data) < prefixSize+tagSize {
+		return nil, ErrTruncated
+	}
+
+	if data[4] != Version {
+		return nil, ErrUnsupportedVersion
+	}
+
+	size := binary.BigEndian.Uint64(data[5:])
+
+	if size > uint64(len(data)) {
+		return nil, ErrTruncated
+	}
+
+	if size < uint64(len(data)) {
+		return nil, ErrTampered
+	}
+
+	body := data[:len(data)-tagSize]
+
+	sum, err := tag(key, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(data[len(body):], sum) {
+		return nil, ErrTampered
+	}
+
+	manifestSize := binary.BigEndian.Uint32(data[13:])
+
+	if uint64(manifestSize) > uint64(len(body)-prefixSize) {
+		return nil, ErrTampered
+	}
+
+	var manifest Manifest
+
+	if err := json.Unmarshal(body[prefixSize:prefixSize+int(manifestSize)], &manifest); err != nil || manifest.Version != Version {
+		return nil, ErrTampered
+	}
+
+	states := body[prefixSize+int(manifestSize):]
+	b := &Backup{Manifest: manifest, Sessions: make([]Session, 0, len(manifest.Sessions))}
+
+	for _, e := range manifest.Sessions {
+		if e.Size < 0 || e.Size > len(states) {
+			return nil, ErrTampered
+		}
+
+		state := states[:e.Size]
+		states = states[e.Size:]
+
+		if sum := sha256.Sum256(state); !bytes.Equal(sum[:], e.SHA256) {
+			return nil, ErrTampered
+		}
+
+		b.Sessions = append(b.Sessions, Session{ID: e.ID, State: append([]byte(nil), state...)})
+	}
+
+	if len(states) != 0 {
+		return nil, ErrTampered
+	}
+
+	return b, nil
+}
+
+// Export creates a backup of every session in store, which must implement
+// session.Lister; other stores fail with session.ErrListUnsupported.
+func Export(ctx context.Context, store session.Store, key []byte, config *Config) ([]byte, error) {
+	lister, ok := store.(session.Lister)
+
+	if !ok {
+		return nil, session.ErrListUnsupported
+	}
+
+	peers, err := lister.List(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(peers))
+
+	for _, peer := range peers {
+		state, err := store.Load(ctx, peer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, Session{ID: peer, State: state})
+	}
+
+	return Create(key, sessions, config)
+}
+
+// Import verifies a backup like Open and saves each of its sessions to store under its
+// ID, replacing sessions with the same IDs. Nothing is saved unless the whole backup
+// verifies. It returns the backup's manifest.
+func Import(ctx context.Context, data, key []byte, store session.Store) (Manifest, error) {
+	b, err := Open(data, key)
+
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	for _, s := range b.Sessions {
+		if err := store.Save(ctx, s.ID, s.State); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	return b.Manifest, nil
+}
+
+// tag returns the integrity tag of data under key.
+func tag(key, data []byte) ([]byte, error) {
+	tagKey, err := crypto.DeriveHKDF(key, nil, tagLabel, KeySize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, tagKey)
+	mac.Write(data)
+
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/session"
+)
+
+// TestCreateOpen verifies that a backup restores its sessions and manifest, and that
+// truncated, altered or foreign backups are refused.
+func TestCreateOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	sessions := []Session{{ID: "alice", State: []byte("state of alice")}, {ID: "bob", State: []byte("state of bob")}}
+
+	data, err := Create(key, sessions, &Config{Comment: "laptop"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(data, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.Sessions) != 2 || b.Sessions[1].ID != "bob" || string(b.Sessions[1].State) != "state of bob" {
+		t.Errorf("Expected the sessions to be restored, got %+v", b.Sessions)
+	}
+
+	if b.Manifest.Comment != "laptop" || b.Manifest.Version != Version || time.Since(b.Manifest.CreatedAt) > time.Minute {
+		t.Errorf("Expected the creation metadata to be restored, got %+v", b.Manifest)
+	}
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-tagSize-1] ^= 1
+
+	wrongKey := bytes.Repeat([]byte{8}, KeySize)
+
+	for name, tc := range map[string]struct {
+		data, key []byte
+		err       error
+	}{
+		"truncated": {data[:len(data)-1], key, ErrTruncated},
+		"short":     {data[:prefixSize], key, ErrTruncated},
+		"trailing":  {append(append([]byte(nil), data...), 0), key, ErrTampered},
+		"altered":   {tampered, key, ErrTampered},
+		"wrong key": {data, wrongKey, ErrTampered},
+		"foreign":   {[]byte("not a backup at all"), key, ErrNotBackup},
+		"version":   {append(append(append([]byte(nil), magic...), Version+1), data[5:]...), key, ErrUnsupportedVersion},
+	} {
+		if _, err := Open(tc.data, tc.key); !errors.Is(err, tc.err) {
+			t.Errorf("%s: Expected %v, got %v", name, tc.err, err)
+		}
+	}
+
+	if _, err := Create(key, []Session{{ID: "a"}, {ID: "a"}}, nil); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("Expected ErrDuplicateID, got %v", err)
+	}
+
+	if _, err := Create(key[:16], sessions, nil); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+// TestExportImport verifies that the sessions of one store are carried to another.
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{7}, KeySize)
+
+	from, to := session.NewMemoryStore(), session.NewMemoryStore()
+
+	_ = from.Save(ctx, "alice", []byte("a"))
+	_ = from.Save(ctx, "bob", []byte("b"))
+
+	data, err := Export(ctx, from, key, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := Import(ctx, data, key, to)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Sessions) != 2 {
+		t.Errorf("Expected 2 sessions in the manifest, got %d", len(manifest.Sessions))
+	}
+
+	if state, err := to.Load(ctx, "bob"); err != nil || string(state) != "b" {
+		t.Errorf("Expected the session of bob to be imported, got %q, %v", state, err)
+	}
+}
@@ -0,0 +1,600 @@
+// Package codec provides wire encodings for Double Ratchet messages.
+package codec
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+const (
+	// compactVersion is the version nibble carried in the flags byte.
+	compactVersion = 1
+
+	// compactPaddedVersion is the version of messages whose header is padded. They carry
+	// the padding length and the padding after the counters.
+	compactPaddedVersion = 2
+
+	// compactTypedVersion is the version of messages whose header has a version or type
+	// (see doubleratchet.MessageType). They carry the padding like padded messages, even
+	// if it is empty, followed by the header version and the type.
+	compactTypedVersion = 3
+
+	// compactMetadataVersion is the version of messages whose header carries a timestamp
+	// or message ID (see doubleratchet.Metadata). They carry everything typed messages do,
+	// followed by the timestamp as a signed varint and the length-prefixed message ID.
+	compactMetadataVersion = 4
+
+	// compactEscrowVersion is the version of messages with an escrow block (see
+	// doubleratchet.WithEscrow). They carry everything metadata messages do, followed by
+	// the length-prefixed escrow block.
+	compactEscrowVersion = 5
+
+	// compactHybridVersion is the version of messages whose header carries post-quantum
+	// fields (see doubleratchet.WithHybridPQ). They carry everything escrowed messages do,
+	// even if the escrow block is empty, followed by the length-prefixed encapsulation key,
+	// ciphertext and target of Header.PQ.
+	compactHybridVersion = 6
+
+	// keyTagSize is the size of the short tag that replaces an already announced key.
+	keyTagSize = 4
+
+	// DefaultKeyRepeat is the number of messages per chain that carry the full ratchet key
+	// before the encoder switches to the short key tag.
+	DefaultKeyRepeat = 3
+
+	// maxCachedKeys bounds the number of ratchet keys remembered by a CompactDecoder.
+	maxCachedKeys = 16
+)
+
+// Flag bits of the compact encoding. Bits 2-3 hold the key format and the upper nibble
+// holds the version.
+const (
+	flagFullKey byte = 1 << iota
+	flagPN
+
+	keyFormatShift = 2
+	keyFormatMask  = 0x3 << keyFormatShift
+)
+
+// compressedCurves lists the curves whose keys are sent compressed, indexed by key format.
+// Format zero denotes a raw, length-prefixed key.
+var compressedCurves = []elliptic.Curve{nil, elliptic.P256(), elliptic.P384(), elliptic.P521()}
+
+var (
+	// ErrShortMessage is returned when an encoded message ends prematurely.
+	ErrShortMessage = errors.New("codec: message too short")
+
+	// ErrUnsupportedVersion is returned when an encoded message carries an unknown version.
+	ErrUnsupportedVersion = errors.New("codec: unsupported encoding version")
+
+	// ErrUnknownKey is returned when a message refers to a ratchet key the decoder has not seen.
+	ErrUnknownKey = errors.New("codec: unknown ratchet key tag")
+
+	// ErrInvalidKey is returned when a compressed ratchet key is not a valid curve point.
+	ErrInvalidKey = errors.New("codec: invalid compressed ratchet key")
+)
+
+// CompactEncoder produces an ultra-compact binary encoding of ciphered messages for
+// constrained links such as CoAP or LoRaWAN. NIST curve keys are sent in SEC 1 compressed
+// form, and after the first KeyRepeat messages of a chain the key is replaced by a 4-byte
+// tag. Counters are varints, with N delta-encoded against the first counter sent under the
+// key, and PN omitted when zero. Padded headers (see doubleratchet.WithHeaderPadding) are
+// sent with their padding, headers with a version, message type or metadata with all
+// of them, and messages with an escrow block or post-quantum fields with those.
+//
+// An encoder is stateful and must be used for a single direction of a single session.
+type CompactEncoder struct {
+	// KeyRepeat is the number of messages per chain carrying the full key. Zero means DefaultKeyRepeat.
+	KeyRepeat int
+
+	key   string
+	base  uint32
+	count int
+}
+
+// Marshal encodes msg.
+func (e *CompactEncoder) Marshal(msg doubleratchet.CipheredMessage) ([]byte, error) {
+	repeat := e.KeyRepeat
+
+	if repeat <= 0 {
+		repeat = DefaultKeyRepeat
+	}
+
+	if string(msg.Header.DH) != e.key || msg.Header.N < e.base {
+		e.key = string(msg.Header.DH)
+		e.base = msg.Header.N
+		e.count = 0
+	}
+
+	hybrid := msg.Header.PQ != nil
+	escrow := hybrid || len(msg.Escrow) > 0
+	meta := escrow || msg.Header.Timestamp != 0 || len(msg.Header.MessageID) > 0
+	typed := meta || msg.Header.Version != 0 || msg.Header.Type != doubleratchet.MessageNormal
+	flags := byte(compactVersion << 4)
+
+	switch {
+	case hybrid:
+		flags = compactHybridVersion << 4
+	case escrow:
+		flags = compactEscrowVersion << 4
+	case meta:
+		flags = compactMetadataVersion << 4
+	case typed:
+		flags = compactTypedVersion << 4
+	case len(msg.Header.Padding) > 0:
+		flags = compactPaddedVersion << 4
+	}
+
+	full := e.count < repeat
+
+	buf := make([]byte, 1, 1+1+len(msg.Header.DH)+2*binary.MaxVarintLen32+len(msg.Ciphertext))
+
+	if full {
+		flags |= flagFullKey
+
+		if format, compressed := compressPoint(msg.Header.DH); compressed != nil {
+			flags |= format << keyFormatShift
+			buf = append(buf, compressed...)
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(len(msg.Header.DH)))
+			buf = append(buf, msg.Header.DH...)
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(e.base))
+	} else {
+		buf = append(buf, keyTag(msg.Header.DH)...)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(msg.Header.N-e.base))
+
+	if msg.Header.PN != 0 {
+		flags |= flagPN
+		buf = binary.AppendUvarint(buf, uint64(msg.Header.PN))
+	}
+
+	if typed || len(msg.Header.Padding) > 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(msg.Header.Padding)))
+		buf = append(buf, msg.Header.Padding...)
+	}
+
+	if typed {
+		buf = append(buf, msg.Header.Version, byte(msg.Header.Type))
+	}
+
+	if meta {
+		buf = binary.AppendVarint(buf, msg.Header.Timestamp)
+		buf = binary.AppendUvarint(buf, uint64(len(msg.Header.MessageID)))
+		buf = append(buf, msg.Header.MessageID...)
+	}
+
+	if escrow {
+		buf = binary.AppendUvarint(buf, uint64(len(msg.Escrow)))
+		buf = append(buf, msg.Escrow...)
+	}
+
+	if hybrid {
+		for _, field := range [][]byte{msg.Header.PQ.Key, msg.Header.PQ.Ciphertext, msg.Header.PQ.Target} {
+			buf = binary.AppendUvarint(buf, uint64(len(field)))
+			buf = append(buf, field...)
+		}
+	}
+
+	buf[0] = flags
+	e.count++
+
+	return append(buf, msg.Ciphertext...), nil
+}
+
+// CompactDecoder decodes messages produced by a CompactEncoder. It remembers the most
+// recently announced ratchet keys so that tagged messages can be resolved, even when
+// they arrive out of order.
+type CompactDecoder struct {
+	keys []cachedKey
+}
+
+// cachedKey is a ratchet key announced by the peer together with its base counter.
+type cachedKey struct {
+	tag  [keyTagSize]byte
+	key  []byte
+	base uint32
+}
+
+// Unmarshal decodes data.
+func (d *CompactDecoder) Unmarshal(data []byte) (doubleratchet.CipheredMessage, error) {
+	m, err := parseCompact(data)
+
+	if err != nil {
+		return doubleratchet.CipheredMessage{}, err
+	}
+
+	header := doubleratchet.Header{
+		PN:        m.pn,
+		Padding:   m.padding,
+		Version:   m.version,
+		Type:      m.typ,
+		Timestamp: m.timestamp,
+		MessageID: m.id,
+		PQ:        m.pq,
+	}
+
+	base := m.base
+
+	if m.key != nil {
+		d.remember(m.key, m.base)
+		header.DH = m.key
+	} else {
+		cached, ok := d.lookup(m.tag)
+
+		if !ok {
+			return doubleratchet.CipheredMessage{}, ErrUnknownKey
+		}
+
+		header.DH, base = append([]byte(nil), cached.key...), cached.base
+	}
+
+	header.N = base + m.delta
+
+	return doubleratchet.CipheredMessage{
+		Header:     header,
+		Ciphertext: append([]byte(nil), m.ciphertext...),
+		Escrow:     m.escrow,
+	}, nil
+}
+
+// WireHeader is the header of a compactly encoded message as seen without decoder state,
+// for example by a router that forwards messages to their sessions without holding any
+// session keys.
+type WireHeader struct {
+	// KeyTag identifies the sender's ratchet key, and thus its sending chain. Every message
+	// carries it or the full key.
+	KeyTag [keyTagSize]byte
+
+	// DH is the sender's ratchet key, or nil if the message refers to it by its tag.
+	DH []byte
+
+	// N is the message number. It is known only if DH is set, since tagged messages encode
+	// it relative to the message that announced the key.
+	N uint32
+
+	// PN is the number of messages in the sender's previous sending chain.
+	PN uint32
+
+	// Version and Type are the header version and message type.
+	Version uint8
+	Type    doubleratchet.MessageType
+
+	// Timestamp and MessageID are the authenticated metadata of the message; see
+	// doubleratchet.Metadata. A router cannot verify them without the session.
+	Timestamp int64
+	MessageID []byte
+}
+
+// ParseHeader parses the header of a message produced by a CompactEncoder without
+// decoding the rest of it and without the key cache of a CompactDecoder.
+func ParseHeader(data []byte) (WireHeader, error) {
+	m, err := parseCompact(data)
+
+	if err != nil {
+		return WireHeader{}, err
+	}
+
+	h := WireHeader{
+		DH:        m.key,
+		PN:        m.pn,
+		Version:   m.version,
+		Type:      m.typ,
+		Timestamp: m.timestamp,
+		MessageID: m.id,
+	}
+
+	if m.key != nil {
+		h.N = m.base + m.delta
+		copy(h.KeyTag[:], keyTag(m.key))
+	} else {
+		copy(h.KeyTag[:], m.tag)
+	}
+
+	return h, nil
+}
+
+// compactMessage holds the fields of a compactly encoded message before key tags are
+// resolved.
+type compactMessage struct {
+	key        []byte
+	tag        []byte
+	base       uint32
+	delta      uint32
+	pn         uint32
+	padding    []byte
+	version    uint8
+	typ        doubleratchet.MessageType
+	timestamp  int64
+	id         []byte
+	escrow     []byte
+	pq         *doubleratchet.HybridHeader
+	ciphertext []byte
+}
+
+// parseCompact splits a compactly encoded message into its fields. Either key or tag is set.
+func parseCompact(data []byte) (compactMessage, error) {
+	if len(data) < 1 {
+		return compactMessage{}, ErrShortMessage
+	}
+
+	flags := data[0]
+	data = data[1:]
+
+	version := flags >> 4
+
+	if version < compactVersion || version > compactHybridVersion {
+		return compactMessage{}, ErrUnsupportedVersion
+	}
+
+	var m compactMessage
+
+	if flags&flagFullKey != 0 {
+		key, rest, err := readKey(data, (flags&keyFormatMask)>>keyFormatShift)
+
+		if err != nil {
+			return compactMessage{}, err
+		}
+
+		announced, rest, err := readUvarint32(rest)
+
+		if err != nil {
+			return compactMessage{}, err
+		}
+
+		m.key, m.base, data = key, announced, rest
+	} else {
+		if len(data) < keyTagSize {
+			return compactMessage{}, ErrShortMessage
+		}
+
+		m.tag, data = data[:keyTagSize], data[keyTagSize:]
+	}
+
+	delta, data, err := readUvarint32(data)
+
+	if err != nil {
+		return compactMessage{}, err
+	}
+
+	m.delta = delta
+
+	if flags&flagPN != 0 {
+		pn, rest, err := readUvarint32(data)
+
+		if err != nil {
+			return compactMessage{}, err
+		}
+
+		m.pn, data = pn, rest
+	}
+
+	if version >= compactPaddedVersion {
+		n, rest, err := readUvarint32(data)
+
+		if err != nil {
+			return compactMessage{}, err
+		}
+
+		if uint32(len(rest)) < n {
+			return compactMessage{}, ErrShortMessage
+		}
+
+		if n > 0 {
+			m.padding = append([]byte(nil), rest[:n]...)
+		}
+
+		data = rest[n:]
+	}
+
+	if version >= compactTypedVersion {
+		if len(data) < 2 {
+			return compactMessage{}, ErrShortMessage
+		}
+
+		m.version, m.typ, data = data[0], doubleratchet.MessageType(data[1]), data[2:]
+	}
+
+	if version >= compactMetadataVersion {
+		timestamp, n := binary.Varint(data)
+
+		if n <= 0 {
+			return compactMessage{}, ErrShortMessage
+		}
+
+		size, rest, err := readUvarint32(data[n:])
+
+		if err != nil {
+			return compactMessage{}, err
+		}
+
+		if uint32(len(rest)) < size {
+			return compactMessage{}, ErrShortMessage
+		}
+
+		if size > 0 {
+			m.id = append([]byte(nil), rest[:size]...)
+		}
+
+		m.timestamp, data = timestamp, rest[size:]
+	}
+
+	if version >= compactEscrowVersion {
+		escrow, rest, err := readBytes(data)
+
+		if err != nil {
+			return compactMessage{}, err
+		}
+
+		m.escrow, data = escrow, rest
+	}
+
+	if version >= compactHybridVersion {
+		var fields [3][]byte
+
+		for i := range fields {
+			field, rest, err := readBytes(data)
+
+			if err != nil {
+				return compactMessage{}, err
+			}
+
+			fields[i], data = field, rest
+		}
+
+		m.pq = &doubleratchet.HybridHeader{Key: fields[0], Ciphertext: fields[1], Target: fields[2]}
+	}
+
+	m.ciphertext = data
+
+	return m, nil
+}
+
+// remember records an announced key and the base counter its tagged messages refer to.
+func (d *CompactDecoder) remember(key []byte, base uint32) {
+	var tag [keyTagSize]byte
+
+	copy(tag[:], keyTag(key))
+
+	for i := range d.keys {
+		if d.keys[i].tag == tag {
+			d.keys[i].base = base
+			return
+		}
+	}
+
+	if len(d.keys) == maxCachedKeys {
+		d.keys = d.keys[1:]
+	}
+
+	d.keys = append(d.keys, cachedKey{tag: tag, key: append([]byte(nil), key...), base: base})
+}
+
+// lookup resolves a key tag.
+func (d *CompactDecoder) lookup(tag []byte) (cachedKey, bool) {
+	for _, k := range d.keys {
+		if string(k.tag[:]) == string(tag) {
+			return k, true
+		}
+	}
+
+	return cachedKey{}, false
+}
+
+// keyTag returns the short tag identifying a ratchet key.
+func keyTag(key []byte) []byte {
+	sum := sha256.Sum256(key)
+
+	return sum[:keyTagSize]
+}
+
+// readKey reads a ratchet key in the given format: compressed for a NIST curve, or
+// length-prefixed raw bytes when format is zero.
+func readKey(data []byte, format byte) (key, rest []byte, err error) {
+	if format == 0 {
+		n, rest, err := readUvarint32(data)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if uint32(len(rest)) < n {
+			return nil, nil, ErrShortMessage
+		}
+
+		return append([]byte(nil), rest[:n]...), rest[n:], nil
+	}
+
+	curve := compressedCurves[format]
+	size := 1 + (curve.Params().BitSize+7)/8
+
+	if len(data) < size {
+		return nil, nil, ErrShortMessage
+	}
+
+	x, y := elliptic.UnmarshalCompressed(curve, data[:size])
+
+	if x == nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	return uncompressedPoint(curve, x, y), data[size:], nil
+}
+
+// compressPoint compresses an uncompressed NIST curve point and returns its key format,
+// or returns a nil slice if key is not such a point.
+func compressPoint(key []byte) (byte, []byte) {
+	if len(key) == 0 || key[0] != 4 {
+		return 0, nil
+	}
+
+	for format, curve := range compressedCurves[1:] {
+		byteLen := (curve.Params().BitSize + 7) / 8
+
+		if len(key) != 1+2*byteLen {
+			continue
+		}
+
+		x := new(big.Int).SetBytes(key[1 : 1+byteLen])
+		y := new(big.Int).SetBytes(key[1+byteLen:])
+
+		if !curve.IsOnCurve(x, y) {
+			return 0, nil
+		}
+
+		return byte(format + 1), elliptic.MarshalCompressed(curve, x, y)
+	}
+
+	return 0, nil
+}
+
+// uncompressedPoint encodes a curve point in SEC 1 uncompressed form.
+func uncompressedPoint(curve elliptic.Curve, x, y *big.Int) []byte {
+	byteLen := (curve.Params().BitSize + 7) / 8
+	out := make([]byte, 1+2*byteLen)
+
+	out[0] = 4
+	x.FillBytes(out[1 : 1+byteLen])
+	y.FillBytes(out[1+byteLen:])
+
+	return out
+}
+
+// readBytes reads a length-prefixed field, returning a copy of it, or nil if it is empty,
+// and the rest.
+func readBytes(data []byte) (field, rest []byte, err error) {
+	size, rest, err := readUvarint32(data)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if uint32(len(rest)) < size {
+		return nil, nil, ErrShortMessage
+	}
+
+	if size > 0 {
+		field = append([]byte(nil), rest[:size]...)
+	}
+
+	return field, rest[size:], nil
+}
+
+// readUvarint32 reads a varint that must fit in 32 bits.
+func readUvarint32(data []byte) (uint32, []byte, error) {
+	v, n := binary.Uvarint(data)
+
+	if n <= 0 || v > 1<<32-1 {
+		return 0, nil, ErrShortMessage
+	}
+
+	return uint32(v), data[n:], nil
+}
@@ -0,0 +1,75 @@
+//go:build go1.24
+
+package codec
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// TestCompactHybridPQ verifies that the post-quantum header fields survive the compact
+// encoding in both directions of a hybrid session, so that the decoded messages still
+// decrypt and the ratchet keeps mixing in ML-KEM secrets.
+func TestCompactHybridPQ(t *testing.T) {
+	sk := make([]byte, 32)
+	rand.Read(sk)
+
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := doubleratchet.InitAlice(sk, bobPri.PublicKey().Bytes(), doubleratchet.WithHybridPQ())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := doubleratchet.InitBob(sk, bobPri.Bytes(), doubleratchet.WithHybridPQ())
+
+	var (
+		toBob, toAlice     CompactEncoder
+		fromAlice, fromBob CompactDecoder
+	)
+
+	for round := 0; round < 3; round++ {
+		for _, dir := range []struct {
+			from, to doubleratchet.DoubleRatchet
+			enc      *CompactEncoder
+			dec      *CompactDecoder
+		}{{alice, bob, &toBob, &fromAlice}, {bob, alice, &toAlice, &fromBob}} {
+			msg, err := dir.from.Send([]byte("hello"), nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := dir.enc.Marshal(msg)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data[0]>>4 != compactHybridVersion {
+				t.Errorf("Expected version %d for a hybrid message, got %d", compactHybridVersion, data[0]>>4)
+			}
+
+			decoded, err := dir.dec.Unmarshal(data)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pq := decoded.Header.PQ
+
+			if pq == nil || !bytes.Equal(pq.Key, msg.Header.PQ.Key) || !bytes.Equal(pq.Ciphertext, msg.Header.PQ.Ciphertext) || !bytes.Equal(pq.Target, msg.Header.PQ.Target) {
+				t.Fatalf("Round %d: expected the post-quantum fields to survive the encoding", round)
+			}
+
+			if _, err := dir.to.Receive(decoded, nil); err != nil {
+				t.Fatalf("Round %d: Receive failed: %v", round, err)
+			}
+		}
+	}
+}
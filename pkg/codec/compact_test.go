@@ -0,0 +1,448 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+func newSessions(t *testing.T) (alice, bob doubleratchet.DoubleRatchet) {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	a, err := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, b
+}
+
+// TestCompactRoundTripAndOverhead verifies that messages survive the compact encoding,
+// including the switch from full keys to key tags, and that the per-message overhead on
+// top of the AEAD ciphertext stays within a few dozen bytes.
+func TestCompactRoundTripAndOverhead(t *testing.T) {
+	alice, bob := newSessions(t)
+
+	var (
+		enc CompactEncoder
+		dec CompactDecoder
+	)
+
+	for i := range 10 {
+		plaintext := []byte("sensor reading")
+
+		msg, err := alice.Send(plaintext, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := enc.Marshal(msg)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		overhead := len(data) - len(plaintext)
+
+		if i < DefaultKeyRepeat && overhead > 1+33+2+crypto.Overhead {
+			t.Errorf("Message %d: full-key overhead too large: %d bytes", i, overhead)
+		}
+
+		if i >= DefaultKeyRepeat && overhead > 1+keyTagSize+1+crypto.Overhead {
+			t.Errorf("Message %d: tagged overhead too large: %d bytes", i, overhead)
+		}
+
+		decoded, err := dec.Unmarshal(data)
+
+		if err != nil {
+			t.Fatalf("Message %d: %v", i, err)
+		}
+
+		if !bytes.Equal(decoded.Header.DH, msg.Header.DH) || decoded.Header.N != msg.Header.N || decoded.Header.PN != msg.Header.PN {
+			t.Fatalf("Message %d: header mismatch: got %+v, want %+v", i, decoded.Header, msg.Header)
+		}
+
+		unciphered, err := bob.Receive(decoded, nil)
+
+		if err != nil {
+			t.Fatalf("Message %d: %v", i, err)
+		}
+
+		if !bytes.Equal(unciphered.Plaintext, plaintext) {
+			t.Fatalf("Message %d: plaintext mismatch", i)
+		}
+	}
+}
+
+// TestCompactOutOfOrderAndLostAnnouncements verifies that tagged messages decode in any
+// order once any full-key message of the chain has arrived, and fail with ErrUnknownKey
+// before that.
+func TestCompactOutOfOrderAndLostAnnouncements(t *testing.T) {
+	alice, _ := newSessions(t)
+
+	var enc CompactEncoder
+
+	var encoded [][]byte
+
+	for range 6 {
+		msg, _ := alice.Send([]byte("x"), nil)
+		data, _ := enc.Marshal(msg)
+
+		encoded = append(encoded, data)
+	}
+
+	var dec CompactDecoder
+
+	if _, err := dec.Unmarshal(encoded[5]); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	// Only the last announcement arrives.
+	if _, err := dec.Unmarshal(encoded[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{5, 3, 4} {
+		decoded, err := dec.Unmarshal(encoded[i])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Header.N != uint32(i) {
+			t.Errorf("Expected N=%d, got %d", i, decoded.Header.N)
+		}
+	}
+}
+
+// TestCompactRawKeysAndMalformedInput verifies that keys which are not NIST points are
+// carried raw, and that truncated or wrongly versioned input is rejected.
+func TestCompactRawKeysAndMalformedInput(t *testing.T) {
+	msg := doubleratchet.CipheredMessage{
+		Header:     doubleratchet.Header{DH: bytes.Repeat([]byte{7}, 32), N: 300, PN: 12},
+		Ciphertext: []byte("ciphertext"),
+	}
+
+	var enc CompactEncoder
+
+	data, err := enc.Marshal(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dec CompactDecoder
+
+	decoded, err := dec.Unmarshal(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded.Header.DH, msg.Header.DH) || decoded.Header.N != 300 || decoded.Header.PN != 12 {
+		t.Errorf("Header mismatch: %+v", decoded.Header)
+	}
+
+	for i := 1; i < len(data)-len(msg.Ciphertext); i++ {
+		var fresh CompactDecoder
+
+		if _, err := fresh.Unmarshal(data[:i]); err == nil {
+			t.Errorf("Expected error for input truncated to %d bytes", i)
+		}
+	}
+
+	if _, err := dec.Unmarshal([]byte{0xF0}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+// TestCompactHeaderPadding verifies that header padding survives the compact encoding and
+// that unpadded messages keep the original encoding version.
+func TestCompactHeaderPadding(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, doubleratchet.WithHeaderPadding(64))
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	var (
+		enc CompactEncoder
+		dec CompactDecoder
+	)
+
+	padded := 0
+
+	for range 20 {
+		msg, _ := alice.Send([]byte("hello"), nil)
+
+		data, err := enc.Marshal(msg)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(msg.Header.Padding) > 0 {
+			padded++
+		} else if data[0]>>4 != compactVersion {
+			t.Errorf("Expected version %d for an unpadded message, got %d", compactVersion, data[0]>>4)
+		}
+
+		decoded, err := dec.Unmarshal(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decoded.Header.Padding, msg.Header.Padding) {
+			t.Fatal("Padding did not survive the compact encoding")
+		}
+
+		if _, err := bob.Receive(decoded, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	if padded == 0 {
+		t.Error("Expected some messages to be padded")
+	}
+}
+
+// TestCompactMessageType verifies that the header version and message type survive the
+// compact encoding, so that a typed message still authenticates after decoding.
+func TestCompactMessageType(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	var (
+		enc CompactEncoder
+		dec CompactDecoder
+	)
+
+	msg, err := alice.SendType(doubleratchet.MessageRekeyRequest, []byte("rekey"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := enc.Marshal(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0]>>4 != compactTypedVersion {
+		t.Errorf("Expected version %d for a typed message, got %d", compactTypedVersion, data[0]>>4)
+	}
+
+	decoded, err := dec.Unmarshal(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Header.Type != doubleratchet.MessageRekeyRequest {
+		t.Errorf("Expected type %d, got %d", doubleratchet.MessageRekeyRequest, decoded.Header.Type)
+	}
+
+	if decoded.Header.Padding != nil {
+		t.Errorf("Expected no padding, got %x", decoded.Header.Padding)
+	}
+
+	plain, err := bob.Receive(decoded, nil)
+
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if plain.Type != doubleratchet.MessageRekeyRequest {
+		t.Errorf("Expected type %d, got %d", doubleratchet.MessageRekeyRequest, plain.Type)
+	}
+}
+
+// TestParseHeader verifies that ParseHeader reads the header of messages carrying the full
+// ratchet key as well as of tagged ones, that both give the same key tag for the same
+// chain, and that it needs no decoder state.
+func TestParseHeader(t *testing.T) {
+	alice, _ := newSessions(t)
+
+	var enc CompactEncoder
+
+	var headers []WireHeader
+
+	for range DefaultKeyRepeat + 1 {
+		msg, err := alice.SendType(doubleratchet.MessagePreKey, []byte("hello"), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := enc.Marshal(msg)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := ParseHeader(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if h.Type != doubleratchet.MessagePreKey {
+			t.Errorf("Expected type %d, got %d", doubleratchet.MessagePreKey, h.Type)
+		}
+
+		if h.DH != nil && (!bytes.Equal(h.DH, msg.Header.DH) || h.N != msg.Header.N) {
+			t.Errorf("Expected key %x and N %d, got %x and %d", msg.Header.DH, msg.Header.N, h.DH, h.N)
+		}
+
+		headers = append(headers, h)
+	}
+
+	if headers[0].DH == nil || headers[DefaultKeyRepeat].DH != nil {
+		t.Fatal("Expected the first message to carry the full key and the last a tag")
+	}
+
+	if headers[0].KeyTag != headers[DefaultKeyRepeat].KeyTag {
+		t.Errorf("Expected the same key tag, got %x and %x", headers[0].KeyTag, headers[DefaultKeyRepeat].KeyTag)
+	}
+
+	if _, err := ParseHeader([]byte{0xF0}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+// TestCompactMetadata verifies that the header timestamp and message ID survive the
+// compact encoding and ParseHeader, so that a message with metadata still authenticates
+// after decoding.
+func TestCompactMetadata(t *testing.T) {
+	alice, bob := newSessions(t)
+
+	var (
+		enc CompactEncoder
+		dec CompactDecoder
+	)
+
+	meta := doubleratchet.Metadata{Timestamp: time.UnixMilli(1760790600123), ID: []byte("msg-1")}
+
+	msg, err := alice.SendWithMetadata(meta, []byte("hello"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := enc.Marshal(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0]>>4 != compactMetadataVersion {
+		t.Errorf("Expected version %d for a message with metadata, got %d", compactMetadataVersion, data[0]>>4)
+	}
+
+	h, err := ParseHeader(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Timestamp != msg.Header.Timestamp || !bytes.Equal(h.MessageID, msg.Header.MessageID) {
+		t.Errorf("Expected timestamp %d and ID %q, got %d and %q", msg.Header.Timestamp, msg.Header.MessageID, h.Timestamp, h.MessageID)
+	}
+
+	decoded, err := dec.Unmarshal(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := bob.Receive(decoded, nil)
+
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if !plain.Metadata.Timestamp.Equal(meta.Timestamp) || !bytes.Equal(plain.Metadata.ID, meta.ID) {
+		t.Errorf("Expected %v, got %v", meta, plain.Metadata)
+	}
+}
+
+// TestCompactEscrow verifies that the escrow block of a message survives the compact
+// encoding, so that the escrow key holder can still open the decoded message.
+func TestCompactEscrow(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, doubleratchet.WithEscrow(escrowPri.PublicKey(), doubleratchet.EscrowAcknowledgement))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	var (
+		enc CompactEncoder
+		dec CompactDecoder
+	)
+
+	msg, err := alice.Send([]byte("audited"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := enc.Marshal(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0]>>4 != compactEscrowVersion {
+		t.Errorf("Expected version %d for an escrowed message, got %d", compactEscrowVersion, data[0]>>4)
+	}
+
+	decoded, err := dec.Unmarshal(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded.Escrow, msg.Escrow) {
+		t.Errorf("Expected escrow block %x, got %x", msg.Escrow, decoded.Escrow)
+	}
+
+	if plaintext, err := doubleratchet.OpenEscrow(escrowPri, decoded, nil); err != nil || string(plaintext) != "audited" {
+		t.Errorf("Expected the escrow key to open the decoded message, got %q, %v", plaintext, err)
+	}
+
+	if plain, err := bob.Receive(decoded, nil); err != nil || string(plain.Plaintext) != "audited" {
+		t.Errorf("Expected the decoded message to decrypt, got %q, %v", plain.Plaintext, err)
+	}
+
+	if _, err := dec.Unmarshal(data[:len(data)-len(msg.Ciphertext)-1]); !errors.Is(err, ErrShortMessage) {
+		t.Errorf("Expected ErrShortMessage for a truncated escrow block, got %v", err)
+	}
+}
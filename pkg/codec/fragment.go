@@ -0,0 +1,150 @@
+package codec
+
+import (
+	"errors"
+)
+
+const (
+	// fragmentHeaderSize is the size of the header prepended to every fragment:
+	// message ID, fragment index and fragment count.
+	fragmentHeaderSize = 3
+
+	// maxFragments is the maximum number of fragments a message can be split into.
+	maxFragments = 255
+
+	// DefaultMaxPending is the default number of partially received messages a Reassembler keeps.
+	DefaultMaxPending = 4
+)
+
+var (
+	// ErrMTUTooSmall is returned when the MTU cannot hold a fragment header and any payload.
+	ErrMTUTooSmall = errors.New("codec: MTU too small")
+
+	// ErrTooManyFragments is returned when a message would need more than 255 fragments.
+	ErrTooManyFragments = errors.New("codec: message needs too many fragments")
+
+	// ErrMalformedFragment is returned when a fragment header is inconsistent.
+	ErrMalformedFragment = errors.New("codec: malformed fragment")
+)
+
+// Fragment splits an encoded message into fragments that each fit in mtu bytes. Every
+// fragment carries a 3-byte header with the message ID, its index and the total count.
+func Fragment(id byte, data []byte, mtu int) ([][]byte, error) {
+	chunk := mtu - fragmentHeaderSize
+
+	if chunk <= 0 {
+		return nil, ErrMTUTooSmall
+	}
+
+	count := max(1, (len(data)+chunk-1)/chunk)
+
+	if count > maxFragments {
+		return nil, ErrTooManyFragments
+	}
+
+	fragments := make([][]byte, 0, count)
+
+	for i := range count {
+		part := data[i*chunk : min(len(data), (i+1)*chunk)]
+
+		fragment := make([]byte, 0, fragmentHeaderSize+len(part))
+		fragment = append(fragment, id, byte(i), byte(count))
+
+		fragments = append(fragments, append(fragment, part...))
+	}
+
+	return fragments, nil
+}
+
+// Reassembler rebuilds messages from fragments produced by Fragment. Fragments may arrive
+// in any order; when more than MaxPending messages are incomplete, the oldest is dropped.
+type Reassembler struct {
+	// MaxPending bounds the number of incomplete messages kept. Zero means DefaultMaxPending.
+	MaxPending int
+
+	pending []*partialMessage
+}
+
+// partialMessage collects the fragments of one message.
+type partialMessage struct {
+	id       byte
+	parts    [][]byte
+	received int
+}
+
+// Add processes a fragment. It returns the reassembled message once all of its fragments
+// have arrived, or nil otherwise.
+func (r *Reassembler) Add(fragment []byte) ([]byte, error) {
+	if len(fragment) < fragmentHeaderSize {
+		return nil, ErrMalformedFragment
+	}
+
+	id, index, count := fragment[0], int(fragment[1]), int(fragment[2])
+
+	if count == 0 || index >= count {
+		return nil, ErrMalformedFragment
+	}
+
+	msg := r.find(id, count)
+
+	if msg.parts[index] == nil {
+		msg.parts[index] = append([]byte{}, fragment[fragmentHeaderSize:]...)
+		msg.received++
+	}
+
+	if msg.received < count {
+		return nil, nil
+	}
+
+	r.remove(msg)
+
+	var data []byte
+
+	for _, part := range msg.parts {
+		data = append(data, part...)
+	}
+
+	return data, nil
+}
+
+// find returns the pending message with the given ID, creating it if needed. A pending
+// message with the same ID but a different fragment count is replaced.
+func (r *Reassembler) find(id byte, count int) *partialMessage {
+	for _, msg := range r.pending {
+		if msg.id == id {
+			if len(msg.parts) == count {
+				return msg
+			}
+
+			r.remove(msg)
+
+			break
+		}
+	}
+
+	limit := r.MaxPending
+
+	if limit <= 0 {
+		limit = DefaultMaxPending
+	}
+
+	if len(r.pending) >= limit {
+		r.pending = r.pending[1:]
+	}
+
+	msg := &partialMessage{id: id, parts: make([][]byte, count)}
+
+	r.pending = append(r.pending, msg)
+
+	return msg
+}
+
+// remove drops a pending message.
+func (r *Reassembler) remove(target *partialMessage) {
+	for i, msg := range r.pending {
+		if msg == target {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestFragmentAndReassembleOutOfOrder verifies that a message split to fit a small MTU
+// is rebuilt correctly when its fragments arrive shuffled and duplicated.
+func TestFragmentAndReassembleOutOfOrder(t *testing.T) {
+	data := bytes.Repeat([]byte("lorawan"), 40)
+
+	fragments, err := Fragment(9, data, 51)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range fragments {
+		if len(f) > 51 {
+			t.Fatalf("Fragment of %d bytes exceeds MTU", len(f))
+		}
+	}
+
+	if len(fragments) != 6 {
+		t.Fatalf("Expected 6 fragments, got %d", len(fragments))
+	}
+
+	var r Reassembler
+
+	order := []int{3, 0, 5, 0, 1, 4, 2}
+
+	for _, i := range order[:len(order)-1] {
+		if out, err := r.Add(fragments[i]); err != nil || out != nil {
+			t.Fatalf("Unexpected early result: %v %v", out, err)
+		}
+	}
+
+	out, err := r.Add(fragments[order[len(order)-1]])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatal("Reassembled data does not match")
+	}
+}
+
+// TestFragmentLimits verifies MTU and fragment-count validation, and that the reassembler
+// evicts the oldest incomplete message when too many are pending.
+func TestFragmentLimits(t *testing.T) {
+	if _, err := Fragment(0, []byte("data"), fragmentHeaderSize); !errors.Is(err, ErrMTUTooSmall) {
+		t.Errorf("Expected ErrMTUTooSmall, got %v", err)
+	}
+
+	if _, err := Fragment(0, make([]byte, 256), fragmentHeaderSize+1); !errors.Is(err, ErrTooManyFragments) {
+		t.Errorf("Expected ErrTooManyFragments, got %v", err)
+	}
+
+	r := Reassembler{MaxPending: 1}
+
+	first, _ := Fragment(1, []byte("first message"), 8)
+	second, _ := Fragment(2, []byte("second message"), 8)
+
+	r.Add(first[0])
+	r.Add(second[0])
+
+	for _, f := range first[1:] {
+		if out, _ := r.Add(f); out != nil && bytes.Equal(out, []byte("first message")) {
+			t.Error("Evicted message should not be reassembled")
+		}
+	}
+
+	if _, err := r.Add([]byte{1, 2, 2}); !errors.Is(err, ErrMalformedFragment) {
+		t.Errorf("Expected ErrMalformedFragment, got %v", err)
+	}
+}
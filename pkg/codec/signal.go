@@ -0,0 +1,281 @@
+package codec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+const (
+	// SignalVersion is the message version written by MarshalSignal, that of libsignal
+	// sessions established with X3DH. ParseSignalMessage also accepts version 4, written
+	// by sessions established with PQXDH.
+	SignalVersion = 3
+
+	// SignalMACSize is the size of the truncated HMAC that ends a SignalMessage.
+	SignalMACSize = 8
+
+	// djbType is the type byte libsignal prepends to Curve25519 public keys.
+	djbType = 0x05
+
+	// maxSignalVersion is the newest message version ParseSignalMessage accepts.
+	maxSignalVersion = 4
+)
+
+// Field numbers of the SignalMessage protobuf.
+const (
+	signalRatchetKey      = 1
+	signalCounter         = 2
+	signalPreviousCounter = 3
+	signalCiphertext      = 4
+)
+
+var (
+	// ErrSignalUnsupported is returned by NewSignalMessage for a message whose header
+	// carries fields the SignalMessage format has no room for, such as padding, a message
+	// type or metadata.
+	ErrSignalUnsupported = errors.New("codec: header fields not representable as a SignalMessage")
+
+	// ErrMalformedSignal is returned by ParseSignalMessage for data that is not a valid
+	// SignalMessage.
+	ErrMalformedSignal = errors.New("codec: malformed SignalMessage")
+)
+
+// SignalMessage is a message in the wire format of libsignal's SignalMessage (formerly
+// WhisperMessage): a version byte, a protobuf holding the sender's ratchet key, the
+// counters and the ciphertext, and a truncated MAC.
+//
+// Only the encoding is shared with libsignal. To exchange messages with a Signal-protocol
+// stack, both sides must also agree on the curve, the KDFs, the encryption and the MAC;
+// see the README's section on libsignal compatibility. Between goratchet sessions the
+// ciphertext is authenticated by the session's AEAD and the MAC may be left zero.
+type SignalMessage struct {
+	// Version is the message version, SignalVersion if zero.
+	Version uint8
+
+	// RatchetKey is the sender's ratchet public key, as in doubleratchet.Header.DH.
+	// Curve25519 keys are sent with libsignal's type byte, 0x05, which is stripped on
+	// parsing; keys of other curves are sent as they are.
+	RatchetKey []byte
+
+	// Counter and PreviousCounter are the header's N and PN.
+	Counter         uint32
+	PreviousCounter uint32
+
+	// Ciphertext is the encrypted payload.
+	Ciphertext []byte
+
+	// MAC is the truncated HMAC-SHA256 set by SetMAC.
+	MAC [SignalMACSize]byte
+}
+
+// NewSignalMessage returns the SignalMessage of msg. It fails with ErrSignalUnsupported if
+// msg carries header padding, a header version or message type, metadata, post-quantum
+// fields or an escrowed key, none of which the format can carry.
+func NewSignalMessage(msg doubleratchet.CipheredMessage) (SignalMessage, error) {
+	h := msg.Header
+
+	if len(h.Padding) > 0 || h.PQ != nil || h.Version != 0 || h.Type != doubleratchet.MessageNormal ||
+		h.Timestamp != 0 || len(h.MessageID) > 0 || len(msg.Escrow) > 0 {
+		return SignalMessage{}, ErrSignalUnsupported
+	}
+
+	return SignalMessage{
+		RatchetKey:      h.DH,
+		Counter:         h.N,
+		PreviousCounter: h.PN,
+		Ciphertext:      msg.Ciphertext,
+	}, nil
+}
+
+// CipheredMessage returns the message to pass to Receive.
+func (m SignalMessage) CipheredMessage() doubleratchet.CipheredMessage {
+	return doubleratchet.CipheredMessage{
+		Header: doubleratchet.Header{
+			DH: append([]byte(nil), m.RatchetKey...),
+			N:  m.Counter,
+			PN: m.PreviousCounter,
+		},
+		Ciphertext: append([]byte(nil), m.Ciphertext...),
+	}
+}
+
+// Marshal encodes m, including its MAC.
+func (m SignalMessage) Marshal() []byte {
+	return append(m.body(), m.MAC[:]...)
+}
+
+// SetMAC computes the MAC of m as libsignal does: HMAC-SHA256 under macKey over the
+// sender's and the receiver's serialized identity keys and the encoded message, truncated
+// to SignalMACSize bytes. libsignal derives macKey from the message key and serializes
+// Curve25519 identity keys with their 0x05 type byte.
+func (m *SignalMessage) SetMAC(macKey, senderIdentity, receiverIdentity []byte) {
+	copy(m.MAC[:], m.mac(macKey, senderIdentity, receiverIdentity))
+}
+
+// VerifyMAC reports whether the MAC of m is the one SetMAC computes for the same keys.
+func (m SignalMessage) VerifyMAC(macKey, senderIdentity, receiverIdentity []byte) bool {
+	return hmac.Equal(m.MAC[:], m.mac(macKey, senderIdentity, receiverIdentity))
+}
+
+// mac returns the truncated MAC of m.
+func (m SignalMessage) mac(macKey, senderIdentity, receiverIdentity []byte) []byte {
+	h := hmac.New(sha256.New, macKey)
+	h.Write(senderIdentity)
+	h.Write(receiverIdentity)
+	h.Write(m.body())
+
+	return h.Sum(nil)[:SignalMACSize]
+}
+
+// body encodes the version byte and the protobuf, which the MAC covers.
+func (m SignalMessage) body() []byte {
+	version := m.Version
+
+	if version == 0 {
+		version = SignalVersion
+	}
+
+	key := m.RatchetKey
+
+	if len(key) == 32 {
+		key = append([]byte{djbType}, key...)
+	}
+
+	buf := make([]byte, 0, 1+2*binary.MaxVarintLen32+len(key)+len(m.Ciphertext)+16+SignalMACSize)
+	buf = append(buf, version<<4|version)
+	buf = appendProtoBytes(buf, signalRatchetKey, key)
+	buf = appendProtoUint(buf, signalCounter, m.Counter)
+	buf = appendProtoUint(buf, signalPreviousCounter, m.PreviousCounter)
+
+	return appendProtoBytes(buf, signalCiphertext, m.Ciphertext)
+}
+
+// ParseSignalMessage decodes a SignalMessage. Unknown protobuf fields are skipped, as
+// protobuf decoders do.
+func ParseSignalMessage(data []byte) (SignalMessage, error) {
+	if len(data) < 1+SignalMACSize {
+		return SignalMessage{}, ErrShortMessage
+	}
+
+	version := data[0] >> 4
+
+	if version < SignalVersion || version > maxSignalVersion {
+		return SignalMessage{}, ErrUnsupportedVersion
+	}
+
+	m := SignalMessage{Version: version}
+	body := data[1 : len(data)-SignalMACSize]
+
+	copy(m.MAC[:], data[len(data)-SignalMACSize:])
+
+	for len(body) > 0 {
+		tag, n := binary.Uvarint(body)
+
+		if n <= 0 {
+			return SignalMessage{}, ErrMalformedSignal
+		}
+
+		body = body[n:]
+
+		switch field, wire := tag>>3, tag&7; wire {
+		case 0:
+			v, n := binary.Uvarint(body)
+
+			if n <= 0 {
+				return SignalMessage{}, ErrMalformedSignal
+			}
+
+			body = body[n:]
+
+			switch field {
+			case signalCounter:
+				m.Counter = uint32(v) // #nosec G115 -- uint32 fields are truncated, as protobuf decoders do
+			case signalPreviousCounter:
+				m.PreviousCounter = uint32(v) // #nosec G115 -- uint32 fields are truncated, as protobuf decoders do
+			}
+		case 2:
+			size, n := binary.Uvarint(body)
+
+			if n <= 0 || size > uint64(len(body)-n) {
+				return SignalMessage{}, ErrMalformedSignal
+			}
+
+			value := body[n : n+int(size)]
+			body = body[n+int(size):]
+
+			switch field {
+			case signalRatchetKey:
+				m.RatchetKey = append([]byte(nil), value...)
+			case signalCiphertext:
+				m.Ciphertext = append([]byte(nil), value...)
+			}
+		case 1, 5:
+			size := 8
+
+			if wire == 5 {
+				size = 4
+			}
+
+			if len(body) < size {
+				return SignalMessage{}, ErrMalformedSignal
+			}
+
+			body = body[size:]
+		default:
+			return SignalMessage{}, ErrMalformedSignal
+		}
+	}
+
+	if len(m.RatchetKey) == 33 && m.RatchetKey[0] == djbType {
+		m.RatchetKey = m.RatchetKey[1:]
+	}
+
+	if len(m.RatchetKey) == 0 {
+		return SignalMessage{}, ErrMalformedSignal
+	}
+
+	return m, nil
+}
+
+// MarshalSignal encodes msg as a SignalMessage with a zero MAC. It fails with
+// ErrSignalUnsupported like NewSignalMessage.
+func MarshalSignal(msg doubleratchet.CipheredMessage) ([]byte, error) {
+	m, err := NewSignalMessage(msg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Marshal(), nil
+}
+
+// UnmarshalSignal decodes a SignalMessage into the message to pass to Receive, ignoring
+// its MAC.
+func UnmarshalSignal(data []byte) (doubleratchet.CipheredMessage, error) {
+	m, err := ParseSignalMessage(data)
+
+	if err != nil {
+		return doubleratchet.CipheredMessage{}, err
+	}
+
+	return m.CipheredMessage(), nil
+}
+
+// appendProtoBytes appends a length-delimited protobuf field.
+func appendProtoBytes(buf []byte, field uint64, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+
+	return append(buf, value...)
+}
+
+// appendProtoUint appends a varint protobuf field.
+func appendProtoUint(buf []byte, field uint64, value uint32) []byte {
+	buf = binary.AppendUvarint(buf, field<<3)
+
+	return binary.AppendUvarint(buf, uint64(value))
+}
@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// TestSignalRoundTrip verifies that messages of X25519 sessions survive the SignalMessage
+// encoding, and that the encoding has libsignal's layout: the version byte, the ratchet
+// key with its type byte, the counters and the ciphertext, followed by the MAC.
+func TestSignalRoundTrip(t *testing.T) {
+	alicePri, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	curve := doubleratchet.WithCurve(ecdh.X25519())
+
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, curve)
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, curve)
+
+	msg, err := alice.Send([]byte("hello signal"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalSignal(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := append([]byte{0x33, 0x0a, 33, djbType}, msg.Header.DH...)
+
+	if !bytes.HasPrefix(data, prefix) {
+		t.Errorf("Expected the version byte and the typed ratchet key first, got %x", data[:len(prefix)])
+	}
+
+	decoded, err := UnmarshalSignal(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := bob.Receive(decoded, nil); err != nil || string(got.Plaintext) != "hello signal" {
+		t.Errorf("Expected the decoded message to decrypt, got %q, %v", got.Plaintext, err)
+	}
+}
+
+// TestSignalMAC verifies that the MAC covers the message and both identity keys.
+func TestSignalMAC(t *testing.T) {
+	macKey := []byte("mac key")
+	sender, receiver := []byte("sender identity"), []byte("receiver identity")
+
+	m := SignalMessage{RatchetKey: make([]byte, 32), Counter: 5, PreviousCounter: 2, Ciphertext: []byte("ciphertext")}
+	m.SetMAC(macKey, sender, receiver)
+
+	parsed, err := ParseSignalMessage(m.Marshal())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Version != SignalVersion || parsed.Counter != 5 || parsed.PreviousCounter != 2 || len(parsed.RatchetKey) != 32 {
+		t.Errorf("Expected the fields to survive a round trip, got %+v", parsed)
+	}
+
+	if !parsed.VerifyMAC(macKey, sender, receiver) {
+		t.Error("Expected the MAC to verify")
+	}
+
+	if parsed.VerifyMAC(macKey, receiver, sender) {
+		t.Error("Expected the MAC to fail with the identity keys swapped")
+	}
+
+	parsed.Counter++
+
+	if parsed.VerifyMAC(macKey, sender, receiver) {
+		t.Error("Expected the MAC to fail for an altered message")
+	}
+}
+
+// TestSignalUnsupportedAndMalformed verifies that headers the format cannot carry are
+// refused and that malformed input is rejected.
+func TestSignalUnsupportedAndMalformed(t *testing.T) {
+	msg := doubleratchet.CipheredMessage{Header: doubleratchet.Header{DH: []byte("key"), Type: doubleratchet.MessageType(1)}}
+
+	if _, err := MarshalSignal(msg); !errors.Is(err, ErrSignalUnsupported) {
+		t.Errorf("Expected ErrSignalUnsupported, got %v", err)
+	}
+
+	valid := SignalMessage{RatchetKey: []byte("key"), Ciphertext: []byte("ct")}.Marshal()
+
+	cases := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"short":     {valid[:4], ErrShortMessage},
+		"version":   {append([]byte{0x22}, valid[1:]...), ErrUnsupportedVersion},
+		"truncated": {append(valid[:len(valid)-SignalMACSize-1:len(valid)-SignalMACSize-1], make([]byte, SignalMACSize)...), ErrMalformedSignal},
+		"no key":    {append([]byte{0x33, 0x10, 1}, make([]byte, SignalMACSize)...), ErrMalformedSignal},
+	}
+
+	for name, c := range cases {
+		if _, err := ParseSignalMessage(c.data); !errors.Is(err, c.err) {
+			t.Errorf("%s: Expected %v, got %v", name, c.err, err)
+		}
+	}
+}
@@ -6,6 +6,12 @@ import (
 	"crypto/rand"
 	"errors"
 	"io"
+	"slices"
+)
+
+const (
+	// Overhead is the number of bytes Encrypt adds to a plaintext: a 12-byte GCM nonce and a 16-byte tag.
+	Overhead = 12 + 16
 )
 
 var (
@@ -15,6 +21,19 @@ var (
 
 // Encrypt uses the Message Key to encrypt plaintext with associated data.
 func Encrypt(mk MessageKey, plaintext, ad []byte) ([]byte, error) {
+	return EncryptWithRand(rand.Reader, mk, plaintext, ad)
+}
+
+// EncryptWithRand is like Encrypt, but reads the nonce from random. It exists for
+// reproducible tests; production code should use Encrypt.
+func EncryptWithRand(random io.Reader, mk MessageKey, plaintext, ad []byte) ([]byte, error) {
+	return EncryptTo(nil, random, mk, plaintext, ad)
+}
+
+// EncryptTo is like EncryptWithRand, but appends the ciphertext to dst and returns the
+// extended slice, so that callers can reuse a buffer across messages. dst must not overlap
+// plaintext.
+func EncryptTo(dst []byte, random io.Reader, mk MessageKey, plaintext, ad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(mk[:])
 
 	if err != nil {
@@ -27,19 +46,25 @@ func Encrypt(mk MessageKey, plaintext, ad []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
+	out := slices.Grow(dst, gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	nonce := out[len(dst) : len(dst)+gcm.NonceSize()]
 
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	if _, err := io.ReadFull(random, nonce); err != nil {
 		return nil, err
 	}
 
-	ciphertext := gcm.Seal(nil, nonce, plaintext, ad)
-
-	return append(nonce, ciphertext...), nil
+	return gcm.Seal(out[:len(dst)+len(nonce)], nonce, plaintext, ad), nil
 }
 
-// Decrypt uses the Message Key to decrypt ciphertext with associated data.
+// Decrypt uses the Message Key to decrypt ciphertext with associated data. It returns
+// ErrAuthenticationFailed when the ciphertext or associated data were altered.
 func Decrypt(mk MessageKey, ciphertextWithNonce, ad []byte) ([]byte, error) {
+	return DecryptTo(nil, mk, ciphertextWithNonce, ad)
+}
+
+// DecryptTo is like Decrypt, but appends the plaintext to dst and returns the extended
+// slice. dst must not overlap ciphertextWithNonce.
+func DecryptTo(dst []byte, mk MessageKey, ciphertextWithNonce, ad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(mk[:])
 
 	if err != nil {
@@ -60,5 +85,11 @@ func Decrypt(mk MessageKey, ciphertextWithNonce, ad []byte) ([]byte, error) {
 
 	nonce, ciphertext := ciphertextWithNonce[:nonceSize], ciphertextWithNonce[nonceSize:]
 
-	return gcm.Open(nil, nonce, ciphertext, ad)
+	plaintext, err := gcm.Open(dst, nonce, ciphertext, ad)
+
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+
+	return plaintext, nil
 }
@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/rand"
 	"testing"
 )
 
@@ -56,12 +57,12 @@ func TestAESGCMDecryptionErrorHandling(t *testing.T) {
 
 	corrupted[len(corrupted)-1] ^= 0xFF
 
-	if _, err := Decrypt(mk, corrupted, ad); err == nil {
-		t.Error("Expected error for corrupted ciphertext, got nil")
+	if _, err := Decrypt(mk, corrupted, ad); err != ErrAuthenticationFailed {
+		t.Errorf("Expected ErrAuthenticationFailed for corrupted ciphertext, got %v", err)
 	}
 
-	if _, err := Decrypt(mk, ciphertext, []byte("Wrong AD")); err == nil {
-		t.Error("Expected error for wrong AD, got nil")
+	if _, err := Decrypt(mk, ciphertext, []byte("Wrong AD")); err != ErrAuthenticationFailed {
+		t.Errorf("Expected ErrAuthenticationFailed for wrong AD, got %v", err)
 	}
 }
 
@@ -184,3 +185,70 @@ func TestAESGCMEncryptionNonDeterministic(t *testing.T) {
 		t.Error("Expected ciphertexts to differ on multiple encryptions")
 	}
 }
+
+// TestAESGCMCiphertextOverhead verifies that Encrypt always expands the plaintext by
+// exactly Overhead bytes, which framing layers rely on to predict ciphertext sizes.
+func TestAESGCMCiphertextOverhead(t *testing.T) {
+	var mk MessageKey
+
+	copy(mk[:], []byte("01234567890123456789012345678901"))
+
+	for _, size := range []int{0, 1, 100, 4096} {
+		ct, err := Encrypt(mk, make([]byte, size), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ct) != size+Overhead {
+			t.Errorf("Size %d: expected ciphertext length %d, got %d", size, size+Overhead, len(ct))
+		}
+	}
+}
+
+// TestAESGCMEncryptToDecryptToAppend verifies that EncryptTo and DecryptTo append to the
+// given buffers, keeping their existing contents and reusing their capacity, and that
+// their output round-trips with Encrypt and Decrypt.
+func TestAESGCMEncryptToDecryptToAppend(t *testing.T) {
+	var mk MessageKey
+
+	copy(mk[:], []byte("01234567890123456789012345678901"))
+
+	plaintext := []byte("Hello World")
+	ad := []byte("Associated Data")
+
+	buf := make([]byte, 3, 128)
+	copy(buf, "abc")
+
+	ciphertext, err := EncryptTo(buf, rand.Reader, mk, plaintext, ad)
+
+	if err != nil {
+		t.Fatalf("EncryptTo failed: %v", err)
+	}
+
+	if string(ciphertext[:3]) != "abc" || len(ciphertext) != 3+len(plaintext)+Overhead {
+		t.Fatalf("Expected the ciphertext appended to the prefix, got %x", ciphertext)
+	}
+
+	if &ciphertext[0] != &buf[0] {
+		t.Error("Expected EncryptTo to reuse the buffer's capacity")
+	}
+
+	decrypted, err := Decrypt(mk, ciphertext[3:], ad)
+
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("Expected %s, got %s (%v)", plaintext, decrypted, err)
+	}
+
+	out := make([]byte, 0, 64)
+
+	decrypted, err = DecryptTo(out, mk, ciphertext[3:], ad)
+
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("Expected %s, got %s (%v)", plaintext, decrypted, err)
+	}
+
+	if &decrypted[0] != &out[:1][0] {
+		t.Error("Expected DecryptTo to reuse the buffer's capacity")
+	}
+}
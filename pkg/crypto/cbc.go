@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+const (
+	// cbcKeysSize is the size of the keys derived from a message key for EncryptCBC: a
+	// 32-byte AES-256 key, a 32-byte HMAC-SHA256 key and a 16-byte IV.
+	cbcKeysSize = 32 + 32 + aes.BlockSize
+)
+
+var (
+	// ErrAuthenticationFailed is returned by Decrypt and DecryptCBC when the ciphertext or
+	// associated data were altered.
+	ErrAuthenticationFailed = errors.New("crypto: message authentication failed")
+
+	// ErrInvalidCBCPadding is returned by DecryptCBC for an authenticated ciphertext that
+	// does not carry valid PKCS#7 padding.
+	ErrInvalidCBCPadding = errors.New("crypto: invalid CBC padding")
+)
+
+// EncryptCBC implements the ENCRYPT function recommended by the Double Ratchet
+// specification. HKDF-SHA256 with a zero salt and info derives an encryption key, an
+// authentication key and an IV from mk; the PKCS#7-padded plaintext is encrypted with
+// AES-256-CBC, and HMAC-SHA256 over ad and the ciphertext is appended to it.
+func EncryptCBC(mk MessageKey, plaintext, ad, info []byte) ([]byte, error) {
+	encKey, authKey, iv, err := deriveCBCKeys(mk, info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(encKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...) // #nosec G115 -- padding is at most aes.BlockSize
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	return append(ciphertext, cbcMAC(authKey, ad, ciphertext)...), nil
+}
+
+// DecryptCBC decrypts a ciphertext produced by EncryptCBC with the same info, verifying
+// the HMAC before decrypting.
+func DecryptCBC(mk MessageKey, ciphertextWithMAC, ad, info []byte) ([]byte, error) {
+	if len(ciphertextWithMAC) < aes.BlockSize+sha256.Size {
+		return nil, ErrCiphertextTooShort
+	}
+
+	encKey, authKey, iv, err := deriveCBCKeys(mk, info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	split := len(ciphertextWithMAC) - sha256.Size
+	ciphertext, mac := ciphertextWithMAC[:split], ciphertextWithMAC[split:]
+
+	if !hmac.Equal(mac, cbcMAC(authKey, ad, ciphertext)) || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrAuthenticationFailed
+	}
+
+	block, err := aes.NewCipher(encKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) { // #nosec G115 -- padding is at most aes.BlockSize
+		return nil, ErrInvalidCBCPadding
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// deriveCBCKeys derives the encryption key, authentication key and IV for EncryptCBC.
+func deriveCBCKeys(mk MessageKey, info []byte) (encKey, authKey, iv []byte, err error) {
+	keys, err := DeriveHKDF(mk[:], nil, info, cbcKeysSize)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return keys[:32], keys[32:64], keys[64:], nil
+}
+
+// cbcMAC returns HMAC-SHA256 over ad and ciphertext.
+func cbcMAC(authKey, ad, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, authKey)
+
+	mac.Write(ad)
+	mac.Write(ciphertext)
+
+	return mac.Sum(nil)
+}
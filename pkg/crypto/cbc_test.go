@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestCBCEncryptDecryptRoundTrip verifies that EncryptCBC and DecryptCBC round trip
+// plaintexts of every length around the block size, that encryption is deterministic for
+// a message key, and that the ciphertext depends on the info label.
+func TestCBCEncryptDecryptRoundTrip(t *testing.T) {
+	var mk MessageKey
+
+	copy(mk[:], []byte("01234567890123456789012345678901"))
+
+	info := []byte("test-info")
+	ad := []byte("Associated Data")
+
+	for size := 0; size <= 33; size++ {
+		plaintext := bytes.Repeat([]byte{'x'}, size)
+
+		ciphertext, err := EncryptCBC(mk, plaintext, ad, info)
+
+		if err != nil {
+			t.Fatalf("EncryptCBC failed: %v", err)
+		}
+
+		decrypted, err := DecryptCBC(mk, ciphertext, ad, info)
+
+		if err != nil {
+			t.Fatalf("DecryptCBC failed for %d bytes: %v", size, err)
+		}
+
+		if !bytes.Equal(plaintext, decrypted) {
+			t.Errorf("Expected %q, got %q", plaintext, decrypted)
+		}
+	}
+
+	first, _ := EncryptCBC(mk, []byte("hello"), ad, info)
+	second, _ := EncryptCBC(mk, []byte("hello"), ad, info)
+	other, _ := EncryptCBC(mk, []byte("hello"), ad, []byte("other-info"))
+
+	if !bytes.Equal(first, second) {
+		t.Error("Expected encryption under the same message key to be deterministic")
+	}
+
+	if bytes.Equal(first, other) {
+		t.Error("Expected a different info label to produce a different ciphertext")
+	}
+}
+
+// TestCBCDecryptRejectsTampering verifies that DecryptCBC rejects altered ciphertexts,
+// MACs and associated data, and ciphertexts too short to hold a block and a MAC.
+func TestCBCDecryptRejectsTampering(t *testing.T) {
+	var mk MessageKey
+
+	info := []byte("test-info")
+	ciphertext, _ := EncryptCBC(mk, []byte("Hello World"), []byte("ad"), info)
+
+	if _, err := DecryptCBC(mk, ciphertext, []byte("other"), info); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed for wrong associated data, got %v", err)
+	}
+
+	for _, i := range []int{0, len(ciphertext) - 1} {
+		tampered := bytes.Clone(ciphertext)
+		tampered[i] ^= 1
+
+		if _, err := DecryptCBC(mk, tampered, []byte("ad"), info); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("Expected ErrAuthenticationFailed for a flipped byte at %d, got %v", i, err)
+		}
+	}
+
+	if _, err := DecryptCBC(mk, ciphertext[:40], []byte("ad"), info); !errors.Is(err, ErrCiphertextTooShort) {
+		t.Errorf("Expected ErrCiphertextTooShort, got %v", err)
+	}
+}
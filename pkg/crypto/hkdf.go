@@ -0,0 +1,18 @@
+//go:build go1.24
+
+package crypto
+
+import (
+	"crypto/hkdf"
+	"hash"
+)
+
+// hkdfExtract is HKDF-Extract of crypto/hkdf.
+func hkdfExtract(h func() hash.Hash, secret, salt []byte) ([]byte, error) {
+	return hkdf.Extract(h, secret, salt)
+}
+
+// hkdfExpand is HKDF-Expand of crypto/hkdf.
+func hkdfExpand(h func() hash.Hash, prk, info []byte, length int) ([]byte, error) {
+	return hkdf.Expand(h, prk, string(info), length)
+}
@@ -0,0 +1,46 @@
+//go:build !go1.24
+
+package crypto
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// hkdfExtract is HKDF-Extract of RFC 5869, for Go releases before 1.24, which lack
+// crypto/hkdf. It is checked against the same test vectors.
+func hkdfExtract(h func() hash.Hash, secret, salt []byte) ([]byte, error) {
+	if salt == nil {
+		salt = make([]byte, h().Size())
+	}
+
+	mac := hmac.New(h, salt)
+
+	mac.Write(secret)
+
+	return mac.Sum(nil), nil
+}
+
+// hkdfExpand is HKDF-Expand of RFC 5869, for Go releases before 1.24. HKDF.Expand checks
+// the length.
+func hkdfExpand(h func() hash.Hash, prk, info []byte, length int) ([]byte, error) {
+	var okm, t []byte
+
+	counter := byte(1)
+
+	mac := hmac.New(h, prk)
+
+	for len(okm) < length {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+
+		t = mac.Sum(nil)
+		okm = append(okm, t...)
+
+		counter++
+	}
+
+	return okm[:length], nil
+}
@@ -3,24 +3,54 @@ package crypto
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"errors"
+	"hash"
+)
+
+var (
+	// ErrHKDFLength is returned by HKDF.Expand and DeriveHKDF for outputs of negative
+	// length or longer than 255 hash blocks.
+	ErrHKDFLength = errors.New("crypto: invalid HKDF output length")
 )
 
 // DeriveRK performs the KDF for the Root Key.
-func DeriveRK(rk ChainKey, dhOut []byte) (ChainKey, ChainKey) {
-	keys := DeriveHKDF(dhOut, rk[:], []byte("DoubleRatchet-Root"), 64)
+func DeriveRK(rk ChainKey, dhOut []byte) (ChainKey, ChainKey, error) {
+	return DeriveRKWith(sha256.New, rk, dhOut)
+}
+
+// DeriveRKWith is like DeriveRK, but uses the hash function h instead of SHA-256.
+func DeriveRKWith(h func() hash.Hash, rk ChainKey, dhOut []byte) (ChainKey, ChainKey, error) {
+	return DeriveRKInfo(h, []byte("DoubleRatchet-Root"), rk, dhOut)
+}
 
+// DeriveRKInfo is like DeriveRKWith, but uses info as the HKDF info string instead of
+// "DoubleRatchet-Root".
+func DeriveRKInfo(h func() hash.Hash, info []byte, rk ChainKey, dhOut []byte) (ChainKey, ChainKey, error) {
 	var nextRk, nextCk ChainKey
 
+	keys, err := DeriveHKDFWith(h, dhOut, rk[:], info, 64)
+
+	if err != nil {
+		return nextRk, nextCk, err
+	}
+
 	copy(nextRk[:], keys[0:32])
 	copy(nextCk[:], keys[32:64])
+	clear(keys)
 
-	return nextRk, nextCk
+	return nextRk, nextCk, nil
 }
 
 // DeriveCK performs the KDF for the Chain Key.
 func DeriveCK(ck ChainKey) (ChainKey, MessageKey) {
+	return DeriveCKWith(sha256.New, ck)
+}
+
+// DeriveCKWith is like DeriveCK, but uses HMAC with the hash function h instead of
+// HMAC-SHA256. Outputs longer than a key are truncated.
+func DeriveCKWith(h func() hash.Hash, ck ChainKey) (ChainKey, MessageKey) {
 	// Message Key derivation
-	mac := hmac.New(sha256.New, ck[:])
+	mac := hmac.New(h, ck[:])
 
 	mac.Write([]byte{0x01})
 	mkBytes := mac.Sum(nil)
@@ -28,6 +58,7 @@ func DeriveCK(ck ChainKey) (ChainKey, MessageKey) {
 	var mk MessageKey
 
 	copy(mk[:], mkBytes)
+	clear(mkBytes)
 
 	// Next Chain Key derivation
 	mac.Reset()
@@ -38,41 +69,61 @@ func DeriveCK(ck ChainKey) (ChainKey, MessageKey) {
 	var nextCk ChainKey
 
 	copy(nextCk[:], ckBytes)
+	clear(ckBytes)
 
 	return nextCk, mk
 }
 
-// DeriveHKDF implements a simple HKDF-SHA256 expansion.
-func DeriveHKDF(secret, salt, info []byte, length int) []byte {
-	// Extract
-	if salt == nil {
-		salt = make([]byte, sha256.Size)
-	}
+// DeriveHKDF implements HKDF-SHA256 (RFC 5869) with a nil salt meaning a zero-filled one.
+// It fails with ErrHKDFLength for a negative length or one above 255 times the hash size.
+func DeriveHKDF(secret, salt, info []byte, length int) ([]byte, error) {
+	return DeriveHKDFWith(sha256.New, secret, salt, info, length)
+}
 
-	mac := hmac.New(sha256.New, salt)
+// DeriveHKDFWith is like DeriveHKDF, but uses the hash function h instead of SHA-256.
+func DeriveHKDFWith(h func() hash.Hash, secret, salt, info []byte, length int) ([]byte, error) {
+	kdf := HKDF{Hash: h}
 
-	mac.Write(secret)
-	prk := mac.Sum(nil)
+	prk, err := kdf.Extract(salt, secret)
 
-	// Expand
-	var okm []byte
-	var t []byte
+	if err != nil {
+		return nil, err
+	}
 
-	counter := byte(1)
+	defer clear(prk)
 
-	mac = hmac.New(sha256.New, prk)
+	return kdf.Expand(prk, info, length)
+}
 
-	for len(okm) < length {
-		mac.Reset()
-		mac.Write(t)
-		mac.Write(info)
-		mac.Write([]byte{counter})
+// KDF is a two-step key derivation function in the style of RFC 5869: Extract
+// concentrates input key material into a pseudorandom key, and Expand stretches it into
+// output keys bound to an info string.
+type KDF interface {
+	// Extract returns a pseudorandom key from salt and the input key material ikm.
+	Extract(salt, ikm []byte) ([]byte, error)
 
-		t = mac.Sum(nil)
-		okm = append(okm, t...)
+	// Expand returns length bytes of output key material derived from prk and info.
+	Expand(prk, info []byte, length int) ([]byte, error)
+}
+
+// HKDF is the RFC 5869 KDF with the hash function Hash, as implemented by crypto/hkdf.
+type HKDF struct {
+	Hash func() hash.Hash
+}
+
+// Extract computes HMAC-Hash(salt, ikm). A nil salt is replaced by a zero-filled one of
+// the hash size, as RFC 5869 specifies.
+func (k HKDF) Extract(salt, ikm []byte) ([]byte, error) {
+	return hkdfExtract(k.Hash, ikm, salt)
+}
 
-		counter++
+// Expand computes T(1) | T(2) | ... truncated to length bytes, where
+// T(i) = HMAC-Hash(prk, T(i-1) | info | i). It fails with ErrHKDFLength for a negative
+// length or one above 255 times the hash size.
+func (k HKDF) Expand(prk, info []byte, length int) ([]byte, error) {
+	if length < 0 || length > 255*k.Hash().Size() {
+		return nil, ErrHKDFLength
 	}
 
-	return okm[:length]
+	return hkdfExpand(k.Hash, prk, info, length)
 }
@@ -2,6 +2,10 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"testing"
 )
 
@@ -14,14 +18,14 @@ func TestHKDFBasicDerivation(t *testing.T) {
 	info := []byte("info")
 	length := 32
 
-	out := DeriveHKDF(secret, salt, info, length)
+	out, _ := DeriveHKDF(secret, salt, info, length)
 
 	if len(out) != length {
 		t.Errorf("Expected length %d, got %d", length, len(out))
 	}
 
 	// Test with nil salt
-	out2 := DeriveHKDF(secret, nil, info, length)
+	out2, _ := DeriveHKDF(secret, nil, info, length)
 
 	if len(out2) != length {
 		t.Errorf("Expected length %d, got %d", length, len(out2))
@@ -38,7 +42,7 @@ func TestRootKeyDerivation(t *testing.T) {
 
 	dhOut := []byte("dhoutput")
 
-	nextRk, nextCk := DeriveRK(rk, dhOut)
+	nextRk, nextCk, _ := DeriveRK(rk, dhOut)
 
 	if nextRk == rk {
 		t.Error("Next Root Key should be different")
@@ -81,9 +85,9 @@ func TestHKDFOutputLengthVariations(t *testing.T) {
 	lengths := []int{0, 1, 16, 32, 64, 128}
 
 	for _, l := range lengths {
-		out := DeriveHKDF(secret, salt, info, l)
+		out, err := DeriveHKDF(secret, salt, info, l)
 
-		if len(out) != l {
+		if err != nil || len(out) != l {
 			t.Errorf("Expected length %d, got %d", l, len(out))
 		}
 	}
@@ -97,8 +101,8 @@ func TestHKDFNilAndEmptySaltEquivalence(t *testing.T) {
 	info := []byte("info")
 	length := 32
 
-	out1 := DeriveHKDF(secret, nil, info, length)
-	out2 := DeriveHKDF(secret, []byte{}, info, length)
+	out1, _ := DeriveHKDF(secret, nil, info, length)
+	out2, _ := DeriveHKDF(secret, []byte{}, info, length)
 
 	if !bytes.Equal(out1, out2) {
 		t.Errorf("Expected nil and empty salt to produce same output")
@@ -116,8 +120,8 @@ func TestRootKeyDerivationUniqueness(t *testing.T) {
 	dhOut1 := []byte("dhoutput1")
 	dhOut2 := []byte("dhoutput2")
 
-	nextRk1, nextCk1 := DeriveRK(rk, dhOut1)
-	nextRk2, nextCk2 := DeriveRK(rk, dhOut2)
+	nextRk1, nextCk1, _ := DeriveRK(rk, dhOut1)
+	nextRk2, nextCk2, _ := DeriveRK(rk, dhOut2)
 
 	if nextRk1 == nextRk2 {
 		t.Error("Different DH outputs should produce different Root Keys")
@@ -183,10 +187,110 @@ func TestRootKeyAndChainKeyDerivationDeterminism(t *testing.T) {
 
 	dhOut := []byte("dhoutput")
 
-	nextRk1, nextCk1 := DeriveRK(rk, dhOut)
-	nextRk2, nextCk2 := DeriveRK(rk, dhOut)
+	nextRk1, nextCk1, _ := DeriveRK(rk, dhOut)
+	nextRk2, nextCk2, _ := DeriveRK(rk, dhOut)
 
 	if nextRk1 != nextRk2 || nextCk1 != nextCk2 {
 		t.Error("DeriveRK should be deterministic for same inputs")
 	}
 }
+
+// TestDeriveWithHash verifies that the *With variants reproduce the SHA-256 functions when
+// given SHA-256, and derive different keys with another hash.
+func TestDeriveWithHash(t *testing.T) {
+	var ck ChainKey
+
+	copy(ck[:], []byte("chainkey012345678901234567890123"))
+
+	nextCk, mk := DeriveCK(ck)
+	nextCk256, mk256 := DeriveCKWith(sha256.New, ck)
+
+	if nextCk != nextCk256 || mk != mk256 {
+		t.Error("Expected DeriveCKWith(sha256.New) to match DeriveCK")
+	}
+
+	rk, rck, _ := DeriveRK(ck, []byte("dhoutput"))
+	rk256, rck256, _ := DeriveRKWith(sha256.New, ck, []byte("dhoutput"))
+
+	if rk != rk256 || rck != rck256 {
+		t.Error("Expected DeriveRKWith(sha256.New) to match DeriveRK")
+	}
+
+	if nextCk512, mk512 := DeriveCKWith(sha512.New, ck); nextCk512 == nextCk || mk512 == mk {
+		t.Error("Expected SHA-512 to derive different chain and message keys")
+	}
+
+	if rk512, _, _ := DeriveRKWith(sha512.New, ck, []byte("dhoutput")); rk512 == rk {
+		t.Error("Expected SHA-512 to derive a different root key")
+	}
+}
+
+// TestHKDFRFC5869Vectors verifies HKDF against the SHA-256 test vectors of RFC 5869,
+// appendix A.1 and A.3, both through Extract and Expand and through DeriveHKDF.
+func TestHKDFRFC5869Vectors(t *testing.T) {
+	vectors := []struct {
+		name            string
+		ikm, salt, info string
+		length          int
+		prk, okm        string
+	}{
+		{
+			name:   "A.1",
+			ikm:    "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+			salt:   "000102030405060708090a0b0c",
+			info:   "f0f1f2f3f4f5f6f7f8f9",
+			length: 42,
+			prk:    "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+			okm:    "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			name:   "A.3",
+			ikm:    "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+			length: 42,
+			prk:    "19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+			okm:    "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+	}
+
+	kdf := HKDF{Hash: sha256.New}
+
+	for _, v := range vectors {
+		ikm, _ := hex.DecodeString(v.ikm)
+		salt, _ := hex.DecodeString(v.salt)
+		info, _ := hex.DecodeString(v.info)
+
+		prk, err := kdf.Extract(salt, ikm)
+
+		if err != nil {
+			t.Fatalf("%s: Extract failed: %v", v.name, err)
+		}
+
+		if got := hex.EncodeToString(prk); got != v.prk {
+			t.Errorf("%s: Expected PRK %s, got %s", v.name, v.prk, got)
+		}
+
+		okm, err := kdf.Expand(prk, info, v.length)
+
+		if err != nil {
+			t.Fatalf("%s: Expand failed: %v", v.name, err)
+		}
+
+		if got := hex.EncodeToString(okm); got != v.okm {
+			t.Errorf("%s: Expected OKM %s, got %s", v.name, v.okm, got)
+		}
+
+		if okm, _ := DeriveHKDF(ikm, salt, info, v.length); hex.EncodeToString(okm) != v.okm {
+			t.Errorf("%s: Expected DeriveHKDF to produce %s, got %x", v.name, v.okm, okm)
+		}
+	}
+
+	if _, err := kdf.Expand(make([]byte, 32), nil, 255*sha256.Size+1); !errors.Is(err, ErrHKDFLength) {
+		t.Errorf("Expected ErrHKDFLength, got %v", err)
+	}
+
+	for _, length := range []int{-1, 255*sha256.Size + 1} {
+		if _, err := DeriveHKDF(make([]byte, 32), nil, nil, length); !errors.Is(err, ErrHKDFLength) {
+			t.Errorf("Expected ErrHKDFLength from DeriveHKDF for length %d, got %v", length, err)
+		}
+	}
+}
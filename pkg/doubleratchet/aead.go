@@ -0,0 +1,209 @@
+package doubleratchet
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+var (
+	// ErrAuthFailed is returned by Receive when a message fails authentication: its
+	// ciphertext, header or associated data were altered, or it was not sent in this
+	// session. It wraps the error of the AEAD.
+	ErrAuthFailed = newError(ErrCrypto, "double ratchet: message authentication failed")
+)
+
+// AEAD encrypts and decrypts message payloads under message keys. The session handles the
+// key schedule and its state, and hands each message key to the AEAD, so implementations
+// can dispatch the bulk cryptography to a hardware accelerator or a crypto sidecar process.
+//
+// Both peers must use compatible implementations: the ciphertext produced by Seal is sent
+// as CipheredMessage.Ciphertext and passed to Open by the receiver unchanged. The default
+// is AES-256-GCM with a random nonce prepended to the ciphertext.
+type AEAD interface {
+	// Seal encrypts and authenticates plaintext and authenticates ad under mk. random is
+	// the session's source of randomness, which implementations may use for nonces.
+	Seal(random io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error)
+
+	// Open decrypts ciphertext under mk, failing if it or ad were altered.
+	Open(mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error)
+}
+
+// AppendAEAD is implemented by AEADs that can append their output to a caller's buffer.
+// EncryptInto and DecryptInto use it to avoid allocating per message; the output of other
+// AEADs is copied into the buffer.
+type AppendAEAD interface {
+	AEAD
+
+	// SealTo is like Seal, but appends the ciphertext to dst and returns the extended slice.
+	SealTo(dst []byte, random io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error)
+
+	// OpenTo is like Open, but appends the plaintext to dst and returns the extended slice.
+	OpenTo(dst []byte, mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error)
+}
+
+// WithAEAD makes the session encrypt and decrypt messages with aead instead of the built-in
+// AES-256-GCM. Message keys of skipped messages are also opened with aead. The AEAD is not
+// part of the serialized state and must be given again when a session is deserialized.
+// Open escrowed messages of such sessions with OpenEscrowAEAD.
+func WithAEAD(aead AEAD) Option {
+	return func(d *doubleRatchet) error {
+		d.aead = aead
+		return nil
+	}
+}
+
+// WithoutLegacyAD makes Receive refuse messages whose header is not bound into the
+// associated data, as sent by versions of this package before it bound headers, instead of
+// retrying them with the associated data as is. Use it once every peer has upgraded, so
+// that forged messages are not opened twice. The option is not part of the serialized
+// state and must be given again when a session is deserialized.
+func WithoutLegacyAD() Option {
+	return func(d *doubleRatchet) error {
+		d.noLegacyAD = true
+		return nil
+	}
+}
+
+// SoftwareAEAD is the built-in AES-256-GCM implementation of AEAD. Offloading
+// implementations can fall back to it when their accelerator is unavailable.
+type SoftwareAEAD struct{}
+
+// Seal encrypts plaintext with AES-256-GCM, prepending a nonce read from random.
+func (SoftwareAEAD) Seal(random io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error) {
+	return crypto.EncryptWithRand(random, mk, plaintext, ad)
+}
+
+// Open decrypts a ciphertext produced by Seal.
+func (SoftwareAEAD) Open(mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error) {
+	return crypto.Decrypt(mk, ciphertext, ad)
+}
+
+// SealTo is like Seal, but appends the ciphertext to dst.
+func (SoftwareAEAD) SealTo(dst []byte, random io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error) {
+	return crypto.EncryptTo(dst, random, mk, plaintext, ad)
+}
+
+// OpenTo is like Open, but appends the plaintext to dst.
+func (SoftwareAEAD) OpenTo(dst []byte, mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error) {
+	return crypto.DecryptTo(dst, mk, ciphertext, ad)
+}
+
+// defaultCBCInfo is the HKDF info of CBCHMAC when none is configured.
+const defaultCBCInfo = "DoubleRatchet-Encrypt"
+
+// CBCHMAC is the ENCRYPT function recommended by the Double Ratchet specification: keys
+// and IV derived from the message key with HKDF-SHA256, AES-256-CBC, and HMAC-SHA256 over
+// the associated data and ciphertext. Use it to exchange messages with Signal-style
+// implementations that encrypt this way; Info must match their HKDF info, such as
+// libsignal's "WhisperMessageKeys".
+//
+// Ciphertexts carry the full 32-byte HMAC. Implementations that truncate it, or that
+// authenticate a different associated data layout, need an adapter around crypto.EncryptCBC.
+type CBCHMAC struct {
+	// Info is the HKDF info string. It defaults to "DoubleRatchet-Encrypt".
+	Info []byte
+}
+
+// Seal encrypts plaintext with AES-256-CBC and appends an HMAC-SHA256. The IV is derived
+// from mk, so random is not used.
+func (c CBCHMAC) Seal(_ io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error) {
+	return crypto.EncryptCBC(mk, plaintext, ad, c.info())
+}
+
+// Open verifies the HMAC and decrypts a ciphertext produced by Seal.
+func (c CBCHMAC) Open(mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error) {
+	return crypto.DecryptCBC(mk, ciphertext, ad, c.info())
+}
+
+// info returns the configured HKDF info or the default.
+func (c CBCHMAC) info() []byte {
+	if c.Info == nil {
+		return []byte(defaultCBCInfo)
+	}
+
+	return c.Info
+}
+
+// aeadOrDefault returns the session's AEAD, or the built-in one if none is configured.
+func (d *doubleRatchet) aeadOrDefault() AEAD {
+	if d.aead == nil {
+		return SoftwareAEAD{}
+	}
+
+	return d.aead
+}
+
+// seal encrypts a message payload with the session's AEAD, appending the ciphertext to
+// dst.
+func (d *doubleRatchet) seal(dst []byte, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error) {
+	aead := d.aeadOrDefault()
+
+	if a, ok := aead.(AppendAEAD); ok {
+		return a.SealTo(dst, d.random(), mk, plaintext, ad)
+	}
+
+	ciphertext, err := aead.Seal(d.random(), mk, plaintext, ad)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dst == nil {
+		return ciphertext, nil
+	}
+
+	return append(dst, ciphertext...), nil
+}
+
+// open decrypts the payload of a message with header h. ad is the associated data before
+// the header is bound into it: the payload is opened with the header bound first, and,
+// for a header of a legacy version, then with ad as is, as sent by versions that did not
+// bind the header, unless the session was created WithoutLegacyAD. A message sent with
+// the header bound never opens without it, so the fallback cannot be used to strip the
+// binding from a message. The plaintext padding of padded versions is stripped.
+func (d *doubleRatchet) open(dst []byte, mk crypto.MessageKey, h Header, ciphertext, ad []byte) ([]byte, error) {
+	plaintext, err := d.openAD(dst, mk, ciphertext, bindHeader(ad, h))
+
+	if err == nil {
+		return unpadPlaintext(h, plaintext)
+	}
+
+	if d.noLegacyAD || !legacyHeader(h) {
+		return nil, err
+	}
+
+	if legacy, lerr := d.openAD(dst, mk, ciphertext, ad); lerr == nil {
+		return legacy, nil
+	}
+
+	return nil, err
+}
+
+// openAD decrypts a message payload with the session's AEAD, appending the plaintext to
+// dst and wrapping its failures in ErrAuthFailed.
+func (d *doubleRatchet) openAD(dst []byte, mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error) {
+	aead := d.aeadOrDefault()
+
+	var (
+		plaintext []byte
+		err       error
+	)
+
+	if a, ok := aead.(AppendAEAD); ok {
+		plaintext, err = a.OpenTo(dst, mk, ciphertext, ad)
+	} else {
+		plaintext, err = aead.Open(mk, ciphertext, ad)
+
+		if err == nil && dst != nil {
+			plaintext = append(dst, plaintext...)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	}
+
+	return plaintext, nil
+}
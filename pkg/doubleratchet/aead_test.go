@@ -0,0 +1,180 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// offloadAEAD stands in for a hardware accelerator: it counts the calls dispatched to it,
+// tags its ciphertexts and delegates the cryptography to SoftwareAEAD.
+type offloadAEAD struct {
+	seals, opens int
+	fail         error
+}
+
+func (a *offloadAEAD) Seal(random io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error) {
+	a.seals++
+
+	if a.fail != nil {
+		return nil, a.fail
+	}
+
+	ciphertext, err := SoftwareAEAD{}.Seal(random, mk, plaintext, ad)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{0xAC}, ciphertext...), nil
+}
+
+func (a *offloadAEAD) Open(mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error) {
+	a.opens++
+
+	if len(ciphertext) == 0 || ciphertext[0] != 0xAC {
+		return nil, crypto.ErrCiphertextTooShort
+	}
+
+	return SoftwareAEAD{}.Open(mk, ciphertext[1:], ad)
+}
+
+// TestAEADOffload verifies that a session with WithAEAD encrypts and decrypts every
+// message, including skipped ones, through the registered AEAD, that peers using the same
+// AEAD interoperate, and that a peer using the built-in AEAD cannot read its messages.
+func TestAEADOffload(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	aliceAEAD, bobAEAD := &offloadAEAD{}, &offloadAEAD{}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(aliceAEAD))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithAEAD(bobAEAD))
+
+	late, _ := alice.Send([]byte("late"), nil)
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	for _, m := range []CipheredMessage{msg, late} {
+		if _, err := bob.Receive(m, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	if aliceAEAD.seals != 2 || bobAEAD.opens != 2 {
+		t.Errorf("Expected 2 seals and 2 opens, got %d and %d", aliceAEAD.seals, bobAEAD.opens)
+	}
+
+	plain, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if _, err := plain.Receive(msg, nil); err == nil {
+		t.Error("Expected a peer using the built-in AEAD to reject an offloaded ciphertext")
+	}
+}
+
+// TestAEADOffloadError verifies that an error from the AEAD is returned by Send.
+func TestAEADOffloadError(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	errDevice := errors.New("device unavailable")
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(&offloadAEAD{fail: errDevice}))
+
+	if _, err := alice.Send([]byte("hello"), nil); !errors.Is(err, errDevice) {
+		t.Errorf("Expected the AEAD error, got %v", err)
+	}
+}
+
+// TestCBCHMACSession verifies that peers using CBCHMAC with the same info exchange
+// messages, including skipped ones, and that a peer using a different info rejects them.
+func TestCBCHMACSession(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	aead := CBCHMAC{Info: []byte("WhisperMessageKeys")}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(aead))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithAEAD(aead))
+
+	late, _ := alice.Send([]byte("late"), []byte("ad"))
+	msg, _ := alice.Send([]byte("hello"), []byte("ad"))
+
+	for _, m := range []CipheredMessage{msg, late} {
+		if _, err := bob.Receive(m, []byte("ad")); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	other, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithAEAD(CBCHMAC{}))
+
+	if _, err := other.Receive(msg, []byte("ad")); !errors.Is(err, crypto.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed for a different info, got %v", err)
+	}
+}
+
+// TestEncryptIntoDecryptInto verifies that EncryptInto and DecryptInto write into the
+// caller's buffers when they are large enough, including for skipped messages, and that
+// they interoperate with Send and Receive and with AEADs that do not implement AppendAEAD.
+func TestEncryptIntoDecryptInto(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Software", nil},
+		{"Offload", []Option{WithAEAD(&offloadAEAD{})}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+			bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+			alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, tc.opts...)
+			bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, tc.opts...)
+
+			late, _ := alice.Send([]byte("late"), nil)
+
+			sendBuf := make([]byte, 256)
+
+			msg, err := alice.EncryptInto(sendBuf, []byte("hello"), []byte("ad"))
+
+			if err != nil {
+				t.Fatalf("EncryptInto failed: %v", err)
+			}
+
+			if &msg.Ciphertext[0] != &sendBuf[0] {
+				t.Error("Expected the ciphertext in the send buffer")
+			}
+
+			recvBuf := make([]byte, 256)
+
+			plain, err := bob.DecryptInto(recvBuf, msg, []byte("ad"))
+
+			if err != nil {
+				t.Fatalf("DecryptInto failed: %v", err)
+			}
+
+			if string(plain.Plaintext) != "hello" || &plain.Plaintext[0] != &recvBuf[0] {
+				t.Errorf("Expected hello in the receive buffer, got %q", plain.Plaintext)
+			}
+
+			plain, err = bob.DecryptInto(recvBuf, late, nil)
+
+			if err != nil {
+				t.Fatalf("DecryptInto failed for a skipped message: %v", err)
+			}
+
+			if string(plain.Plaintext) != "late" {
+				t.Errorf("Expected late, got %q", plain.Plaintext)
+			}
+
+			reply, _ := bob.EncryptInto(nil, []byte("reply"), nil)
+
+			if plain, err := alice.Receive(reply, nil); err != nil || string(plain.Plaintext) != "reply" {
+				t.Errorf("Expected reply, got %q (%v)", plain.Plaintext, err)
+			}
+		})
+	}
+}
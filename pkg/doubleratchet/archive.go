@@ -0,0 +1,54 @@
+package doubleratchet
+
+import (
+	"context"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+var (
+	// ErrArchived is returned by Send on an archived session, and by Receive when an
+	// archived session retains no key for the message.
+	ErrArchived = newError(ErrState, "double ratchet: session is archived")
+)
+
+// Archive freezes the session into a read-only archive, for example to keep showing a
+// conversation's history after it was closed. The archive refuses Send with ErrArchived.
+// Receive only decrypts messages whose skipped keys were retained and keeps those keys,
+// so the same message can be decrypted again; it never changes the session state, usage
+// counters or transcript.
+//
+// The root and chain keys are erased, so no new messages can be sent or received. The
+// archived mode is recorded in the serialized state and cannot be undone.
+func (d *doubleRatchet) Archive() error {
+	d.lock()
+	defer d.unlock()
+
+	if d.closed {
+		return ErrSessionClosed
+	}
+
+	d.archived = true
+	d.rootKey = crypto.ChainKey{}
+	d.sendChainKey = crypto.ChainKey{}
+	d.recvChainKey = crypto.ChainKey{}
+	d.prederived = nil
+
+	return d.save(context.Background())
+}
+
+// openArchived decrypts msg with a retained skipped key, without consuming it, appending
+// the plaintext to dst. The caller must hold the lock.
+func (d *doubleRatchet) openArchived(dst []byte, msg CipheredMessage, ad []byte) ([]byte, error) {
+	if err := d.checkHeaderKey(msg.Header); err != nil {
+		return nil, err
+	}
+
+	sk, ok := d.skippedMessageKeys[msg.Header.key()]
+
+	if !ok {
+		return nil, ErrArchived
+	}
+
+	return d.open(dst, sk.key, msg.Header, msg.Ciphertext, ad)
+}
@@ -0,0 +1,73 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestArchive verifies that an archived session refuses to send, decrypts messages with
+// retained skipped keys as often as asked without changing its state, refuses messages it
+// has no key for, and stays archived across serialization.
+func TestArchive(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithTranscript())
+
+	late, _ := alice.Send([]byte("late"), nil)
+	msg, _ := alice.Send([]byte("on time"), nil)
+	unseen, _ := alice.Send([]byte("unseen"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bob.Archive(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.Send([]byte("hello"), nil); !errors.Is(err, ErrArchived) {
+		t.Errorf("Expected ErrArchived from Send, got %v", err)
+	}
+
+	before, _ := bob.Serialize()
+
+	for range 2 {
+		plain, err := bob.Receive(late, nil)
+
+		if err != nil {
+			t.Fatalf("Expected the retained key to decrypt the late message, got %v", err)
+		}
+
+		if string(plain.Plaintext) != "late" {
+			t.Errorf("Expected %q, got %q", "late", plain.Plaintext)
+		}
+	}
+
+	if _, err := bob.Receive(unseen, nil); !errors.Is(err, ErrArchived) {
+		t.Errorf("Expected ErrArchived for a message without a retained key, got %v", err)
+	}
+
+	after, _ := bob.Serialize()
+
+	if string(before) != string(after) {
+		t.Error("Expected the archive's state not to change")
+	}
+
+	restored, err := Deserialize(after, WithStrictVerification())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restored.Send([]byte("hello"), nil); !errors.Is(err, ErrArchived) {
+		t.Errorf("Expected the restored session to stay archived, got %v", err)
+	}
+
+	if _, err := restored.Receive(late, nil); err != nil {
+		t.Errorf("Expected the restored archive to decrypt the late message, got %v", err)
+	}
+}
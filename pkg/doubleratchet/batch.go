@@ -0,0 +1,107 @@
+package doubleratchet
+
+import (
+	"context"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// SendAll encrypts each plaintext as if by Send, in order, under a single acquisition of
+// the session lock. The ciphertexts share one buffer, so a queue of messages costs one
+// allocation for their payloads instead of one per message.
+//
+// SendAll stops at the first failure and returns the messages sent before it together
+// with the error; those messages have advanced the session and must still be delivered.
+func (d *doubleRatchet) SendAll(plaintexts [][]byte, ad []byte) ([]CipheredMessage, error) {
+	return d.SendAllCtx(context.Background(), plaintexts, ad)
+}
+
+// SendAllCtx is like SendAll, but gives up with the context's error if ctx is done before
+// the session lock is acquired or before any of the messages is sent. The messages sent
+// before are returned, as for any other failure.
+func (d *doubleRatchet) SendAllCtx(ctx context.Context, plaintexts [][]byte, ad []byte) ([]CipheredMessage, error) {
+	if err := d.lockContext(ctx); err != nil {
+		return nil, err
+	}
+
+	defer d.unlock()
+
+	size := 0
+
+	for _, plaintext := range plaintexts {
+		size += len(plaintext) + crypto.Overhead
+	}
+
+	scratch := make([]byte, size)
+	msgs := make([]CipheredMessage, 0, len(plaintexts))
+	off := 0
+
+	for _, plaintext := range plaintexts {
+		if err := ctx.Err(); err != nil {
+			return msgs, err
+		}
+
+		end := off + len(plaintext) + crypto.Overhead
+
+		msg, err := d.sendLocked(ctx, sendParams{typ: MessageNormal}, scratch[off:off:end], plaintext, ad)
+
+		if err != nil {
+			return msgs, err
+		}
+
+		msgs = append(msgs, msg)
+		off = end
+	}
+
+	return msgs, nil
+}
+
+// ReceiveAll decrypts each message as if by Receive, in order, under a single acquisition
+// of the session lock. The plaintexts share one buffer, as the ciphertexts of SendAll do.
+//
+// ReceiveAll stops at the first message that fails and returns the plaintexts of the
+// messages before it together with the error. The failed message leaves the session as it
+// was, so the caller can drop it and pass the rest of the queue again.
+func (d *doubleRatchet) ReceiveAll(msgs []CipheredMessage, ad []byte) ([]UncipheredMessage, error) {
+	return d.ReceiveAllCtx(context.Background(), msgs, ad)
+}
+
+// ReceiveAllCtx is like ReceiveAll, but gives up with the context's error if ctx is done
+// before the session lock is acquired or before any of the messages is received. ctx is
+// passed on to the key operations of the DH function, as by ReceiveCtx.
+func (d *doubleRatchet) ReceiveAllCtx(ctx context.Context, msgs []CipheredMessage, ad []byte) ([]UncipheredMessage, error) {
+	if err := d.lockContext(ctx); err != nil {
+		return nil, err
+	}
+
+	defer d.unlock()
+
+	size := 0
+
+	for _, msg := range msgs {
+		size += len(msg.Ciphertext)
+	}
+
+	scratch := make([]byte, size)
+	plaintexts := make([]UncipheredMessage, 0, len(msgs))
+	off := 0
+
+	for _, msg := range msgs {
+		if err := ctx.Err(); err != nil {
+			return plaintexts, err
+		}
+
+		end := off + len(msg.Ciphertext)
+
+		plaintext, err := d.receiveLocked(ctx, scratch[off:off:end], msg, ad)
+
+		if err != nil {
+			return plaintexts, err
+		}
+
+		plaintexts = append(plaintexts, plaintext)
+		off = end
+	}
+
+	return plaintexts, nil
+}
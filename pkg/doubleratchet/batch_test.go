@@ -0,0 +1,106 @@
+package doubleratchet
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSendAllReceiveAll verifies that a batch of messages round-trips in order, that the
+// batches interoperate with Send and Receive, and that ReceiveAll stops at a tampered
+// message without consuming it, so the rest of the queue can be passed again.
+func TestSendAllReceiveAll(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	var plaintexts [][]byte
+
+	for i := 0; i < 10; i++ {
+		plaintexts = append(plaintexts, []byte(fmt.Sprintf("message %d", i)))
+	}
+
+	msgs, err := alice.SendAll(plaintexts, []byte("ad"))
+
+	if err != nil {
+		t.Fatalf("SendAll failed: %v", err)
+	}
+
+	if len(msgs) != len(plaintexts) {
+		t.Fatalf("Expected %d messages, got %d", len(plaintexts), len(msgs))
+	}
+
+	tampered := msgs[4]
+	tampered.Ciphertext = append([]byte(nil), tampered.Ciphertext...)
+	tampered.Ciphertext[0] ^= 0xFF
+
+	queue := append(append(append([]CipheredMessage(nil), msgs[:4]...), tampered), msgs[4:]...)
+
+	received, err := bob.ReceiveAll(queue, []byte("ad"))
+
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+
+	if len(received) != 4 {
+		t.Fatalf("Expected 4 messages before the tampered one, got %d", len(received))
+	}
+
+	rest, err := bob.ReceiveAll(queue[5:], []byte("ad"))
+
+	if err != nil {
+		t.Fatalf("ReceiveAll failed: %v", err)
+	}
+
+	for i, plain := range append(received, rest...) {
+		if string(plain.Plaintext) != string(plaintexts[i]) {
+			t.Errorf("Message %d: expected %q, got %q", i, plaintexts[i], plain.Plaintext)
+		}
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if plain, err := alice.ReceiveAll([]CipheredMessage{reply}, nil); err != nil || string(plain[0].Plaintext) != "reply" {
+		t.Errorf("Expected reply, got %v (%v)", plain, err)
+	}
+}
+
+// TestSendAllCtxDeadlineWhileLocked verifies that SendAllCtx and ReceiveAllCtx give up
+// when the session stays locked past the deadline, so a stuck caller cannot hold a queue
+// flush forever, and that nothing is sent or received.
+func TestSendAllCtxDeadlineWhileLocked(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msgs, _ := alice.SendAll([][]byte{[]byte("one"), []byte("two")}, nil)
+
+	alice.Lock()
+	bob.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if sent, err := alice.SendAllCtx(ctx, [][]byte{[]byte("three")}, nil); !errors.Is(err, context.DeadlineExceeded) || len(sent) != 0 {
+		t.Errorf("Expected context.DeadlineExceeded and no messages, got %d and %v", len(sent), err)
+	}
+
+	if received, err := bob.ReceiveAllCtx(ctx, msgs, nil); !errors.Is(err, context.DeadlineExceeded) || len(received) != 0 {
+		t.Errorf("Expected context.DeadlineExceeded and no messages, got %d and %v", len(received), err)
+	}
+
+	alice.Unlock()
+	bob.Unlock()
+
+	if received, err := bob.ReceiveAllCtx(context.Background(), msgs, nil); err != nil || len(received) != 2 {
+		t.Errorf("Expected both messages after the lock was released, got %d and %v", len(received), err)
+	}
+}
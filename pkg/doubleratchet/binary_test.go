@@ -0,0 +1,114 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestStateMarshalBinary verifies that every field of a State survives the binary layout,
+// that the encoding is smaller than JSON, and that truncated or extended data is rejected.
+func TestStateMarshalBinary(t *testing.T) {
+	state := testState()
+
+	var _ encoding.BinaryMarshaler = state
+
+	data, err := state.MarshalBinary()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded State
+
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, state) {
+		t.Errorf("Expected %+v, got %+v", state, decoded)
+	}
+
+	json, _ := JSONSerializer{}.Marshal(state)
+
+	if len(data) >= len(json) {
+		t.Errorf("Expected the binary layout to be smaller than JSON, got %d and %d bytes", len(data), len(json))
+	}
+
+	for i := 0; i < len(data); i++ {
+		if err := new(State).UnmarshalBinary(data[:i]); !errors.Is(err, ErrMalformedState) {
+			t.Fatalf("Expected ErrMalformedState for data truncated to %d bytes, got %v", i, err)
+		}
+	}
+
+	if err := new(State).UnmarshalBinary(append(data, 0)); !errors.Is(err, ErrMalformedState) {
+		t.Errorf("Expected ErrMalformedState for trailing bytes, got %v", err)
+	}
+}
+
+// TestBinarySerializerSize verifies that a session serialized with BinarySerializer is
+// restored by Deserialize and takes less than two thirds of the size of its JSON encoding.
+func TestBinarySerializerSize(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSerializer(BinarySerializer{}))
+
+	data, err := alice.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Deserialize(data); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := Deserialize(data, WithSerializer(JSONSerializer{}))
+	json, _ := restored.Serialize()
+
+	if 3*len(data) >= 2*len(json) {
+		t.Errorf("Expected the binary state to be less than two thirds of the JSON size, got %d and %d bytes", len(data), len(json))
+	}
+}
+
+// testState returns a State with every field set, for the serializer tests.
+func testState() State {
+	recvPN := uint32(7)
+
+	return State{
+		RootKey:            [32]byte{1},
+		SendChainKey:       [32]byte{2},
+		RecvChainKey:       [32]byte{3},
+		SendN:              4,
+		RecvN:              5,
+		PrevN:              6,
+		RecvPN:             &recvPN,
+		StepPending:        true,
+		Epoch:              8,
+		LocalPri:           []byte("local private key"),
+		LocalPub:           []byte("local public key"),
+		RemotePub:          []byte("remote public key"),
+		TranscriptSent:     make([]byte, 32),
+		TranscriptReceived: make([]byte, 32),
+		EscrowKey:          []byte("escrow key"),
+		Usage:              Usage{MessagesSent: 9, MessagesReceived: 10, BytesSent: 11, BytesReceived: 12},
+		UsageMAC:           []byte("usage mac"),
+		FIPS:               true,
+		Archived:           true,
+		Curve:              "X25519",
+		KDFHash:            KDFSHA512,
+		Label:              "label",
+		ChainStartedAt:     -13,
+		ChainBytes:         14,
+		Hybrid:             &HybridState{Key: []byte("key"), RemoteKey: []byte("remote"), Target: []byte("target")},
+		SessionID:          []byte("session id"),
+		SkippedKeys: []SkippedMessageKey{
+			{Header: Header{DH: []byte("dh"), N: 15, PN: 16}, Key: [32]byte{17}, Epoch: 18, StoredAt: 19},
+			{Header: Header{DH: []byte("dh2")}, Key: [32]byte{20}},
+		},
+	}
+}
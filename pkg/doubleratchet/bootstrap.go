@@ -0,0 +1,119 @@
+package doubleratchet
+
+import (
+	"context"
+	"errors"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+var (
+	// ErrInvalidSharedSecret is returned by InitAlice and InitBob for a shared secret that
+	// is not 32 bytes long.
+	ErrInvalidSharedSecret = errors.New("double ratchet: shared secret must be 32 bytes")
+
+	// ErrNoSendingChain is returned by Send on a session created with InitBob before it
+	// has received the initiator's first message.
+	ErrNoSendingChain = newError(ErrState, "double ratchet: no sending chain before the first message is received")
+)
+
+// InitAlice creates the initiator's side of a session as specified by the Double Ratchet
+// algorithm (RatchetInitAlice): the root key is the shared secret sk, for example the
+// output of X3DH, and the session immediately performs a DH ratchet step against the
+// responder's ratchet public key remotePub, deriving a sending chain. It has no receiving
+// chain until the responder replies under a new ratchet key.
+//
+// Unlike New, the peers need not know each other's private keys or share a salt, which
+// makes sessions interoperable with other implementations of the specification. A secret
+// given with WithInitialPQSecret is mixed into the root key.
+func InitAlice(sk, remotePub []byte, opts ...Option) (*doubleRatchet, error) {
+	d, err := bootstrap(sk, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := d.dh.function().NewPublicKey(remotePub)
+
+	if err != nil {
+		return nil, cryptoError(err)
+	}
+
+	d.dh.remotePublicKey = pub
+
+	if err := d.stepSendingRatchet(context.Background()); err != nil {
+		return nil, err
+	}
+
+	d.prederive()
+
+	return d, nil
+}
+
+// InitBob creates the responder's side of a session as specified by the Double Ratchet
+// algorithm (RatchetInitBob): the root key is the shared secret sk and localPri is the
+// ratchet private key whose public key the initiator was given, for example the signed
+// prekey of X3DH. The session has no chains until the initiator's first message arrives;
+// until then Send fails with ErrNoSendingChain.
+func InitBob(sk, localPri []byte, opts ...Option) (*doubleRatchet, error) {
+	d, err := bootstrap(sk, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pri, err := d.dh.function().NewPrivateKey(localPri)
+
+	if err != nil {
+		return nil, cryptoError(err)
+	}
+
+	d.dh.localPrivateKey = pri
+
+	return d, nil
+}
+
+// bootstrap creates a session with the options applied and the root key set from sk.
+func bootstrap(sk []byte, opts []Option) (*doubleRatchet, error) {
+	d, err := configure(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.checkFIPS(); err != nil {
+		return nil, err
+	}
+
+	if len(sk) != len(d.rootKey) {
+		return nil, ErrInvalidSharedSecret
+	}
+
+	d.skippedMessageKeys = make(map[headerID]skippedKey)
+	if err := d.deriveSessionIDFromSecret(sk); err != nil {
+		return nil, err
+	}
+
+	if d.initialPQ == nil {
+		copy(d.rootKey[:], sk)
+		return d, nil
+	}
+
+	// Combine the shared secret with the secret given with WithInitialPQSecret.
+	ikm := append(append([]byte(nil), sk...), d.initialPQ...)
+
+	clear(d.initialPQ)
+	d.initialPQ = nil
+
+	rk, err := crypto.DeriveHKDFWith(d.hash(), ikm, nil, d.info("-Root"), 32)
+
+	clear(ikm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	copy(d.rootKey[:], rk)
+
+	return d, nil
+}
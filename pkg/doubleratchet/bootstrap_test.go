@@ -0,0 +1,74 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestInitAliceInitBob verifies that sessions bootstrapped from a shared secret as in the
+// specification exchange messages in both directions across several ratchet steps, that
+// the responder cannot send before the initiator's first message, and that both sides
+// survive a strict serialization round trip before and after it.
+func TestInitAliceInitBob(t *testing.T) {
+	sk := make([]byte, 32)
+	rand.Read(sk)
+
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := InitAlice(sk, bobPri.PublicKey().Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := InitBob(sk, bobPri.Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.Send([]byte("too early"), nil); !errors.Is(err, ErrNoSendingChain) {
+		t.Errorf("Expected ErrNoSendingChain, got %v", err)
+	}
+
+	for _, d := range []**doubleRatchet{&alice, &bob} {
+		state, _ := (*d).Serialize()
+
+		if *d, err = Deserialize(state, WithStrictVerification()); err != nil {
+			t.Fatalf("Expected a fresh session to pass strict verification, got %v", err)
+		}
+	}
+
+	first, _ := alice.Send([]byte("hello"), nil)
+
+	if bytes.Equal(first.Header.DH, bobPri.PublicKey().Bytes()) {
+		t.Error("Expected the initiator to send under a fresh ratchet key")
+	}
+
+	for round := 0; round < 3; round++ {
+		if received, err := bob.Receive(first, nil); err != nil || string(received.Plaintext) != "hello" {
+			t.Fatalf("Round %d: Bob failed to receive: %v", round, err)
+		}
+
+		state, _ := bob.Serialize()
+
+		if bob, err = Deserialize(state, WithStrictVerification()); err != nil {
+			t.Fatalf("Round %d: expected strict verification to pass, got %v", round, err)
+		}
+
+		reply, _ := bob.Send([]byte("reply"), nil)
+
+		if received, err := alice.Receive(reply, nil); err != nil || string(received.Plaintext) != "reply" {
+			t.Fatalf("Round %d: Alice failed to receive: %v", round, err)
+		}
+
+		first, _ = alice.Send([]byte("hello"), nil)
+	}
+
+	if _, err := InitAlice(sk[:16], bobPri.PublicKey().Bytes()); !errors.Is(err, ErrInvalidSharedSecret) {
+		t.Errorf("Expected ErrInvalidSharedSecret, got %v", err)
+	}
+}
@@ -0,0 +1,721 @@
+package doubleratchet
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// CBOR major types (RFC 8949, section 3.1).
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// cborMaxDepth bounds the nesting of items skipped by the decoder.
+const cborMaxDepth = 16
+
+var (
+	// ErrMalformedCBOR is returned by the CBOR decoders for data that is not a valid CBOR
+	// item of the expected shape.
+	ErrMalformedCBOR = newError(ErrProtocol, "double ratchet: malformed CBOR")
+)
+
+// CBORSerializer encodes state as the CBOR map of State.MarshalCBOR, prefixed with
+// FormatCBOR. CBOR is self-describing, so the state can be read on embedded and mobile
+// platforms without a schema.
+type CBORSerializer struct{}
+
+// Format returns FormatCBOR.
+func (CBORSerializer) Format() byte {
+	return FormatCBOR
+}
+
+// Marshal encodes state with State.MarshalCBOR.
+func (CBORSerializer) Marshal(state State) ([]byte, error) {
+	data, err := state.MarshalCBOR()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{FormatCBOR}, data...), nil
+}
+
+// Unmarshal decodes data with State.UnmarshalCBOR after checking its format tag.
+func (CBORSerializer) Unmarshal(data []byte, state *State) error {
+	if len(data) == 0 || data[0] != FormatCBOR {
+		return ErrUnknownFormat
+	}
+
+	return state.UnmarshalCBOR(data[1:])
+}
+
+// MarshalCBOR encodes the state as a CBOR map keyed by field name. Fields holding their
+// zero value are left out, except for the keys.
+func (s State) MarshalCBOR() ([]byte, error) {
+	var m cborMapWriter
+
+	m.bytes("RootKey", s.RootKey[:])
+	m.bytes("SendChainKey", s.SendChainKey[:])
+	m.bytes("RecvChainKey", s.RecvChainKey[:])
+	m.uint("SendN", uint64(s.SendN))
+	m.uint("RecvN", uint64(s.RecvN))
+	m.uint("PrevN", uint64(s.PrevN))
+
+	if s.RecvPN != nil {
+		m.key("RecvPN")
+		m.head(cborUint, uint64(*s.RecvPN))
+	}
+
+	m.bool("StepPending", s.StepPending)
+	m.uint("Epoch", uint64(s.Epoch))
+
+	if len(s.SkippedKeys) != 0 {
+		m.key("SkippedKeys")
+		m.head(cborArray, uint64(len(s.SkippedKeys)))
+
+		for _, sk := range s.SkippedKeys {
+			var k cborMapWriter
+
+			k.item("Header", sk.Header.cbor())
+			k.bytes("Key", sk.Key[:])
+			k.uint("Epoch", uint64(sk.Epoch))
+			k.int("StoredAt", sk.StoredAt)
+
+			m.buf = append(m.buf, k.encode()...)
+		}
+	}
+
+	m.bytes("LocalPri", s.LocalPri)
+	m.bytes("LocalPub", s.LocalPub)
+	m.bytes("RemotePub", s.RemotePub)
+	m.bytes("TranscriptSent", s.TranscriptSent)
+	m.bytes("TranscriptReceived", s.TranscriptReceived)
+	m.bytes("EscrowKey", s.EscrowKey)
+
+	if s.Usage != (Usage{}) {
+		var u cborMapWriter
+
+		u.uint("MessagesSent", s.Usage.MessagesSent)
+		u.uint("MessagesReceived", s.Usage.MessagesReceived)
+		u.uint("BytesSent", s.Usage.BytesSent)
+		u.uint("BytesReceived", s.Usage.BytesReceived)
+
+		m.item("Usage", u.encode())
+	}
+
+	m.bytes("UsageMAC", s.UsageMAC)
+	m.bool("FIPS", s.FIPS)
+	m.bool("Archived", s.Archived)
+	m.text("Curve", s.Curve)
+	m.text("KDFHash", string(s.KDFHash))
+	m.text("Label", s.Label)
+	m.int("ChainStartedAt", s.ChainStartedAt)
+	m.uint("ChainBytes", s.ChainBytes)
+
+	if s.Hybrid != nil {
+		var h cborMapWriter
+
+		h.bytes("Key", s.Hybrid.Key)
+		h.bytes("PrevKey", s.Hybrid.PrevKey)
+		h.bytes("RemoteKey", s.Hybrid.RemoteKey)
+		h.bytes("Ciphertext", s.Hybrid.Ciphertext)
+		h.bytes("Target", s.Hybrid.Target)
+
+		m.item("Hybrid", h.encode())
+	}
+
+	m.bytes("SessionID", s.SessionID)
+	m.bytes("ReplayWindow", s.ReplayWindow)
+
+	return m.encode(), nil
+}
+
+// UnmarshalCBOR decodes a state encoded by MarshalCBOR. Unknown keys are skipped.
+func (s *State) UnmarshalCBOR(data []byte) error {
+	var state State
+
+	r := cborReader{data: data}
+
+	r.fields(func(key string) {
+		switch key {
+		case "RootKey":
+			r.key(&state.RootKey)
+		case "SendChainKey":
+			r.key(&state.SendChainKey)
+		case "RecvChainKey":
+			r.key(&state.RecvChainKey)
+		case "SendN":
+			state.SendN = r.uint32()
+		case "RecvN":
+			state.RecvN = r.uint32()
+		case "PrevN":
+			state.PrevN = r.uint32()
+		case "RecvPN":
+			recvPN := r.uint32()
+			state.RecvPN = &recvPN
+		case "StepPending":
+			state.StepPending = r.bool()
+		case "Epoch":
+			state.Epoch = r.uint32()
+		case "SkippedKeys":
+			r.array(func() {
+				var sk SkippedMessageKey
+
+				r.fields(func(key string) {
+					switch key {
+					case "Header":
+						sk.Header = r.header()
+					case "Key":
+						r.key(&sk.Key)
+					case "Epoch":
+						sk.Epoch = r.uint32()
+					case "StoredAt":
+						sk.StoredAt = r.int()
+					default:
+						r.skip(0)
+					}
+				})
+
+				state.SkippedKeys = append(state.SkippedKeys, sk)
+			})
+		case "LocalPri":
+			state.LocalPri = r.bytes()
+		case "LocalPub":
+			state.LocalPub = r.bytes()
+		case "RemotePub":
+			state.RemotePub = r.bytes()
+		case "TranscriptSent":
+			state.TranscriptSent = r.bytes()
+		case "TranscriptReceived":
+			state.TranscriptReceived = r.bytes()
+		case "EscrowKey":
+			state.EscrowKey = r.bytes()
+		case "Usage":
+			r.fields(func(key string) {
+				switch key {
+				case "MessagesSent":
+					state.Usage.MessagesSent = r.uint()
+				case "MessagesReceived":
+					state.Usage.MessagesReceived = r.uint()
+				case "BytesSent":
+					state.Usage.BytesSent = r.uint()
+				case "BytesReceived":
+					state.Usage.BytesReceived = r.uint()
+				default:
+					r.skip(0)
+				}
+			})
+		case "UsageMAC":
+			state.UsageMAC = r.bytes()
+		case "FIPS":
+			state.FIPS = r.bool()
+		case "Archived":
+			state.Archived = r.bool()
+		case "Curve":
+			state.Curve = r.text()
+		case "KDFHash":
+			state.KDFHash = KDFHash(r.text())
+		case "Label":
+			state.Label = r.text()
+		case "ChainStartedAt":
+			state.ChainStartedAt = r.int()
+		case "ChainBytes":
+			state.ChainBytes = r.uint()
+		case "Hybrid":
+			h := &HybridState{}
+			state.Hybrid = h
+
+			r.fields(func(key string) {
+				switch key {
+				case "Key":
+					h.Key = r.bytes()
+				case "PrevKey":
+					h.PrevKey = r.bytes()
+				case "RemoteKey":
+					h.RemoteKey = r.bytes()
+				case "Ciphertext":
+					h.Ciphertext = r.bytes()
+				case "Target":
+					h.Target = r.bytes()
+				default:
+					r.skip(0)
+				}
+			})
+		case "SessionID":
+			state.SessionID = r.bytes()
+		case "ReplayWindow":
+			state.ReplayWindow = r.bytes()
+		default:
+			r.skip(0)
+		}
+	})
+
+	if err := r.finish(); err != nil {
+		return err
+	}
+
+	*s = state
+
+	return nil
+}
+
+// MarshalCBOR encodes the header as a CBOR map keyed by field name, leaving out fields
+// holding their zero value.
+func (h Header) MarshalCBOR() ([]byte, error) {
+	return h.cbor(), nil
+}
+
+// UnmarshalCBOR decodes a header encoded by MarshalCBOR. Unknown keys are skipped.
+func (h *Header) UnmarshalCBOR(data []byte) error {
+	r := cborReader{data: data}
+	header := r.header()
+
+	if err := r.finish(); err != nil {
+		return err
+	}
+
+	*h = header
+
+	return nil
+}
+
+// MarshalCBOR encodes the message as a CBOR map holding the header map, the ciphertext
+// and the escrowed key, if any.
+func (msg CipheredMessage) MarshalCBOR() ([]byte, error) {
+	var m cborMapWriter
+
+	m.item("Header", msg.Header.cbor())
+	m.bytes("Ciphertext", msg.Ciphertext)
+	m.bytes("Escrow", msg.Escrow)
+
+	return m.encode(), nil
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR. Unknown keys are skipped.
+func (msg *CipheredMessage) UnmarshalCBOR(data []byte) error {
+	var m CipheredMessage
+
+	r := cborReader{data: data}
+
+	r.fields(func(key string) {
+		switch key {
+		case "Header":
+			m.Header = r.header()
+		case "Ciphertext":
+			m.Ciphertext = r.bytes()
+		case "Escrow":
+			m.Escrow = r.bytes()
+		default:
+			r.skip(0)
+		}
+	})
+
+	if err := r.finish(); err != nil {
+		return err
+	}
+
+	*msg = m
+
+	return nil
+}
+
+// cbor encodes the header map.
+func (h Header) cbor() []byte {
+	var m cborMapWriter
+
+	m.bytes("DH", h.DH)
+	m.uint("N", uint64(h.N))
+	m.uint("PN", uint64(h.PN))
+	m.bytes("Padding", h.Padding)
+
+	if h.PQ != nil {
+		var pq cborMapWriter
+
+		pq.bytes("Key", h.PQ.Key)
+		pq.bytes("Ciphertext", h.PQ.Ciphertext)
+		pq.bytes("Target", h.PQ.Target)
+
+		m.item("PQ", pq.encode())
+	}
+
+	m.uint("Version", uint64(h.Version))
+	m.uint("Type", uint64(h.Type))
+	m.int("Timestamp", h.Timestamp)
+	m.bytes("MessageID", h.MessageID)
+
+	return m.encode()
+}
+
+// cborWriter appends CBOR items to buf.
+type cborWriter struct {
+	buf []byte
+}
+
+// head writes the initial bytes of an item of the given major type, with n encoded in
+// the shortest form.
+func (w *cborWriter) head(major byte, n uint64) {
+	major <<= 5
+
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, major|26), uint32(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, major|27), n)
+	}
+}
+
+// cborMapWriter writes the entries of a map with text keys, counting them so that
+// encode can prefix the map header. The typed methods leave out zero values.
+type cborMapWriter struct {
+	cborWriter
+	n uint64
+}
+
+func (m *cborMapWriter) key(k string) {
+	m.n++
+	m.head(cborText, uint64(len(k)))
+	m.buf = append(m.buf, k...)
+}
+
+// item writes an entry whose value is an encoded item.
+func (m *cborMapWriter) item(k string, v []byte) {
+	m.key(k)
+	m.buf = append(m.buf, v...)
+}
+
+func (m *cborMapWriter) uint(k string, v uint64) {
+	if v != 0 {
+		m.key(k)
+		m.head(cborUint, v)
+	}
+}
+
+func (m *cborMapWriter) int(k string, v int64) {
+	switch {
+	case v > 0:
+		m.key(k)
+		m.head(cborUint, uint64(v)) // #nosec G115 -- v is positive
+	case v < 0:
+		m.key(k)
+		m.head(cborNegint, uint64(-(v + 1))) // #nosec G115 -- -(v+1) is non-negative
+	}
+}
+
+func (m *cborMapWriter) bytes(k string, v []byte) {
+	if len(v) != 0 {
+		m.key(k)
+		m.head(cborBytes, uint64(len(v)))
+		m.buf = append(m.buf, v...)
+	}
+}
+
+func (m *cborMapWriter) text(k, v string) {
+	if v != "" {
+		m.key(k)
+		m.head(cborText, uint64(len(v)))
+		m.buf = append(m.buf, v...)
+	}
+}
+
+func (m *cborMapWriter) bool(k string, v bool) {
+	if v {
+		m.key(k)
+		m.buf = append(m.buf, cborSimple<<5|21)
+	}
+}
+
+// encode returns the map header followed by the entries.
+func (m *cborMapWriter) encode() []byte {
+	var w cborWriter
+
+	w.head(cborMap, m.n)
+
+	return append(w.buf, m.buf...)
+}
+
+// cborReader decodes CBOR items. Like binaryReader, it keeps the first error, and later
+// reads return zero values.
+type cborReader struct {
+	data []byte
+	err  error
+}
+
+// head reads the initial bytes of an item and returns its major type and argument.
+// Indefinite lengths are not supported.
+func (r *cborReader) head() (byte, uint64) {
+	if r.err != nil || len(r.data) == 0 {
+		r.fail()
+		return 0, 0
+	}
+
+	major, info := r.data[0]>>5, r.data[0]&31
+	r.data = r.data[1:]
+
+	size := 0
+
+	switch {
+	case info < 24:
+		return major, uint64(info)
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		r.fail()
+		return 0, 0
+	}
+
+	if len(r.data) < size {
+		r.fail()
+		return 0, 0
+	}
+
+	var n uint64
+
+	for _, b := range r.data[:size] {
+		n = n<<8 | uint64(b)
+	}
+
+	r.data = r.data[size:]
+
+	return major, n
+}
+
+// expect reads the head of an item of the given major type.
+func (r *cborReader) expect(major byte) uint64 {
+	m, n := r.head()
+
+	if r.err == nil && m != major {
+		r.fail()
+		return 0
+	}
+
+	return n
+}
+
+// payload returns the next n bytes of a byte or text string.
+func (r *cborReader) payload(n uint64) []byte {
+	if r.err != nil || n > uint64(len(r.data)) {
+		r.fail()
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b
+}
+
+func (r *cborReader) uint() uint64 {
+	return r.expect(cborUint)
+}
+
+func (r *cborReader) uint32() uint32 {
+	n := r.uint()
+
+	if n > math.MaxUint32 {
+		r.fail()
+		return 0
+	}
+
+	return uint32(n)
+}
+
+func (r *cborReader) uint8() uint8 {
+	n := r.uint()
+
+	if n > math.MaxUint8 {
+		r.fail()
+		return 0
+	}
+
+	return uint8(n)
+}
+
+func (r *cborReader) int() int64 {
+	major, n := r.head()
+
+	if r.err != nil || n > math.MaxInt64 || (major != cborUint && major != cborNegint) {
+		r.fail()
+		return 0
+	}
+
+	if major == cborNegint {
+		return -1 - int64(n)
+	}
+
+	return int64(n)
+}
+
+func (r *cborReader) bool() bool {
+	switch r.expect(cborSimple) {
+	case 20:
+		return false
+	case 21:
+		return true
+	default:
+		r.fail()
+		return false
+	}
+}
+
+// bytes reads a byte string, returning nil for an empty one.
+func (r *cborReader) bytes() []byte {
+	b := r.payload(r.expect(cborBytes))
+
+	if len(b) == 0 {
+		return nil
+	}
+
+	return append([]byte(nil), b...)
+}
+
+func (r *cborReader) text() string {
+	return string(r.payload(r.expect(cborText)))
+}
+
+// key reads a 32-byte key.
+func (r *cborReader) key(dst *[32]byte) {
+	if b := r.payload(r.expect(cborBytes)); len(b) == len(dst) {
+		copy(dst[:], b)
+	} else {
+		r.fail()
+	}
+}
+
+// count reads the head of an array or map of the given major type and checks its length
+// with countOf.
+func (r *cborReader) count(major byte) uint64 {
+	return r.countOf(r.expect(major))
+}
+
+// array calls fn to read each element of an array.
+func (r *cborReader) array(fn func()) {
+	for i := r.count(cborArray); i > 0 && r.err == nil; i-- {
+		fn()
+	}
+}
+
+// fields reads a map with text keys, calling fn with each key to read its value.
+func (r *cborReader) fields(fn func(key string)) {
+	for i := r.count(cborMap); i > 0 && r.err == nil; i-- {
+		if key := r.text(); r.err == nil {
+			fn(key)
+		}
+	}
+}
+
+// skip reads and discards an item, such as the value of an unknown key.
+func (r *cborReader) skip(depth int) {
+	if depth > cborMaxDepth {
+		r.fail()
+		return
+	}
+
+	major, n := r.head()
+
+	switch major {
+	case cborBytes, cborText:
+		r.payload(n)
+	case cborArray:
+		for i := r.countOf(n); i > 0 && r.err == nil; i-- {
+			r.skip(depth + 1)
+		}
+	case cborMap:
+		for i := r.countOf(n); i > 0 && r.err == nil; i-- {
+			r.skip(depth + 1)
+			r.skip(depth + 1)
+		}
+	case cborTag:
+		r.skip(depth + 1)
+	}
+}
+
+// countOf checks that the n entries of an array or map, each of which takes at least one
+// byte, fit in the remaining data.
+func (r *cborReader) countOf(n uint64) uint64 {
+	if n > uint64(len(r.data)) {
+		r.fail()
+		return 0
+	}
+
+	return n
+}
+
+// header reads a header map.
+func (r *cborReader) header() Header {
+	var h Header
+
+	r.fields(func(key string) {
+		switch key {
+		case "DH":
+			h.DH = r.bytes()
+		case "N":
+			h.N = r.uint32()
+		case "PN":
+			h.PN = r.uint32()
+		case "Padding":
+			h.Padding = r.bytes()
+		case "PQ":
+			pq := &HybridHeader{}
+			h.PQ = pq
+
+			r.fields(func(key string) {
+				switch key {
+				case "Key":
+					pq.Key = r.bytes()
+				case "Ciphertext":
+					pq.Ciphertext = r.bytes()
+				case "Target":
+					pq.Target = r.bytes()
+				default:
+					r.skip(0)
+				}
+			})
+		case "Version":
+			h.Version = r.uint8()
+		case "Type":
+			h.Type = MessageType(r.uint8())
+		case "Timestamp":
+			h.Timestamp = r.int()
+		case "MessageID":
+			h.MessageID = r.bytes()
+		default:
+			r.skip(0)
+		}
+	})
+
+	return h
+}
+
+func (r *cborReader) fail() {
+	if r.err == nil {
+		r.err = ErrMalformedCBOR
+	}
+}
+
+// finish returns the first error, or ErrMalformedCBOR if data is left after the item.
+func (r *cborReader) finish() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.fail()
+	}
+
+	return r.err
+}
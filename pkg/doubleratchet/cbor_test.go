@@ -0,0 +1,116 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestStateMarshalCBOR verifies that every field of a State survives the CBOR encoding,
+// that unknown keys are skipped, and that truncated or extended data is rejected.
+func TestStateMarshalCBOR(t *testing.T) {
+	state := testState()
+
+	data, err := CBORSerializer{}.Marshal(state)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded State
+
+	if err := (CBORSerializer{}).Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, state) {
+		t.Errorf("Expected %+v, got %+v", state, decoded)
+	}
+
+	// Add a key "x" holding a tagged array, as a newer version might, by bumping the map
+	// length from 27 (0xb8 0x1b) to 28.
+	if data[1] != 0xb8 || data[2] != 27 {
+		t.Fatalf("Expected a map of 27 entries, got %x", data[1:3])
+	}
+
+	extended := append([]byte{FormatCBOR, 0xb8, 28}, data[3:]...)
+	extended = append(extended, 0x61, 'x', 0xc1, 0x82, 0x01, 0x41, 0xff)
+
+	decoded = State{}
+
+	if err := (CBORSerializer{}).Unmarshal(extended, &decoded); err != nil {
+		t.Fatalf("Expected unknown keys to be skipped, got %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, state) {
+		t.Errorf("Expected unknown keys not to change the state, got %+v", decoded)
+	}
+
+	for i := 1; i < len(data); i++ {
+		if err := (CBORSerializer{}).Unmarshal(data[:i], new(State)); !errors.Is(err, ErrMalformedCBOR) {
+			t.Fatalf("Expected ErrMalformedCBOR for data truncated to %d bytes, got %v", i, err)
+		}
+	}
+
+	if err := (CBORSerializer{}).Unmarshal(append(data, 0), new(State)); !errors.Is(err, ErrMalformedCBOR) {
+		t.Errorf("Expected ErrMalformedCBOR for trailing data, got %v", err)
+	}
+
+	if err := (CBORSerializer{}).Unmarshal(data[1:], new(State)); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat without the format tag, got %v", err)
+	}
+}
+
+// TestCipheredMessageMarshalCBOR verifies that messages round-trip through CBOR and that
+// a header encodes to the expected bytes.
+func TestCipheredMessageMarshalCBOR(t *testing.T) {
+	header := Header{DH: []byte{1, 2}, N: 1, Timestamp: -1}
+
+	data, err := header.MarshalCBOR()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// {"DH": h'0102', "N": 1, "Timestamp": -1}
+	want := []byte{0xa3, 0x62, 'D', 'H', 0x42, 1, 2, 0x61, 'N', 0x01, 0x69, 'T', 'i', 'm', 'e', 's', 't', 'a', 'm', 'p', 0x20}
+
+	if !bytes.Equal(data, want) {
+		t.Errorf("Expected %x, got %x", want, data)
+	}
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithHeaderPadding(16))
+
+	msg, err := alice.SendWithMetadata(Metadata{ID: []byte("id")}, []byte("hello"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err = msg.MarshalCBOR(); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded CipheredMessage
+
+	if err := decoded.UnmarshalCBOR(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("Expected %+v, got %+v", msg, decoded)
+	}
+
+	// A version that does not fit in a byte must not wrap around to a supported one.
+	overflow := []byte{0xa1, 0x67, 'V', 'e', 'r', 's', 'i', 'o', 'n', 0x19, 0x01, 0x00}
+
+	if err := new(Header).UnmarshalCBOR(overflow); !errors.Is(err, ErrMalformedCBOR) {
+		t.Errorf("Expected ErrMalformedCBOR for version 256, got %v", err)
+	}
+}
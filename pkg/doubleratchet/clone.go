@@ -0,0 +1,73 @@
+package doubleratchet
+
+// Clone returns an independent copy of the session, for example to try decrypting a
+// message against it without knowing whether the message belongs to the session. Sending
+// or receiving with the copy does not change the original, and vice versa; keep only one
+// of them afterwards, since both would reuse the same message keys.
+//
+// The copy has the original's options, including interceptors, the AEAD and the DH
+// function, but no subscribers and no stores; see WithStore and WithSkippedKeyStore. It
+// shares the current local private key with the original and never destroys it; see
+// Destroyer. A key the original destroys in a DH ratchet step can no longer be used by
+// the copy.
+//
+// The root and chain keys of a copy of a session with WithSecureMemory are written to new
+// memory of the same SecureMemory, never to ordinary memory. If that memory cannot be
+// allocated, the copy is returned closed, holding no keys.
+func (d *doubleRatchet) Clone() DoubleRatchet {
+	d.lock()
+	defer d.unlock()
+
+	c := &doubleRatchet{
+		escrow:           d.escrow,
+		rand:             d.rand,
+		interceptors:     append([]Interceptor(nil), d.interceptors...),
+		identity:         d.identity,
+		usageKey:         append([]byte(nil), d.usageKey...),
+		closed:           d.closed,
+		strict:           d.strict,
+		now:              d.now,
+		serializer:       d.serializer,
+		aead:             d.aead,
+		noLegacyAD:       d.noLegacyAD,
+		prederiveMax:     d.prederiveMax,
+		paddingMax:       d.paddingMax,
+		fips:             d.fips,
+		kdfHash:          d.kdfHash,
+		label:            d.label,
+		maxPlaintext:     d.maxPlaintext,
+		maxCiphertext:    d.maxCiphertext,
+		maxSkipMessage:   d.maxSkipMessage,
+		maxSkipTotal:     d.maxSkipTotal,
+		limiter:          d.limiter,
+		plaintextPadding: d.plaintextPadding,
+		unlocked:         d.unlocked,
+		rekeyPolicy:      d.rekeyPolicy,
+		maxPrevChains:    d.maxPrevChains,
+		replayMax:        d.replayMax,
+		limitPrevChains:  d.limitPrevChains,
+		initialPQ:        append([]byte(nil), d.initialPQ...),
+		sharedKey:        true,
+		sessionID:        d.sessionID,
+		identityStatus:   d.identityStatus,
+		secureMemory:     d.secureMemory,
+		chainKeys:        &chainKeys{},
+	}
+
+	if err := c.secureKeys(); err != nil {
+		c.closed = true
+		return c
+	}
+
+	c.copyState(d)
+
+	if d.usageKey == nil {
+		c.usageKey = nil
+	}
+
+	if d.initialPQ == nil {
+		c.initialPQ = nil
+	}
+
+	return c
+}
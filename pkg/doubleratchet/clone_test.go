@@ -0,0 +1,59 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// TestClone verifies that a clone decrypts and sends like the original without changing
+// it: the original's state, skipped keys and transcript stay as they were, and it can
+// still receive the messages the clone consumed.
+func TestClone(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithTranscript())
+
+	msg1, _ := alice.Send([]byte("one"), nil)
+	msg2, _ := alice.Send([]byte("two"), nil)
+
+	bob.Receive(msg2, nil)
+
+	before, _ := bob.Serialize()
+	sentBefore, receivedBefore := bob.Transcript()
+
+	clone := bob.Clone()
+
+	if m, err := clone.Receive(msg1, nil); err != nil || string(m.Plaintext) != "one" {
+		t.Fatalf("Expected the clone to decrypt the message, got %q, %v", m.Plaintext, err)
+	}
+
+	reply, err := clone.Send([]byte("reply"), nil)
+
+	if err != nil {
+		t.Fatalf("Send on the clone failed: %v", err)
+	}
+
+	if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+		t.Error("Expected the original state to be unchanged by the clone")
+	}
+
+	if sent, received := bob.Transcript(); !bytes.Equal(sent, sentBefore) || !bytes.Equal(received, receivedBefore) {
+		t.Error("Expected the original transcript to be unchanged by the clone")
+	}
+
+	if stats := bob.SkippedKeys(); stats.Count != 1 {
+		t.Errorf("Expected the original to keep its skipped key, got %d", stats.Count)
+	}
+
+	if m, err := bob.Receive(msg1, nil); err != nil || string(m.Plaintext) != "one" {
+		t.Errorf("Expected the original to decrypt the message, got %q, %v", m.Plaintext, err)
+	}
+
+	if m, err := alice.Receive(reply, nil); err != nil || string(m.Plaintext) != "reply" {
+		t.Errorf("Expected the peer to decrypt the clone's reply, got %q, %v", m.Plaintext, err)
+	}
+}
@@ -0,0 +1,42 @@
+package doubleratchet
+
+import (
+	"context"
+)
+
+// Close erases the session's secret keys from memory and makes every later Send,
+// Receive, Serialize, Handoff and Archive fail with ErrSessionClosed. The root key, the
+// chain keys, the skipped and prederived message keys and the usage key are overwritten
+// with zeros, the memory of WithSecureMemory is released, and the ratchet private key is
+// destroyed if it implements Destroyer.
+//
+// Private keys of crypto/ecdh cannot be overwritten in place; the session drops its
+// references to them so that they become garbage. Serialized copies of the state are not
+// affected and must be erased by the caller. Closing a closed session does nothing.
+func (d *doubleRatchet) Close() error {
+	d.lock()
+	defer d.unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	pri := d.dh.localPrivateKey
+
+	d.close()
+
+	clear(d.usageKey)
+	clear(d.initialPQ)
+
+	d.usageKey = nil
+	d.initialPQ = nil
+	d.hybrid = nil
+	d.dh.localPrivateKey = nil
+	d.dh.remotePublicKey = nil
+
+	if d.sharedKey || pri == nil {
+		return nil
+	}
+
+	return destroyKey(context.Background(), pri)
+}
@@ -0,0 +1,100 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// TestClose verifies that Close zeroes the root, chain and skipped message keys in place,
+// drops the ratchet keys, and makes every later operation fail with ErrSessionClosed.
+func TestClose(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithUsageKey([]byte("usage key")))
+
+	alice.Send([]byte("skipped"), nil)
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	bob.Receive(msg, nil)
+
+	usageKey := bob.usageKey
+
+	if err := bob.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var zero crypto.ChainKey
+
+	if bob.rootKey != zero || bob.sendChainKey != zero || bob.recvChainKey != zero {
+		t.Error("Expected the root and chain keys to be zeroed")
+	}
+
+	if len(bob.skippedMessageKeys) != 0 {
+		t.Errorf("Expected no skipped keys, got %d", len(bob.skippedMessageKeys))
+	}
+
+	for _, b := range usageKey {
+		if b != 0 {
+			t.Fatal("Expected the usage key to be zeroed")
+		}
+	}
+
+	if bob.dh.localPrivateKey != nil || bob.dh.remotePublicKey != nil {
+		t.Error("Expected the ratchet keys to be dropped")
+	}
+
+	if _, err := bob.Send([]byte("after close"), nil); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed from Send, got %v", err)
+	}
+
+	if _, err := bob.Receive(msg, nil); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed from Receive, got %v", err)
+	}
+
+	if _, err := bob.Serialize(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed from Serialize, got %v", err)
+	}
+
+	if err := bob.Close(); err != nil {
+		t.Errorf("Expected closing twice to succeed, got %v", err)
+	}
+}
+
+// TestCloseDestroysHardwareKey verifies that Close destroys a ratchet private key that
+// implements Destroyer, but not the key a clone shares with its original.
+func TestCloseDestroysHardwareKey(t *testing.T) {
+	module := &moduleDH{CurveDH: CurveDH{Curve: ecdh.P256()}, keys: map[uint32]*ecdh.PrivateKey{}}
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	handle := module.store(bobPri)
+
+	bob, err := New(handle.Bytes(), alicePri.PublicKey().Bytes(), nil, WithDH(module))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bob.Clone().Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, ok := module.keys[handle.handle]; !ok {
+		t.Error("Expected closing a clone to keep the shared key")
+	}
+
+	if err := bob.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, ok := module.keys[handle.handle]; ok {
+		t.Error("Expected Close to destroy the hardware key")
+	}
+}
@@ -0,0 +1,60 @@
+package doubleratchet
+
+import "context"
+
+// WithoutLocking turns off the session's internal mutex for callers that already
+// serialize every call on the session, for example by confining it to one goroutine in an
+// actor model. Calls must then never overlap, including Serialize, Subscribe and the
+// functions it returns. Sends do not refill keys of WithPrederivation in the background;
+// call Prederive when idle instead.
+func WithoutLocking() Option {
+	return func(d *doubleRatchet) error {
+		d.unlocked = true
+		return nil
+	}
+}
+
+// lock acquires the session lock, unless the session was created WithoutLocking.
+func (d *doubleRatchet) lock() {
+	if !d.unlocked {
+		d.Lock()
+	}
+}
+
+// unlock releases the session lock, unless the session was created WithoutLocking.
+func (d *doubleRatchet) unlock() {
+	if !d.unlocked {
+		d.Unlock()
+	}
+}
+
+// lockContext acquires the session lock, or returns the context's error if ctx is done
+// first. A lock acquired after giving up is released right away.
+func (d *doubleRatchet) lockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if d.unlocked || d.TryLock() {
+		return nil
+	}
+
+	locked := make(chan struct{})
+
+	go func() {
+		d.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			d.Unlock()
+		}()
+
+		return ctx.Err()
+	}
+}
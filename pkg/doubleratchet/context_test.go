@@ -0,0 +1,102 @@
+package doubleratchet
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSendCtxCancelled verifies that a cancelled context fails SendCtx and ReceiveCtx
+// without advancing the session.
+func TestSendCtxCancelled(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := alice.SendCtx(ctx, []byte("hello"), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if msg.Header.N != 0 {
+		t.Errorf("Expected the cancelled send not to advance the chain, got N = %d", msg.Header.N)
+	}
+
+	if _, err := bob.ReceiveCtx(ctx, msg, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := bob.ReceiveCtx(context.Background(), msg, nil); err != nil {
+		t.Errorf("Expected the message to be received after a cancelled attempt, got %v", err)
+	}
+}
+
+// TestSendCtxDeadlineWhileLocked verifies that SendCtx gives up when the session stays
+// locked past the deadline, and that the session is usable once the lock is released.
+func TestSendCtxDeadlineWhileLocked(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	alice.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := alice.SendCtx(ctx, []byte("hello"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	alice.Unlock()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := alice.SendCtx(ctx, []byte("hello"), nil); err != nil {
+		t.Errorf("Expected the session to be usable after the lock was released, got %v", err)
+	}
+}
+
+// TestWithoutLocking verifies that a session created WithoutLocking never takes its
+// mutex, exchanging messages while the mutex is held elsewhere, and does not start
+// background refills of pre-derived keys.
+func TestWithoutLocking(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithoutLocking(), WithPrederivation(4))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithoutLocking())
+
+	alice.Lock()
+	defer alice.Unlock()
+
+	msg, err := alice.Send([]byte("hello"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alice.refilling || len(alice.prederived) != 3 {
+		t.Errorf("Expected no background refill and 3 pre-derived keys, got %v and %d", alice.refilling, len(alice.prederived))
+	}
+
+	alice.Prederive()
+
+	if len(alice.prederived) != 4 {
+		t.Errorf("Expected Prederive to refill 4 keys, got %d", len(alice.prederived))
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+}
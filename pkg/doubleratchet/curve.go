@@ -0,0 +1,76 @@
+package doubleratchet
+
+import "crypto/ecdh"
+
+var (
+	// ErrCurveMismatch is returned by Deserialize when the curve given with WithCurve or
+	// WithDH differs from the curve recorded in the serialized state, and by NewECDH when
+	// the keys are not on the session's curve.
+	ErrCurveMismatch = newError(ErrState, "double ratchet: curve does not match serialized state")
+)
+
+// curves maps the names recorded in serialized state to the curves WithCurve accepts.
+var curves = map[string]ecdh.Curve{
+	"X25519": ecdh.X25519(),
+	"P-384":  ecdh.P384(),
+	"P-521":  ecdh.P521(),
+}
+
+// WithCurve makes the session use curve for its identity and ratchet keys instead of
+// P-256. Both peers must use the same curve, and the keys given to New must be encoded
+// for it. The curve is recorded in the serialized state, so Deserialize restores it
+// without the option. Supported curves are P-256, P-384, P-521 and X25519.
+func WithCurve(curve ecdh.Curve) Option {
+	return func(d *doubleRatchet) error {
+		if curve != ecdh.P256() && curveName(CurveDH{Curve: curve}) == "" {
+			return ErrUnsupportedCurve
+		}
+
+		d.dh.fn = CurveDH{Curve: curve}
+		return nil
+	}
+}
+
+// curveName returns the name recorded in serialized state for a DH function. It is empty
+// for P-256, which sessions use unless configured otherwise, and for DH functions other
+// than the supported curves.
+func curveName(fn DH) string {
+	c, ok := fn.(CurveDH)
+
+	if !ok {
+		return ""
+	}
+
+	for name, curve := range curves {
+		if curve == c.Curve {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// restoreCurve selects the curve recorded in serialized state, unless the options already
+// selected a DH function, which must then be the same curve.
+func (d *doubleRatchet) restoreCurve(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	curve, ok := curves[name]
+
+	if !ok {
+		return ErrUnsupportedCurve
+	}
+
+	if d.dh.fn == nil {
+		d.dh.fn = CurveDH{Curve: curve}
+		return nil
+	}
+
+	if curveName(d.dh.fn) != name {
+		return ErrCurveMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestX25519Session verifies that sessions created with WithCurve(X25519) exchange
+// messages across a DH ratchet step with 32-byte header keys, and that the curve is
+// restored from serialized state without the option.
+func TestX25519Session(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.X25519())
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil); err == nil {
+		t.Fatal("Expected X25519 keys to be rejected without WithCurve")
+	}
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithCurve(ecdh.X25519()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithCurve(ecdh.X25519()))
+
+	stepSendingRatchet(t, alice)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if len(msg.Header.DH) != 32 {
+		t.Errorf("Expected a 32-byte header key, got %d bytes", len(msg.Header.DH))
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	state, _ := bob.Serialize()
+
+	restored, err := Deserialize(state, WithStrictVerification())
+
+	if err != nil {
+		t.Fatalf("Expected the state to be restored with its recorded curve, got %v", err)
+	}
+
+	reply, _ := restored.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if _, err := Deserialize(state, WithDH(CurveDH{Curve: ecdh.P384()})); !errors.Is(err, ErrCurveMismatch) {
+		t.Errorf("Expected ErrCurveMismatch for a different curve, got %v", err)
+	}
+}
+
+// TestWithCurveNIST verifies that sessions on P-384 and P-521 ratchet across a
+// serialization round trip that restores the curve from state, and that P-256 sessions
+// keep serializing without a curve name.
+func TestWithCurveNIST(t *testing.T) {
+	for _, curve := range []ecdh.Curve{ecdh.P384(), ecdh.P521()} {
+		alicePri, bobPri := orderedKeys(curve)
+
+		alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithCurve(curve))
+
+		if err != nil {
+			t.Fatalf("%v: %v", curve, err)
+		}
+
+		bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithCurve(curve))
+
+		stepSendingRatchet(t, alice)
+
+		msg, _ := alice.Send([]byte("hello"), nil)
+
+		if want := (CurveDH{Curve: curve}).PublicKeySize(); len(msg.Header.DH) != want {
+			t.Errorf("%v: Expected a %d-byte header key, got %d bytes", curve, want, len(msg.Header.DH))
+		}
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("%v: Receive failed: %v", curve, err)
+		}
+
+		state, _ := alice.Serialize()
+		restored, err := Deserialize(state, WithStrictVerification())
+
+		if err != nil {
+			t.Fatalf("%v: Expected the state to be restored with its recorded curve, got %v", curve, err)
+		}
+
+		next, _ := restored.Send([]byte("again"), nil)
+
+		if _, err := bob.Receive(next, nil); err != nil {
+			t.Fatalf("%v: Receive after restore failed: %v", curve, err)
+		}
+	}
+
+	pri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	session, err := New(pri.Bytes(), pri.PublicKey().Bytes(), nil, WithCurve(ecdh.P256()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := session.Serialize()
+	decoded, _, _ := decodeState(state)
+
+	if decoded.Curve != "" {
+		t.Errorf("Expected no curve name for P-256, got %q", decoded.Curve)
+	}
+}
+
+// TestWithCurveRejectsUnsupported verifies that WithCurve rejects curves it cannot record
+// in serialized state.
+func TestWithCurveRejectsUnsupported(t *testing.T) {
+	pri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	if _, err := New(pri.Bytes(), pri.PublicKey().Bytes(), nil, WithCurve(nil)); !errors.Is(err, ErrUnsupportedCurve) {
+		t.Errorf("Expected ErrUnsupportedCurve, got %v", err)
+	}
+}
+
+// TestNewECDH verifies that NewECDH takes the session's curve from crypto/ecdh keys,
+// interoperates with a peer created from encoded keys, and rejects keys on a curve other
+// than the configured one.
+func TestNewECDH(t *testing.T) {
+	alicePri, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	alice, err := NewECDH(alicePri, bobPri.PublicKey(), nil)
+
+	if err != nil {
+		t.Fatalf("NewECDH failed: %v", err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithCurve(ecdh.X25519()))
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if len(msg.Header.DH) != 32 {
+		t.Errorf("Expected a 32-byte X25519 header key, got %d bytes", len(msg.Header.DH))
+	}
+
+	if m, err := bob.Receive(msg, nil); err != nil || string(m.Plaintext) != "hello" {
+		t.Errorf("Expected the peer to decrypt the message, got %q, %v", m.Plaintext, err)
+	}
+
+	p256Pri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	if _, err := NewECDH(alicePri, p256Pri.PublicKey(), nil); !errors.Is(err, ErrCurveMismatch) {
+		t.Errorf("Expected ErrCurveMismatch for keys on different curves, got %v", err)
+	}
+
+	if _, err := NewECDH(p256Pri, p256Pri.PublicKey(), nil, WithCurve(ecdh.P384())); !errors.Is(err, ErrCurveMismatch) {
+		t.Errorf("Expected ErrCurveMismatch for keys on another curve than WithCurve, got %v", err)
+	}
+
+	if _, err := NewECDH(alicePri, bobPri.PublicKey(), nil, WithFIPS()); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved for X25519 keys in FIPS mode, got %v", err)
+	}
+}
@@ -1,26 +1,201 @@
 package doubleratchet
 
 import (
+	"context"
 	"crypto/ecdh"
 	"crypto/rand"
 	"errors"
+	"io"
 )
 
 var (
 	// ErrNilRemotePublicKey is returned when the remote public key is nil.
 	ErrNilRemotePublicKey = errors.New("double ratchet: remote public key is nil")
+
+	// ErrUnsupportedCurve is returned when deterministic key generation is requested for an unknown curve.
+	ErrUnsupportedCurve = errors.New("double ratchet: unsupported curve")
 )
 
+// DH is the Diffie-Hellman function of the DH ratchet. The default is P-256 from
+// crypto/ecdh; other implementations, such as FIPS-validated modules, keys held in an HSM
+// or test doubles, can be given with WithDH without changing the ratchet logic.
+type DH interface {
+	// GenerateKey returns a new private key. random is the session's source of
+	// randomness; implementations that generate keys elsewhere may ignore it.
+	GenerateKey(ctx context.Context, random io.Reader) (PrivateKey, error)
+
+	// NewPrivateKey parses a private key encoded by PrivateKey.Bytes. The returned key
+	// must not retain key, which Deserialize erases after use.
+	NewPrivateKey(key []byte) (PrivateKey, error)
+
+	// NewPublicKey parses and validates an encoded public key.
+	NewPublicKey(key []byte) (PublicKey, error)
+
+	// PublicKeySize returns the size of an encoded public key. Headers with ratchet keys
+	// of any other size are rejected before any key operation.
+	PublicKeySize() int
+}
+
+// PrivateKey is a private key of a DH function.
+type PrivateKey interface {
+	// Bytes returns the encoding of the key stored in serialized state. Keys that cannot
+	// leave their module may return a handle that NewPrivateKey accepts instead.
+	Bytes() []byte
+
+	// PublicKey returns the matching public key.
+	PublicKey() PublicKey
+
+	// ECDH returns the shared secret with remote.
+	ECDH(ctx context.Context, remote PublicKey) ([]byte, error)
+}
+
+// Destroyer is implemented by private keys that hold resources outside the process, such
+// as a key slot in a PKCS#11 token, a TPM or a secure enclave. The session calls Destroy on
+// its previous ratchet key once a DH ratchet step has replaced it, so that the module can
+// erase the key and forward secrecy does not depend on the module's key storage.
+type Destroyer interface {
+	Destroy(ctx context.Context) error
+}
+
+// PublicKey is a public key of a DH function.
+type PublicKey interface {
+	// Bytes returns the encoding of the key sent in message headers.
+	Bytes() []byte
+}
+
+// WithDH makes the session use dh for the DH ratchet instead of P-256 from crypto/ecdh.
+// Both peers must use compatible functions. Other than a curve selected with WithCurve,
+// the DH function is not part of the serialized state and must be given again when a
+// session is deserialized.
+func WithDH(dh DH) Option {
+	return func(d *doubleRatchet) error {
+		d.dh.fn = dh
+		return nil
+	}
+}
+
+// CurveDH is the DH implementation backed by a crypto/ecdh curve.
+type CurveDH struct {
+	Curve ecdh.Curve
+}
+
+// GenerateKey generates a key on the curve. Bytes read from random are used as the
+// private key, so that a deterministic reader yields deterministic keys.
+func (c CurveDH) GenerateKey(_ context.Context, random io.Reader) (PrivateKey, error) {
+	pri, err := generateKey(c.Curve, random)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return curvePrivateKey{pri}, nil
+}
+
+// NewPrivateKey parses a private key in the encoding of crypto/ecdh.
+func (c CurveDH) NewPrivateKey(key []byte) (PrivateKey, error) {
+	pri, err := c.Curve.NewPrivateKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return curvePrivateKey{pri}, nil
+}
+
+// NewPublicKey parses a public key in the encoding of crypto/ecdh.
+func (c CurveDH) NewPublicKey(key []byte) (PublicKey, error) {
+	pub, err := c.Curve.NewPublicKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return curvePublicKey{pub}, nil
+}
+
+// PublicKeySize returns the size of a public key on the curve.
+func (c CurveDH) PublicKeySize() int {
+	switch c.Curve {
+	case ecdh.X25519():
+		return 32
+	case ecdh.P256():
+		return p256PointSize
+	case ecdh.P384():
+		return 1 + 2*48
+	case ecdh.P521():
+		return 1 + 2*66
+	default:
+		return 0
+	}
+}
+
+// curvePrivateKey adapts a crypto/ecdh private key to PrivateKey.
+type curvePrivateKey struct {
+	*ecdh.PrivateKey
+}
+
+func (k curvePrivateKey) PublicKey() PublicKey {
+	return curvePublicKey{k.PrivateKey.PublicKey()}
+}
+
+func (k curvePrivateKey) ECDH(_ context.Context, remote PublicKey) ([]byte, error) {
+	pub, ok := remote.(curvePublicKey)
+
+	if !ok {
+		parsed, err := k.Curve().NewPublicKey(remote.Bytes())
+
+		if err != nil {
+			return nil, err
+		}
+
+		pub = curvePublicKey{parsed}
+	}
+
+	return k.PrivateKey.ECDH(pub.PublicKey)
+}
+
+// curvePublicKey adapts a crypto/ecdh public key to PublicKey.
+type curvePublicKey struct {
+	*ecdh.PublicKey
+}
+
+// defaultDH is the DH function of sessions created without WithDH.
+var defaultDH DH = CurveDH{Curve: ecdh.P256()}
+
 type diffieHellmanRatchet struct {
-	localPrivateKey *ecdh.PrivateKey
-	remotePublicKey *ecdh.PublicKey
+	fn              DH
+	localPrivateKey PrivateKey
+	remotePublicKey PublicKey
 }
 
-func (dh *diffieHellmanRatchet) refresh() error {
-	pri, err := ecdh.P256().GenerateKey(rand.Reader)
+// remoteBytes returns the encoding of the remote public key, or nil if the ratchet has
+// not received one yet.
+func (dh *diffieHellmanRatchet) remoteBytes() []byte {
+	if dh.remotePublicKey == nil {
+		return nil
+	}
+
+	return dh.remotePublicKey.Bytes()
+}
+
+// function returns the DH function of the ratchet.
+func (dh *diffieHellmanRatchet) function() DH {
+	if dh.fn == nil {
+		return defaultDH
+	}
+
+	return dh.fn
+}
+
+func (dh *diffieHellmanRatchet) refresh(random io.Reader) error {
+	return dh.refreshContext(context.Background(), random)
+}
+
+func (dh *diffieHellmanRatchet) refreshContext(ctx context.Context, random io.Reader) error {
+	pri, err := dh.function().GenerateKey(ctx, random)
 
 	if err != nil {
-		return err
+		return cryptoError(err)
 	}
 
 	dh.localPrivateKey = pri
@@ -28,18 +203,70 @@ func (dh *diffieHellmanRatchet) refresh() error {
 	return nil
 }
 
-func (dh *diffieHellmanRatchet) exchange(remotePub *ecdh.PublicKey) ([]byte, error) {
+func (dh *diffieHellmanRatchet) exchange(remotePub PublicKey) ([]byte, error) {
+	return dh.exchangeContext(context.Background(), remotePub)
+}
+
+func (dh *diffieHellmanRatchet) exchangeContext(ctx context.Context, remotePub PublicKey) ([]byte, error) {
 	if remotePub == nil {
 		return nil, ErrNilRemotePublicKey
 	}
 
-	sharedSecret, err := dh.localPrivateKey.ECDH(remotePub)
+	sharedSecret, err := dh.localPrivateKey.ECDH(ctx, remotePub)
 
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(err)
 	}
 
 	dh.remotePublicKey = remotePub
 
 	return sharedSecret, nil
 }
+
+// destroyKey destroys pri if it implements Destroyer.
+func destroyKey(ctx context.Context, pri PrivateKey) error {
+	if d, ok := pri.(Destroyer); ok {
+		return d.Destroy(ctx)
+	}
+
+	return nil
+}
+
+// generateKey generates a key pair on curve. A nil random uses crypto/rand; otherwise the
+// private key is derived from bytes read from random, so that a deterministic reader
+// yields deterministic keys (crypto/ecdh's GenerateKey may ignore its reader).
+func generateKey(curve ecdh.Curve, random io.Reader) (*ecdh.PrivateKey, error) {
+	if random == nil {
+		return curve.GenerateKey(rand.Reader)
+	}
+
+	var size int
+
+	switch curve {
+	case ecdh.P256(), ecdh.X25519():
+		size = 32
+	case ecdh.P384():
+		size = 48
+	case ecdh.P521():
+		size = 66
+	default:
+		return nil, ErrUnsupportedCurve
+	}
+
+	buf := make([]byte, size)
+
+	for {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return nil, err
+		}
+
+		if curve == ecdh.P521() {
+			buf[0] &= 0x01
+		}
+
+		// Scalars outside the curve order are rejected; draw again, as in rejection sampling.
+		if pri, err := curve.NewPrivateKey(buf); err == nil {
+			return pri, nil
+		}
+	}
+}
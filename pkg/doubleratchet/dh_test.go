@@ -2,6 +2,11 @@ package doubleratchet
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -11,13 +16,13 @@ import (
 func TestDHKeyExchangeAndSharedSecretAgreement(t *testing.T) {
 	dh1 := &diffieHellmanRatchet{}
 
-	if err := dh1.refresh(); err != nil {
+	if err := dh1.refresh(nil); err != nil {
 		t.Fatal(err)
 	}
 
 	dh2 := &diffieHellmanRatchet{}
 
-	if err := dh2.refresh(); err != nil {
+	if err := dh2.refresh(nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -47,7 +52,7 @@ func TestDHKeyExchangeAndSharedSecretAgreement(t *testing.T) {
 
 	oldPub := dh1.localPrivateKey.PublicKey().Bytes()
 
-	if err := dh1.refresh(); err != nil {
+	if err := dh1.refresh(nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -64,13 +69,13 @@ func TestDHKeyExchangeAndSharedSecretAgreement(t *testing.T) {
 func TestDHKeyRefreshChangesPublicKey(t *testing.T) {
 	dh := &diffieHellmanRatchet{}
 
-	if err := dh.refresh(); err != nil {
+	if err := dh.refresh(nil); err != nil {
 		t.Fatal(err)
 	}
 
 	secret1, _ := dh.exchange(dh.localPrivateKey.PublicKey())
 
-	if err := dh.refresh(); err != nil {
+	if err := dh.refresh(nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -89,11 +94,11 @@ func TestDHSharedSecretChangesAfterRefresh(t *testing.T) {
 	dh2 := &diffieHellmanRatchet{}
 
 	for i := range 5 {
-		if err := dh1.refresh(); err != nil {
+		if err := dh1.refresh(nil); err != nil {
 			t.Fatal(err)
 		}
 
-		if err := dh2.refresh(); err != nil {
+		if err := dh2.refresh(nil); err != nil {
 			t.Fatal(err)
 		}
 
@@ -113,8 +118,8 @@ func TestDHMultipleRatchetStepsProduceUniqueSecrets(t *testing.T) {
 	dh1 := &diffieHellmanRatchet{}
 	dh2 := &diffieHellmanRatchet{}
 
-	dh1.refresh()
-	dh2.refresh()
+	dh1.refresh(nil)
+	dh2.refresh(nil)
 
 	pub2Before := dh2.localPrivateKey.PublicKey().Bytes()
 	dh1.exchange(dh2.localPrivateKey.PublicKey())
@@ -123,7 +128,7 @@ func TestDHMultipleRatchetStepsProduceUniqueSecrets(t *testing.T) {
 		t.Error("dh1 remotePublicKey not updated correctly")
 	}
 
-	dh2.refresh()
+	dh2.refresh(nil)
 
 	pub2After := dh2.localPrivateKey.PublicKey().Bytes()
 	dh1.exchange(dh2.localPrivateKey.PublicKey())
@@ -138,7 +143,7 @@ func TestDHMultipleRatchetStepsProduceUniqueSecrets(t *testing.T) {
 // synchronization between parties.
 func TestDHRemotePublicKeyUpdateTracking(t *testing.T) {
 	dh := &diffieHellmanRatchet{}
-	dh.refresh()
+	dh.refresh(nil)
 
 	if _, err := dh.exchange(nil); err == nil {
 		t.Error("Expected error when exchanging with nil public key")
@@ -150,7 +155,7 @@ func TestDHRemotePublicKeyUpdateTracking(t *testing.T) {
 // and potential security vulnerabilities.
 func TestDHExchangeWithNilKeyReturnsError(t *testing.T) {
 	dh := &diffieHellmanRatchet{}
-	dh.refresh()
+	dh.refresh(nil)
 
 	if _, err := dh.exchange(nil); err == nil {
 		t.Error("Expected error when exchanging with nil public key")
@@ -164,8 +169,8 @@ func TestDHExchangeDeterminism(t *testing.T) {
 	dh1 := &diffieHellmanRatchet{}
 	dh2 := &diffieHellmanRatchet{}
 
-	dh1.refresh()
-	dh2.refresh()
+	dh1.refresh(nil)
+	dh2.refresh(nil)
 
 	secret1, _ := dh1.exchange(dh2.localPrivateKey.PublicKey())
 	secret2, _ := dh1.exchange(dh2.localPrivateKey.PublicKey())
@@ -174,3 +179,248 @@ func TestDHExchangeDeterminism(t *testing.T) {
 		t.Error("Exchange should be deterministic for same keys")
 	}
 }
+
+// ctxDH wraps a CurveDH and records the contexts its key operations run under.
+type ctxDH struct {
+	CurveDH
+
+	contexts []context.Context
+}
+
+type ctxPrivateKey struct {
+	PrivateKey
+
+	dh *ctxDH
+}
+
+func (d *ctxDH) GenerateKey(ctx context.Context, random io.Reader) (PrivateKey, error) {
+	pri, err := d.CurveDH.GenerateKey(ctx, random)
+
+	return ctxPrivateKey{pri, d}, err
+}
+
+func (d *ctxDH) NewPrivateKey(key []byte) (PrivateKey, error) {
+	pri, err := d.CurveDH.NewPrivateKey(key)
+
+	return ctxPrivateKey{pri, d}, err
+}
+
+func (k ctxPrivateKey) ECDH(ctx context.Context, remote PublicKey) ([]byte, error) {
+	k.dh.contexts = append(k.dh.contexts, ctx)
+
+	return k.PrivateKey.ECDH(ctx, remote)
+}
+
+// TestWithDHReplacesCurve verifies that sessions run the DH ratchet with the DH function
+// given by WithDH, on keys of its curve, and reject header keys of another size.
+func TestWithDHReplacesCurve(t *testing.T) {
+	dh := CurveDH{Curve: ecdh.P384()}
+
+	alicePri, bobPri := orderedKeys(ecdh.P384())
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil); err == nil {
+		t.Fatal("Expected P-384 keys to be rejected by the default DH function")
+	}
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithDH(dh))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithDH(dh))
+
+	stepSendingRatchet(t, alice)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if len(msg.Header.DH) != dh.PublicKeySize() {
+		t.Errorf("Expected a %d-byte header key, got %d bytes", dh.PublicKeySize(), len(msg.Header.DH))
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	state, _ := bob.Serialize()
+
+	if _, err := Deserialize(state, WithDH(dh), WithStrictVerification()); err != nil {
+		t.Errorf("Expected the state to be restored with the same DH function, got %v", err)
+	}
+
+	p256, _ := ecdh.P256().GenerateKey(rand.Reader)
+	msg.Header.DH = p256.PublicKey().Bytes()
+
+	if _, err := bob.Receive(msg, nil); !errors.Is(err, ErrInvalidHeaderKey) {
+		t.Errorf("Expected ErrInvalidHeaderKey for a P-256 header key, got %v", err)
+	}
+}
+
+// TestWithDHReceivesContext verifies that the DH operations of a ratchet step run under
+// the context given to ReceiveCtx for the receiving half, and to SendCtx for the sending
+// half.
+func TestWithDHReceivesContext(t *testing.T) {
+	dh := &ctxDH{CurveDH: CurveDH{Curve: ecdh.P256()}}
+
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithDH(dh))
+
+	stepSendingRatchet(t, alice)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	type key struct{}
+
+	ctx := context.WithValue(context.Background(), key{}, "receive")
+	dh.contexts = nil
+
+	if _, err := bob.ReceiveCtx(ctx, msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sendCtx := context.WithValue(context.Background(), key{}, "send")
+
+	if _, err := bob.SendCtx(sendCtx, []byte("reply"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dh.contexts) != 2 {
+		t.Fatalf("Expected 2 DH operations in the ratchet step, got %d", len(dh.contexts))
+	}
+
+	if dh.contexts[0].Value(key{}) != "receive" || dh.contexts[1].Value(key{}) != "send" {
+		t.Error("Expected the DH operations to run under the ReceiveCtx and SendCtx contexts")
+	}
+}
+
+// moduleDH stands in for a hardware key store: private keys stay in the module, sessions
+// hold 4-byte handles to them, and destroyed keys are erased.
+type moduleDH struct {
+	CurveDH
+
+	keys map[uint32]*ecdh.PrivateKey
+	next uint32
+}
+
+type moduleKey struct {
+	dh     *moduleDH
+	handle uint32
+}
+
+func (m *moduleDH) store(pri *ecdh.PrivateKey) moduleKey {
+	m.next++
+	m.keys[m.next] = pri
+
+	return moduleKey{m, m.next}
+}
+
+func (m *moduleDH) GenerateKey(_ context.Context, _ io.Reader) (PrivateKey, error) {
+	pri, err := m.Curve.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.store(pri), nil
+}
+
+func (m *moduleDH) NewPrivateKey(key []byte) (PrivateKey, error) {
+	if len(key) != 4 {
+		return nil, errors.New("module: invalid handle")
+	}
+
+	handle := uint32(key[0])<<24 | uint32(key[1])<<16 | uint32(key[2])<<8 | uint32(key[3])
+
+	if m.keys[handle] == nil {
+		return nil, errors.New("module: unknown key")
+	}
+
+	return moduleKey{m, handle}, nil
+}
+
+func (k moduleKey) Bytes() []byte {
+	return []byte{byte(k.handle >> 24), byte(k.handle >> 16), byte(k.handle >> 8), byte(k.handle)}
+}
+
+func (k moduleKey) PublicKey() PublicKey {
+	return curvePublicKey{k.dh.keys[k.handle].PublicKey()}
+}
+
+func (k moduleKey) ECDH(ctx context.Context, remote PublicKey) ([]byte, error) {
+	pri := k.dh.keys[k.handle]
+
+	if pri == nil {
+		return nil, errors.New("module: key destroyed")
+	}
+
+	return curvePrivateKey{pri}.ECDH(ctx, remote)
+}
+
+func (k moduleKey) Destroy(context.Context) error {
+	delete(k.dh.keys, k.handle)
+	return nil
+}
+
+// TestWithDHHardwareKeys verifies that a session can keep its ratchet keys in an external
+// module: the serialized state holds only the module's handle, a ratchet step requests a
+// new key from the module and destroys the replaced one, and the state is restored
+// through the module.
+func TestWithDHHardwareKeys(t *testing.T) {
+	module := &moduleDH{CurveDH: CurveDH{Curve: ecdh.P256()}, keys: map[uint32]*ecdh.PrivateKey{}}
+
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	handle := module.store(bobPri)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, err := New(handle.Bytes(), alicePri.PublicKey().Bytes(), nil, WithDH(module))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stepSendingRatchet(t, alice)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	first, _ := bob.Send([]byte("first reply"), nil)
+
+	if _, err := alice.Receive(first, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if _, ok := module.keys[handle.handle]; ok || len(module.keys) != 1 {
+		t.Errorf("Expected the module to hold only the new ratchet key, got %d keys", len(module.keys))
+	}
+
+	data, _ := bob.Serialize()
+	state, _, _ := decodeState(data)
+
+	if len(state.LocalPri) != 4 {
+		t.Errorf("Expected the state to hold a 4-byte handle, got %d bytes", len(state.LocalPri))
+	}
+
+	restored, err := Deserialize(data, WithDH(module))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, _ := restored.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"hash"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// maxChainDistance bounds how many chain steps CompareStates derives when checking that
+// one snapshot's chain key follows from the other's.
+const maxChainDistance = 1 << 16
+
+// Recovery is the recovery action recommended by CompareStates.
+type Recovery int
+
+const (
+	// RecoveryNone means the snapshots are identical and nothing needs to be done.
+	RecoveryNone Recovery = iota
+
+	// RecoveryKeepA means snapshot B is an older copy of A: discard B and continue with A.
+	RecoveryKeepA
+
+	// RecoveryKeepB means snapshot A is an older copy of B: discard A and continue with B.
+	RecoveryKeepB
+
+	// RecoveryReset means both snapshots advanced independently, or cannot be related to
+	// each other. Neither is safe to continue with: a new session must be established
+	// with the peer.
+	RecoveryReset
+)
+
+// String returns a short description of the recovery action.
+func (r Recovery) String() string {
+	switch r {
+	case RecoveryNone:
+		return "none"
+	case RecoveryKeepA:
+		return "keep A, discard B"
+	case RecoveryKeepB:
+		return "keep B, discard A"
+	default:
+		return "re-establish the session"
+	}
+}
+
+// Divergence reports how two snapshots of the same session relate to each other.
+type Divergence struct {
+	// Diverged is false if the snapshots are identical.
+	Diverged bool
+
+	// Point names where the snapshots first differ: "epoch", "ratchet keys", "sending
+	// chain" or "receiving chain". It is empty if they do not differ.
+	Point string
+
+	// Reason explains the recommendation.
+	Reason string
+
+	// Recommendation is the safe recovery action.
+	Recommendation Recovery
+
+	// The epoch and chain counters of each snapshot.
+	EpochA, EpochB uint32
+	SendNA, SendNB uint32
+	RecvNA, RecvNB uint32
+}
+
+// CompareStates compares two serialized snapshots of the same session that may have been
+// advanced independently, for example by two processes after a split-brain, and reports
+// where they diverged and how to recover.
+//
+// A snapshot is only recommended when the other is provably an older copy of it: within
+// an epoch, its chain keys must follow from the older ones. When each snapshot is ahead
+// in a different direction, both may have used the same message keys for different
+// messages, so a new session is recommended instead of guessing.
+func CompareStates(a, b []byte) (Divergence, error) {
+	sa, _, err := decodeState(a)
+
+	if err != nil {
+		return Divergence{}, err
+	}
+
+	sb, _, err := decodeState(b)
+
+	if err != nil {
+		return Divergence{}, err
+	}
+
+	report := Divergence{
+		EpochA: sa.Epoch, EpochB: sb.Epoch,
+		SendNA: sa.SendN, SendNB: sb.SendN,
+		RecvNA: sa.RecvN, RecvNB: sb.RecvN,
+	}
+
+	if sa.Epoch != sb.Epoch {
+		return report.diverged("epoch", recommendNewer(sa.Epoch, sb.Epoch),
+			"the snapshots are in different epochs; the newer one has performed more DH ratchet steps, "+
+				"and messages the older copy sent since the split may not be decryptable"), nil
+	}
+
+	if sa.RootKey != sb.RootKey || !bytes.Equal(sa.LocalPri, sb.LocalPri) || !bytes.Equal(sa.RemotePub, sb.RemotePub) || sa.KDFHash != sb.KDFHash || sa.Label != sb.Label {
+		return report.diverged("ratchet keys", RecoveryReset,
+			"the snapshots are in the same epoch but hold different ratchet keys, KDF hashes or labels; they belong to different sessions or forked during a DH ratchet step"), nil
+	}
+
+	h := kdfHashFunc(sa.KDFHash)
+	send := compareChain(h, sa.SendChainKey, sa.SendN, sb.SendChainKey, sb.SendN)
+	recv := compareChain(h, sa.RecvChainKey, sa.RecvN, sb.RecvChainKey, sb.RecvN)
+
+	switch {
+	case send == chainUnrelated:
+		return report.diverged("sending chain", RecoveryReset, "the sending chain keys do not follow from each other"), nil
+	case recv == chainUnrelated:
+		return report.diverged("receiving chain", RecoveryReset, "the receiving chain keys do not follow from each other"), nil
+	case send == chainEqual && recv == chainEqual:
+		return report, nil
+	}
+
+	point := "sending chain"
+
+	if send == chainEqual {
+		point = "receiving chain"
+	}
+
+	switch {
+	case send != chainBAhead && recv != chainBAhead:
+		return report.diverged(point, RecoveryKeepA, "B is an older copy of A"), nil
+	case send != chainAAhead && recv != chainAAhead:
+		return report.diverged(point, RecoveryKeepB, "A is an older copy of B"), nil
+	default:
+		return report.diverged(point, RecoveryReset,
+			"each snapshot is ahead in a different direction, so both advanced independently and may have reused message keys"), nil
+	}
+}
+
+// diverged marks the report as diverged at point with the given recommendation.
+func (d Divergence) diverged(point string, recommendation Recovery, reason string) Divergence {
+	d.Diverged = true
+	d.Point = point
+	d.Recommendation = recommendation
+	d.Reason = reason
+
+	return d
+}
+
+// recommendNewer recommends keeping the snapshot in the later epoch.
+func recommendNewer(epochA, epochB uint32) Recovery {
+	if epochA > epochB {
+		return RecoveryKeepA
+	}
+
+	return RecoveryKeepB
+}
+
+// chainRelation describes how two positions of the same symmetric chain relate.
+type chainRelation int
+
+const (
+	chainEqual chainRelation = iota
+	chainAAhead
+	chainBAhead
+	chainUnrelated
+)
+
+// compareChain determines which chain position is ahead, verifying that the chain key
+// ahead is derived from the one behind with the hash function h.
+func compareChain(h func() hash.Hash, ckA crypto.ChainKey, nA uint32, ckB crypto.ChainKey, nB uint32) chainRelation {
+	switch {
+	case nA == nB && ckA == ckB:
+		return chainEqual
+	case nA > nB && chainReaches(h, ckB, nA-nB, ckA):
+		return chainAAhead
+	case nB > nA && chainReaches(h, ckA, nB-nA, ckB):
+		return chainBAhead
+	default:
+		return chainUnrelated
+	}
+}
+
+// chainReaches reports whether advancing from by steps chain steps yields to.
+func chainReaches(h func() hash.Hash, from crypto.ChainKey, steps uint32, to crypto.ChainKey) bool {
+	if steps > maxChainDistance {
+		return false
+	}
+
+	for range steps {
+		from, _ = crypto.DeriveCKWith(h, from)
+	}
+
+	return from == to
+}
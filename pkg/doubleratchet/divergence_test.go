@@ -0,0 +1,132 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+// newDivergencePair returns a fresh session pair for divergence tests.
+func newDivergencePair(t *testing.T) (alice, bob *doubleRatchet) {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ = New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ = New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	return alice, bob
+}
+
+// TestCompareStatesStaleCopy verifies that identical snapshots are not reported as
+// diverged, and that a snapshot that merely fell behind is identified so the newer copy
+// can be kept.
+func TestCompareStatesStaleCopy(t *testing.T) {
+	alice, bob := newDivergencePair(t)
+
+	old, _ := alice.Serialize()
+
+	report, err := CompareStates(old, old)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Diverged || report.Recommendation != RecoveryNone {
+		t.Errorf("Expected identical snapshots, got %+v", report)
+	}
+
+	for range 2 {
+		msg, _ := alice.Send([]byte("hello"), nil)
+		_, _ = bob.Receive(msg, nil)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+	_, _ = alice.Receive(reply, nil)
+
+	current, _ := alice.Serialize()
+
+	report, err = CompareStates(old, current)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Diverged || report.Recommendation != RecoveryKeepB {
+		t.Errorf("Expected %v, got %+v", RecoveryKeepB, report)
+	}
+
+	if report.SendNA != 0 || report.SendNB != 2 || report.RecvNB != 1 {
+		t.Errorf("Unexpected counters in report: %+v", report)
+	}
+}
+
+// TestCompareStatesSplitBrain verifies that two copies advanced independently in
+// different directions are reported as forked with a reset recommendation.
+func TestCompareStatesSplitBrain(t *testing.T) {
+	alice, bob := newDivergencePair(t)
+
+	snapshot, _ := alice.Serialize()
+
+	first, _ := Deserialize(snapshot)
+	second, _ := Deserialize(snapshot)
+
+	_, _ = first.Send([]byte("from the first copy"), nil)
+
+	msg, _ := bob.Send([]byte("to the second copy"), nil)
+
+	if _, err := second.Receive(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := first.Serialize()
+	b, _ := second.Serialize()
+
+	report, err := CompareStates(a, b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Recommendation != RecoveryReset || report.Point != "sending chain" {
+		t.Errorf("Expected a reset at the sending chain, got %+v", report)
+	}
+}
+
+// TestCompareStatesUnrelated verifies that snapshots of different sessions, or in
+// different epochs, are reported at the right divergence point.
+func TestCompareStatesUnrelated(t *testing.T) {
+	alice, _ := newDivergencePair(t)
+	other, _ := newDivergencePair(t)
+
+	a, _ := alice.Serialize()
+	b, _ := other.Serialize()
+
+	report, err := CompareStates(a, b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Recommendation != RecoveryReset || report.Point != "ratchet keys" {
+		t.Errorf("Expected a reset at the ratchet keys, got %+v", report)
+	}
+
+	var state State
+
+	_ = json.Unmarshal(a, &state)
+	state.Epoch = 3
+	later, _ := json.Marshal(state)
+
+	report, err = CompareStates(later, a)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Point != "epoch" || report.Recommendation != RecoveryKeepA || report.EpochA != 3 {
+		t.Errorf("Expected to keep the later epoch, got %+v", report)
+	}
+}
@@ -2,12 +2,17 @@ package doubleratchet
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdh"
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/othonhugo/goratchet/pkg/crypto"
+	"github.com/othonhugo/goratchet/pkg/transparency"
 )
 
 const (
@@ -15,85 +20,252 @@ const (
 	MaxSkip = 1000
 )
 
+// errNoSkippedKey is returned by trySkippedMessageKeys when no skipped key matches a header.
+var errNoSkippedKey = errors.New("double ratchet: message key not found")
+
 type doubleRatchet struct {
 	sync.Mutex
 
-	dh      diffieHellmanRatchet
-	rootKey crypto.ChainKey
+	dh diffieHellmanRatchet
+
+	*chainKeys
+
+	secureMemory SecureMemory
+	secured      *securedKeys
+
+	sendN  uint32
+	recvN  uint32
+	prevN  uint32
+	recvPN uint32
+	epoch  uint32
+
+	skippedMessageKeys map[headerID]skippedKey
+
+	replay    []replayID
+	replayMax int
+
+	transcript *transcript
+	escrow     *ecdh.PublicKey
+	rand       io.Reader
+
+	interceptors []Interceptor
+	identity     *identity
+
+	identityStatus transparency.Status
+
+	usage    Usage
+	usageKey []byte
+
+	closed     bool
+	strict     bool
+	eraseInput bool
+
+	now func() time.Time
+
+	serializer Serializer
+	aead       AEAD
+	noLegacyAD bool
+
+	prederived   []prederivedKey
+	prederiveMax int
+	refilling    bool
+
+	paddingMax int
+
+	subscribers   map[*subscriber]struct{}
+	pendingEvents []Event
+	rekeyPending  bool
+
+	fips     bool
+	archived bool
+
+	kdfHash KDFHash
+	label   string
 
-	sendChainKey crypto.ChainKey
-	recvChainKey crypto.ChainKey
+	maxPlaintext  int
+	maxCiphertext int
 
-	sendN uint32
-	recvN uint32
-	prevN uint32
+	maxSkipMessage int
+	maxSkipTotal   int
+	limiter        Limiter
 
-	skippedMessageKeys map[headerID]crypto.MessageKey
+	plaintextPadding PaddingScheme
+
+	unlocked bool
+
+	rekeyPolicy   RekeyPolicy
+	chainStarted  time.Time
+	chainBytes    uint64
+	rekeyDeferred bool
+
+	sendStepPending bool
+
+	maxPrevChains   int
+	limitPrevChains bool
+
+	hybrid    *hybridRatchet
+	initialPQ []byte
+
+	txn *receiveTxn
+
+	sharedKey bool
+
+	sessionID []byte
+
+	store        Store
+	skippedStore SkippedKeyStore
 }
 
-// New creates a new DoubleRatchet session.
-func New(localPri, remotePub, salt []byte) (*doubleRatchet, error) {
-	pri, err := ecdh.P256().NewPrivateKey(localPri)
+// New creates a new DoubleRatchet session from the parties' initial key pairs. Both sides
+// derive a sending and a receiving chain at once from their shared secret, ordered by their
+// public keys, so either may send first. Use InitAlice and InitBob for the specification's
+// initialization, in which the initiator has only a sending chain until the responder's
+// first ratchet key arrives.
+func New(localPri, remotePub, salt []byte, opts ...Option) (*doubleRatchet, error) {
+	d, err := configure(opts)
 
 	if err != nil {
 		return nil, err
 	}
 
-	pub, err := ecdh.P256().NewPublicKey(remotePub)
+	if err := d.checkFIPS(); err != nil {
+		return nil, err
+	}
+
+	pri, err := d.dh.function().NewPrivateKey(localPri)
 
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(err)
 	}
 
-	sharedSecret, err := pri.ECDH(pub)
+	pub, err := d.dh.function().NewPublicKey(remotePub)
+
+	if err != nil {
+		return nil, cryptoError(err)
+	}
+
+	return d.start(pri, pub, salt)
+}
+
+// NewECDH is like New, but takes crypto/ecdh keys instead of their encodings. Unless the
+// options select a DH function, the session uses the curve of the keys, which must be
+// one WithCurve supports; otherwise both keys must be on the curve of WithCurve and
+// ErrCurveMismatch is returned if they are not.
+func NewECDH(localPri *ecdh.PrivateKey, remotePub *ecdh.PublicKey, salt []byte, opts ...Option) (*doubleRatchet, error) {
+	d, err := configure(opts)
 
 	if err != nil {
 		return nil, err
 	}
 
-	d := &doubleRatchet{}
+	curve := localPri.Curve()
+
+	if d.dh.fn == nil {
+		if err := WithCurve(curve)(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if fn, ok := d.dh.fn.(CurveDH); !ok || fn.Curve != curve || remotePub.Curve() != curve {
+		return nil, ErrCurveMismatch
+	}
+
+	if err := d.checkFIPS(); err != nil {
+		return nil, err
+	}
+
+	return d.start(curvePrivateKey{localPri}, curvePublicKey{remotePub}, salt)
+}
+
+// configure creates a session with opts applied.
+func configure(opts []Option) (*doubleRatchet, error) {
+	d := &doubleRatchet{chainKeys: &chainKeys{}, fips: fipsDefault}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.secureKeys(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// start initializes a configured session from its initial key pair.
+func (d *doubleRatchet) start(pri PrivateKey, pub PublicKey, salt []byte) (*doubleRatchet, error) {
+	sharedSecret, err := pri.ECDH(context.Background(), pub)
+
+	if err != nil {
+		return nil, cryptoError(err)
+	}
 
 	// We use a default salt or nil.
 	if err := d.init(pri, pub, sharedSecret, salt); err != nil {
 		return nil, err
 	}
 
+	d.prederive()
+
 	return d, nil
 }
 
-// init initializes the DoubleRatchet with the given keys and shared secret.
-func (d *doubleRatchet) init(localPri *ecdh.PrivateKey, remotePub *ecdh.PublicKey, sharedSecret, salt []byte) error {
+func (d *doubleRatchet) init(localPri PrivateKey, remotePub PublicKey, sharedSecret, salt []byte) error {
 	d.dh.localPrivateKey = localPri
 	d.dh.remotePublicKey = remotePub
 
-	d.skippedMessageKeys = make(map[headerID]crypto.MessageKey)
+	d.skippedMessageKeys = make(map[headerID]skippedKey)
 
 	// Derive distinct keys for send and receive chains to prevent reflection attacks.
 	localPubBytes := localPri.PublicKey().Bytes()
 	remotePubBytes := remotePub.Bytes()
 
+	if err := d.deriveSessionID(localPubBytes, remotePubBytes); err != nil {
+		return err
+	}
+
 	var infoSend, infoRecv []byte
 
 	if bytes.Compare(localPubBytes, remotePubBytes) < 0 {
 		// We are "Alice" (lesser key)
-		infoSend = []byte("DoubleRatchet-Chain-1")
-		infoRecv = []byte("DoubleRatchet-Chain-2")
+		infoSend = d.info("-Chain-1")
+		infoRecv = d.info("-Chain-2")
 	} else {
 		// We are "Bob" (greater key)
-		infoSend = []byte("DoubleRatchet-Chain-2")
-		infoRecv = []byte("DoubleRatchet-Chain-1")
+		infoSend = d.info("-Chain-2")
+		infoRecv = d.info("-Chain-1")
 	}
 
+	// Combine the ECDH secret with any post-quantum secret given with WithInitialPQSecret.
+	ikm := append(sharedSecret[:len(sharedSecret):len(sharedSecret)], d.initialPQ...)
+
+	clear(d.initialPQ)
+	d.initialPQ = nil
+
 	// Derive Root Key
-	rk := crypto.DeriveHKDF(sharedSecret, salt, []byte("DoubleRatchet-Root"), 32)
+	rk, err := crypto.DeriveHKDFWith(d.hash(), ikm, salt, d.info("-Root"), 32)
+
+	if err != nil {
+		return err
+	}
 
 	copy(d.rootKey[:], rk)
 
-	ckSend := crypto.DeriveHKDF(sharedSecret, salt, infoSend, 32)
+	ckSend, err := crypto.DeriveHKDFWith(d.hash(), ikm, salt, infoSend, 32)
+
+	if err != nil {
+		return err
+	}
 
 	copy(d.sendChainKey[:], ckSend)
 
-	ckRecv := crypto.DeriveHKDF(sharedSecret, salt, infoRecv, 32)
+	ckRecv, err := crypto.DeriveHKDFWith(d.hash(), ikm, salt, infoRecv, 32)
+
+	if err != nil {
+		return err
+	}
 
 	copy(d.recvChainKey[:], ckRecv)
 
@@ -102,74 +274,398 @@ func (d *doubleRatchet) init(localPri *ecdh.PrivateKey, remotePub *ecdh.PublicKe
 
 // Send encrypts the given plaintext with associated data and returns a CipheredMessage.
 func (d *doubleRatchet) Send(plaintext, ad []byte) (CipheredMessage, error) {
-	d.Lock()
-	defer d.Unlock()
+	return d.SendCtx(context.Background(), plaintext, ad)
+}
+
+// SendCtx is like Send, but gives up with the context's error if ctx is done before the
+// session lock is acquired or before the message key is derived.
+func (d *doubleRatchet) SendCtx(ctx context.Context, plaintext, ad []byte) (CipheredMessage, error) {
+	return d.SendTypeCtx(ctx, MessageNormal, plaintext, ad)
+}
+
+// SendType is like Send, but marks the message with typ. See MessageType.
+func (d *doubleRatchet) SendType(typ MessageType, plaintext, ad []byte) (CipheredMessage, error) {
+	return d.SendTypeCtx(context.Background(), typ, plaintext, ad)
+}
+
+// SendTypeCtx is like SendType, but gives up with the context's error like SendCtx.
+func (d *doubleRatchet) SendTypeCtx(ctx context.Context, typ MessageType, plaintext, ad []byte) (CipheredMessage, error) {
+	return d.send(ctx, sendParams{typ: typ}, nil, plaintext, ad)
+}
+
+// EncryptInto is like Send, but appends the ciphertext to dst[:0], so that the returned
+// message's Ciphertext reuses dst's storage when it is large enough. dst must not overlap
+// plaintext, and must not be reused while the message is still needed.
+func (d *doubleRatchet) EncryptInto(dst, plaintext, ad []byte) (CipheredMessage, error) {
+	return d.send(context.Background(), sendParams{typ: MessageNormal}, dst[:0], plaintext, ad)
+}
+
+// send encrypts plaintext as a message with the header fields of params, appending the
+// ciphertext to dst.
+func (d *doubleRatchet) send(ctx context.Context, params sendParams, dst, plaintext, ad []byte) (CipheredMessage, error) {
+	if err := d.lockContext(ctx); err != nil {
+		return CipheredMessage{}, err
+	}
+
+	defer d.unlock()
+
+	return d.sendLocked(ctx, params, dst, plaintext, ad)
+}
+
+// sendLocked does the work of send. The caller must hold the lock.
+func (d *doubleRatchet) sendLocked(ctx context.Context, params sendParams, dst, plaintext, ad []byte) (CipheredMessage, error) {
+	if d.closed {
+		return CipheredMessage{}, ErrSessionClosed
+	}
+
+	if d.archived {
+		return CipheredMessage{}, ErrArchived
+	}
+
+	if err := d.checkPlaintextSize(plaintext); err != nil {
+		return CipheredMessage{}, err
+	}
+
+	if d.dh.remotePublicKey == nil {
+		return CipheredMessage{}, ErrNoSendingChain
+	}
+
+	fullAD, err := d.sendAD(plaintext, ad)
+
+	if err != nil {
+		return CipheredMessage{}, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return CipheredMessage{}, err
+	}
+
+	step := d.sendStepPending
+
+	if d.rekeyDue() {
+		if d.hasTurn() {
+			step = true
+		} else {
+			d.deferRekey()
+		}
+	}
+
+	if step {
+		if err := d.stepSendingRatchet(ctx); err != nil {
+			return CipheredMessage{}, err
+		}
+	}
+
+	padding, err := d.padding()
+
+	if err != nil {
+		return CipheredMessage{}, err
+	}
+
+	nextCk, mk := d.nextSendingKey()
 
-	nextCk, mk := crypto.DeriveCK(d.sendChainKey)
+	defer clear(mk[:])
 
 	d.sendChainKey = nextCk
+	clear(nextCk[:])
 
 	header := Header{
-		DH: d.dh.localPrivateKey.PublicKey().Bytes(),
-		N:  d.sendN,
-		PN: d.prevN,
+		DH:      d.dh.localPrivateKey.PublicKey().Bytes(),
+		N:       d.sendN,
+		PN:      d.prevN,
+		Padding: padding,
+		PQ:      d.hybridHeader(),
+	}
+
+	params.apply(&header)
+
+	payload := plaintext
+
+	if d.plaintextPadding != nil {
+		header.Version = headerVersionPadded
+		payload = d.padPlaintext(plaintext)
+
+		defer clear(payload)
 	}
 
 	d.sendN++
 
-	ciphertext, err := crypto.Encrypt(mk, plaintext, ad)
+	var escrow []byte
+
+	if d.escrow != nil {
+		block, err := wrapEscrow(d.rand, d.escrow, mk, header)
+
+		if err != nil {
+			return CipheredMessage{}, err
+		}
+
+		escrow = block
+	}
+
+	ciphertext, err := d.seal(dst, mk, payload, bindHeader(escrowAD(headerAD(fullAD, header), escrow), header))
 
 	if err != nil {
 		return CipheredMessage{}, err
 	}
 
-	return CipheredMessage{
+	msg := CipheredMessage{
 		Header:     header,
 		Ciphertext: ciphertext,
-	}, nil
+		Escrow:     escrow,
+	}
+
+	d.usage.MessagesSent++
+	d.usage.BytesSent += uint64(len(plaintext))
+	d.chainBytes += uint64(len(plaintext))
+
+	if d.transcript != nil {
+		d.transcript.add(&d.transcript.sent, msg)
+	}
+
+	if err := d.save(ctx); err != nil {
+		return CipheredMessage{}, err
+	}
+
+	d.scheduleRefill()
+
+	if d.rekeyPending {
+		d.rekeyPending = false
+		d.record(EventRekeyCompleted, header.DH)
+	}
+
+	d.publish(true)
+
+	return msg, nil
 }
 
 // Receive decrypts the given CipheredMessage with associated data and returns an UncipheredMessage.
 func (d *doubleRatchet) Receive(msg CipheredMessage, ad []byte) (UncipheredMessage, error) {
-	d.Lock()
-	defer d.Unlock()
+	return d.ReceiveCtx(context.Background(), msg, ad)
+}
+
+// ReceiveCtx is like Receive, but gives up with the context's error if ctx is done before
+// the session lock is acquired or before a DH ratchet step. ctx is passed on to the key
+// operations of the DH function, so that remote key backends can honor it; see WithDH.
+func (d *doubleRatchet) ReceiveCtx(ctx context.Context, msg CipheredMessage, ad []byte) (UncipheredMessage, error) {
+	return d.receiveInto(ctx, nil, msg, ad)
+}
+
+// DecryptInto is like Receive, but appends the plaintext to dst[:0], so that the returned
+// Plaintext reuses dst's storage when it is large enough. dst must not overlap the
+// message's ciphertext, and its contents are undefined if DecryptInto fails.
+func (d *doubleRatchet) DecryptInto(dst []byte, msg CipheredMessage, ad []byte) (UncipheredMessage, error) {
+	return d.receiveInto(context.Background(), dst[:0], msg, ad)
+}
+
+// receiveInto decrypts msg, appending the plaintext to dst.
+func (d *doubleRatchet) receiveInto(ctx context.Context, dst []byte, msg CipheredMessage, ad []byte) (UncipheredMessage, error) {
+	if err := d.lockContext(ctx); err != nil {
+		return UncipheredMessage{}, err
+	}
+
+	defer d.unlock()
+
+	return d.receiveLocked(ctx, dst, msg, ad)
+}
+
+// receiveLocked does the work of receiveInto. The caller must hold the lock.
+func (d *doubleRatchet) receiveLocked(ctx context.Context, dst []byte, msg CipheredMessage, ad []byte) (UncipheredMessage, error) {
+	if d.closed {
+		return UncipheredMessage{}, ErrSessionClosed
+	}
+
+	if err := d.checkCiphertextSize(msg.Ciphertext); err != nil {
+		return UncipheredMessage{}, err
+	}
+
+	if err := checkHeaderVersion(msg.Header); err != nil {
+		return UncipheredMessage{}, err
+	}
+
+	if err := checkHeaderMetadata(msg.Header); err != nil {
+		return UncipheredMessage{}, err
+	}
+
+	fullAD, err := d.receiveAD(msg.Header, ad)
 
-	if plaintext, err := d.trySkippedMessageKeys(msg.Header, msg.Ciphertext, ad); err == nil {
-		return UncipheredMessage{Plaintext: plaintext}, nil
+	if err != nil {
+		return UncipheredMessage{}, err
 	}
 
-	if !bytes.Equal(msg.Header.DH, d.dh.remotePublicKey.Bytes()) {
-		if err := d.skipMessageKeys(d.recvN, msg.Header.PN); err != nil {
+	if d.archived {
+		plaintext, err := d.openArchived(dst, msg, escrowAD(headerAD(fullAD, msg.Header), msg.Escrow))
+
+		if err != nil {
 			return UncipheredMessage{}, err
 		}
 
-		if err := d.dhRatchet(msg.Header.DH); err != nil {
+		if err := d.afterReceive(plaintext, ad); err != nil {
 			return UncipheredMessage{}, err
 		}
+
+		return UncipheredMessage{Plaintext: plaintext, Escrowed: len(msg.Escrow) > 0, Type: msg.Header.Type, Metadata: msg.Header.metadata()}, nil
 	}
 
-	if err := d.skipMessageKeys(d.recvN, msg.Header.N); err != nil {
+	published := false
+
+	defer func() { d.publish(published) }()
+
+	plaintext, err := d.receive(ctx, dst, msg, escrowAD(headerAD(fullAD, msg.Header), msg.Escrow))
+
+	if err != nil {
+		return UncipheredMessage{}, err
+	}
+
+	if err := d.afterReceive(plaintext, ad); err != nil {
+		return UncipheredMessage{}, err
+	}
+
+	d.usage.MessagesReceived++
+	d.usage.BytesReceived += uint64(len(plaintext))
+
+	if d.transcript != nil {
+		d.transcript.add(&d.transcript.received, msg)
+	}
+
+	if err := d.save(ctx); err != nil {
 		return UncipheredMessage{}, err
 	}
 
-	nextCk, mk := crypto.DeriveCK(d.recvChainKey)
+	published = true
+
+	return UncipheredMessage{Plaintext: plaintext, Escrowed: len(msg.Escrow) > 0, Type: msg.Header.Type, Metadata: msg.Header.metadata()}, nil
+}
+
+// receive decrypts msg, performing any required skipping and DH ratchet steps. The
+// changes are kept only if the message decrypts; otherwise the session is left as it was.
+// Inside a transaction the caller began, the caller commits or rolls back the changes.
+// The plaintext is appended to dst.
+func (d *doubleRatchet) receive(ctx context.Context, dst []byte, msg CipheredMessage, ad []byte) ([]byte, error) {
+	if d.txn != nil {
+		return d.receiveTxn(ctx, dst, msg, ad)
+	}
+
+	d.begin()
+
+	plaintext, err := d.receiveTxn(ctx, dst, msg, ad)
+
+	if err != nil {
+		d.rollback()
+		return nil, err
+	}
+
+	d.commit()
+
+	return plaintext, nil
+}
+
+// receiveTxn does the work of receive inside the current transaction.
+func (d *doubleRatchet) receiveTxn(ctx context.Context, dst []byte, msg CipheredMessage, ad []byte) ([]byte, error) {
+	plaintext, err := d.advance(ctx, dst, msg, ad)
+
+	if err == nil {
+		err = d.flushSkipped(ctx, d.txn.changed())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.remember(msg.Header)
+
+	return plaintext, nil
+}
+
+// advance does the work of receive inside its transaction.
+func (d *doubleRatchet) advance(ctx context.Context, dst []byte, msg CipheredMessage, ad []byte) ([]byte, error) {
+	if err := d.checkHeaderKey(msg.Header); err != nil {
+		return nil, err
+	}
+
+	if err := checkHeaderPadding(msg.Header); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkHybridHeader(msg.Header); err != nil {
+		return nil, err
+	}
+
+	if plaintext, err := d.trySkippedMessageKeys(dst, msg.Header, msg.Ciphertext, ad); !errors.Is(err, errNoSkippedKey) {
+		return plaintext, err
+	}
+
+	if d.consumed(msg.Header) || d.replayed(msg.Header) {
+		return nil, ErrDuplicateMessage
+	}
+
+	if err := d.checkHeaderCounters(msg.Header); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkRate(msg.Header); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(msg.Header.DH, d.dh.remoteBytes()) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if d.dh.remotePublicKey != nil {
+			if err := d.skipMessageKeys(d.recvN, msg.Header.PN); err != nil {
+				return nil, err
+			}
+		}
+
+		d.record(EventPeerKeyChanged, append([]byte(nil), msg.Header.DH...))
+
+		if err := d.dhRatchet(ctx, msg.Header); err != nil {
+			return nil, err
+		}
+
+		d.prunePreviousChains()
+	}
+
+	if err := d.skipMessageKeys(d.recvN, msg.Header.N); err != nil {
+		return nil, err
+	}
+
+	nextCk, mk := crypto.DeriveCKWith(d.hash(), d.recvChainKey)
+
+	defer clear(mk[:])
 
 	d.recvChainKey = nextCk
 	d.recvN++
 
-	plaintext, err := crypto.Decrypt(mk, msg.Ciphertext, ad)
+	clear(nextCk[:])
 
-	if err != nil {
-		return UncipheredMessage{}, err
+	return d.open(dst, mk, msg.Header, msg.Ciphertext, ad)
+}
+
+// random returns the session's source of randomness.
+func (d *doubleRatchet) random() io.Reader {
+	if d.rand == nil {
+		return rand.Reader
 	}
 
-	return UncipheredMessage{Plaintext: plaintext}, nil
+	return d.rand
 }
 
 // Serialize serializes the current state of the DoubleRatchet.
 func (d *doubleRatchet) Serialize() ([]byte, error) {
-	d.Lock()
-	defer d.Unlock()
+	d.lock()
+	defer d.unlock()
+
+	if d.closed {
+		return nil, ErrSessionClosed
+	}
+
+	return d.serialize()
+}
+
+// serialize marshals the session state. The caller must hold the lock.
+func (d *doubleRatchet) serialize() ([]byte, error) {
+	recvPN := d.recvPN
 
 	state := State{
 		RootKey:      d.rootKey,
@@ -178,64 +674,123 @@ func (d *doubleRatchet) Serialize() ([]byte, error) {
 		SendN:        d.sendN,
 		RecvN:        d.recvN,
 		PrevN:        d.prevN,
+		RecvPN:       &recvPN,
+		StepPending:  d.sendStepPending,
+		Epoch:        d.epoch,
 		LocalPri:     d.dh.localPrivateKey.Bytes(),
-		RemotePub:    d.dh.remotePublicKey.Bytes(),
+		LocalPub:     d.dh.localPrivateKey.PublicKey().Bytes(),
+		RemotePub:    d.dh.remoteBytes(),
+		Usage:        d.usage,
+		FIPS:         d.fips,
+		Archived:     d.archived,
+		Curve:        curveName(d.dh.function()),
+		KDFHash:      d.recordedKDFHash(),
+		Label:        d.recordedLabel(),
+		Hybrid:       d.hybridState(),
+		ChainBytes:   d.chainBytes,
+		SessionID:    d.sessionID,
+		ReplayWindow: d.replayState(),
+	}
+
+	if !d.chainStarted.IsZero() {
+		state.ChainStartedAt = d.chainStarted.Unix()
+	}
+
+	if d.usageKey != nil {
+		state.UsageMAC = usageMAC(d.usageKey, d.usage, d.dh.localPrivateKey.PublicKey().Bytes(), state.RemotePub)
+	}
+
+	if d.escrow != nil {
+		state.EscrowKey = d.escrow.Bytes()
 	}
 
-	for id, key := range d.skippedMessageKeys {
-		h := Header{
-			DH: []byte(id.dh),
-			N:  id.n,
-			PN: id.pn,
+	if d.transcript != nil {
+		state.TranscriptSent = d.transcript.sent[:]
+		state.TranscriptReceived = d.transcript.received[:]
+	}
+
+	// Sessions with a skipped key store keep their keys out of the state.
+	if d.skippedStore == nil {
+		for id, sk := range d.skippedMessageKeys {
+			state.SkippedKeys = append(state.SkippedKeys, skippedEntry(id, sk))
 		}
+	}
+
+	if d.serializer == nil {
+		return JSONSerializer{}.Marshal(state)
+	}
+
+	return d.serializer.Marshal(state)
+}
+
+// Transcript returns the running hashes over all sent and received messages.
+func (d *doubleRatchet) Transcript() (sent, received []byte) {
+	d.lock()
+	defer d.unlock()
+
+	if d.transcript == nil {
+		return nil, nil
+	}
+
+	return append([]byte(nil), d.transcript.sent[:]...), append([]byte(nil), d.transcript.received[:]...)
+}
+
+// EscrowKey returns the escrow public key every sent message key is wrapped to, or nil if
+// the session was not created with WithEscrow.
+func (d *doubleRatchet) EscrowKey() []byte {
+	d.lock()
+	defer d.unlock()
 
-		state.SkippedKeys = append(state.SkippedKeys, SkippedMessageKey{
-			Header: h,
-			Key:    key,
-		})
+	if d.escrow == nil {
+		return nil
 	}
 
-	return json.Marshal(state)
+	return d.escrow.Bytes()
 }
 
 // trySkippedMessageKeys checks if there is a skipped message key for the given header and attempts to decrypt the ciphertext.
-func (d *doubleRatchet) trySkippedMessageKeys(header Header, ciphertext, ad []byte) ([]byte, error) {
-	if mk, ok := d.skippedMessageKeys[header.key()]; ok {
-		plaintext, err := crypto.Decrypt(mk, ciphertext, ad)
+func (d *doubleRatchet) trySkippedMessageKeys(dst []byte, header Header, ciphertext, ad []byte) ([]byte, error) {
+	if sk, ok := d.skippedMessageKeys[header.key()]; ok {
+		defer clear(sk.key[:])
+
+		plaintext, err := d.open(dst, sk.key, header, ciphertext, ad)
 
 		if err != nil {
 			return nil, err
 		}
 
-		delete(d.skippedMessageKeys, header.key())
+		d.deleteSkipped(header.key())
 
 		return plaintext, nil
 	}
 
-	return nil, fmt.Errorf("message key not found")
+	return nil, errNoSkippedKey
 }
 
 // skipMessageKeys derives and stores skipped message keys up to the target message number.
 func (d *doubleRatchet) skipMessageKeys(until, target uint32) error {
 	if target < until {
-		return fmt.Errorf("received message out of order (old)")
+		return ErrOldMessage
 	}
 
 	if target-until >= MaxSkip {
-		return fmt.Errorf("too many skipped messages")
+		return ErrTooManySkipped
 	}
 
 	for until < target {
-		nextCk, mk := crypto.DeriveCK(d.recvChainKey)
+		nextCk, mk := crypto.DeriveCKWith(d.hash(), d.recvChainKey)
 		d.recvChainKey = nextCk
 
 		header := Header{
-			DH: d.dh.remotePublicKey.Bytes(),
+			DH: d.dh.remoteBytes(),
 			N:  until,
-			PN: d.prevN,
+			PN: d.recvPN,
 		}
 
-		d.skippedMessageKeys[header.key()] = mk
+		d.storeSkipped(header.key(), skippedKey{key: mk, epoch: d.epoch, stored: d.clock()})
+
+		clear(nextCk[:])
+		clear(mk[:])
 
 		until++
 		d.recvN++
@@ -243,39 +798,43 @@ func (d *doubleRatchet) skipMessageKeys(until, target uint32) error {
 	return nil
 }
 
-// dhRatchet performs a Diffie-Hellman ratchet step with the given remote public key bytes.
-func (d *doubleRatchet) dhRatchet(remotePubBytes []byte) error {
-	d.prevN = d.recvN
+// dhRatchet performs the receiving half of a Diffie-Hellman ratchet step with the remote
+// public key of header, mixing in the post-quantum secret of a hybrid session. The
+// sending half follows with the next Send, so that further steps of the peer made before
+// it has seen a reply still derive from the same root key.
+func (d *doubleRatchet) dhRatchet(ctx context.Context, header Header) error {
+	d.epoch++
+	d.recvPN = header.PN
 	d.recvN = 0
-	d.sendN = 0
 
-	remotePub, err := ecdh.P256().NewPublicKey(remotePubBytes)
+	remotePub, err := d.dh.function().NewPublicKey(header.DH)
 
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
 	}
 
-	d.dh.remotePublicKey = remotePub
-
-	dhOut1, err := d.dh.exchange(d.dh.remotePublicKey)
+	dhOut, err := d.dh.localPrivateKey.ECDH(ctx, remotePub)
 
 	if err != nil {
-		return err
+		return cryptoError(err)
 	}
 
-	d.rootKey, d.recvChainKey = crypto.DeriveRK(d.rootKey, dhOut1)
+	d.dh.remotePublicKey = remotePub
+
+	pqOut, err := d.hybridReceiveSecret(header)
 
-	if err := d.dh.refresh(); err != nil {
+	if err != nil {
 		return err
 	}
 
-	dhOut2, err := d.dh.exchange(d.dh.remotePublicKey)
+	rootKey, chainKey, err := d.deriveRK(d.rootKey, append(dhOut, pqOut...))
 
 	if err != nil {
 		return err
 	}
 
-	d.rootKey, d.sendChainKey = crypto.DeriveRK(d.rootKey, dhOut2)
+	d.rootKey, d.recvChainKey = rootKey, chainKey
+	d.sendStepPending = true
 
 	return nil
 }
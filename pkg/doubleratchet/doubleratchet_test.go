@@ -6,8 +6,6 @@ import (
 	"math/big"
 	"sync"
 	"testing"
-
-	"github.com/othonhugo/goratchet/pkg/crypto"
 )
 
 // TestBasicMessageExchangeAndOutOfOrderDelivery verifies that the Double Ratchet protocol
@@ -109,8 +107,7 @@ func TestBasicMessageExchangeAndOutOfOrderDelivery(t *testing.T) {
 // the protocol state when a party performs a DH ratchet (key refresh), ensuring that
 // both parties can continue to communicate after the ratchet step.
 func TestDiffieHellmanRatchetStep(t *testing.T) {
-	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
-	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	alicePri, bobPri := orderedKeys(ecdh.P256())
 
 	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
 
@@ -134,20 +131,10 @@ func TestDiffieHellmanRatchetStep(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := alice.dh.refresh(); err != nil {
-		t.Fatal(err)
-	}
-
-	dhOut, err := alice.dh.exchange(alice.dh.remotePublicKey)
-
-	if err != nil {
+	if err := alice.Rekey(); err != nil {
 		t.Fatal(err)
 	}
 
-	alice.rootKey, alice.sendChainKey = crypto.DeriveRK(alice.rootKey, dhOut)
-	alice.prevN = alice.sendN
-	alice.sendN = 0
-
 	msg2, err := alice.Send([]byte("Msg 2 (New Key)"), nil)
 
 	if err != nil {
@@ -213,8 +200,7 @@ func TestMaxSkipLimitEnforcement(t *testing.T) {
 // DH ratchet step can still be decrypted after the ratchet has advanced, ensuring
 // the protocol maintains backward compatibility with skipped message keys.
 func TestDelayedMessageDecryptionAcrossDHRatchet(t *testing.T) {
-	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
-	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	alicePri, bobPri := orderedKeys(ecdh.P256())
 
 	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
 	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
@@ -225,13 +211,9 @@ func TestDelayedMessageDecryptionAcrossDHRatchet(t *testing.T) {
 
 	msgA2, _ := alice.Send([]byte("A2"), nil)
 
-	alice.dh.refresh()
-
-	dhOut, _ := alice.dh.exchange(alice.dh.remotePublicKey)
-	alice.rootKey, alice.sendChainKey = crypto.DeriveRK(alice.rootKey, dhOut)
-
-	alice.prevN = alice.sendN
-	alice.sendN = 0
+	if err := alice.Rekey(); err != nil {
+		t.Fatal(err)
+	}
 
 	msgB1, _ := alice.Send([]byte("B1"), nil)
 
@@ -0,0 +1,50 @@
+package doubleratchet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error categories. Every error of this package that stems from cryptography, the
+// session's state or a peer's message matches one of them with errors.Is, so callers can
+// decide how to react without knowing each error. Errors from options and configuration
+// belong to none.
+var (
+	// ErrCrypto is the category of cryptographic failures: messages that fail
+	// authentication, and failures of the DH function, whose errors are wrapped.
+	ErrCrypto = errors.New("double ratchet: cryptographic failure")
+
+	// ErrState is the category of operations the session's state does not allow, such as
+	// sending on a closed or archived session, and of serialized state that is
+	// inconsistent, tampered with or does not match the options.
+	ErrState = errors.New("double ratchet: invalid session state")
+
+	// ErrProtocol is the category of received messages that violate the protocol, such as
+	// malformed headers, replays and messages that would skip too many keys.
+	ErrProtocol = errors.New("double ratchet: protocol violation")
+)
+
+// categorizedError is a sentinel error that also matches its category with errors.Is.
+type categorizedError struct {
+	msg      string
+	category error
+}
+
+// newError returns a sentinel error with text msg in category.
+func newError(category error, msg string) error {
+	return &categorizedError{msg: msg, category: category}
+}
+
+func (e *categorizedError) Error() string {
+	return e.msg
+}
+
+// Is reports whether target is the error's category.
+func (e *categorizedError) Is(target error) bool {
+	return target == e.category
+}
+
+// cryptoError wraps an error of the DH function in ErrCrypto.
+func cryptoError(err error) error {
+	return fmt.Errorf("%w: %w", ErrCrypto, err)
+}
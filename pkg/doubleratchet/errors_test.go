@@ -0,0 +1,70 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestErrorCategories verifies that errors from cryptography, the session state and
+// received messages match their category with errors.Is, while still matching their own
+// sentinel, and that DH errors are wrapped rather than replaced.
+func TestErrorCategories(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	tampered := msg
+	tampered.Ciphertext = append([]byte(nil), msg.Ciphertext...)
+	tampered.Ciphertext[0] ^= 0xFF
+
+	skipping := msg
+	skipping.Header.N = MaxSkip + 1
+
+	_, badKey := New([]byte("short"), bobPri.PublicKey().Bytes(), nil)
+
+	_, authFailed := bob.Receive(tampered, nil)
+
+	bob.Receive(msg, nil)
+
+	_, duplicate := bob.Receive(msg, nil)
+	_, tooMany := bob.Receive(skipping, nil)
+
+	bob.Close()
+
+	_, closed := bob.Send([]byte("hello"), nil)
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		sentinel error
+		category error
+	}{
+		{"auth failed", authFailed, ErrAuthFailed, ErrCrypto},
+		{"bad key", badKey, ErrCrypto, ErrCrypto},
+		{"duplicate", duplicate, ErrDuplicateMessage, ErrProtocol},
+		{"too many skipped", tooMany, ErrTooManySkipped, ErrProtocol},
+		{"closed", closed, ErrSessionClosed, ErrState},
+	} {
+		if !errors.Is(tc.err, tc.sentinel) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.sentinel, tc.err)
+		}
+
+		for _, category := range []error{ErrCrypto, ErrState, ErrProtocol} {
+			if errors.Is(tc.err, category) != (category == tc.category) {
+				t.Errorf("%s: expected category %v only, got %v", tc.name, tc.category, tc.err)
+			}
+		}
+	}
+
+	var wrapped interface{ Unwrap() []error }
+
+	if !errors.As(badKey, &wrapped) || len(wrapped.Unwrap()) != 2 {
+		t.Errorf("Expected the DH error to be wrapped, got %v", badKey)
+	}
+}
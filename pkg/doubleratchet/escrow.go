@@ -0,0 +1,216 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// EscrowAcknowledgement must be passed verbatim to WithEscrow. It exists so that key escrow
+// can never be switched on by accident, by a zero value or by a forwarded configuration flag:
+// the calling code has to spell out that it understands what it is doing.
+const EscrowAcknowledgement = "I understand that escrow lets a third party decrypt every message of this session"
+
+var (
+	// ErrEscrowNotAcknowledged is returned by New when WithEscrow is given anything other
+	// than EscrowAcknowledgement.
+	ErrEscrowNotAcknowledged = errors.New("double ratchet: escrow requires EscrowAcknowledgement")
+
+	// ErrInvalidEscrowKey is returned by New when WithEscrow is not given a P-256 public key.
+	ErrInvalidEscrowKey = errors.New("double ratchet: escrow key must be a P-256 public key")
+
+	// ErrMalformedEscrow is returned when a message's escrow block cannot be parsed.
+	ErrMalformedEscrow = newError(ErrProtocol, "double ratchet: malformed escrow block")
+
+	// ErrNotEscrowed is returned by OpenEscrow for messages that carry no escrow block.
+	ErrNotEscrowed = newError(ErrProtocol, "double ratchet: message is not escrowed")
+)
+
+// escrowLabel domain-separates escrow key wrapping from other uses of HKDF.
+var escrowLabel = []byte("DoubleRatchet-Escrow")
+
+// WithEscrow makes the session wrap every message key to the escrow public key pub and
+// attach the result to the message as CipheredMessage.Escrow. Whoever holds the matching
+// private key can decrypt every message sent by this session with OpenEscrow.
+//
+// WARNING: this deliberately breaks the end-to-end guarantee of the Double Ratchet. It is
+// meant only for regulated deployments that are legally required to support compliance
+// decryption. Escrow is never silent: acknowledgement must equal EscrowAcknowledgement,
+// the escrow block is authenticated as part of each message's associated data, receivers
+// see UncipheredMessage.Escrowed, and the escrow key is recorded in the serialized state.
+//
+// Only the sending direction of the session is escrowed; the peer must enable escrow on
+// its own session for its messages to be escrowed too.
+func WithEscrow(pub *ecdh.PublicKey, acknowledgement string) Option {
+	return func(d *doubleRatchet) error {
+		if acknowledgement != EscrowAcknowledgement {
+			return ErrEscrowNotAcknowledged
+		}
+
+		if pub == nil || pub.Curve() != ecdh.P256() {
+			return ErrInvalidEscrowKey
+		}
+
+		d.escrow = pub
+
+		return nil
+	}
+}
+
+// OpenEscrow decrypts an escrowed message using the escrow private key. ad must be the
+// associated data the message was sent with, including any interceptor fragments folded
+// in with FoldAD. OpenEscrow assumes the built-in AEAD; use OpenEscrowAEAD for messages of
+// sessions created with WithAEAD.
+func OpenEscrow(pri *ecdh.PrivateKey, msg CipheredMessage, ad []byte) ([]byte, error) {
+	return OpenEscrowAEAD(SoftwareAEAD{}, pri, msg, ad)
+}
+
+// OpenEscrowAEAD is like OpenEscrow, but decrypts the message with aead, which must be
+// compatible with the AEAD the sending session was given with WithAEAD. The escrow block
+// itself is always wrapped with the built-in AES-256-GCM.
+func OpenEscrowAEAD(aead AEAD, pri *ecdh.PrivateKey, msg CipheredMessage, ad []byte) ([]byte, error) {
+	if len(msg.Escrow) == 0 {
+		return nil, ErrNotEscrowed
+	}
+
+	if len(msg.Escrow) < 2 {
+		return nil, ErrMalformedEscrow
+	}
+
+	ephLen := int(binary.BigEndian.Uint16(msg.Escrow))
+	rest := msg.Escrow[2:]
+
+	if len(rest) < ephLen {
+		return nil, ErrMalformedEscrow
+	}
+
+	eph, err := pri.Curve().NewPublicKey(rest[:ephLen])
+
+	if err != nil {
+		return nil, ErrMalformedEscrow
+	}
+
+	shared, err := pri.ECDH(eph)
+
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := escrowKEK(shared, eph, pri.PublicKey())
+
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := crypto.Decrypt(kek, rest[ephLen:], msg.Header.encode())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) != crypto.MessageKeySize {
+		return nil, ErrMalformedEscrow
+	}
+
+	var mk crypto.MessageKey
+
+	copy(mk[:], wrapped)
+
+	ad = escrowAD(headerAD(ad, msg.Header), msg.Escrow)
+
+	plaintext, err := aead.Open(mk, msg.Ciphertext, bindHeader(ad, msg.Header))
+
+	if err != nil {
+		if !legacyHeader(msg.Header) {
+			return nil, err
+		}
+
+		if legacy, lerr := aead.Open(mk, msg.Ciphertext, ad); lerr == nil {
+			return legacy, nil
+		}
+
+		return nil, err
+	}
+
+	return unpadPlaintext(msg.Header, plaintext)
+}
+
+// wrapEscrow encrypts mk to the escrow key pub under a fresh ephemeral key, reading
+// randomness from random (crypto/rand if nil). The header is bound as associated data so
+// the block cannot be moved to another message. The result is laid out as a uint16
+// ephemeral key length, the ephemeral key and the wrapped key.
+func wrapEscrow(random io.Reader, pub *ecdh.PublicKey, mk crypto.MessageKey, header Header) ([]byte, error) {
+	eph, err := generateKey(pub.Curve(), random)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := eph.ECDH(pub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := escrowKEK(shared, eph.PublicKey(), pub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if random == nil {
+		random = rand.Reader
+	}
+
+	wrapped, err := crypto.EncryptWithRand(random, kek, mk[:], header.encode())
+
+	if err != nil {
+		return nil, err
+	}
+
+	ephBytes := eph.PublicKey().Bytes()
+
+	block := make([]byte, 0, 2+len(ephBytes)+len(wrapped))
+	block = binary.BigEndian.AppendUint16(block, uint16(len(ephBytes))) // #nosec G115 -- public keys are far below 64 KiB
+	block = append(block, ephBytes...)
+
+	return append(block, wrapped...), nil
+}
+
+// escrowKEK derives the key-encryption key from the shared secret between an ephemeral
+// key and the escrow key, binding both public keys into the derivation.
+func escrowKEK(shared []byte, eph, escrow *ecdh.PublicKey) (crypto.MessageKey, error) {
+	var kek crypto.MessageKey
+
+	info := append(append(append([]byte(nil), escrowLabel...), eph.Bytes()...), escrow.Bytes()...)
+
+	derived, err := crypto.DeriveHKDF(shared, nil, info, crypto.MessageKeySize)
+
+	if err != nil {
+		return kek, err
+	}
+
+	copy(kek[:], derived)
+	clear(derived)
+
+	return kek, nil
+}
+
+// escrowAD extends the caller's associated data with the escrow block, so that stripping,
+// replacing or adding an escrow block makes the message fail to decrypt.
+func escrowAD(ad, block []byte) []byte {
+	if len(block) == 0 {
+		return ad
+	}
+
+	out := make([]byte, 0, len(ad)+len(escrowLabel)+len(block)+4)
+	out = append(out, ad...)
+	out = append(out, escrowLabel...)
+	out = append(out, block...)
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
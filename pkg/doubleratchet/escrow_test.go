@@ -0,0 +1,131 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestEscrowRequiresAcknowledgement verifies that escrow cannot be enabled without the
+// exact acknowledgement string or with an unsupported key.
+func TestEscrowRequiresAcknowledgement(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	_, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithEscrow(escrowPri.PublicKey(), "yes"))
+
+	if !errors.Is(err, ErrEscrowNotAcknowledged) {
+		t.Errorf("Expected ErrEscrowNotAcknowledged, got %v", err)
+	}
+
+	x25519, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	_, err = New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithEscrow(x25519.PublicKey(), EscrowAcknowledgement))
+
+	if !errors.Is(err, ErrInvalidEscrowKey) {
+		t.Errorf("Expected ErrInvalidEscrowKey, got %v", err)
+	}
+}
+
+// TestEscrowDecryption verifies that the escrow key holder can decrypt escrowed messages,
+// that the receiver is told the message was escrowed, and that the escrow key survives
+// serialization.
+func TestEscrowDecryption(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithEscrow(escrowPri.PublicKey(), EscrowAcknowledgement))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, err := alice.Send([]byte("audited"), []byte("ad"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg.Escrow) == 0 {
+		t.Fatal("Expected an escrow block")
+	}
+
+	plaintext, err := OpenEscrow(escrowPri, msg, []byte("ad"))
+
+	if err != nil || string(plaintext) != "audited" {
+		t.Fatalf("Expected escrow decryption to succeed, got %q, %v", plaintext, err)
+	}
+
+	unciphered, err := bob.Receive(msg, []byte("ad"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !unciphered.Escrowed {
+		t.Error("Expected the receiver to see the message as escrowed")
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := OpenEscrow(escrowPri, reply, nil); !errors.Is(err, ErrNotEscrowed) {
+		t.Errorf("Expected ErrNotEscrowed, got %v", err)
+	}
+
+	data, _ := alice.Serialize()
+	restored, err := Deserialize(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(restored.EscrowKey(), escrowPri.PublicKey().Bytes()) {
+		t.Error("Escrow key not preserved by serialization")
+	}
+}
+
+// TestEscrowCustomAEAD verifies that OpenEscrowAEAD opens escrowed messages of a session
+// with another AEAD, which OpenEscrow cannot.
+func TestEscrowCustomAEAD(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(CBCHMAC{}), WithEscrow(escrowPri.PublicKey(), EscrowAcknowledgement))
+
+	msg, _ := alice.Send([]byte("audited"), []byte("ad"))
+
+	if _, err := OpenEscrow(escrowPri, msg, []byte("ad")); err == nil {
+		t.Error("Expected OpenEscrow to fail with the wrong AEAD")
+	}
+
+	plaintext, err := OpenEscrowAEAD(CBCHMAC{}, escrowPri, msg, []byte("ad"))
+
+	if err != nil || string(plaintext) != "audited" {
+		t.Errorf("Expected escrow decryption to succeed, got %q, %v", plaintext, err)
+	}
+}
+
+// TestEscrowBlockIsAuthenticated verifies that stripping the escrow block from a message
+// makes it fail to decrypt, so a relay cannot hide that a message was escrowed.
+func TestEscrowBlockIsAuthenticated(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithEscrow(escrowPri.PublicKey(), EscrowAcknowledgement))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+	msg.Escrow = nil
+
+	if _, err := bob.Receive(msg, nil); err == nil {
+		t.Error("Expected decryption to fail after stripping the escrow block")
+	}
+}
@@ -0,0 +1,142 @@
+package doubleratchet
+
+// EventKind identifies a session event.
+type EventKind int
+
+const (
+	// EventPeerKeyChanged is delivered when a message arrives under a new ratchet key of
+	// the peer. Event.Key holds the new key.
+	EventPeerKeyChanged EventKind = iota + 1
+
+	// EventRatchetStep is delivered when the session has started a new sending chain under
+	// a new ratchet key on its turn, with Rekey, with a Send its RekeyPolicy requires, or
+	// with the first Send after a new peer key. Event.Key holds the session's new ratchet
+	// public key.
+	EventRatchetStep
+
+	// EventRekeyCompleted is delivered when the first message under the session's new
+	// ratchet key was sent, which lets the peer complete its side of the step.
+	EventRekeyCompleted
+
+	// EventRekeyDue is delivered once per sending chain when a limit of the session's
+	// RekeyPolicy is reached but the step must wait for the peer's next ratchet key. An
+	// application can answer it by asking the peer to reply, for example with a
+	// MessageRekeyRequest. Event.Key holds the session's current ratchet public key.
+	EventRekeyDue
+)
+
+// String returns the name of the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventPeerKeyChanged:
+		return "peer key changed"
+	case EventRatchetStep:
+		return "ratchet step"
+	case EventRekeyCompleted:
+		return "rekey completed"
+	case EventRekeyDue:
+		return "rekey due"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a notification about a change of the session's ratchet keys.
+type Event struct {
+	Kind EventKind
+
+	// Epoch is the session's epoch after the event.
+	Epoch uint32
+
+	// Key is the ratchet public key the event is about; see the event kinds.
+	Key []byte
+
+	// Missed is the number of events dropped for this subscriber since the previous event
+	// it received, because its channel was full.
+	Missed int
+}
+
+// subscriber is a channel returned by Subscribe.
+type subscriber struct {
+	events chan Event
+	missed int
+}
+
+// Subscribe returns a channel of session events, for use in select-based event loops, and
+// a function that ends the subscription and closes the channel. Events are delivered
+// after the Send or Receive that caused them succeeded, without blocking: when the
+// channel's buffer of the given size is full, events are dropped and counted in
+// Event.Missed of the next event delivered. The channel is also closed when the session is
+// closed by Handoff. Subscriptions are not serialized.
+func (d *doubleRatchet) Subscribe(buffer int) (<-chan Event, func()) {
+	d.lock()
+	defer d.unlock()
+
+	s := &subscriber{events: make(chan Event, max(buffer, 0))}
+
+	if d.closed {
+		close(s.events)
+		return s.events, func() {}
+	}
+
+	if d.subscribers == nil {
+		d.subscribers = make(map[*subscriber]struct{})
+	}
+
+	d.subscribers[s] = struct{}{}
+
+	cancel := func() {
+		d.lock()
+		defer d.unlock()
+
+		if _, ok := d.subscribers[s]; ok {
+			delete(d.subscribers, s)
+			close(s.events)
+		}
+	}
+
+	return s.events, cancel
+}
+
+// record queues an event until the operation causing it succeeds. The caller must hold
+// the lock.
+func (d *doubleRatchet) record(kind EventKind, key []byte) {
+	if len(d.subscribers) == 0 {
+		return
+	}
+
+	d.pendingEvents = append(d.pendingEvents, Event{Kind: kind, Epoch: d.epoch, Key: key})
+}
+
+// publish delivers the queued events, or discards them if the operation failed. The
+// caller must hold the lock.
+func (d *doubleRatchet) publish(ok bool) {
+	events := d.pendingEvents
+	d.pendingEvents = nil
+
+	if !ok {
+		return
+	}
+
+	for _, e := range events {
+		for s := range d.subscribers {
+			e.Missed = s.missed
+
+			select {
+			case s.events <- e:
+				s.missed = 0
+			default:
+				s.missed++
+			}
+		}
+	}
+}
+
+// unsubscribeAll closes every subscriber's channel. The caller must hold the lock.
+func (d *doubleRatchet) unsubscribeAll() {
+	for s := range d.subscribers {
+		close(s.events)
+	}
+
+	d.subscribers = nil
+}
@@ -0,0 +1,140 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// orderedKeys generates two keys on curve, the first with the lesser public key, so that
+// in a pair of sessions created with New the session of the first may rekey at once.
+func orderedKeys(curve ecdh.Curve) (*ecdh.PrivateKey, *ecdh.PrivateKey) {
+	a, _ := curve.GenerateKey(rand.Reader)
+	b, _ := curve.GenerateKey(rand.Reader)
+
+	if bytes.Compare(a.PublicKey().Bytes(), b.PublicKey().Bytes()) > 0 {
+		return b, a
+	}
+
+	return a, b
+}
+
+// stepSendingRatchet makes d start a new sending chain under a fresh ratchet key, as the
+// sender side of a DH ratchet step. It must be d's turn.
+func stepSendingRatchet(t *testing.T, d *doubleRatchet) {
+	t.Helper()
+
+	if !d.hasTurn() {
+		t.Fatal("Expected the session to have its turn")
+	}
+
+	if err := d.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSubscribeEvents verifies that a DH ratchet step is reported as a peer key change on
+// receipt, followed by a ratchet step and a completed rekey on the next send, and that
+// cancelling the subscription closes the channel.
+func TestSubscribeEvents(t *testing.T) {
+	bobPri, alicePri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	events, cancel := alice.Subscribe(8)
+
+	stepSendingRatchet(t, bob)
+
+	msg, _ := bob.Send([]byte("new key"), nil)
+
+	if _, err := alice.Receive(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := <-events; e.Kind != EventPeerKeyChanged || !bytes.Equal(e.Key, msg.Header.DH) {
+		t.Errorf("Expected a peer key change to Bob's new key, got %v", e.Kind)
+	}
+
+	reply, _ := alice.Send([]byte("reply"), nil)
+
+	step := <-events
+
+	if step.Kind != EventRatchetStep || step.Epoch != 1 {
+		t.Errorf("Expected a ratchet step into epoch 1, got %v in epoch %d", step.Kind, step.Epoch)
+	}
+
+	if e := <-events; e.Kind != EventRekeyCompleted || !bytes.Equal(e.Key, step.Key) || !bytes.Equal(reply.Header.DH, step.Key) {
+		t.Errorf("Expected a completed rekey under the new key, got %v", e.Kind)
+	}
+
+	if _, err := bob.Receive(reply, nil); err != nil {
+		t.Fatalf("Expected Bob to follow the ratchet step, got %v", err)
+	}
+
+	cancel()
+	cancel()
+
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed after cancel")
+	}
+}
+
+// TestSubscribeDropsWhenFull verifies that events are dropped instead of blocking the
+// session when a subscriber falls behind, and that the next delivered event reports how
+// many were missed.
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	bobPri, alicePri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	events, _ := alice.Subscribe(1)
+
+	stepSendingRatchet(t, bob)
+
+	msg, _ := bob.Send([]byte("new key"), nil)
+
+	if _, err := alice.Receive(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := <-events; e.Kind != EventPeerKeyChanged || e.Missed != 0 {
+		t.Errorf("Expected the first event without misses, got %+v", e)
+	}
+
+	reply, _ := alice.Send([]byte("reply"), nil)
+	_, _ = bob.Receive(reply, nil)
+
+	if e := <-events; e.Kind != EventRatchetStep || e.Missed != 0 {
+		t.Errorf("Expected the ratchet step without misses, got %+v", e)
+	}
+
+	answer, _ := bob.Send([]byte("answer"), nil)
+	_, _ = alice.Receive(answer, nil)
+
+	if e := <-events; e.Kind != EventPeerKeyChanged || e.Missed != 1 {
+		t.Errorf("Expected a peer key change after one missed event, got %v with %d missed", e.Kind, e.Missed)
+	}
+}
+
+// TestSubscribeClosedByHandoff verifies that Handoff closes subscribers' channels.
+func TestSubscribeClosedByHandoff(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	events, cancel := alice.Subscribe(1)
+
+	if _, err := alice.Handoff(make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed by Handoff")
+	}
+
+	cancel()
+}
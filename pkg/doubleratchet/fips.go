@@ -0,0 +1,74 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"errors"
+)
+
+var (
+	// ErrNotApproved is returned by New and Deserialize in FIPS mode when the session is
+	// configured with an algorithm outside the approved set.
+	ErrNotApproved = errors.New("double ratchet: algorithm not approved in FIPS mode")
+)
+
+// Approved is implemented by DH and AEAD implementations that use only FIPS-approved
+// algorithms, for example ones backed by a validated module. Implementations that do not
+// implement it are rejected in FIPS mode.
+type Approved interface {
+	FIPSApproved() bool
+}
+
+// WithFIPS restricts the session to FIPS-approved algorithms: P-256 or P-384 for the DH
+// ratchet, AES-GCM or CBCHMAC for messages, and HKDF and HMAC with SHA-2 for the key
+// schedule. A session configured with anything else, such as another curve or a custom
+// AEAD that does not implement Approved, fails to construct with ErrNotApproved.
+//
+// The mode is recorded in the serialized state for audits, and a session serialized in
+// FIPS mode is restored in FIPS mode. Building with the goratchet_fips tag enables it for
+// every session. It restricts the algorithms this package selects; for validated
+// implementations, also run Go's cryptography in FIPS 140-3 mode (GODEBUG=fips140=on).
+func WithFIPS() Option {
+	return func(d *doubleRatchet) error {
+		d.fips = true
+		return nil
+	}
+}
+
+// FIPSApproved reports whether the curve is approved in FIPS mode.
+func (c CurveDH) FIPSApproved() bool {
+	return c.Curve == ecdh.P256() || c.Curve == ecdh.P384()
+}
+
+// FIPSApproved reports true: AES-256-GCM is approved.
+func (SoftwareAEAD) FIPSApproved() bool {
+	return true
+}
+
+// FIPSApproved reports true: AES-CBC, HMAC-SHA256 and HKDF-SHA256 are approved.
+func (CBCHMAC) FIPSApproved() bool {
+	return true
+}
+
+// checkFIPS rejects non-approved algorithms if the session is in FIPS mode.
+func (d *doubleRatchet) checkFIPS() error {
+	if !d.fips {
+		return nil
+	}
+
+	if !approved(d.dh.function()) {
+		return ErrNotApproved
+	}
+
+	if d.aead != nil && !approved(d.aead) {
+		return ErrNotApproved
+	}
+
+	return nil
+}
+
+// approved reports whether an algorithm implementation declares itself approved.
+func approved(impl any) bool {
+	a, ok := impl.(Approved)
+
+	return ok && a.FIPSApproved()
+}
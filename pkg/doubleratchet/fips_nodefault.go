@@ -0,0 +1,6 @@
+//go:build !goratchet_fips
+
+package doubleratchet
+
+// fipsDefault enables FIPS mode for every session in builds with the goratchet_fips tag.
+const fipsDefault = false
@@ -0,0 +1,98 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestFIPSRejectsUnapproved verifies that FIPS mode accepts the approved curves and the
+// built-in AEAD, and rejects other curves and AEADs that do not declare approval.
+func TestFIPSRejectsUnapproved(t *testing.T) {
+	for _, curve := range []ecdh.Curve{ecdh.P256(), ecdh.P384(), ecdh.P521(), ecdh.X25519()} {
+		alicePri, _ := curve.GenerateKey(rand.Reader)
+		bobPri, _ := curve.GenerateKey(rand.Reader)
+
+		_, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithDH(CurveDH{Curve: curve}), WithFIPS())
+
+		approved := curve == ecdh.P256() || curve == ecdh.P384()
+
+		if approved && err != nil {
+			t.Errorf("Expected %v to be approved, got %v", curve, err)
+		}
+
+		if !approved && !errors.Is(err, ErrNotApproved) {
+			t.Errorf("Expected ErrNotApproved for %v, got %v", curve, err)
+		}
+	}
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(&offloadAEAD{}), WithFIPS()); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved for an undeclared AEAD, got %v", err)
+	}
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(SoftwareAEAD{}), WithFIPS()); err != nil {
+		t.Errorf("Expected the built-in AEAD to be approved, got %v", err)
+	}
+}
+
+// TestFIPSRecordedInState verifies that FIPS mode is recorded in the serialized state and
+// enforced when the state is restored.
+func TestFIPSRecordedInState(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithFIPS())
+
+	data, _ := alice.Serialize()
+	state, _, _ := decodeState(data)
+
+	if !state.FIPS {
+		t.Fatal("Expected the state to record FIPS mode")
+	}
+
+	restored, err := Deserialize(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := restored.Serialize()
+	state, _, _ = decodeState(again)
+
+	if !state.FIPS {
+		t.Error("Expected the restored session to stay in FIPS mode")
+	}
+
+	if _, err := Deserialize(data, WithAEAD(&offloadAEAD{})); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved when restoring with an undeclared AEAD, got %v", err)
+	}
+}
+
+// TestFIPSSuites verifies that FIPS mode accepts a suite assembled from approved choices,
+// P-384 keys with an SHA-384 key schedule and CBCHMAC, and fails fast for X25519 whether
+// it is selected with WithCurve or restored from serialized state.
+func TestFIPSSuites(t *testing.T) {
+	alicePri, _ := ecdh.P384().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P384().GenerateKey(rand.Reader)
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithCurve(ecdh.P384()), WithKDFHash(KDFSHA384), WithAEAD(CBCHMAC{}), WithFIPS()); err != nil {
+		t.Errorf("Expected the approved suite to be accepted, got %v", err)
+	}
+
+	xPri, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if _, err := New(xPri.Bytes(), xPri.PublicKey().Bytes(), nil, WithCurve(ecdh.X25519()), WithFIPS()); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved for X25519, got %v", err)
+	}
+
+	session, _ := New(xPri.Bytes(), xPri.PublicKey().Bytes(), nil, WithCurve(ecdh.X25519()))
+	state, _ := session.Serialize()
+
+	if _, err := Deserialize(state, WithFIPS()); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved for restored X25519 state, got %v", err)
+	}
+}
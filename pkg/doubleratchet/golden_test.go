@@ -0,0 +1,242 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"embed"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// goldenDir holds one corpus file per released version of the state and wire formats.
+const goldenDir = "testdata/golden"
+
+//go:embed testdata/golden
+var goldenCorpus embed.FS
+
+// goldenVersion, when set, makes TestRecordGoldenCorpus write the corpus of the current
+// code to testdata/golden/<version>.json. Run it once per release:
+//
+//	go test ./pkg/doubleratchet -run TestRecordGoldenCorpus -golden.version=v1.2.0
+var goldenVersion = flag.String("golden.version", "", "record the golden corpus under this version")
+
+// goldenFile is the corpus recorded by one version.
+type goldenFile struct {
+	Version string
+	Cases   []goldenCase
+}
+
+// goldenCase is a serialized receiving session and the messages it must still decrypt.
+type goldenCase struct {
+	Scenario string
+	State    []byte
+	Messages []goldenMessage
+}
+
+// goldenMessage is a recorded message with its associated data and expected plaintext.
+type goldenMessage struct {
+	Message   CipheredMessage
+	AD        []byte
+	Plaintext []byte
+}
+
+// TestGoldenCorpus verifies that sessions serialized by every recorded version can still
+// be loaded by the current code, pass strict verification, and decrypt the messages
+// recorded with them, guaranteeing backward compatibility of the state and wire formats.
+func TestGoldenCorpus(t *testing.T) {
+	entries, err := goldenCorpus.ReadDir(goldenDir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files int
+
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		files++
+
+		data, err := goldenCorpus.ReadFile(goldenDir + "/" + entry.Name())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var file goldenFile
+
+		if err := json.Unmarshal(data, &file); err != nil {
+			t.Fatalf("%s: %v", entry.Name(), err)
+		}
+
+		for _, c := range file.Cases {
+			t.Run(file.Version+"/"+c.Scenario, func(t *testing.T) {
+				session, err := Deserialize(c.State, WithStrictVerification())
+
+				if err != nil {
+					t.Fatalf("Failed to load state: %v", err)
+				}
+
+				for i, m := range c.Messages {
+					unciphered, err := session.Receive(m.Message, m.AD)
+
+					if err != nil {
+						t.Fatalf("Message %d: %v", i, err)
+					}
+
+					if !bytes.Equal(unciphered.Plaintext, m.Plaintext) {
+						t.Errorf("Message %d: expected %q, got %q", i, m.Plaintext, unciphered.Plaintext)
+					}
+				}
+			})
+		}
+	}
+
+	if files == 0 {
+		t.Fatal("Golden corpus is empty")
+	}
+}
+
+// TestRecordGoldenCorpus records the golden corpus for the version named by
+// -golden.version. It is skipped otherwise. It only uses the original New, Send, Receive
+// and Serialize API, so it can also be run against older checkouts.
+func TestRecordGoldenCorpus(t *testing.T) {
+	if *goldenVersion == "" {
+		t.Skip("set -golden.version to record the golden corpus")
+	}
+
+	file := goldenFile{Version: *goldenVersion}
+
+	for _, scenario := range []struct {
+		name   string
+		record func(t *testing.T) goldenCase
+	}{
+		{"in-order", recordInOrder},
+		{"out-of-order", recordOutOfOrder},
+		{"bidirectional", recordBidirectional},
+	} {
+		c := scenario.record(t)
+		c.Scenario = scenario.name
+		file.Cases = append(file.Cases, c)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(goldenDir, *goldenVersion+".json"), append(data, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newGoldenPair returns two fresh sessions for recording the corpus.
+func newGoldenPair(t *testing.T) (alice, bob *doubleRatchet) {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), []byte("golden"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err = New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), []byte("golden"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return alice, bob
+}
+
+// sendGolden sends plaintext from sender and returns it as a corpus message.
+func sendGolden(t *testing.T, sender *doubleRatchet, plaintext string) goldenMessage {
+	t.Helper()
+
+	ad := []byte("golden-ad")
+	msg, err := sender.Send([]byte(plaintext), ad)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return goldenMessage{Message: msg, AD: ad, Plaintext: []byte(plaintext)}
+}
+
+// serializeGolden serializes a session for the corpus.
+func serializeGolden(t *testing.T, session *doubleRatchet) []byte {
+	t.Helper()
+
+	state, err := session.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+// recordInOrder records a fresh receiving session and messages sent in order.
+func recordInOrder(t *testing.T) goldenCase {
+	alice, bob := newGoldenPair(t)
+
+	c := goldenCase{State: serializeGolden(t, bob)}
+
+	for _, plaintext := range []string{"first", "second", "third"} {
+		c.Messages = append(c.Messages, sendGolden(t, alice, plaintext))
+	}
+
+	return c
+}
+
+// recordOutOfOrder records a receiving session holding skipped message keys, and the
+// delayed messages they decrypt.
+func recordOutOfOrder(t *testing.T) goldenCase {
+	alice, bob := newGoldenPair(t)
+
+	delayed := []goldenMessage{sendGolden(t, alice, "delayed 0"), sendGolden(t, alice, "delayed 1")}
+	latest := sendGolden(t, alice, "latest")
+
+	if _, err := bob.Receive(latest.Message, latest.AD); err != nil {
+		t.Fatal(err)
+	}
+
+	return goldenCase{State: serializeGolden(t, bob), Messages: append(delayed, sendGolden(t, alice, "after"))}
+}
+
+// recordBidirectional records a session that has sent and received messages, and
+// further messages from its peer.
+func recordBidirectional(t *testing.T) goldenCase {
+	alice, bob := newGoldenPair(t)
+
+	for i := range 3 {
+		m := sendGolden(t, alice, "ping")
+
+		if _, err := bob.Receive(m.Message, m.AD); err != nil {
+			t.Fatal(err)
+		}
+
+		if i < 2 {
+			reply := sendGolden(t, bob, "pong")
+
+			if _, err := alice.Receive(reply.Message, reply.AD); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return goldenCase{
+		State:    serializeGolden(t, alice),
+		Messages: []goldenMessage{sendGolden(t, bob, "pong again"), sendGolden(t, bob, "and again")},
+	}
+}
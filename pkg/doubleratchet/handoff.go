@@ -0,0 +1,155 @@
+package doubleratchet
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+const (
+	// handoffVersion is the first byte of every handoff token.
+	handoffVersion = 1
+
+	// handoffIDSize is the size of the random token ID.
+	handoffIDSize = 16
+
+	// minHandoffKeySize is the minimum size of a handoff key.
+	minHandoffKeySize = 16
+)
+
+var (
+	// ErrSessionClosed is returned by a session that was closed with Close or handed off
+	// to another process.
+	ErrSessionClosed = newError(ErrState, "double ratchet: session closed")
+
+	// ErrHandoffKeyTooShort is returned when a handoff key is shorter than 16 bytes.
+	ErrHandoffKeyTooShort = errors.New("double ratchet: handoff key too short")
+
+	// ErrMalformedHandoff is returned when a handoff token cannot be parsed or decrypted.
+	ErrMalformedHandoff = newError(ErrState, "double ratchet: malformed handoff token")
+)
+
+// handoffLabel domain-separates the handoff encryption key from other uses of HKDF.
+var handoffLabel = []byte("DoubleRatchet-Handoff")
+
+// Handoff transfers the session to another process or host, for example during a
+// blue/green deployment. It returns the session state encrypted under key together with
+// a random token ID, and closes the session: every later call returns ErrSessionClosed,
+// so the source can no longer send or receive with keys the target now owns.
+//
+// key must be shared with the target out of band and be at least 16 bytes long. The
+// token must be accepted exactly once; see AcceptHandoff.
+func (d *doubleRatchet) Handoff(key []byte) ([]byte, error) {
+	if len(key) < minHandoffKeySize {
+		return nil, ErrHandoffKeyTooShort
+	}
+
+	d.lock()
+	defer d.unlock()
+
+	if d.closed {
+		return nil, ErrSessionClosed
+	}
+
+	state, err := d.serialize()
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 1+handoffIDSize, 1+handoffIDSize+len(state)+crypto.Overhead)
+	token[0] = handoffVersion
+
+	if _, err := io.ReadFull(rand.Reader, token[1:]); err != nil {
+		return nil, err
+	}
+
+	mk, err := handoffKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := crypto.Encrypt(mk, state, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.close()
+
+	return append(token, sealed...), nil
+}
+
+// AcceptHandoff restores a session from a token produced by Handoff. opts configure the
+// restored session as they do for Deserialize.
+//
+// claim is called with the token ID before the session is returned and must fail if the
+// ID was claimed before, typically by an atomic insert into storage shared by all
+// targets. This makes the token single-use even if it is replayed to several targets. A
+// nil claim skips the check, leaving single use to the caller.
+func AcceptHandoff(token, key []byte, claim func(id []byte) error, opts ...Option) (*doubleRatchet, error) {
+	if len(key) < minHandoffKeySize {
+		return nil, ErrHandoffKeyTooShort
+	}
+
+	if len(token) < 1+handoffIDSize || token[0] != handoffVersion {
+		return nil, ErrMalformedHandoff
+	}
+
+	header := token[:1+handoffIDSize]
+
+	mk, err := handoffKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := crypto.Decrypt(mk, token[len(header):], header)
+
+	if err != nil {
+		return nil, ErrMalformedHandoff
+	}
+
+	defer clear(state)
+
+	if claim != nil {
+		if err := claim(append([]byte(nil), header[1:]...)); err != nil {
+			return nil, err
+		}
+	}
+
+	return Deserialize(state, opts...)
+}
+
+// close marks the session as closed and erases its secret keys. The caller must hold the lock.
+func (d *doubleRatchet) close() {
+	d.closed = true
+
+	d.releaseKeys()
+
+	for id := range d.skippedMessageKeys {
+		d.eraseSkipped(id)
+	}
+
+	d.clearPrederived()
+	d.unsubscribeAll()
+}
+
+// handoffKey derives the token encryption key from the handoff key.
+func handoffKey(key []byte) (crypto.MessageKey, error) {
+	var mk crypto.MessageKey
+
+	derived, err := crypto.DeriveHKDF(key, nil, handoffLabel, crypto.MessageKeySize)
+
+	if err != nil {
+		return mk, err
+	}
+
+	copy(mk[:], derived)
+	clear(derived)
+
+	return mk, nil
+}
@@ -0,0 +1,92 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestHandoffTransfersSession verifies that a handed-off session continues on the target,
+// that the source is closed, and that the token can only be claimed once.
+func TestHandoffTransfersSession(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("before"), nil)
+	_, _ = bob.Receive(msg, nil)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	token, err := alice.Handoff(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := alice.Send([]byte("stale"), nil); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed from the source, got %v", err)
+	}
+
+	if _, err := alice.Handoff(key); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected a second handoff to fail, got %v", err)
+	}
+
+	claimed := make(map[string]bool)
+	errClaimed := errors.New("already claimed")
+
+	claim := func(id []byte) error {
+		if claimed[string(id)] {
+			return errClaimed
+		}
+
+		claimed[string(id)] = true
+
+		return nil
+	}
+
+	target, err := AcceptHandoff(token, key, claim)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ = target.Send([]byte("after"), nil)
+
+	if plaintext, err := bob.Receive(msg, nil); err != nil || string(plaintext.Plaintext) != "after" {
+		t.Fatalf("Expected the target to continue the session, got %v", err)
+	}
+
+	if _, err := AcceptHandoff(token, key, claim); !errors.Is(err, errClaimed) {
+		t.Errorf("Expected the replayed token to be rejected, got %v", err)
+	}
+}
+
+// TestAcceptHandoffRejectsBadTokens verifies that tokens are rejected under the wrong key
+// or when tampered with.
+func TestAcceptHandoffRejectsBadTokens(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	if _, err := alice.Handoff([]byte("short")); !errors.Is(err, ErrHandoffKeyTooShort) {
+		t.Errorf("Expected ErrHandoffKeyTooShort, got %v", err)
+	}
+
+	key := []byte("0123456789abcdef")
+	token, _ := alice.Handoff(key)
+
+	if _, err := AcceptHandoff(token, []byte("fedcba9876543210"), nil); !errors.Is(err, ErrMalformedHandoff) {
+		t.Errorf("Expected ErrMalformedHandoff for the wrong key, got %v", err)
+	}
+
+	token[1] ^= 1
+
+	if _, err := AcceptHandoff(token, key, nil); !errors.Is(err, ErrMalformedHandoff) {
+		t.Errorf("Expected ErrMalformedHandoff for a tampered ID, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// p256PointSize is the size of an uncompressed P-256 public key.
+const p256PointSize = 1 + 2*32
+
+// headerLabel domain-separates the encoded header in the associated data.
+var headerLabel = []byte("DoubleRatchet-Header")
+
+var (
+	// ErrInvalidHeaderKey is returned when a header's DH key does not have the size of a
+	// public key of the session's DH function.
+	ErrInvalidHeaderKey = newError(ErrProtocol, "double ratchet: invalid header key size")
+
+	// ErrTooManySkipped is returned when a header's N or PN would require skipping
+	// MaxSkip or more message keys. Its message predates the typed error and is kept for
+	// compatibility.
+	ErrTooManySkipped = newError(ErrProtocol, "too many skipped messages")
+
+	// ErrOldMessage is returned when a header's counters point behind the receiving
+	// chain without a skipped key for the message. Its message predates the typed error
+	// and is kept for compatibility.
+	ErrOldMessage = newError(ErrProtocol, "received message out of order (old)")
+
+	// ErrDuplicateMessage is returned by Receive for a message whose key was already
+	// consumed, such as a replayed or redelivered message. The session state is unchanged.
+	ErrDuplicateMessage = newError(ErrProtocol, "double ratchet: duplicate message")
+)
+
+// checkHeaderKey rejects a header whose DH key has the wrong size for the DH function.
+func (d *doubleRatchet) checkHeaderKey(h Header) error {
+	if len(h.DH) != d.dh.function().PublicKeySize() {
+		return ErrInvalidHeaderKey
+	}
+
+	return nil
+}
+
+// checkHeaderCounters rejects a header whose counters are too far ahead to be processed,
+// before any key is derived for it. Messages in the current receiving chain may be at
+// most MaxSkip messages ahead; messages under a new ratchet key start a new chain, so
+// their N is bounded by MaxSkip and their PN by the current chain's limit. The limits of
+// WithMaxSkip are checked last.
+func (d *doubleRatchet) checkHeaderCounters(h Header) error {
+	limit := uint64(d.recvN) + MaxSkip
+
+	if bytes.Equal(h.DH, d.dh.remoteBytes()) {
+		if uint64(h.N) >= limit {
+			return ErrTooManySkipped
+		}
+
+		return d.checkSkipLimits(h)
+	}
+
+	if h.N >= MaxSkip || uint64(h.PN) >= limit {
+		return ErrTooManySkipped
+	}
+
+	return d.checkSkipLimits(h)
+}
+
+// consumed reports whether h names a message whose key the session has already used:
+// an earlier message of the current receiving chain, or a message of a previous chain
+// whose remaining skipped keys are still kept but which is not among them. Callers check
+// the skipped keys first.
+func (d *doubleRatchet) consumed(h Header) bool {
+	if bytes.Equal(h.DH, d.dh.remoteBytes()) {
+		return h.N < d.recvN
+	}
+
+	for id := range d.skippedMessageKeys {
+		if id.dh == string(h.DH) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// legacyHeader reports whether h has a version that versions which did not bind the header
+// into the associated data could send. Later versions, such as padded messages, were
+// introduced with the header bound.
+func legacyHeader(h Header) bool {
+	return h.Version == 0
+}
+
+// bindHeader extends the associated data with the encoded header, as the specification's
+// ENCRYPT(mk, plaintext, CONCAT(AD, header)) does, so that a header altered in transit
+// fails authentication. This also covers fields that do not affect the message key, such
+// as PN, which would otherwise make the receiver skip keys the sender never announced.
+func bindHeader(ad []byte, h Header) []byte {
+	encoded := h.encode()
+
+	out := make([]byte, 0, len(ad)+len(headerLabel)+len(encoded)+4)
+	out = append(out, ad...)
+	out = append(out, headerLabel...)
+	out = append(out, encoded...)
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
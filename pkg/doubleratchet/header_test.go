@@ -0,0 +1,230 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// TestHeaderBoundsRejectedBeforeKeyDerivation verifies that headers with a malformed DH
+// key or out-of-range counters are rejected with typed errors and leave the session
+// state untouched.
+func TestHeaderBoundsRejectedBeforeKeyDerivation(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	otherPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+	before, _ := bob.Serialize()
+
+	for _, tc := range []struct {
+		name   string
+		header Header
+		err    error
+	}{
+		{"short key", Header{DH: msg.Header.DH[:33]}, ErrInvalidHeaderKey},
+		{"empty key", Header{}, ErrInvalidHeaderKey},
+		{"N too far ahead", Header{DH: msg.Header.DH, N: MaxSkip}, ErrTooManySkipped},
+		{"N too far ahead in new chain", Header{DH: otherPri.PublicKey().Bytes(), N: MaxSkip}, ErrTooManySkipped},
+		{"PN too far ahead", Header{DH: otherPri.PublicKey().Bytes(), PN: 1 << 31}, ErrTooManySkipped},
+	} {
+		_, err := bob.Receive(CipheredMessage{Header: tc.header, Ciphertext: msg.Ciphertext}, nil)
+
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+		t.Error("Expected rejected headers to leave the session state untouched")
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Expected the genuine message to decrypt, got %v", err)
+	}
+}
+
+// TestReceiveDuplicateMessage verifies that a message received twice, in the current
+// receiving chain or in a previous chain whose skipped keys are kept, is rejected with
+// ErrDuplicateMessage without changing the session state.
+func TestReceiveDuplicateMessage(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg1, _ := alice.Send([]byte("one"), nil)
+	msg2, _ := alice.Send([]byte("two"), nil)
+	msg3, _ := alice.Send([]byte("three"), nil)
+
+	bob.Receive(msg1, nil)
+	bob.Receive(msg3, nil)
+
+	before, _ := bob.Serialize()
+
+	if _, err := bob.Receive(msg1, nil); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("Expected ErrDuplicateMessage in the current chain, got %v", err)
+	}
+
+	if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+		t.Error("Expected a duplicate message to leave the session state untouched")
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+	alice.Receive(reply, nil)
+
+	msg4, _ := alice.Send([]byte("four"), nil)
+
+	if _, err := bob.Receive(msg4, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if _, err := bob.Receive(msg3, nil); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("Expected ErrDuplicateMessage in a previous chain, got %v", err)
+	}
+
+	if m, err := bob.Receive(msg2, nil); err != nil || string(m.Plaintext) != "two" {
+		t.Errorf("Expected the skipped message to decrypt, got %q, %v", m.Plaintext, err)
+	}
+
+	if _, err := bob.Receive(msg2, nil); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("Expected ErrDuplicateMessage for a consumed skipped key, got %v", err)
+	}
+}
+
+// TestReceiveAuthFailed verifies that a tampered ciphertext is rejected with
+// ErrAuthFailed, which wraps the AEAD's error, and that a tampered message whose key was
+// skipped keeps the key for the genuine message.
+func TestReceiveAuthFailed(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg1, _ := alice.Send([]byte("one"), nil)
+	msg2, _ := alice.Send([]byte("two"), nil)
+
+	bob.Receive(msg2, nil)
+
+	tampered := msg1
+	tampered.Ciphertext = append([]byte(nil), msg1.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 0xFF
+
+	_, err := bob.Receive(tampered, nil)
+
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed, got %v", err)
+	}
+
+	if !errors.Is(err, crypto.ErrAuthenticationFailed) {
+		t.Errorf("Expected the AEAD error to be wrapped, got %v", err)
+	}
+
+	if errors.Is(err, ErrDuplicateMessage) {
+		t.Error("Expected a tampered message not to be reported as a duplicate")
+	}
+
+	if _, err := bob.Receive(msg1, []byte("other ad")); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed for different associated data, got %v", err)
+	}
+
+	if m, err := bob.Receive(msg1, nil); err != nil || string(m.Plaintext) != "one" {
+		t.Errorf("Expected the genuine message to decrypt, got %q, %v", m.Plaintext, err)
+	}
+}
+
+// TestHeaderBoundIntoAD verifies that a header field that does not affect the message key,
+// PN on a new chain, is authenticated: altering it makes the message fail with
+// ErrAuthFailed instead of making the receiver skip keys the sender never announced.
+func TestHeaderBoundIntoAD(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	first, _ := alice.Send([]byte("first"), nil)
+
+	if _, err := bob.Receive(first, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := alice.Send([]byte("new chain"), nil)
+
+	tampered := msg
+	tampered.Header.PN += 5
+
+	if _, err := bob.Receive(tampered, nil); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed for an altered PN, got %v", err)
+	}
+
+	if got, err := bob.Receive(msg, nil); err != nil || string(got.Plaintext) != "new chain" {
+		t.Errorf("Expected the original message to decrypt, got %q, %v", got.Plaintext, err)
+	}
+
+	if info := bob.Info(); info.SkippedKeys != 0 {
+		t.Errorf("Expected no skipped keys, got %d", info.SkippedKeys)
+	}
+}
+
+// legacyAEAD stands in for a peer on a version that did not bind the header into the
+// associated data: it seals under the associated data bindHeader was given.
+type legacyAEAD struct{}
+
+func (legacyAEAD) Seal(random io.Reader, mk crypto.MessageKey, plaintext, ad []byte) ([]byte, error) {
+	n := binary.BigEndian.Uint32(ad[len(ad)-4:])
+
+	return SoftwareAEAD{}.Seal(random, mk, plaintext, ad[:n])
+}
+
+func (legacyAEAD) Open(mk crypto.MessageKey, ciphertext, ad []byte) ([]byte, error) {
+	return SoftwareAEAD{}.Open(mk, ciphertext, ad)
+}
+
+// TestLegacyADFallback verifies that messages of a legacy header version sealed without
+// the header bound are accepted, unless the receiver was created WithoutLegacyAD, and that
+// messages of later versions are never opened without the header bound.
+func TestLegacyADFallback(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(legacyAEAD{}))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+	strict, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithoutLegacyAD())
+
+	msg, _ := alice.Send([]byte("legacy"), []byte("ad"))
+
+	if _, err := strict.Receive(msg, []byte("ad")); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed WithoutLegacyAD, got %v", err)
+	}
+
+	if got, err := bob.Receive(msg, []byte("ad")); err != nil || string(got.Plaintext) != "legacy" {
+		t.Errorf("Expected the legacy message to decrypt, got %q, %v", got.Plaintext, err)
+	}
+
+	alice, _ = New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithAEAD(legacyAEAD{}), WithPlaintextPadding(Padme{}))
+	bob, _ = New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ = alice.Send([]byte("padded"), []byte("ad"))
+
+	if _, err := bob.Receive(msg, []byte("ad")); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed for version %d without the header bound, got %v", msg.Header.Version, err)
+	}
+}
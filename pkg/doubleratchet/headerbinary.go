@@ -0,0 +1,148 @@
+package doubleratchet
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// HeaderPrefixSize is the size of the fixed part of Header.MarshalBinary's layout. It
+	// holds every counter and the lengths of the variable fields that follow it, so a
+	// transport that has read it knows the size of the whole header.
+	HeaderPrefixSize = 29
+
+	// headerLayout is the version of the binary header layout, its first byte.
+	headerLayout = 1
+
+	// headerPQ is the flag of headers with post-quantum fields.
+	headerPQ byte = 1
+)
+
+var (
+	// ErrMalformedHeader is returned by Header.UnmarshalBinary for data that does not
+	// follow the binary header layout exactly, and by Header.MarshalBinary for a header
+	// whose fields do not fit it.
+	ErrMalformedHeader = newError(ErrProtocol, "double ratchet: malformed binary header")
+)
+
+// MarshalBinary encodes the header in a fixed layout of HeaderPrefixSize bytes followed by
+// the variable-length fields. All integers are big-endian:
+//
+//	offset  size  field
+//	0       1     layout version, 1
+//	1       1     flags: bit 0 is set if PQ is not nil
+//	2       1     Version
+//	3       1     Type
+//	4       4     N
+//	8       4     PN
+//	12      8     Timestamp
+//	20      1     length of DH
+//	21      1     length of Padding
+//	22      1     length of MessageID
+//	23      2     length of PQ.Key
+//	25      2     length of PQ.Ciphertext
+//	27      2     length of PQ.Target
+//	29            DH, Padding, MessageID, PQ.Key, PQ.Ciphertext, PQ.Target
+//
+// DH must not be empty. DH, Padding and MessageID are at most 255 bytes long, and the PQ
+// fields at most 65535.
+func (h Header) MarshalBinary() ([]byte, error) {
+	var flags byte
+	var pq HybridHeader
+
+	if h.PQ != nil {
+		flags, pq = headerPQ, *h.PQ
+	}
+
+	if len(h.DH) == 0 {
+		return nil, ErrMalformedHeader
+	}
+
+	for _, field := range [][]byte{h.DH, h.Padding, h.MessageID} {
+		if len(field) > math.MaxUint8 {
+			return nil, ErrMalformedHeader
+		}
+	}
+
+	for _, field := range [][]byte{pq.Key, pq.Ciphertext, pq.Target} {
+		if len(field) > math.MaxUint16 {
+			return nil, ErrMalformedHeader
+		}
+	}
+
+	buf := make([]byte, 0, HeaderPrefixSize+len(h.DH)+len(h.Padding)+len(h.MessageID)+len(pq.Key)+len(pq.Ciphertext)+len(pq.Target))
+
+	buf = append(buf, headerLayout, flags, h.Version, byte(h.Type))
+	buf = binary.BigEndian.AppendUint32(buf, h.N)
+	buf = binary.BigEndian.AppendUint32(buf, h.PN)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Timestamp))                    // #nosec G115 -- round-trips through the same conversion
+	buf = append(buf, byte(len(h.DH)), byte(len(h.Padding)), byte(len(h.MessageID))) // #nosec G115 -- checked above
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(pq.Key)))                    // #nosec G115 -- checked above
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(pq.Ciphertext)))             // #nosec G115 -- checked above
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(pq.Target)))                 // #nosec G115 -- checked above
+
+	for _, field := range [][]byte{h.DH, h.Padding, h.MessageID, pq.Key, pq.Ciphertext, pq.Target} {
+		buf = append(buf, field...)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a header encoded by MarshalBinary. It accepts exactly the
+// encodings MarshalBinary produces: data must have the length its prefix announces, the
+// DH key must not be empty, unknown flags are rejected, and a header without the PQ flag
+// must not have PQ fields.
+func (h *Header) UnmarshalBinary(data []byte) error {
+	if len(data) < HeaderPrefixSize || data[0] != headerLayout || data[1]&^headerPQ != 0 {
+		return ErrMalformedHeader
+	}
+
+	sizes := []int{
+		int(data[20]),
+		int(data[21]),
+		int(data[22]),
+		int(binary.BigEndian.Uint16(data[23:])),
+		int(binary.BigEndian.Uint16(data[25:])),
+		int(binary.BigEndian.Uint16(data[27:])),
+	}
+
+	total := HeaderPrefixSize
+
+	for _, size := range sizes {
+		total += size
+	}
+
+	if len(data) != total || sizes[0] == 0 || (data[1]&headerPQ == 0 && sizes[3]+sizes[4]+sizes[5] != 0) {
+		return ErrMalformedHeader
+	}
+
+	fields := make([][]byte, len(sizes))
+	rest := data[HeaderPrefixSize:]
+
+	for i, size := range sizes {
+		if size > 0 {
+			fields[i] = append([]byte(nil), rest[:size]...)
+		}
+
+		rest = rest[size:]
+	}
+
+	header := Header{
+		DH:        fields[0],
+		N:         binary.BigEndian.Uint32(data[4:]),
+		PN:        binary.BigEndian.Uint32(data[8:]),
+		Padding:   fields[1],
+		Version:   data[2],
+		Type:      MessageType(data[3]),
+		Timestamp: int64(binary.BigEndian.Uint64(data[12:])), // #nosec G115 -- inverse of MarshalBinary
+		MessageID: fields[2],
+	}
+
+	if data[1]&headerPQ != 0 {
+		header.PQ = &HybridHeader{Key: fields[3], Ciphertext: fields[4], Target: fields[5]}
+	}
+
+	*h = header
+
+	return nil
+}
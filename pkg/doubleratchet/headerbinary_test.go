@@ -0,0 +1,92 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestHeaderMarshalBinary verifies that headers survive the binary layout with every
+// field set, that the prefix announces the size of the whole header, and that malformed
+// encodings are rejected.
+func TestHeaderMarshalBinary(t *testing.T) {
+	pri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	for _, h := range []Header{
+		{DH: pri.PublicKey().Bytes(), N: 7, PN: 3},
+		{
+			DH: pri.PublicKey().Bytes(), N: 1 << 31, PN: 9,
+			Padding: []byte("padding"), Version: 1, Type: MessageType(2),
+			Timestamp: -1, MessageID: []byte("id"),
+			PQ: &HybridHeader{Key: bytes.Repeat([]byte{1}, 1184), Ciphertext: []byte("ct"), Target: []byte("target")},
+		},
+	} {
+		data, err := h.MarshalBinary()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := HeaderPrefixSize + int(data[20]) + int(data[21]) + int(data[22]) +
+			int(binary.BigEndian.Uint16(data[23:])) + int(binary.BigEndian.Uint16(data[25:])) + int(binary.BigEndian.Uint16(data[27:]))
+
+		if size != len(data) {
+			t.Errorf("Expected the prefix to announce %d bytes, got %d", len(data), size)
+		}
+
+		var decoded Header
+
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(decoded, h) {
+			t.Errorf("Expected %+v, got %+v", h, decoded)
+		}
+	}
+
+	valid, _ := Header{DH: []byte("key")}.MarshalBinary()
+
+	for name, data := range map[string][]byte{
+		"short":    valid[:HeaderPrefixSize-1],
+		"trailing": append(valid[:len(valid):len(valid)], 0),
+		"layout":   append([]byte{2}, valid[1:]...),
+		"flags":    append([]byte{headerLayout, 2}, valid[2:]...),
+		"no key":   append(append([]byte(nil), valid[:20]...), append([]byte{0}, valid[21:HeaderPrefixSize]...)...),
+	} {
+		if err := new(Header).UnmarshalBinary(data); !errors.Is(err, ErrMalformedHeader) {
+			t.Errorf("%s: Expected ErrMalformedHeader, got %v", name, err)
+		}
+	}
+
+	if _, err := (Header{DH: make([]byte, 256)}).MarshalBinary(); !errors.Is(err, ErrMalformedHeader) {
+		t.Errorf("Expected ErrMalformedHeader for an oversized key, got %v", err)
+	}
+}
+
+// FuzzHeaderUnmarshalBinary verifies that UnmarshalBinary never panics and accepts only
+// canonical encodings: every header it decodes is encoded back to the same bytes.
+func FuzzHeaderUnmarshalBinary(f *testing.F) {
+	seed, _ := Header{DH: []byte("key"), N: 1, Padding: []byte("pad"), PQ: &HybridHeader{Key: []byte("pq")}}.MarshalBinary()
+
+	f.Add(seed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var h Header
+
+		if err := h.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		encoded, err := h.MarshalBinary()
+
+		if err != nil || !bytes.Equal(encoded, data) {
+			t.Errorf("Expected %x to be encoded back to itself, got %x, %v", data, encoded, err)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package doubleratchet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// hybridKeySize, hybridCiphertextSize and hybridTargetSize are the sizes of an
+	// ML-KEM-768 encapsulation key, an ML-KEM-768 ciphertext and a key ID.
+	hybridKeySize        = 1184
+	hybridCiphertextSize = 1088
+	hybridTargetSize     = 8
+)
+
+// hybridLabel separates the post-quantum header fields from the rest of the associated data.
+var hybridLabel = []byte("DoubleRatchet-PQ")
+
+var (
+	// ErrHybridUnsupported is returned by WithHybridPQ, and by Deserialize for hybrid
+	// state, when the package was built with a Go release without crypto/mlkem.
+	ErrHybridUnsupported = errors.New("double ratchet: hybrid mode requires Go 1.24 or later")
+
+	// ErrShortPQSecret is returned by WithInitialPQSecret for secrets shorter than 32 bytes.
+	ErrShortPQSecret = errors.New("double ratchet: post-quantum secret shorter than 32 bytes")
+
+	// ErrInvalidHybridHeader is returned when a header's post-quantum fields are malformed,
+	// missing in a hybrid session, or encapsulated to a key the session does not hold.
+	ErrInvalidHybridHeader = newError(ErrProtocol, "double ratchet: invalid post-quantum header")
+)
+
+// HybridHeader carries the post-quantum fields of a header sent by a session created with
+// WithHybridPQ.
+type HybridHeader struct {
+	// Key is the sender's current ML-KEM-768 encapsulation key.
+	Key []byte `json:"Key"`
+
+	// Ciphertext is an ML-KEM-768 ciphertext encapsulated to the receiver's key
+	// identified by Target. Its shared secret was mixed into the root key when the
+	// sender's current chain was derived. Both are empty for chains derived before the
+	// sender learned a key of the receiver.
+	Ciphertext []byte `json:"Ciphertext,omitempty"`
+	Target     []byte `json:"Target,omitempty"`
+}
+
+// HybridState is the serialized post-quantum state of a session created with WithHybridPQ.
+type HybridState struct {
+	// Key and PrevKey are the seeds of the current and previous decapsulation keys.
+	Key     []byte `json:"Key"`
+	PrevKey []byte `json:"PrevKey,omitempty"`
+
+	// RemoteKey is the last encapsulation key received from the peer.
+	RemoteKey []byte `json:"RemoteKey,omitempty"`
+
+	// Ciphertext and Target are sent in the headers of the current sending chain.
+	Ciphertext []byte `json:"Ciphertext,omitempty"`
+	Target     []byte `json:"Target,omitempty"`
+}
+
+// WithInitialPQSecret mixes secret, a shared secret agreed with the peer out of band, such
+// as from an ML-KEM or Kyber encapsulation during the handshake, into the derivation of the
+// initial root and chain keys. Both peers must give the same secret to New; a session
+// derived this way stays confidential unless both the ECDH and the post-quantum secret are
+// broken. The secret must be at least 32 bytes and is not recorded in the serialized state.
+func WithInitialPQSecret(secret []byte) Option {
+	return func(d *doubleRatchet) error {
+		if len(secret) < 32 {
+			return ErrShortPQSecret
+		}
+
+		d.initialPQ = append([]byte(nil), secret...)
+		return nil
+	}
+}
+
+// checkHybridHeader rejects a header whose post-quantum fields have the wrong sizes, or
+// that lacks them although the session is hybrid.
+func (d *doubleRatchet) checkHybridHeader(h Header) error {
+	if h.PQ == nil {
+		if d.hybrid != nil {
+			return ErrInvalidHybridHeader
+		}
+
+		return nil
+	}
+
+	if d.hybrid == nil || len(h.PQ.Key) != hybridKeySize {
+		return ErrInvalidHybridHeader
+	}
+
+	if len(h.PQ.Ciphertext) == 0 && len(h.PQ.Target) == 0 {
+		return nil
+	}
+
+	if len(h.PQ.Ciphertext) != hybridCiphertextSize || len(h.PQ.Target) != hybridTargetSize {
+		return ErrInvalidHybridHeader
+	}
+
+	return nil
+}
+
+// hybridKeyID identifies an encapsulation key in HybridHeader.Target.
+func hybridKeyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+
+	return sum[:hybridTargetSize]
+}
+
+// hybridAD extends the associated data with the post-quantum header fields, so that a
+// substituted encapsulation key or ciphertext fails authentication.
+func hybridAD(ad []byte, pq *HybridHeader) []byte {
+	if pq == nil {
+		return ad
+	}
+
+	out := make([]byte, 0, len(ad)+len(hybridLabel)+len(pq.Key)+len(pq.Ciphertext)+len(pq.Target)+4)
+	out = append(out, ad...)
+	out = append(out, hybridLabel...)
+	out = append(out, pq.encode()...)
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
+
+// encode returns the fields length-prefixed, in order.
+func (pq *HybridHeader) encode() []byte {
+	var buf []byte
+
+	for _, field := range [][]byte{pq.Key, pq.Ciphertext, pq.Target} {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(field))) // #nosec G115 -- checked against the ML-KEM sizes on receipt
+		buf = append(buf, field...)
+	}
+
+	return buf
+}
+
+// headerAD extends the associated data with the optional header fields that are not
+// covered by the message key derivation: the padding, the post-quantum fields, the version
+// and type, and the metadata.
+func headerAD(ad []byte, h Header) []byte {
+	return metadataAD(typeAD(hybridAD(paddingAD(ad, h.Padding), h.PQ), h), h)
+}
@@ -0,0 +1,46 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestWithInitialPQSecret verifies that peers giving the same post-quantum secret to New
+// exchange messages, that the secret changes the initial keys, and that short secrets
+// are rejected.
+func TestWithInitialPQSecret(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	secret := bytes.Repeat([]byte{0x42}, 32)
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithInitialPQSecret(secret))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithInitialPQSecret(secret))
+	classical, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if bob.rootKey == classical.rootKey {
+		t.Error("Expected the post-quantum secret to change the root key")
+	}
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if _, err := classical.Receive(msg, nil); err == nil {
+		t.Error("Expected a peer without the post-quantum secret to reject the message")
+	}
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithInitialPQSecret(secret[:16])); !errors.Is(err, ErrShortPQSecret) {
+		t.Errorf("Expected ErrShortPQSecret, got %v", err)
+	}
+}
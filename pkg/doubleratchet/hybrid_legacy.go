@@ -0,0 +1,38 @@
+//go:build !go1.24
+
+package doubleratchet
+
+// hybridRatchet is empty: Go releases before 1.24 lack crypto/mlkem.
+type hybridRatchet struct{}
+
+// WithHybridPQ enables the hybrid post-quantum ratchet. It requires Go 1.24 or later and
+// returns ErrHybridUnsupported otherwise.
+func WithHybridPQ() Option {
+	return func(*doubleRatchet) error {
+		return ErrHybridUnsupported
+	}
+}
+
+func (d *doubleRatchet) hybridHeader() *HybridHeader {
+	return nil
+}
+
+func (d *doubleRatchet) hybridReceiveSecret(Header) ([]byte, error) {
+	return nil, nil
+}
+
+func (d *doubleRatchet) hybridSendSecret() ([]byte, error) {
+	return nil, nil
+}
+
+func (d *doubleRatchet) hybridState() *HybridState {
+	return nil
+}
+
+func (d *doubleRatchet) restoreHybrid(state *HybridState) error {
+	if state != nil {
+		return ErrHybridUnsupported
+	}
+
+	return nil
+}
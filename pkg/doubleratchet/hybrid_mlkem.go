@@ -0,0 +1,163 @@
+//go:build go1.24
+
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/mlkem"
+)
+
+// hybridRatchet holds the ML-KEM-768 keys of a hybrid session.
+type hybridRatchet struct {
+	key    *mlkem.DecapsulationKey768
+	prev   *mlkem.DecapsulationKey768
+	remote *mlkem.EncapsulationKey768
+
+	ciphertext []byte
+	target     []byte
+}
+
+// WithHybridPQ makes every DH ratchet step also perform an ML-KEM-768 encapsulation and
+// mix both shared secrets into the root key, so that recorded traffic stays confidential
+// against an attacker who later breaks the elliptic curve with a quantum computer.
+//
+// Each header carries the sender's 1184-byte encapsulation key, and the headers of a new
+// sending chain also carry a 1088-byte ciphertext encapsulated to the peer's latest key.
+// Sessions learn the peer's key from its first message, so the first ratchet step of each
+// side is classical. Both peers must use the option, and ML-KEM draws its randomness from
+// crypto/rand rather than from WithRand. The keys are recorded in the serialized state.
+func WithHybridPQ() Option {
+	return func(d *doubleRatchet) error {
+		key, err := mlkem.GenerateKey768()
+
+		if err != nil {
+			return err
+		}
+
+		d.hybrid = &hybridRatchet{key: key}
+		return nil
+	}
+}
+
+// hybridHeader returns the post-quantum fields of the next sent header.
+func (d *doubleRatchet) hybridHeader() *HybridHeader {
+	if d.hybrid == nil {
+		return nil
+	}
+
+	return &HybridHeader{
+		Key:        d.hybrid.key.EncapsulationKey().Bytes(),
+		Ciphertext: d.hybrid.ciphertext,
+		Target:     d.hybrid.target,
+	}
+}
+
+// hybridReceiveSecret decapsulates the shared secret of a new receiving chain from h and
+// remembers the peer's encapsulation key. It returns nil for classical sessions and for
+// chains the peer derived without a ciphertext.
+func (d *doubleRatchet) hybridReceiveSecret(h Header) ([]byte, error) {
+	if d.hybrid == nil {
+		return nil, nil
+	}
+
+	remote, err := mlkem.NewEncapsulationKey768(h.PQ.Key)
+
+	if err != nil {
+		return nil, ErrInvalidHybridHeader
+	}
+
+	d.hybrid.remote = remote
+
+	if len(h.PQ.Ciphertext) == 0 {
+		return nil, nil
+	}
+
+	for _, key := range []*mlkem.DecapsulationKey768{d.hybrid.key, d.hybrid.prev} {
+		if key != nil && bytes.Equal(hybridKeyID(key.EncapsulationKey().Bytes()), h.PQ.Target) {
+			return key.Decapsulate(h.PQ.Ciphertext)
+		}
+	}
+
+	return nil, ErrInvalidHybridHeader
+}
+
+// hybridSendSecret rotates the session's decapsulation key for a new sending chain and
+// encapsulates a shared secret to the peer's latest key. It returns nil for classical
+// sessions and while the peer's key is unknown.
+func (d *doubleRatchet) hybridSendSecret() ([]byte, error) {
+	if d.hybrid == nil {
+		return nil, nil
+	}
+
+	key, err := mlkem.GenerateKey768()
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.hybrid.prev, d.hybrid.key = d.hybrid.key, key
+	d.hybrid.ciphertext, d.hybrid.target = nil, nil
+
+	if d.hybrid.remote == nil {
+		return nil, nil
+	}
+
+	secret, ciphertext := d.hybrid.remote.Encapsulate()
+
+	d.hybrid.ciphertext = ciphertext
+	d.hybrid.target = hybridKeyID(d.hybrid.remote.Bytes())
+
+	return secret, nil
+}
+
+// hybridState returns the serialized post-quantum state, or nil for classical sessions.
+func (d *doubleRatchet) hybridState() *HybridState {
+	if d.hybrid == nil {
+		return nil
+	}
+
+	state := &HybridState{
+		Key:        d.hybrid.key.Bytes(),
+		Ciphertext: d.hybrid.ciphertext,
+		Target:     d.hybrid.target,
+	}
+
+	if d.hybrid.prev != nil {
+		state.PrevKey = d.hybrid.prev.Bytes()
+	}
+
+	if d.hybrid.remote != nil {
+		state.RemoteKey = d.hybrid.remote.Bytes()
+	}
+
+	return state
+}
+
+// restoreHybrid restores the post-quantum state recorded by hybridState.
+func (d *doubleRatchet) restoreHybrid(state *HybridState) error {
+	if state == nil {
+		return nil
+	}
+
+	key, err := mlkem.NewDecapsulationKey768(state.Key)
+
+	if err != nil {
+		return err
+	}
+
+	d.hybrid = &hybridRatchet{key: key, ciphertext: state.Ciphertext, target: state.Target}
+
+	if state.PrevKey != nil {
+		if d.hybrid.prev, err = mlkem.NewDecapsulationKey768(state.PrevKey); err != nil {
+			return err
+		}
+	}
+
+	if state.RemoteKey != nil {
+		if d.hybrid.remote, err = mlkem.NewEncapsulationKey768(state.RemoteKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
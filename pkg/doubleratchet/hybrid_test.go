@@ -0,0 +1,132 @@
+//go:build go1.24
+
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestHybridPQ verifies that hybrid sessions exchange messages across DH ratchet steps
+// whose chains mix in ML-KEM secrets once each side knows the other's key, that the
+// post-quantum state survives serialization, and that altered post-quantum fields are
+// rejected.
+func TestHybridPQ(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithHybridPQ())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithHybridPQ())
+	classical, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	// One message each way on the initial chains, so that the previous chain lengths
+	// sent in the ratcheted headers match what each side received.
+	for _, pair := range [][2]*doubleRatchet{{alice, bob}, {bob, alice}} {
+		msg, _ := pair[0].Send([]byte("hello"), nil)
+
+		if _, err := pair[1].Receive(msg, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	stepSendingRatchet(t, alice)
+
+	first, _ := alice.Send([]byte("first"), nil)
+
+	if first.Header.PQ == nil || len(first.Header.PQ.Key) != hybridKeySize || first.Header.PQ.Ciphertext != nil {
+		t.Fatal("Expected the first chain to carry an encapsulation key and no ciphertext")
+	}
+
+	if _, err := classical.Receive(first, nil); !errors.Is(err, ErrInvalidHybridHeader) {
+		t.Errorf("Expected a classical session to reject a hybrid header, got %v", err)
+	}
+
+	if _, err := bob.Receive(first, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if len(reply.Header.PQ.Ciphertext) != hybridCiphertextSize {
+		t.Fatal("Expected the chain after learning the peer's key to carry a ciphertext")
+	}
+
+	state, _ := alice.Serialize()
+
+	for _, tamper := range []func(*HybridHeader){
+		func(pq *HybridHeader) { pq.Ciphertext[0] ^= 1 },
+		func(pq *HybridHeader) { pq.Key[0] ^= 1 },
+	} {
+		copied, _ := Deserialize(state)
+		altered := reply
+		pq := &HybridHeader{Key: append([]byte(nil), reply.Header.PQ.Key...), Ciphertext: append([]byte(nil), reply.Header.PQ.Ciphertext...), Target: reply.Header.PQ.Target}
+		tamper(pq)
+		altered.Header.PQ = pq
+
+		if _, err := copied.Receive(altered, nil); err == nil {
+			t.Error("Expected altered post-quantum fields to be rejected")
+		}
+	}
+
+	restored, err := Deserialize(state)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restored.Receive(reply, nil); err != nil {
+		t.Fatalf("Receive after restore failed: %v", err)
+	}
+
+	next, _ := restored.Send([]byte("next"), nil)
+
+	if len(next.Header.PQ.Ciphertext) != hybridCiphertextSize {
+		t.Error("Expected Alice's new chain to carry a ciphertext")
+	}
+
+	if _, err := bob.Receive(next, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	next.Header.PQ = nil
+
+	if _, err := bob.Receive(next, nil); !errors.Is(err, ErrInvalidHybridHeader) {
+		t.Errorf("Expected a hybrid session to reject a header without post-quantum fields, got %v", err)
+	}
+}
+
+// TestHybridPQInitAliceInitBob verifies that sessions bootstrapped with InitAlice and
+// InitBob run the hybrid ratchet from the initiator's first message on.
+func TestHybridPQInitAliceInitBob(t *testing.T) {
+	sk := make([]byte, 32)
+	rand.Read(sk)
+
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := InitAlice(sk, bobPri.PublicKey().Bytes(), WithHybridPQ())
+	bob, _ := InitBob(sk, bobPri.Bytes(), WithHybridPQ())
+
+	for round := 0; round < 3; round++ {
+		msg, _ := alice.Send([]byte("hello"), nil)
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("Round %d: Bob failed to receive: %v", round, err)
+		}
+
+		reply, _ := bob.Send([]byte("reply"), nil)
+
+		if len(reply.Header.PQ.Ciphertext) != hybridCiphertextSize {
+			t.Errorf("Round %d: expected the reply to carry a ciphertext", round)
+		}
+
+		if _, err := alice.Receive(reply, nil); err != nil {
+			t.Fatalf("Round %d: Alice failed to receive: %v", round, err)
+		}
+	}
+}
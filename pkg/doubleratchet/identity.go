@@ -0,0 +1,61 @@
+package doubleratchet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrInvalidIdentityKey is returned by WithIdentityAD for an empty identity key.
+	ErrInvalidIdentityKey = errors.New("double ratchet: empty identity key")
+)
+
+// identityLabel domain-separates the identity keys in the associated data.
+var identityLabel = []byte("DoubleRatchet-Identity")
+
+// identity holds the identity public keys set with WithIdentityAD.
+type identity struct {
+	local, remote []byte
+}
+
+// WithIdentityAD binds both parties' identity public keys into the associated data of
+// every message, as X3DH recommends, so a message only decrypts for the pair of
+// identities it was sent between. The keys are taken as opaque bytes in whatever encoding
+// the peers agree on; each side gives its own key as local and the peer's as remote.
+//
+// The keys are ordered by direction, the sender's first, so both peers compute the same
+// associated data without agreeing on who initiated the session. They are not part of
+// the serialized state and must be given again when a session is deserialized.
+func WithIdentityAD(local, remote []byte) Option {
+	return func(d *doubleRatchet) error {
+		if len(local) == 0 || len(remote) == 0 {
+			return ErrInvalidIdentityKey
+		}
+
+		d.identity = &identity{local: append([]byte(nil), local...), remote: append([]byte(nil), remote...)}
+
+		return nil
+	}
+}
+
+// identityAD extends ad with the sender's and the receiver's identity keys. Without
+// WithIdentityAD, ad is returned unchanged.
+func (d *doubleRatchet) identityAD(ad []byte, sending bool) []byte {
+	if d.identity == nil {
+		return ad
+	}
+
+	sender, receiver := d.identity.remote, d.identity.local
+
+	if sending {
+		sender, receiver = receiver, sender
+	}
+
+	out := make([]byte, 0, len(ad)+len(identityLabel)+len(sender)+len(receiver)+12)
+	out = append(out, ad...)
+	out = append(out, identityLabel...)
+	out = appendLengthPrefixed(out, sender)
+	out = appendLengthPrefixed(out, receiver)
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
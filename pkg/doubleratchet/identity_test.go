@@ -0,0 +1,86 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// newIdentityPair returns sessions for Alice and Bob with the given identity options.
+func newIdentityPair(t *testing.T, aliceOpts, bobOpts []Option) (alice, bob *doubleRatchet) {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, aliceOpts...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err = New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, bobOpts...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return alice, bob
+}
+
+// TestIdentityAD verifies that messages decrypt in both directions when both peers bind
+// the same pair of identity keys.
+func TestIdentityAD(t *testing.T) {
+	aliceID, bobID := []byte("alice-identity"), []byte("bob-identity")
+	alice, bob := newIdentityPair(t, []Option{WithIdentityAD(aliceID, bobID)}, []Option{WithIdentityAD(bobID, aliceID)})
+
+	msg, _ := alice.Send([]byte("hello"), []byte("ad"))
+
+	if got, err := bob.Receive(msg, []byte("ad")); err != nil || string(got.Plaintext) != "hello" {
+		t.Fatalf("Expected hello, got %q, %v", got.Plaintext, err)
+	}
+
+	reply, _ := bob.Send([]byte("hi"), nil)
+
+	if got, err := alice.Receive(reply, nil); err != nil || string(got.Plaintext) != "hi" {
+		t.Errorf("Expected hi, got %q, %v", got.Plaintext, err)
+	}
+}
+
+// TestIdentityADMismatch verifies that a message fails authentication when the receiver
+// expects a different sender identity, or binds no identities at all.
+func TestIdentityADMismatch(t *testing.T) {
+	aliceID, bobID := []byte("alice-identity"), []byte("bob-identity")
+
+	tests := []struct {
+		name    string
+		bobOpts []Option
+	}{
+		{"other sender", []Option{WithIdentityAD(bobID, []byte("mallory-identity"))}},
+		{"swapped keys", []Option{WithIdentityAD(aliceID, bobID)}},
+		{"no identities", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice, bob := newIdentityPair(t, []Option{WithIdentityAD(aliceID, bobID)}, tt.bobOpts)
+
+			msg, _ := alice.Send([]byte("hello"), nil)
+
+			if _, err := bob.Receive(msg, nil); !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("Expected ErrAuthFailed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestIdentityADEmptyKey verifies that WithIdentityAD refuses empty keys.
+func TestIdentityADEmptyKey(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithIdentityAD(nil, []byte("bob"))); !errors.Is(err, ErrInvalidIdentityKey) {
+		t.Errorf("Expected ErrInvalidIdentityKey, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package doubleratchet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/othonhugo/goratchet/pkg/transparency"
+)
+
+// SessionInfo holds non-secret facts about a session for debugging and display.
+type SessionInfo struct {
+	// SendN and RecvN are the numbers of messages sent on the current sending chain and
+	// received on the current receiving chain.
+	SendN uint32
+	RecvN uint32
+
+	// SkippedKeys is the number of stored skipped message keys.
+	SkippedKeys int
+
+	// Epoch is the number of DH ratchet steps the session has performed. Events carry
+	// the same number.
+	Epoch uint32
+
+	// RemoteKeyFingerprint is the hex-encoded SHA-256 of the peer's current ratchet
+	// public key, or empty if the session has not received one.
+	RemoteKeyFingerprint string
+
+	// Archived and Closed report whether the session was archived or closed.
+	Archived bool
+	Closed   bool
+
+	// IdentityStatus is the outcome of the last VerifyIdentity, or StatusUnverified if
+	// the peer's identity key was not checked against a transparency log.
+	IdentityStatus transparency.Status
+}
+
+// Info returns non-secret facts about the session.
+func (d *doubleRatchet) Info() SessionInfo {
+	d.lock()
+	defer d.unlock()
+
+	return SessionInfo{
+		SendN:                d.sendN,
+		RecvN:                d.recvN,
+		SkippedKeys:          len(d.skippedMessageKeys),
+		Epoch:                d.epoch,
+		RemoteKeyFingerprint: fingerprint(d.dh.remoteBytes()),
+		Archived:             d.archived,
+		Closed:               d.closed,
+		IdentityStatus:       d.identityStatus,
+	}
+}
+
+// LocalRatchetKey returns the encoding of the session's current ratchet public key, which
+// the headers of its sent messages carry, or nil if the session was closed. After a
+// message under a new peer key arrives, the next Send first replaces the key.
+func (d *doubleRatchet) LocalRatchetKey() []byte {
+	d.lock()
+	defer d.unlock()
+
+	if d.dh.localPrivateKey == nil {
+		return nil
+	}
+
+	return append([]byte(nil), d.dh.localPrivateKey.PublicKey().Bytes()...)
+}
+
+// RemoteRatchetKey returns the encoding of the peer's current ratchet public key, or nil
+// if the session has not received one or was closed.
+func (d *doubleRatchet) RemoteRatchetKey() []byte {
+	d.lock()
+	defer d.unlock()
+
+	return append([]byte(nil), d.dh.remoteBytes()...)
+}
+
+// fingerprint returns the hex-encoded SHA-256 of a public key, or empty for nil.
+func fingerprint(pub []byte) string {
+	if pub == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(pub)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/transparency"
+)
+
+// TestInfo verifies that Info reports the chain counters, the number of skipped keys, the
+// number of DH ratchet steps and the fingerprint of the peer's current ratchet key.
+func TestInfo(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	alice.Send([]byte("skipped"), nil)
+	msg, _ := alice.Send([]byte("hello"), nil)
+	bob.Receive(msg, nil)
+
+	info := bob.Info()
+
+	if info.RecvN != 2 || info.SendN != 0 || info.SkippedKeys != 1 || info.Epoch != 0 {
+		t.Errorf("Expected RecvN 2, SendN 0, 1 skipped key and epoch 0, got %+v", info)
+	}
+
+	sum := sha256.Sum256(alicePri.PublicKey().Bytes())
+
+	if info.RemoteKeyFingerprint != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the fingerprint of the peer's key, got %s", info.RemoteKeyFingerprint)
+	}
+
+	stepSendingRatchet(t, alice)
+
+	next, _ := alice.Send([]byte("next"), nil)
+	bob.Receive(next, nil)
+
+	info = bob.Info()
+
+	if info.Epoch != 1 || info.RecvN != 1 || info.SkippedKeys != 1 {
+		t.Errorf("Expected epoch 1, RecvN 1 and 1 skipped key after a ratchet step, got %+v", info)
+	}
+
+	sum = sha256.Sum256(next.Header.DH)
+
+	if info.RemoteKeyFingerprint != hex.EncodeToString(sum[:]) {
+		t.Error("Expected the fingerprint to follow the peer's new ratchet key")
+	}
+
+	bob.Close()
+
+	if info := bob.Info(); !info.Closed || info.RemoteKeyFingerprint != "" {
+		t.Errorf("Expected a closed session without a remote key, got %+v", info)
+	}
+}
+
+// TestRatchetKeys verifies that LocalRatchetKey and RemoteRatchetKey return the keys the
+// peers' headers carry, including after a DH ratchet step, and nil once nothing is known.
+func TestRatchetKeys(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if !bytes.Equal(alice.LocalRatchetKey(), bob.RemoteRatchetKey()) || !bytes.Equal(bob.LocalRatchetKey(), alice.RemoteRatchetKey()) {
+		t.Fatal("Expected each session's local key to be the peer's remote key")
+	}
+
+	stepSendingRatchet(t, alice)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if !bytes.Equal(alice.LocalRatchetKey(), msg.Header.DH) {
+		t.Error("Expected the local key to be the key of the sent header")
+	}
+
+	bob.Receive(msg, nil)
+
+	if !bytes.Equal(bob.RemoteRatchetKey(), msg.Header.DH) {
+		t.Error("Expected the remote key to follow the peer's new key")
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if !bytes.Equal(bob.LocalRatchetKey(), reply.Header.DH) {
+		t.Error("Expected the local key to be replaced by the ratchet step of Send")
+	}
+
+	responderPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	sk := make([]byte, 32)
+	rand.Read(sk)
+
+	responder, _ := InitBob(sk, responderPri.Bytes())
+
+	if responder.RemoteRatchetKey() != nil {
+		t.Error("Expected no remote key before the first message")
+	}
+
+	bob.Close()
+
+	if bob.LocalRatchetKey() != nil || bob.RemoteRatchetKey() != nil {
+		t.Error("Expected no keys after Close")
+	}
+}
+
+// TestInfoIdentityStatus verifies that Info reports the outcome of VerifyIdentity, and
+// that a copy made with Clone keeps it.
+func TestInfoIdentityStatus(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	if status := alice.Info().IdentityStatus; status != transparency.StatusUnverified {
+		t.Errorf("Expected %v, got %v", transparency.StatusUnverified, status)
+	}
+
+	log := transparency.NewMemoryLog()
+	_, _ = log.Publish("bob", []byte("bob-identity"))
+
+	v := &transparency.Verifier{Log: log, LogKey: log.PublicKey(), Identity: "bob"}
+
+	if status, err := alice.VerifyIdentity(v, []byte("rogue")); status != transparency.StatusMismatch || err == nil {
+		t.Errorf("Expected %v, got %v (%v)", transparency.StatusMismatch, status, err)
+	}
+
+	if status := alice.Info().IdentityStatus; status != transparency.StatusMismatch {
+		t.Errorf("Expected Info to report %v, got %v", transparency.StatusMismatch, status)
+	}
+
+	if _, err := alice.VerifyIdentity(v, []byte("bob-identity")); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := alice.Clone().Info().IdentityStatus; status != transparency.StatusVerified {
+		t.Errorf("Expected the copy to report %v, got %v", transparency.StatusVerified, status)
+	}
+}
@@ -0,0 +1,147 @@
+package doubleratchet
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrDuplicateADFragment is returned when interceptors contribute two associated data
+	// fragments with the same name.
+	ErrDuplicateADFragment = errors.New("double ratchet: duplicate associated data fragment")
+)
+
+// adLabel domain-separates folded associated data from plain associated data.
+var adLabel = []byte("DoubleRatchet-AD")
+
+// ADFragment is a named piece of associated data contributed by an Interceptor, such as
+// a tenant ID or a schema version.
+type ADFragment struct {
+	Name  string
+	Value []byte
+}
+
+// Interceptor hooks into Send and Receive to enforce policy in one place. The fragments
+// it returns are authenticated with the message, so a message is only accepted if the
+// sender and receiver agree on them.
+type Interceptor interface {
+	// BeforeSend runs before plaintext is encrypted with associated data ad. It returns
+	// fragments to bind to the message, or an error to refuse sending it.
+	BeforeSend(plaintext, ad []byte) ([]ADFragment, error)
+
+	// BeforeReceive runs before a message is decrypted and returns the fragments it must
+	// be bound to, or an error to refuse it.
+	BeforeReceive(header Header, ad []byte) ([]ADFragment, error)
+
+	// AfterReceive runs after a message is decrypted and may refuse it by returning an
+	// error. The session state has already advanced past the message.
+	AfterReceive(plaintext, ad []byte) error
+}
+
+// WithInterceptor adds an interceptor to the session. Interceptors run in the order they
+// were added. They are not part of the serialized state and must be given again when a
+// session is deserialized.
+func WithInterceptor(interceptor Interceptor) Option {
+	return func(d *doubleRatchet) error {
+		d.interceptors = append(d.interceptors, interceptor)
+		return nil
+	}
+}
+
+// FoldAD returns the associated data that authenticates ad together with fragments. The
+// encoding is canonical: fragments are sorted by name and every field is length-prefixed,
+// so the result does not depend on the order interceptors ran in. Without fragments, ad
+// is returned unchanged.
+func FoldAD(ad []byte, fragments []ADFragment) ([]byte, error) {
+	if len(fragments) == 0 {
+		return ad, nil
+	}
+
+	sorted := append([]ADFragment(nil), fragments...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	out := append([]byte(nil), adLabel...)
+	out = appendLengthPrefixed(out, ad)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(sorted))) // #nosec G115 -- fragment counts are small
+
+	for i, f := range sorted {
+		if i > 0 && sorted[i-1].Name == f.Name {
+			return nil, ErrDuplicateADFragment
+		}
+
+		out = appendLengthPrefixed(out, []byte(f.Name))
+		out = appendLengthPrefixed(out, f.Value)
+	}
+
+	return out, nil
+}
+
+// sendAD runs the BeforeSend hooks, folds their fragments into ad and binds the identity
+// keys, if any.
+func (d *doubleRatchet) sendAD(plaintext, ad []byte) ([]byte, error) {
+	var fragments []ADFragment
+
+	for _, interceptor := range d.interceptors {
+		f, err := interceptor.BeforeSend(plaintext, ad)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fragments = append(fragments, f...)
+	}
+
+	folded, err := FoldAD(ad, fragments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.identityAD(folded, true), nil
+}
+
+// receiveAD runs the BeforeReceive hooks, folds their fragments into ad and binds the
+// identity keys, if any.
+func (d *doubleRatchet) receiveAD(header Header, ad []byte) ([]byte, error) {
+	var fragments []ADFragment
+
+	for _, interceptor := range d.interceptors {
+		f, err := interceptor.BeforeReceive(header, ad)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fragments = append(fragments, f...)
+	}
+
+	folded, err := FoldAD(ad, fragments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.identityAD(folded, false), nil
+}
+
+// afterReceive runs the AfterReceive hooks.
+func (d *doubleRatchet) afterReceive(plaintext, ad []byte) error {
+	for _, interceptor := range d.interceptors {
+		if err := interceptor.AfterReceive(plaintext, ad); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendLengthPrefixed appends a uint32 length followed by b.
+func appendLengthPrefixed(out, b []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(b))) // #nosec G115 -- lengths are bounded by memory
+
+	return append(out, b...)
+}
@@ -0,0 +1,102 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// tenantInterceptor binds a tenant ID and schema version to every message and refuses
+// empty plaintexts after decryption.
+type tenantInterceptor struct {
+	tenant string
+}
+
+func (i tenantInterceptor) fragments() []ADFragment {
+	return []ADFragment{{Name: "tenant", Value: []byte(i.tenant)}, {Name: "schema", Value: []byte("v2")}}
+}
+
+func (i tenantInterceptor) BeforeSend([]byte, []byte) ([]ADFragment, error) {
+	return i.fragments(), nil
+}
+
+func (i tenantInterceptor) BeforeReceive(Header, []byte) ([]ADFragment, error) {
+	return i.fragments(), nil
+}
+
+func (i tenantInterceptor) AfterReceive(plaintext, _ []byte) error {
+	if len(plaintext) == 0 {
+		return errEmptyMessage
+	}
+
+	return nil
+}
+
+var errEmptyMessage = errors.New("empty message")
+
+// newInterceptedPair returns sessions for Alice and Bob using the given interceptors.
+func newInterceptedPair(t *testing.T, aliceInterceptor, bobInterceptor Interceptor) (alice, bob *doubleRatchet) {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ = New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithInterceptor(aliceInterceptor))
+	bob, _ = New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithInterceptor(bobInterceptor))
+
+	return alice, bob
+}
+
+// TestInterceptorFragmentsAreAuthenticated verifies that fragments contributed by
+// interceptors are bound to messages: matching fragments decrypt, mismatching ones fail,
+// and AfterReceive can refuse a decrypted message.
+func TestInterceptorFragmentsAreAuthenticated(t *testing.T) {
+	alice, bob := newInterceptedPair(t, tenantInterceptor{"acme"}, tenantInterceptor{"acme"})
+
+	msg, _ := alice.Send([]byte("hello"), []byte("ad"))
+
+	if unciphered, err := bob.Receive(msg, []byte("ad")); err != nil || !bytes.Equal(unciphered.Plaintext, []byte("hello")) {
+		t.Fatalf("Expected matching fragments to decrypt, got %v", err)
+	}
+
+	msg, _ = alice.Send(nil, []byte("ad"))
+
+	if _, err := bob.Receive(msg, []byte("ad")); !errors.Is(err, errEmptyMessage) {
+		t.Errorf("Expected AfterReceive to refuse the message, got %v", err)
+	}
+
+	alice, bob = newInterceptedPair(t, tenantInterceptor{"acme"}, tenantInterceptor{"globex"})
+
+	msg, _ = alice.Send([]byte("hello"), []byte("ad"))
+
+	if _, err := bob.Receive(msg, []byte("ad")); err == nil {
+		t.Error("Expected mismatching fragments to fail decryption")
+	}
+}
+
+// TestFoldADIsCanonical verifies that folding ignores fragment order, leaves associated
+// data without fragments unchanged, and rejects duplicate fragment names.
+func TestFoldADIsCanonical(t *testing.T) {
+	a, _ := FoldAD([]byte("ad"), []ADFragment{{"x", []byte("1")}, {"y", []byte("2")}})
+	b, _ := FoldAD([]byte("ad"), []ADFragment{{"y", []byte("2")}, {"x", []byte("1")}})
+
+	if !bytes.Equal(a, b) {
+		t.Error("Expected folding to be independent of fragment order")
+	}
+
+	if plain, _ := FoldAD([]byte("ad"), nil); !bytes.Equal(plain, []byte("ad")) {
+		t.Errorf("Expected unchanged associated data, got %q", plain)
+	}
+
+	ambiguous, _ := FoldAD([]byte("ad"), []ADFragment{{"x", []byte("12")}})
+
+	if bytes.Equal(a, ambiguous) {
+		t.Error("Expected different fragments to fold differently")
+	}
+
+	if _, err := FoldAD(nil, []ADFragment{{"x", nil}, {"x", nil}}); !errors.Is(err, ErrDuplicateADFragment) {
+		t.Errorf("Expected ErrDuplicateADFragment, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package doubleratchet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// skippedKey is a stored skipped message key with the metadata reported by SkippedKeys.
+type skippedKey struct {
+	key    crypto.MessageKey
+	epoch  uint32
+	stored time.Time
+}
+
+// SkippedKeyStats describes the skipped message keys a session holds for messages that
+// have not arrived yet. Large or old inventories point at lost messages or a peer that
+// skips ahead, and can drive warnings or garbage collection.
+type SkippedKeyStats struct {
+	// Count is the number of stored skipped keys.
+	Count int
+
+	// PerEpoch maps each epoch to the number of keys skipped in it.
+	PerEpoch map[uint32]int
+
+	// OldestAge is the age of the oldest key whose storage time is known, or zero.
+	OldestAge time.Duration
+}
+
+// SkippedKeys reports the session's skipped message keys.
+func (d *doubleRatchet) SkippedKeys() SkippedKeyStats {
+	d.lock()
+	defer d.unlock()
+
+	stats := SkippedKeyStats{
+		Count:    len(d.skippedMessageKeys),
+		PerEpoch: make(map[uint32]int),
+	}
+
+	var oldest time.Time
+
+	for _, sk := range d.skippedMessageKeys {
+		stats.PerEpoch[sk.epoch]++
+
+		if !sk.stored.IsZero() && (oldest.IsZero() || sk.stored.Before(oldest)) {
+			oldest = sk.stored
+		}
+	}
+
+	if !oldest.IsZero() {
+		stats.OldestAge = d.clock().Sub(oldest)
+	}
+
+	return stats
+}
+
+// clock returns the current time, as seen by the session.
+func (d *doubleRatchet) clock() time.Time {
+	if d.now == nil {
+		return time.Now()
+	}
+
+	return d.now()
+}
+
+// WithMaxPreviousChains limits the number of previous receiving chains whose skipped
+// message keys the session keeps. When a DH ratchet step would retain more, the keys of
+// the oldest chains are deleted, so messages still missing from them can no longer be
+// decrypted. Zero keeps only the current chain's keys. By default all chains' keys are
+// kept, bounded only by MaxSkip per chain. The limit is not part of the serialized state
+// and must be given again when a session is deserialized.
+func WithMaxPreviousChains(n int) Option {
+	return func(d *doubleRatchet) error {
+		d.maxPrevChains = max(n, 0)
+		d.limitPrevChains = true
+		return nil
+	}
+}
+
+// prunePreviousChains deletes the skipped keys of the oldest previous receiving chains
+// beyond the configured limit. Chains are ordered by the latest epoch they hold keys
+// from. The caller must hold the lock.
+func (d *doubleRatchet) prunePreviousChains() {
+	if !d.limitPrevChains {
+		return
+	}
+
+	current := string(d.dh.remoteBytes())
+	latest := make(map[string]uint32)
+
+	for id, sk := range d.skippedMessageKeys {
+		if id.dh != current && sk.epoch >= latest[id.dh] {
+			latest[id.dh] = sk.epoch
+		}
+	}
+
+	if len(latest) <= d.maxPrevChains {
+		return
+	}
+
+	chains := make([]string, 0, len(latest))
+
+	for dh := range latest {
+		chains = append(chains, dh)
+	}
+
+	sort.Slice(chains, func(i, j int) bool {
+		if latest[chains[i]] != latest[chains[j]] {
+			return latest[chains[i]] < latest[chains[j]]
+		}
+
+		return chains[i] < chains[j]
+	})
+
+	evict := make(map[string]bool)
+
+	for _, dh := range chains[:len(chains)-d.maxPrevChains] {
+		evict[dh] = true
+	}
+
+	for id := range d.skippedMessageKeys {
+		if evict[id.dh] {
+			d.deleteSkipped(id)
+		}
+	}
+}
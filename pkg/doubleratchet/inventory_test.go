@@ -0,0 +1,125 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// TestSkippedKeyInventory verifies that the inventory reports the number of skipped keys,
+// their epochs and the age of the oldest one, that consumed keys leave it, and that the
+// inventory survives serialization.
+func TestSkippedKeyInventory(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	clock := time.Unix(1_700_000_000, 0)
+	bob.now = func() time.Time { return clock }
+
+	if stats := bob.SkippedKeys(); stats.Count != 0 || stats.OldestAge != 0 {
+		t.Fatalf("Expected an empty inventory, got %+v", stats)
+	}
+
+	var skipped []CipheredMessage
+
+	for range 3 {
+		msg, _ := alice.Send([]byte("lost"), nil)
+		skipped = append(skipped, msg)
+	}
+
+	msg, _ := alice.Send([]byte("arrived"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(time.Hour)
+
+	stats := bob.SkippedKeys()
+
+	if stats.Count != 3 || stats.PerEpoch[0] != 3 || stats.OldestAge != time.Hour {
+		t.Errorf("Unexpected inventory: %+v", stats)
+	}
+
+	if _, err := bob.Receive(skipped[1], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := bob.SkippedKeys(); stats.Count != 2 {
+		t.Errorf("Expected 2 skipped keys after a late delivery, got %d", stats.Count)
+	}
+
+	data, _ := bob.Serialize()
+	restored, err := Deserialize(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored.now = func() time.Time { return clock }
+
+	if stats := restored.SkippedKeys(); stats.Count != 2 || stats.OldestAge != time.Hour {
+		t.Errorf("Inventory not preserved by serialization: %+v", stats)
+	}
+}
+
+// TestWithMaxPreviousChains verifies that a session keeps the skipped keys of at most the
+// configured number of previous receiving chains, deleting the oldest chain's keys first,
+// while keys of the current chain are unaffected.
+func TestWithMaxPreviousChains(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithMaxPreviousChains(1))
+
+	var msgs []CipheredMessage
+
+	send := func() {
+		for i := 0; i < 2; i++ {
+			msg, _ := alice.Send([]byte("message"), nil)
+			msgs = append(msgs, msg)
+		}
+	}
+
+	// Deliver the second message of each of the three chains. Bob's reply gives Alice
+	// the turn for the third.
+	send()
+	stepSendingRatchet(t, alice)
+	send()
+
+	for _, i := range []int{1, 3} {
+		if _, err := bob.Receive(msgs[i], nil); err != nil {
+			t.Fatalf("Receive of message %d failed: %v", i, err)
+		}
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	send()
+
+	if _, err := bob.Receive(msgs[5], nil); err != nil {
+		t.Fatalf("Receive of message 5 failed: %v", err)
+	}
+
+	if stats := bob.SkippedKeys(); stats.Count != 2 {
+		t.Errorf("Expected the skipped keys of the current and one previous chain, got %d", stats.Count)
+	}
+
+	if _, err := bob.Receive(msgs[0], nil); err == nil {
+		t.Error("Expected the oldest chain's skipped key to be deleted")
+	}
+
+	for _, i := range []int{2, 4} {
+		if _, err := bob.Receive(msgs[i], nil); err != nil {
+			t.Errorf("Receive of message %d failed: %v", i, err)
+		}
+	}
+}
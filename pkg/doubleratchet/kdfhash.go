@@ -0,0 +1,97 @@
+package doubleratchet
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+)
+
+// KDFHash names the hash function of a session's key derivation chain: HKDF for the root
+// key and HMAC for the chain keys.
+type KDFHash string
+
+// Supported KDF hash functions.
+const (
+	KDFSHA256 KDFHash = "SHA-256"
+	KDFSHA384 KDFHash = "SHA-384"
+	KDFSHA512 KDFHash = "SHA-512"
+)
+
+var (
+	// ErrUnsupportedKDFHash is returned for a KDF hash function this package does not implement.
+	ErrUnsupportedKDFHash = errors.New("double ratchet: unsupported KDF hash")
+
+	// ErrKDFHashMismatch is returned by Deserialize when the hash given with WithKDFHash
+	// differs from the hash recorded in the serialized state.
+	ErrKDFHashMismatch = newError(ErrState, "double ratchet: KDF hash does not match serialized state")
+)
+
+// kdfHashes maps the supported KDF hash functions to their implementations.
+var kdfHashes = map[KDFHash]func() hash.Hash{
+	KDFSHA256: sha256.New,
+	KDFSHA384: sha512.New384,
+	KDFSHA512: sha512.New,
+}
+
+// WithKDFHash makes the session derive its root, chain and message keys with h instead of
+// SHA-256. Both peers must use the same hash. The choice is recorded in the serialized
+// state, so Deserialize restores it without the option. Keys stay 32 bytes long; longer
+// hash outputs are truncated.
+func WithKDFHash(h KDFHash) Option {
+	return func(d *doubleRatchet) error {
+		if _, ok := kdfHashes[h]; !ok {
+			return ErrUnsupportedKDFHash
+		}
+
+		d.kdfHash = h
+		return nil
+	}
+}
+
+// hash returns the hash function of the session's KDF chain.
+func (d *doubleRatchet) hash() func() hash.Hash {
+	return kdfHashFunc(d.kdfHash)
+}
+
+// kdfHashFunc returns the implementation of h, defaulting to SHA-256.
+func kdfHashFunc(h KDFHash) func() hash.Hash {
+	if fn, ok := kdfHashes[h]; ok {
+		return fn
+	}
+
+	return sha256.New
+}
+
+// recordedKDFHash returns the hash name recorded in serialized state, which is empty for
+// the default SHA-256.
+func (d *doubleRatchet) recordedKDFHash() KDFHash {
+	if d.kdfHash == KDFSHA256 {
+		return ""
+	}
+
+	return d.kdfHash
+}
+
+// restoreKDFHash selects the hash recorded in serialized state, unless the options
+// already selected one, which must then be the same.
+func (d *doubleRatchet) restoreKDFHash(recorded KDFHash) error {
+	if recorded == "" {
+		recorded = KDFSHA256
+	}
+
+	if _, ok := kdfHashes[recorded]; !ok {
+		return ErrUnsupportedKDFHash
+	}
+
+	if d.kdfHash == "" {
+		d.kdfHash = recorded
+		return nil
+	}
+
+	if d.kdfHash != recorded {
+		return ErrKDFHashMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"testing"
+)
+
+// TestWithKDFHash verifies that peers deriving keys with SHA-512 exchange messages across
+// a DH ratchet step and a serialization round trip that restores the hash from state, and
+// that a peer using the default SHA-256 cannot read their messages.
+func TestWithKDFHash(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithKDFHash(KDFSHA512))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithKDFHash(KDFSHA512))
+	plain, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := plain.Receive(msg, nil); err == nil {
+		t.Error("Expected a SHA-256 peer to reject a message derived with SHA-512")
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	stepSendingRatchet(t, alice)
+
+	state, _ := alice.Serialize()
+	restored, err := Deserialize(state)
+
+	if err != nil {
+		t.Fatalf("Expected the state to be restored with its recorded hash, got %v", err)
+	}
+
+	next, _ := restored.Send([]byte("after ratchet"), nil)
+
+	if _, err := bob.Receive(next, nil); err != nil {
+		t.Fatalf("Receive after restore failed: %v", err)
+	}
+
+	if _, err := Deserialize(state, WithKDFHash(KDFSHA384)); !errors.Is(err, ErrKDFHashMismatch) {
+		t.Errorf("Expected ErrKDFHashMismatch, got %v", err)
+	}
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithKDFHash("BLAKE3")); !errors.Is(err, ErrUnsupportedKDFHash) {
+		t.Errorf("Expected ErrUnsupportedKDFHash, got %v", err)
+	}
+}
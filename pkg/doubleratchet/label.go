@@ -0,0 +1,81 @@
+package doubleratchet
+
+import (
+	"errors"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// defaultLabel prefixes the HKDF info strings of sessions created without WithLabel.
+const defaultLabel = "DoubleRatchet"
+
+var (
+	// ErrEmptyLabel is returned by WithLabel for an empty label.
+	ErrEmptyLabel = errors.New("double ratchet: empty domain-separation label")
+
+	// ErrLabelMismatch is returned by Deserialize when the label given with WithLabel
+	// differs from the label recorded in the serialized state.
+	ErrLabelMismatch = newError(ErrState, "double ratchet: label does not match serialized state")
+)
+
+// WithLabel replaces the "DoubleRatchet" prefix of the HKDF info strings of the root and
+// initial chain keys ("DoubleRatchet-Root", "DoubleRatchet-Chain-1" and
+// "DoubleRatchet-Chain-2") with a protocol-specific label such as "myapp-v2", so that the
+// keys of different applications are cryptographically separated even if they were to
+// share a secret. Both peers must use the same label. The label is recorded in the
+// serialized state, so Deserialize restores it without the option.
+func WithLabel(label string) Option {
+	return func(d *doubleRatchet) error {
+		if label == "" {
+			return ErrEmptyLabel
+		}
+
+		d.label = label
+		return nil
+	}
+}
+
+// info returns the HKDF info string with the given suffix under the session's label.
+func (d *doubleRatchet) info(suffix string) []byte {
+	label := d.label
+
+	if label == "" {
+		label = defaultLabel
+	}
+
+	return []byte(label + suffix)
+}
+
+// deriveRK performs the root key KDF with the session's hash and label.
+func (d *doubleRatchet) deriveRK(rk crypto.ChainKey, in []byte) (crypto.ChainKey, crypto.ChainKey, error) {
+	return crypto.DeriveRKInfo(d.hash(), d.info("-Root"), rk, in)
+}
+
+// recordedLabel returns the label recorded in serialized state, which is empty for the
+// default label.
+func (d *doubleRatchet) recordedLabel() string {
+	if d.label == defaultLabel {
+		return ""
+	}
+
+	return d.label
+}
+
+// restoreLabel selects the label recorded in serialized state, unless the options already
+// selected one, which must then be the same.
+func (d *doubleRatchet) restoreLabel(recorded string) error {
+	if recorded == "" {
+		recorded = defaultLabel
+	}
+
+	if d.label == "" {
+		d.label = recorded
+		return nil
+	}
+
+	if d.label != recorded {
+		return ErrLabelMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestWithLabel verifies that peers using the same label exchange messages across a DH
+// ratchet step and a serialization round trip that restores the label from state, and
+// that a peer using another label cannot read their messages.
+func TestWithLabel(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithLabel("myapp-v2"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithLabel("myapp-v2"))
+	other, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithLabel("otherapp-v1"))
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := other.Receive(msg, nil); err == nil {
+		t.Error("Expected a peer with another label to reject the message")
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	stepSendingRatchet(t, alice)
+
+	state, _ := alice.Serialize()
+	restored, err := Deserialize(state)
+
+	if err != nil {
+		t.Fatalf("Expected the state to be restored with its recorded label, got %v", err)
+	}
+
+	next, _ := restored.Send([]byte("after ratchet"), nil)
+
+	if _, err := bob.Receive(next, nil); err != nil {
+		t.Fatalf("Receive after restore failed: %v", err)
+	}
+
+	if _, err := Deserialize(state, WithLabel("otherapp-v1")); !errors.Is(err, ErrLabelMismatch) {
+		t.Errorf("Expected ErrLabelMismatch, got %v", err)
+	}
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithLabel("")); !errors.Is(err, ErrEmptyLabel) {
+		t.Errorf("Expected ErrEmptyLabel, got %v", err)
+	}
+}
+
+// TestDefaultLabelCompatible verifies that WithLabel("DoubleRatchet") derives the same
+// keys as the default, so the option can be set explicitly without breaking peers.
+func TestDefaultLabelCompatible(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithLabel(defaultLabel))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+}
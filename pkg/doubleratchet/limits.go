@@ -0,0 +1,117 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	// ErrMessageTooLarge is returned by Send for a plaintext, and by Receive for a
+	// ciphertext, larger than the limit set with WithMaxMessageSize.
+	ErrMessageTooLarge = newError(ErrProtocol, "double ratchet: message too large")
+
+	// ErrInvalidSizeLimit is returned by WithMaxMessageSize for a negative limit.
+	ErrInvalidSizeLimit = errors.New("double ratchet: invalid message size limit")
+
+	// ErrInvalidSkipLimit is returned by WithMaxSkip for a negative limit.
+	ErrInvalidSkipLimit = errors.New("double ratchet: invalid skip limit")
+)
+
+// WithMaxMessageSize limits the plaintexts Send accepts and the ciphertexts Receive
+// accepts, in bytes, so that a hostile peer cannot make the session decrypt, and allocate
+// a buffer for, an arbitrarily large message. Oversized messages fail with
+// ErrMessageTooLarge before any key is derived, leaving the session unchanged. A limit of
+// zero leaves that direction unlimited, which is the default. The ciphertext limit must
+// allow for the AEAD's overhead; the built-in AES-256-GCM adds 28 bytes. The limits are
+// not part of the serialized state and must be given again when a session is
+// deserialized.
+func WithMaxMessageSize(plaintext, ciphertext int) Option {
+	return func(d *doubleRatchet) error {
+		if plaintext < 0 || ciphertext < 0 {
+			return ErrInvalidSizeLimit
+		}
+
+		d.maxPlaintext = plaintext
+		d.maxCiphertext = ciphertext
+		return nil
+	}
+}
+
+// checkPlaintextSize rejects a plaintext larger than the session's limit.
+func (d *doubleRatchet) checkPlaintextSize(plaintext []byte) error {
+	if d.maxPlaintext > 0 && len(plaintext) > d.maxPlaintext {
+		return ErrMessageTooLarge
+	}
+
+	return nil
+}
+
+// checkCiphertextSize rejects a ciphertext larger than the session's limit.
+func (d *doubleRatchet) checkCiphertextSize(ciphertext []byte) error {
+	if d.maxCiphertext > 0 && len(ciphertext) > d.maxCiphertext {
+		return ErrMessageTooLarge
+	}
+
+	return nil
+}
+
+// WithMaxSkip bounds the message keys the session skips for a peer. perMessage is the most
+// keys a single message may make it skip, counting both the rest of the current receiving
+// chain and the start of a new one, and total the most skipped keys the session may hold
+// at once, across all chains. A header that would exceed either limit fails with
+// ErrTooManySkipped, judged from its counters alone, before any key is derived or DH
+// computed, so forged headers with fresh ratchet keys and huge counters are turned away
+// cheaply. A limit of zero leaves it at its default: MaxSkip keys per chain, and no total.
+// The limits are not part of the serialized state and must be given again when a session
+// is deserialized.
+func WithMaxSkip(perMessage, total int) Option {
+	return func(d *doubleRatchet) error {
+		if perMessage < 0 || total < 0 {
+			return ErrInvalidSkipLimit
+		}
+
+		d.maxSkipMessage = perMessage
+		d.maxSkipTotal = total
+		return nil
+	}
+}
+
+// checkSkipLimits rejects a header that would make the session skip more keys than
+// WithMaxSkip allows.
+func (d *doubleRatchet) checkSkipLimits(h Header) error {
+	if d.maxSkipMessage == 0 && d.maxSkipTotal == 0 {
+		return nil
+	}
+
+	skip := d.skipCount(h)
+
+	if d.maxSkipMessage > 0 && skip > uint64(d.maxSkipMessage) {
+		return ErrTooManySkipped
+	}
+
+	if d.maxSkipTotal > 0 && uint64(len(d.skippedMessageKeys))+skip > uint64(d.maxSkipTotal) {
+		return ErrTooManySkipped
+	}
+
+	return nil
+}
+
+// skipCount returns the number of message keys the session would skip to receive a
+// message with header h, judged from its counters alone.
+func (d *doubleRatchet) skipCount(h Header) uint64 {
+	if bytes.Equal(h.DH, d.dh.remoteBytes()) {
+		if h.N > d.recvN {
+			return uint64(h.N - d.recvN)
+		}
+
+		return 0
+	}
+
+	var skip uint64
+
+	if d.dh.remotePublicKey != nil && h.PN > d.recvN {
+		skip = uint64(h.PN - d.recvN)
+	}
+
+	return skip + uint64(h.N)
+}
@@ -0,0 +1,98 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestWithMaxMessageSize verifies that Send rejects plaintexts and Receive rejects
+// ciphertexts above the configured limits with ErrMessageTooLarge, without advancing the
+// session, and that messages within the limits are exchanged.
+func TestWithMaxMessageSize(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithMaxMessageSize(16, 0))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithMaxMessageSize(0, 16+28))
+
+	if _, err := alice.Send(make([]byte, 17), nil); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge for a 17-byte plaintext, got %v", err)
+	}
+
+	msg, err := alice.Send(make([]byte, 16), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Header.N != 0 {
+		t.Errorf("Expected the rejected plaintext not to use a message key, got N=%d", msg.Header.N)
+	}
+
+	oversized := msg
+	oversized.Ciphertext = make([]byte, 16+29)
+
+	if _, err := bob.Receive(oversized, nil); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge for an oversized ciphertext, got %v", err)
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithMaxMessageSize(-1, 0)); !errors.Is(err, ErrInvalidSizeLimit) {
+		t.Errorf("Expected ErrInvalidSizeLimit, got %v", err)
+	}
+}
+
+// TestWithMaxSkip verifies that Receive rejects headers that would skip more keys than
+// the per-message or total limit allows with ErrTooManySkipped, without advancing the
+// session, and that messages within the limits are received.
+func TestWithMaxSkip(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	otherPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithMaxSkip(4, 6))
+
+	var msgs []CipheredMessage
+
+	for range 12 {
+		msg, err := alice.Send([]byte("hello"), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if _, err := bob.Receive(msgs[5], nil); !errors.Is(err, ErrTooManySkipped) {
+		t.Errorf("Expected ErrTooManySkipped for 5 skipped keys, got %v", err)
+	}
+
+	forged := CipheredMessage{Header: Header{DH: otherPri.PublicKey().Bytes(), N: 5}, Ciphertext: msgs[0].Ciphertext}
+
+	if _, err := bob.Receive(forged, nil); !errors.Is(err, ErrTooManySkipped) {
+		t.Errorf("Expected ErrTooManySkipped for a new chain starting at N=5, got %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[4], nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[8], nil); !errors.Is(err, ErrTooManySkipped) {
+		t.Errorf("Expected ErrTooManySkipped above the total limit, got %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[7], nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+
+	if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithMaxSkip(-1, 0)); !errors.Is(err, ErrInvalidSkipLimit) {
+		t.Errorf("Expected ErrInvalidSkipLimit, got %v", err)
+	}
+}
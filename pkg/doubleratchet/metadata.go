@@ -0,0 +1,102 @@
+package doubleratchet
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// MaxMessageIDSize is the largest application message ID a session sends or accepts.
+const MaxMessageIDSize = 255
+
+var (
+	// ErrInvalidMessageID is returned by SendWithMetadata for a message ID longer than
+	// MaxMessageIDSize, and by Receive for a header carrying one.
+	ErrInvalidMessageID = newError(ErrProtocol, "double ratchet: invalid message ID")
+)
+
+// metadataLabel domain-separates the header timestamp and message ID in the associated data.
+var metadataLabel = []byte("DoubleRatchet-Metadata")
+
+// Metadata is application metadata that travels in a message's header and is
+// authenticated with it, so that receivers can rely on it for ordering and deduplication
+// without defining their own associated data conventions. Unlike the plaintext, it is not
+// encrypted.
+type Metadata struct {
+	// Timestamp is the time the message was sent, with millisecond precision. The zero
+	// time sends no timestamp.
+	Timestamp time.Time
+
+	// ID is an application message ID of at most MaxMessageIDSize bytes, or nil.
+	ID []byte
+}
+
+// SendWithMetadata is like Send, but attaches meta to the message header. The receiver
+// gets it back in UncipheredMessage.Metadata.
+func (d *doubleRatchet) SendWithMetadata(meta Metadata, plaintext, ad []byte) (CipheredMessage, error) {
+	if len(meta.ID) > MaxMessageIDSize {
+		return CipheredMessage{}, ErrInvalidMessageID
+	}
+
+	return d.send(context.Background(), sendParams{typ: MessageNormal, meta: meta}, nil, plaintext, ad)
+}
+
+// sendParams holds the per-message header fields the caller chose.
+type sendParams struct {
+	typ  MessageType
+	meta Metadata
+}
+
+// apply copies the fields into h.
+func (p sendParams) apply(h *Header) {
+	h.Type = p.typ
+
+	if !p.meta.Timestamp.IsZero() {
+		h.Timestamp = p.meta.Timestamp.UnixMilli()
+	}
+
+	if len(p.meta.ID) > 0 {
+		h.MessageID = append([]byte(nil), p.meta.ID...)
+	}
+}
+
+// metadata returns the metadata carried by h.
+func (h Header) metadata() Metadata {
+	var meta Metadata
+
+	if h.Timestamp != 0 {
+		meta.Timestamp = time.UnixMilli(h.Timestamp)
+	}
+
+	if len(h.MessageID) > 0 {
+		meta.ID = append([]byte(nil), h.MessageID...)
+	}
+
+	return meta
+}
+
+// checkHeaderMetadata rejects a header carrying an oversized message ID.
+func checkHeaderMetadata(h Header) error {
+	if len(h.MessageID) > MaxMessageIDSize {
+		return ErrInvalidMessageID
+	}
+
+	return nil
+}
+
+// metadataAD extends the associated data with the header timestamp and message ID, so
+// that altering, stripping or adding either makes the message fail to decrypt. Headers
+// without them leave the associated data unchanged.
+func metadataAD(ad []byte, h Header) []byte {
+	if h.Timestamp == 0 && len(h.MessageID) == 0 {
+		return ad
+	}
+
+	out := make([]byte, 0, len(ad)+len(metadataLabel)+8+len(h.MessageID)+4)
+	out = append(out, ad...)
+	out = append(out, metadataLabel...)
+	out = binary.BigEndian.AppendUint64(out, uint64(h.Timestamp)) // #nosec G115 -- the bits are authenticated as is
+	out = append(out, h.MessageID...)
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
@@ -0,0 +1,75 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSendWithMetadata verifies that the timestamp and message ID arrive with the message,
+// that altering or stripping either makes it fail to decrypt, and that oversized message
+// IDs are refused on both sides.
+func TestSendWithMetadata(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	sent := time.Date(2026, 10, 18, 12, 30, 0, 123456789, time.UTC)
+
+	msg, err := alice.SendWithMetadata(Metadata{Timestamp: sent, ID: []byte("msg-1")}, []byte("hello"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tamper := range map[string]func(*Header){
+		"timestamp":  func(h *Header) { h.Timestamp++ },
+		"no time":    func(h *Header) { h.Timestamp = 0 },
+		"message ID": func(h *Header) { h.MessageID = []byte("msg-2") },
+		"no ID":      func(h *Header) { h.MessageID = nil },
+	} {
+		tampered := msg
+		tamper(&tampered.Header)
+
+		if _, err := bob.Receive(tampered, nil); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("%s: expected ErrAuthFailed, got %v", name, err)
+		}
+	}
+
+	plain, err := bob.Receive(msg, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !plain.Metadata.Timestamp.Equal(sent.Truncate(time.Millisecond)) {
+		t.Errorf("Expected timestamp %v, got %v", sent.Truncate(time.Millisecond), plain.Metadata.Timestamp)
+	}
+
+	if !bytes.Equal(plain.Metadata.ID, []byte("msg-1")) {
+		t.Errorf("Expected message ID msg-1, got %q", plain.Metadata.ID)
+	}
+
+	next, _ := alice.Send([]byte("plain"), nil)
+
+	if next.Header.Timestamp != 0 || next.Header.MessageID != nil {
+		t.Errorf("Expected Send to attach no metadata, got %d and %x", next.Header.Timestamp, next.Header.MessageID)
+	}
+
+	long := make([]byte, MaxMessageIDSize+1)
+
+	if _, err := alice.SendWithMetadata(Metadata{ID: long}, []byte("hello"), nil); !errors.Is(err, ErrInvalidMessageID) {
+		t.Errorf("Expected ErrInvalidMessageID, got %v", err)
+	}
+
+	next.Header.MessageID = long
+
+	if _, err := bob.Receive(next, nil); !errors.Is(err, ErrInvalidMessageID) {
+		t.Errorf("Expected ErrInvalidMessageID, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package doubleratchet
+
+import "encoding/binary"
+
+// HeaderVersion is the newest message format version sessions understand. Messages carry
+// version zero, or version 1 if their plaintext is padded; see WithPlaintextPadding.
+const HeaderVersion = headerVersionPadded
+
+var (
+	// ErrUnsupportedVersion is returned by Receive for a message whose header carries a
+	// version newer than HeaderVersion.
+	ErrUnsupportedVersion = newError(ErrProtocol, "double ratchet: unsupported header version")
+)
+
+// MessageType discriminates messages that the application handles differently, for
+// example an envelope that also carries the key agreement which starts the session. The
+// type travels in the header and is authenticated with the message, so a tampered type
+// makes it fail to decrypt. The session itself treats all types alike.
+type MessageType uint8
+
+const (
+	// MessageNormal is an ordinary message. Send uses it.
+	MessageNormal MessageType = iota
+
+	// MessagePreKey is a message that carries, next to the ciphertext, the key agreement
+	// the receiver needs to create its session, such as an X3DH initial message.
+	MessagePreKey
+
+	// MessageRekeyRequest asks the receiver to call Rekey and send a message, which may be
+	// empty, for example after the sender suspects a compromise of the receiver's current
+	// chain or when its RekeyPolicy is due; the reply also lets the sender take its next
+	// DH ratchet step.
+	MessageRekeyRequest
+)
+
+// typeLabel domain-separates the header version and type in the associated data.
+var typeLabel = []byte("DoubleRatchet-Type")
+
+// checkHeaderVersion rejects a header of a newer message format.
+func checkHeaderVersion(h Header) error {
+	if h.Version > HeaderVersion {
+		return ErrUnsupportedVersion
+	}
+
+	return nil
+}
+
+// typeAD extends the associated data with the header version and type, so that changing
+// either makes the message fail to decrypt. Normal messages of the current version leave
+// the associated data unchanged, as before the fields existed.
+func typeAD(ad []byte, h Header) []byte {
+	if h.Version == 0 && h.Type == MessageNormal {
+		return ad
+	}
+
+	out := make([]byte, 0, len(ad)+len(typeLabel)+2+4)
+	out = append(out, ad...)
+	out = append(out, typeLabel...)
+	out = append(out, h.Version, byte(h.Type))
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
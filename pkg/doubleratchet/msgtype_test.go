@@ -0,0 +1,72 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestMessageType verifies that the message type travels with a message and is
+// authenticated with it, and that a header of a newer version is refused without
+// changing the session.
+func TestMessageType(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	normal, _ := alice.Send([]byte("hello"), nil)
+
+	if normal.Header.Type != MessageNormal || normal.Header.Version != 0 {
+		t.Errorf("Expected a normal message of version 0, got type %d version %d", normal.Header.Type, normal.Header.Version)
+	}
+
+	plain, err := bob.Receive(normal, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plain.Type != MessageNormal {
+		t.Errorf("Expected type %d, got %d", MessageNormal, plain.Type)
+	}
+
+	typed, err := alice.SendType(MessageRekeyRequest, []byte("rekey please"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := typed
+	tampered.Header.Type = MessageNormal
+
+	if _, err := bob.Receive(tampered, nil); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed for a tampered type, got %v", err)
+	}
+
+	future := typed
+	future.Header.Version = HeaderVersion + 1
+
+	before, _ := bob.Serialize()
+
+	if _, err := bob.Receive(future, nil); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+		t.Error("Expected an unsupported version to leave the state untouched")
+	}
+
+	plain, err = bob.Receive(typed, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plain.Type != MessageRekeyRequest || string(plain.Plaintext) != "rekey please" {
+		t.Errorf("Expected a rekey request, got type %d with %q", plain.Type, plain.Plaintext)
+	}
+}
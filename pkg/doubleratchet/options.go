@@ -0,0 +1,25 @@
+package doubleratchet
+
+import "io"
+
+// Option configures optional behavior of a Double Ratchet session.
+type Option func(*doubleRatchet) error
+
+// WithTranscript enables a running hash over every sent and received message header and
+// ciphertext. See DoubleRatchet.Transcript.
+func WithTranscript() Option {
+	return func(d *doubleRatchet) error {
+		d.transcript = &transcript{}
+		return nil
+	}
+}
+
+// WithRand makes the session read ratchet keys and nonces from random instead of
+// crypto/rand. It exists so that tests can replay sessions from a seed; see package
+// ratchettest. Never use a predictable reader outside of tests.
+func WithRand(random io.Reader) Option {
+	return func(d *doubleRatchet) error {
+		d.rand = random
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package doubleratchet
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// MaxHeaderPadding is the largest amount of header padding a session adds or accepts.
+const MaxHeaderPadding = 255
+
+var (
+	// ErrInvalidPadding is returned by WithHeaderPadding for a bound outside 0 to
+	// MaxHeaderPadding, and when a received header carries more than MaxHeaderPadding bytes
+	// of padding.
+	ErrInvalidPadding = newError(ErrProtocol, "double ratchet: invalid header padding")
+)
+
+// paddingLabel domain-separates header padding in the associated data.
+var paddingLabel = []byte("DoubleRatchet-Padding")
+
+// WithHeaderPadding makes the session attach between 0 and max random bytes to the header
+// of every message it sends, as Header.Padding, so that message sizes and header layouts
+// do not form a stable fingerprint on the wire. The padding is authenticated as part of
+// the message's associated data, so it cannot be stripped or altered.
+//
+// Receivers accept padded messages without any option, but the wire encoding must carry
+// the padding: codec's compact encoding and JSON do; encodings with a fixed header layout,
+// such as the one of package record, do not.
+func WithHeaderPadding(max int) Option {
+	return func(d *doubleRatchet) error {
+		if max < 0 || max > MaxHeaderPadding {
+			return ErrInvalidPadding
+		}
+
+		d.paddingMax = max
+
+		return nil
+	}
+}
+
+// padding returns random padding of a random length up to the configured bound.
+func (d *doubleRatchet) padding() ([]byte, error) {
+	if d.paddingMax == 0 {
+		return nil, nil
+	}
+
+	var n [1]byte
+
+	if _, err := io.ReadFull(d.random(), n[:]); err != nil {
+		return nil, err
+	}
+
+	padding := make([]byte, int(n[0])%(d.paddingMax+1))
+
+	if _, err := io.ReadFull(d.random(), padding); err != nil {
+		return nil, err
+	}
+
+	if len(padding) == 0 {
+		return nil, nil
+	}
+
+	return padding, nil
+}
+
+// checkHeaderPadding rejects a header carrying more padding than any sender adds.
+func checkHeaderPadding(h Header) error {
+	if len(h.Padding) > MaxHeaderPadding {
+		return ErrInvalidPadding
+	}
+
+	return nil
+}
+
+// paddingAD extends the caller's associated data with the header padding, so that
+// stripping, replacing or adding padding makes the message fail to decrypt.
+func paddingAD(ad, padding []byte) []byte {
+	if len(padding) == 0 {
+		return ad
+	}
+
+	out := make([]byte, 0, len(ad)+len(paddingLabel)+len(padding)+4)
+	out = append(out, ad...)
+	out = append(out, paddingLabel...)
+	out = append(out, padding...)
+
+	return binary.BigEndian.AppendUint32(out, uint32(len(ad))) // #nosec G115 -- lengths are bounded by memory
+}
@@ -0,0 +1,86 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestHeaderPadding verifies that padded messages vary in size and are received without
+// any option, that stripped or altered padding makes a message fail to decrypt, and that
+// escrowed padded messages can still be opened.
+func TestHeaderPadding(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil,
+		WithHeaderPadding(32), WithEscrow(escrowPri.PublicKey(), EscrowAcknowledgement))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	sizes := map[int]bool{}
+
+	for range 20 {
+		msg, _ := alice.Send([]byte("hello"), nil)
+		sizes[len(msg.Header.Padding)] = true
+
+		if len(msg.Header.Padding) > 32 {
+			t.Fatalf("Expected at most 32 bytes of padding, got %d", len(msg.Header.Padding))
+		}
+
+		if len(msg.Header.Padding) > 0 {
+			// Receive advances the state even when decryption fails, so try the tampered
+			// message on a copy of Bob.
+			state, _ := bob.Serialize()
+			copied, _ := Deserialize(state)
+
+			tampered := msg
+			tampered.Header.Padding = nil
+
+			if _, err := copied.Receive(tampered, nil); err == nil {
+				t.Fatal("Expected a message with stripped padding to be rejected")
+			}
+		}
+
+		if _, err := OpenEscrow(escrowPri, msg, nil); err != nil {
+			t.Fatalf("OpenEscrow failed on a padded message: %v", err)
+		}
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	if len(sizes) < 2 {
+		t.Errorf("Expected padding of varying length, got lengths %v", sizes)
+	}
+}
+
+// TestHeaderPaddingBounds verifies that the padding bound is validated and that headers
+// with oversized padding are rejected.
+func TestHeaderPaddingBounds(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	for _, max := range []int{-1, MaxHeaderPadding + 1} {
+		if _, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithHeaderPadding(max)); !errors.Is(err, ErrInvalidPadding) {
+			t.Errorf("Expected ErrInvalidPadding for bound %d, got %v", max, err)
+		}
+	}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+	msg.Header.Padding = make([]byte, MaxHeaderPadding+1)
+
+	if _, err := bob.Receive(msg, nil); !errors.Is(err, ErrInvalidPadding) {
+		t.Errorf("Expected ErrInvalidPadding, got %v", err)
+	}
+}
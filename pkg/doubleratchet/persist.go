@@ -0,0 +1,78 @@
+package doubleratchet
+
+import (
+	"context"
+	"errors"
+)
+
+// ReceiveAndPersist is like Receive, but saves the session state that follows msg to
+// store before it returns the plaintext. If the call fails, whether the message does not
+// decrypt, an AfterReceive hook refuses it or the save fails, the session is put back
+// into the state it had before the call, so that the message can be received again. A
+// crash between decrypting a message and persisting the state can therefore never make
+// the session reuse a message key it already consumed.
+//
+// The state is saved under the session ID, as by WithStore. A session created with
+// WithStore also saves to its own store, and both stores are written back if the session
+// is rolled back.
+func (d *doubleRatchet) ReceiveAndPersist(msg CipheredMessage, ad []byte, store Store) (UncipheredMessage, error) {
+	d.lock()
+	defer d.unlock()
+
+	if d.closed || d.archived {
+		return d.receiveLocked(context.Background(), nil, msg, ad)
+	}
+
+	ctx := context.Background()
+	own := d.store
+	saved := false
+
+	d.store = StoreFunc(func(ctx context.Context, sessionID, state []byte) error {
+		saved = true
+		return stores{own, store}.Save(ctx, sessionID, state)
+	})
+
+	// The transaction spans the whole receive, so that a message refused or not saved
+	// after it decrypted is rolled back too.
+	d.begin()
+
+	m, err := d.receiveLocked(ctx, nil, msg, ad)
+
+	d.store = own
+
+	if err == nil {
+		d.commit()
+		return m, nil
+	}
+
+	changed := d.txn.changed()
+
+	d.rollback()
+
+	rerr := d.flushSkipped(ctx, changed)
+
+	if rerr == nil && saved {
+		rerr = d.save(ctx)
+	}
+
+	return UncipheredMessage{}, errors.Join(err, rerr)
+}
+
+// stores saves the state to each of its non-nil stores in turn, stopping at the first
+// failure.
+type stores []Store
+
+// Save calls Save on each store.
+func (s stores) Save(ctx context.Context, sessionID, state []byte) error {
+	for _, store := range s {
+		if store == nil {
+			continue
+		}
+
+		if err := store.Save(ctx, sessionID, state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package doubleratchet
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestReceiveAndPersist verifies that the state is saved before the plaintext is returned,
+// and that a failed save leaves the session, its own store and its skipped key store as
+// they were, so that the message can be received again.
+func TestReceiveAndPersist(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	var saved, own []byte
+
+	store := StoreFunc(func(_ context.Context, _, state []byte) error {
+		saved = state
+		return nil
+	})
+
+	skipped := NewMemorySkippedKeyStore()
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithSkippedKeyStore(skipped), WithStore(StoreFunc(func(_ context.Context, _, state []byte) error {
+		own = state
+		return nil
+	})))
+
+	first, _ := alice.Send([]byte("first"), nil)
+	second, _ := alice.Send([]byte("second"), nil)
+	third, _ := alice.Send([]byte("third"), nil)
+
+	if got, err := bob.ReceiveAndPersist(second, nil, store); err != nil || string(got.Plaintext) != "second" {
+		t.Fatalf("Expected %q, got %q, %v", "second", got.Plaintext, err)
+	}
+
+	restored, err := Deserialize(saved, WithSkippedKeyStore(skipped))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := restored.Receive(third, nil); err != nil || string(got.Plaintext) != "third" {
+		t.Errorf("Expected the persisted state to follow the message, got %q, %v", got.Plaintext, err)
+	}
+
+	before := own
+	errDisk := errors.New("disk full")
+
+	failing := StoreFunc(func(context.Context, []byte, []byte) error {
+		return errDisk
+	})
+
+	if _, err := bob.ReceiveAndPersist(first, nil, failing); !errors.Is(err, ErrStoreFailed) || !errors.Is(err, errDisk) {
+		t.Fatalf("Expected ErrStoreFailed wrapping the store's error, got %v", err)
+	}
+
+	if string(own) != string(before) {
+		t.Errorf("Expected the session's own store to be written back")
+	}
+
+	if keys, _ := skipped.List(context.Background(), bob.SessionID()); len(keys) != 1 {
+		t.Errorf("Expected the skipped key to be written back, got %d keys", len(keys))
+	}
+
+	if got, err := bob.ReceiveAndPersist(first, nil, store); err != nil || string(got.Plaintext) != "first" {
+		t.Errorf("Expected the message to be received again, got %q, %v", got.Plaintext, err)
+	}
+
+	if _, err := bob.ReceiveAndPersist(first, nil, store); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("Expected ErrDuplicateMessage, got %v", err)
+	}
+}
+
+// TestReceiveAndPersistRatchetStep verifies that a failed save of a message that carries a
+// new ratchet key of the peer rolls back the DH ratchet step, so that the message can be
+// received again and the session answers it as if the failure had not happened.
+func TestReceiveAndPersistRatchetStep(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	hello, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := bob.Receive(hello, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alice.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	stepped, _ := alice.Send([]byte("stepped"), nil)
+
+	failing := StoreFunc(func(context.Context, []byte, []byte) error {
+		return errors.New("disk full")
+	})
+
+	if _, err := bob.ReceiveAndPersist(stepped, nil, failing); !errors.Is(err, ErrStoreFailed) {
+		t.Fatalf("Expected ErrStoreFailed, got %v", err)
+	}
+
+	if got, err := bob.Receive(stepped, nil); err != nil || string(got.Plaintext) != "stepped" {
+		t.Fatalf("Expected the message to be received again, got %q, %v", got.Plaintext, err)
+	}
+
+	answer, _ := bob.Send([]byte("answer"), nil)
+
+	if got, err := alice.Receive(answer, nil); err != nil || string(got.Plaintext) != "answer" {
+		t.Errorf("Expected Alice to read Bob's answer, got %q, %v", got.Plaintext, err)
+	}
+}
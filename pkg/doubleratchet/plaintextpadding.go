@@ -0,0 +1,125 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// headerVersionPadded is the header version of messages whose plaintext is padded.
+const headerVersionPadded = 1
+
+// paddingMarker ends the plaintext of a padded message, before the zero bytes of padding.
+const paddingMarker = 0x80
+
+var (
+	// ErrMalformedPlaintextPadding is returned by Receive for a message of a padded
+	// version whose decrypted payload does not end in valid padding.
+	ErrMalformedPlaintextPadding = newError(ErrProtocol, "double ratchet: malformed plaintext padding")
+)
+
+// PaddingScheme chooses the size plaintexts are padded to before encryption; see
+// WithPlaintextPadding.
+type PaddingScheme interface {
+	// PaddedSize returns the size to pad n bytes to. Sizes below n are taken as n.
+	PaddedSize(n int) int
+}
+
+// Padme is the Padmé scheme of Nikitin et al., "Reducing Metadata Leakage from Encrypted
+// Files and Communication with PURBs". It pads a size to one with at most as many
+// significant bits as its exponent has, which leaks O(log log n) bits about the size at a
+// cost of at most 12% overhead, and less for larger messages.
+type Padme struct{}
+
+// PaddedSize implements PaddingScheme.
+func (Padme) PaddedSize(n int) int {
+	if n < 2 {
+		return n
+	}
+
+	e := bits.Len(uint(n)) - 1 // #nosec G115 -- n is positive
+	mask := 1<<max(e-bits.Len(uint(e)), 0) - 1
+
+	return (n + mask) &^ mask
+}
+
+// Buckets pads a size to the smallest bucket that holds it, and sizes above the largest
+// bucket to a multiple of it, so that all messages within a bucket have the same size.
+// Buckets that are not positive are ignored.
+type Buckets []int
+
+// PaddedSize implements PaddingScheme.
+func (b Buckets) PaddedSize(n int) int {
+	best, largest := 0, 0
+
+	for _, size := range b {
+		if size >= n && (best == 0 || size < best) {
+			best = size
+		}
+
+		largest = max(largest, size)
+	}
+
+	switch {
+	case best > 0:
+		return best
+	case largest > 0:
+		return (n + largest - 1) / largest * largest
+	default:
+		return n
+	}
+}
+
+// WithPlaintextPadding makes the session pad every plaintext it sends to the size scheme
+// chooses, such as Padme{} or Buckets{256, 1024, 4096}, so that ciphertext sizes leak
+// much less about plaintext sizes. The plaintext is followed by a 0x80 byte and zero
+// bytes up to the padded size, and the message carries header version 1, which is
+// authenticated with it, so receivers know to strip the padding. Receivers need no option,
+// but must understand version 1, and the wire encoding must carry Header.Version.
+//
+// Limits of WithMaxMessageSize apply to the plaintext before padding on the sender, and
+// to the padded ciphertext on the receiver. The scheme is not part of the serialized
+// state and must be given again when a session is deserialized.
+func WithPlaintextPadding(scheme PaddingScheme) Option {
+	return func(d *doubleRatchet) error {
+		d.plaintextPadding = scheme
+		return nil
+	}
+}
+
+// padPlaintext returns plaintext padded according to the session's scheme.
+func (d *doubleRatchet) padPlaintext(plaintext []byte) []byte {
+	size := max(d.plaintextPadding.PaddedSize(len(plaintext)+1), len(plaintext)+1)
+
+	padded := make([]byte, size)
+	copy(padded, plaintext)
+	padded[len(plaintext)] = paddingMarker
+
+	return padded
+}
+
+// unpadPlaintext strips the padding from the decrypted payload of a message with header
+// h, if its version is a padded one.
+func unpadPlaintext(h Header, payload []byte) ([]byte, error) {
+	if h.Version < headerVersionPadded {
+		return payload, nil
+	}
+
+	i := bytes.LastIndexByte(payload, paddingMarker)
+
+	if i < 0 || !allZero(payload[i+1:]) {
+		return nil, ErrMalformedPlaintextPadding
+	}
+
+	return payload[:i], nil
+}
+
+// allZero reports whether b holds only zero bytes.
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,81 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestPaddingSchemes verifies the sizes chosen by Padme and Buckets.
+func TestPaddingSchemes(t *testing.T) {
+	for _, tc := range []struct {
+		scheme PaddingScheme
+		n      int
+		want   int
+	}{
+		{Padme{}, 1, 1},
+		{Padme{}, 9, 10},
+		{Padme{}, 100, 104},
+		{Padme{}, 1000, 1024},
+		{Buckets{256, 64, 1024}, 10, 64},
+		{Buckets{256, 64, 1024}, 65, 256},
+		{Buckets{256, 64, 1024}, 1025, 2048},
+		{Buckets{0, -1}, 10, 10},
+	} {
+		if got := tc.scheme.PaddedSize(tc.n); got != tc.want {
+			t.Errorf("Expected %T to pad %d to %d, got %d", tc.scheme, tc.n, tc.want, got)
+		}
+	}
+}
+
+// TestWithPlaintextPadding verifies that padded messages carry the padded header version,
+// hide the plaintext size within a bucket, and decrypt to the original plaintext for a
+// receiver without the option, and that the version cannot be stripped.
+func TestWithPlaintextPadding(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithPlaintextPadding(Buckets{64}))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	short, _ := alice.Send([]byte("hi"), nil)
+	long, _ := alice.Send([]byte("a somewhat longer message"), nil)
+
+	if short.Header.Version != headerVersionPadded {
+		t.Errorf("Expected version %d, got %d", headerVersionPadded, short.Header.Version)
+	}
+
+	if len(short.Ciphertext) != len(long.Ciphertext) {
+		t.Errorf("Expected equal ciphertext sizes, got %d and %d", len(short.Ciphertext), len(long.Ciphertext))
+	}
+
+	stripped := short
+	stripped.Header.Version = 0
+
+	if _, err := bob.Receive(stripped, nil); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed for a stripped version, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		msg  CipheredMessage
+		want string
+	}{
+		{short, "hi"},
+		{long, "a somewhat longer message"},
+	} {
+		plain, err := bob.Receive(tc.msg, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plain.Plaintext) != tc.want {
+			t.Errorf("Expected %q, got %q", tc.want, plain.Plaintext)
+		}
+	}
+
+	if _, err := unpadPlaintext(short.Header, []byte{'h', 'i', 0, 0}); !errors.Is(err, ErrMalformedPlaintextPadding) {
+		t.Errorf("Expected ErrMalformedPlaintextPadding, got %v", err)
+	}
+}
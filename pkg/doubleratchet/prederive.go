@@ -0,0 +1,102 @@
+package doubleratchet
+
+import (
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// prederivedKey is a sending chain step computed ahead of time.
+type prederivedKey struct {
+	from crypto.ChainKey // chain key the step starts from
+	next crypto.ChainKey
+	mk   crypto.MessageKey
+}
+
+// WithPrederivation makes the session keep up to k sending message keys derived ahead of
+// time, so that Send only has to encrypt. After each Send the session refills the keys in
+// the background; callers can also refill them at idle with Prederive.
+//
+// Pre-derived keys are held in memory until they are used or the DH ratchet replaces the
+// sending chain, so a memory compromise exposes up to k future messages of the current
+// chain. They are not serialized.
+func WithPrederivation(k int) Option {
+	return func(d *doubleRatchet) error {
+		d.prederiveMax = max(k, 0)
+		return nil
+	}
+}
+
+// Prederive derives sending message keys until the session holds as many as configured
+// with WithPrederivation. It does nothing for sessions without pre-derivation.
+func (d *doubleRatchet) Prederive() {
+	d.lock()
+	defer d.unlock()
+
+	d.prederive()
+}
+
+// prederive fills the pre-derived keys. The caller must hold the lock.
+func (d *doubleRatchet) prederive() {
+	if d.closed || d.archived {
+		return
+	}
+
+	if len(d.prederived) > 0 && d.prederived[0].from != d.sendChainKey {
+		// The DH ratchet replaced the sending chain.
+		d.clearPrederived()
+	}
+
+	ck := d.sendChainKey
+
+	if n := len(d.prederived); n > 0 {
+		ck = d.prederived[n-1].next
+	}
+
+	for len(d.prederived) < d.prederiveMax {
+		next, mk := crypto.DeriveCKWith(d.hash(), ck)
+
+		d.prederived = append(d.prederived, prederivedKey{from: ck, next: next, mk: mk})
+
+		ck = next
+	}
+}
+
+// nextSendingKey advances the sending chain, using a pre-derived step if one matches the
+// current chain key. The caller must hold the lock.
+func (d *doubleRatchet) nextSendingKey() (crypto.ChainKey, crypto.MessageKey) {
+	if len(d.prederived) > 0 && d.prederived[0].from == d.sendChainKey {
+		step := d.prederived[0]
+		d.prederived[0] = prederivedKey{}
+		d.prederived = d.prederived[1:]
+
+		return step.next, step.mk
+	}
+
+	d.clearPrederived()
+
+	return crypto.DeriveCKWith(d.hash(), d.sendChainKey)
+}
+
+// clearPrederived overwrites the pre-derived keys with zeros and drops them. The caller
+// must hold the lock.
+func (d *doubleRatchet) clearPrederived() {
+	clear(d.prederived)
+	d.prederived = nil
+}
+
+// scheduleRefill refills the pre-derived keys in the background once the caller releases
+// the lock. At most one refill runs at a time. The caller must hold the lock.
+func (d *doubleRatchet) scheduleRefill() {
+	if d.prederiveMax == 0 || d.unlocked || d.refilling || len(d.prederived) >= d.prederiveMax {
+		return
+	}
+
+	d.refilling = true
+
+	go func() {
+		d.lock()
+		defer d.unlock()
+
+		d.refilling = false
+		d.prederive()
+	}()
+}
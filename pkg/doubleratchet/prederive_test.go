@@ -0,0 +1,118 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// TestPrederivation verifies that pre-derived sending keys produce messages a peer without
+// pre-derivation can read, that they are refilled after sends, that they are discarded when
+// the DH ratchet replaces the sending chain, and that they are not part of the state.
+func TestPrederivation(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithPrederivation(4))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	alice.Lock()
+	held := len(alice.prederived)
+	alice.Unlock()
+
+	if held != 4 {
+		t.Fatalf("Expected 4 pre-derived keys after New, got %d", held)
+	}
+
+	for i := range 10 {
+		msg, _ := alice.Send([]byte("hello"), nil)
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("Receive of message %d failed: %v", i, err)
+		}
+	}
+
+	alice.Prederive()
+
+	alice.Lock()
+	held = len(alice.prederived)
+	alice.Unlock()
+
+	if held != 4 {
+		t.Errorf("Expected Prederive to refill 4 keys, got %d", held)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := alice.Send([]byte("after ratchet"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatalf("Expected stale pre-derived keys to be discarded, got %v", err)
+	}
+
+	data, _ := alice.Serialize()
+	restored, err := Deserialize(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ = restored.Send([]byte("restored"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Expected the state to reflect only the keys that were used, got %v", err)
+	}
+}
+
+// TestPrederivationConcurrent verifies that background refills do not race with sends.
+func TestPrederivationConcurrent(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithPrederivation(2))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	done := make(chan CipheredMessage)
+
+	for range 20 {
+		go func() {
+			msg, _ := alice.Send([]byte("hello"), nil)
+			done <- msg
+		}()
+	}
+
+	for range 20 {
+		if _, err := bob.Receive(<-done, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+}
+
+// TestPrederivedKeyErased verifies that a pre-derived key is overwritten with zeros once
+// Send has used it, so it does not linger in the slice's backing array.
+func TestPrederivedKeyErased(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithPrederivation(2))
+
+	alice.Lock()
+	held := alice.prederived
+	alice.Unlock()
+
+	if _, err := alice.Send([]byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	alice.Lock()
+	used := held[0]
+	alice.Unlock()
+
+	if used != (prederivedKey{}) {
+		t.Errorf("Expected the used pre-derived key to be erased")
+	}
+}
@@ -0,0 +1,385 @@
+package doubleratchet
+
+import "encoding/binary"
+
+// Protocol buffer wire types used by state.proto.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var (
+	// ErrMalformedProto is returned by ProtoSerializer for data that is not a valid
+	// encoding of the State message of state.proto.
+	ErrMalformedProto = newError(ErrState, "double ratchet: malformed protobuf state")
+)
+
+// ProtoSerializer encodes state as the State message of state.proto, prefixed with
+// FormatProto, so that services in other languages can read persisted sessions with code
+// generated from the schema after stripping the tag. The encoding is written by hand
+// to keep the module free of dependencies; it follows the proto3 wire format, omits
+// fields holding their zero value and skips unknown fields when reading.
+type ProtoSerializer struct{}
+
+// Format returns FormatProto.
+func (ProtoSerializer) Format() byte {
+	return FormatProto
+}
+
+// Marshal encodes state as a State message.
+func (ProtoSerializer) Marshal(state State) ([]byte, error) {
+	var w protoWriter
+
+	w.message(1, state.RootKey[:])
+	w.message(2, state.SendChainKey[:])
+	w.message(3, state.RecvChainKey[:])
+	w.varint(4, uint64(state.SendN))
+	w.varint(5, uint64(state.RecvN))
+	w.varint(6, uint64(state.PrevN))
+
+	if state.RecvPN != nil {
+		w.tag(7, protoVarint)
+		w.buf = binary.AppendUvarint(w.buf, uint64(*state.RecvPN))
+	}
+
+	w.bool(8, state.StepPending)
+	w.varint(9, uint64(state.Epoch))
+
+	for _, sk := range state.SkippedKeys {
+		var m, h protoWriter
+
+		h.bytes(1, sk.Header.DH)
+		h.varint(2, uint64(sk.Header.N))
+		h.varint(3, uint64(sk.Header.PN))
+
+		m.message(1, h.buf)
+		m.message(2, sk.Key[:])
+		m.varint(3, uint64(sk.Epoch))
+		m.varint(4, uint64(sk.StoredAt)) // #nosec G115 -- int64 fields are encoded as two's complement
+
+		w.message(10, m.buf)
+	}
+
+	w.bytes(11, state.LocalPri)
+	w.bytes(12, state.LocalPub)
+	w.bytes(13, state.RemotePub)
+	w.bytes(14, state.TranscriptSent)
+	w.bytes(15, state.TranscriptReceived)
+	w.bytes(16, state.EscrowKey)
+
+	if state.Usage != (Usage{}) {
+		var u protoWriter
+
+		u.varint(1, state.Usage.MessagesSent)
+		u.varint(2, state.Usage.MessagesReceived)
+		u.varint(3, state.Usage.BytesSent)
+		u.varint(4, state.Usage.BytesReceived)
+
+		w.message(17, u.buf)
+	}
+
+	w.bytes(18, state.UsageMAC)
+	w.bool(19, state.FIPS)
+	w.bool(20, state.Archived)
+	w.bytes(21, []byte(state.Curve))
+	w.bytes(22, []byte(state.KDFHash))
+	w.bytes(23, []byte(state.Label))
+	w.varint(24, uint64(state.ChainStartedAt)) // #nosec G115 -- int64 fields are encoded as two's complement
+	w.varint(25, state.ChainBytes)
+
+	if state.Hybrid != nil {
+		var h protoWriter
+
+		h.bytes(1, state.Hybrid.Key)
+		h.bytes(2, state.Hybrid.PrevKey)
+		h.bytes(3, state.Hybrid.RemoteKey)
+		h.bytes(4, state.Hybrid.Ciphertext)
+		h.bytes(5, state.Hybrid.Target)
+
+		w.message(26, h.buf)
+	}
+
+	w.bytes(27, state.SessionID)
+	w.bytes(28, state.ReplayWindow)
+
+	return append([]byte{FormatProto}, w.buf...), nil
+}
+
+// Unmarshal decodes a State message prefixed with FormatProto.
+func (ProtoSerializer) Unmarshal(data []byte, state *State) error {
+	if len(data) == 0 || data[0] != FormatProto {
+		return ErrUnknownFormat
+	}
+
+	var s State
+
+	err := readProto(data[1:], func(field int, v protoValue) error {
+		switch field {
+		case 1:
+			return v.key(&s.RootKey)
+		case 2:
+			return v.key(&s.SendChainKey)
+		case 3:
+			return v.key(&s.RecvChainKey)
+		case 4:
+			s.SendN = v.uint32()
+		case 5:
+			s.RecvN = v.uint32()
+		case 6:
+			s.PrevN = v.uint32()
+		case 7:
+			recvPN := v.uint32()
+			s.RecvPN = &recvPN
+		case 8:
+			s.StepPending = v.n != 0
+		case 9:
+			s.Epoch = v.uint32()
+		case 10:
+			sk, err := readSkippedKey(v.b)
+
+			if err != nil {
+				return err
+			}
+
+			s.SkippedKeys = append(s.SkippedKeys, sk)
+		case 11:
+			s.LocalPri = v.copy()
+		case 12:
+			s.LocalPub = v.copy()
+		case 13:
+			s.RemotePub = v.copy()
+		case 14:
+			s.TranscriptSent = v.copy()
+		case 15:
+			s.TranscriptReceived = v.copy()
+		case 16:
+			s.EscrowKey = v.copy()
+		case 17:
+			return readProto(v.b, func(field int, v protoValue) error {
+				switch field {
+				case 1:
+					s.Usage.MessagesSent = v.n
+				case 2:
+					s.Usage.MessagesReceived = v.n
+				case 3:
+					s.Usage.BytesSent = v.n
+				case 4:
+					s.Usage.BytesReceived = v.n
+				}
+
+				return nil
+			})
+		case 18:
+			s.UsageMAC = v.copy()
+		case 19:
+			s.FIPS = v.n != 0
+		case 20:
+			s.Archived = v.n != 0
+		case 21:
+			s.Curve = string(v.b)
+		case 22:
+			s.KDFHash = KDFHash(v.b)
+		case 23:
+			s.Label = string(v.b)
+		case 24:
+			s.ChainStartedAt = int64(v.n) // #nosec G115 -- inverse of Marshal
+		case 25:
+			s.ChainBytes = v.n
+		case 26:
+			h := &HybridState{}
+			s.Hybrid = h
+
+			return readProto(v.b, func(field int, v protoValue) error {
+				switch field {
+				case 1:
+					h.Key = v.copy()
+				case 2:
+					h.PrevKey = v.copy()
+				case 3:
+					h.RemoteKey = v.copy()
+				case 4:
+					h.Ciphertext = v.copy()
+				case 5:
+					h.Target = v.copy()
+				}
+
+				return nil
+			})
+		case 27:
+			s.SessionID = v.copy()
+		case 28:
+			s.ReplayWindow = v.copy()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	*state = s
+
+	return nil
+}
+
+// readSkippedKey decodes a SkippedMessageKey message.
+func readSkippedKey(data []byte) (SkippedMessageKey, error) {
+	var sk SkippedMessageKey
+
+	err := readProto(data, func(field int, v protoValue) error {
+		switch field {
+		case 1:
+			return readProto(v.b, func(field int, v protoValue) error {
+				switch field {
+				case 1:
+					sk.Header.DH = v.copy()
+				case 2:
+					sk.Header.N = v.uint32()
+				case 3:
+					sk.Header.PN = v.uint32()
+				}
+
+				return nil
+			})
+		case 2:
+			return v.key(&sk.Key)
+		case 3:
+			sk.Epoch = v.uint32()
+		case 4:
+			sk.StoredAt = int64(v.n) // #nosec G115 -- inverse of Marshal
+		}
+
+		return nil
+	})
+
+	return sk, err
+}
+
+// protoWriter appends fields in the protocol buffer wire format, omitting zero values.
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) tag(field, wireType int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field<<3|wireType)) // #nosec G115 -- field numbers are small constants
+}
+
+func (w *protoWriter) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	w.tag(field, protoVarint)
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *protoWriter) bool(field int, v bool) {
+	if v {
+		w.varint(field, 1)
+	}
+}
+
+// bytes writes a bytes or string field, omitting it if it is empty.
+func (w *protoWriter) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	w.message(field, b)
+}
+
+// message writes a length-delimited field, even if it is empty.
+func (w *protoWriter) message(field int, b []byte) {
+	w.tag(field, protoBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// protoValue is the value of a field: n for varint and fixed fields, b for
+// length-delimited ones.
+type protoValue struct {
+	n uint64
+	b []byte
+}
+
+func (v protoValue) uint32() uint32 {
+	return uint32(v.n) // #nosec G115 -- proto3 truncates uint32 fields the same way
+}
+
+// copy returns the length-delimited value, or nil if it is empty.
+func (v protoValue) copy() []byte {
+	if len(v.b) == 0 {
+		return nil
+	}
+
+	return append([]byte(nil), v.b...)
+}
+
+// key copies a 32-byte key field. Keys are always written, even if they are all zero.
+func (v protoValue) key(dst *[32]byte) error {
+	if len(v.b) != len(dst) {
+		return ErrMalformedProto
+	}
+
+	copy(dst[:], v.b)
+
+	return nil
+}
+
+// readProto calls fn for every field of a message. Unknown fields are passed to fn too,
+// which ignores them.
+func readProto(data []byte, fn func(field int, v protoValue) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+
+		if n <= 0 || tag>>3 == 0 || tag>>3 > 1<<29-1 {
+			return ErrMalformedProto
+		}
+
+		data = data[n:]
+
+		var v protoValue
+
+		switch tag & 7 {
+		case protoVarint:
+			v.n, n = binary.Uvarint(data)
+
+			if n <= 0 {
+				return ErrMalformedProto
+			}
+
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return ErrMalformedProto
+			}
+
+			v.n, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+
+			if n <= 0 || size > uint64(len(data)-n) {
+				return ErrMalformedProto
+			}
+
+			v.b, data = data[n:n+int(size)], data[n+int(size):] // #nosec G115 -- bounded by len(data)
+		case protoFixed32:
+			if len(data) < 4 {
+				return ErrMalformedProto
+			}
+
+			v.n, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return ErrMalformedProto
+		}
+
+		if err := fn(int(tag>>3), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
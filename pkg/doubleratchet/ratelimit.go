@@ -0,0 +1,109 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ratchetStepCost is the cost of a DH ratchet step in key derivations. A step computes a
+// DH and derives two root keys, so it costs well above a chain key derivation.
+const ratchetStepCost = 2
+
+var (
+	// ErrRateLimited is returned by Receive when the session's Limiter refuses the work a
+	// message would cost. The session is unchanged and the message may be retried later.
+	ErrRateLimited = newError(ErrProtocol, "double ratchet: receive rate limit exceeded")
+
+	// ErrInvalidRateLimit is returned by NewRateLimiter for a non-positive limit or window.
+	ErrInvalidRateLimit = errors.New("double ratchet: invalid rate limit")
+)
+
+// Limiter accounts for the work received messages make a session do. Before deriving any
+// key for a message, Receive asks the limiter whether it may spend the message's cost: one
+// for each message key it derives, skipped keys included, and 2 more for a DH ratchet
+// step. Messages that decrypt with a stored skipped key, and messages rejected as
+// duplicates or by WithMaxSkip, are not charged. Cost is charged whether or not the
+// message then decrypts, since a forged message costs as much work as a genuine one.
+//
+// A Limiter may be shared by the sessions of one peer, or of all peers, to bound their
+// combined cost. Implementations must be safe for concurrent use.
+type Limiter interface {
+	// Allow reports whether cost units of work may be spent now, and if so, records them.
+	Allow(cost uint64) bool
+}
+
+// WithLimiter makes Receive charge the work each message costs to limiter, and fail with
+// ErrRateLimited, before deriving any key, for messages the limiter refuses. It protects a
+// server from peers that send crafted messages to exhaust its CPU with key derivations and
+// DH computations. See NewRateLimiter for a limiter that allows a fixed cost per time
+// window. The limiter is not part of the serialized state and must be given again when a
+// session is deserialized.
+func WithLimiter(limiter Limiter) Option {
+	return func(d *doubleRatchet) error {
+		d.limiter = limiter
+		return nil
+	}
+}
+
+// receiveCost returns the cost of receiving a message with header h, in key derivations.
+func (d *doubleRatchet) receiveCost(h Header) uint64 {
+	cost := d.skipCount(h) + 1
+
+	if !bytes.Equal(h.DH, d.dh.remoteBytes()) {
+		cost += ratchetStepCost
+	}
+
+	return cost
+}
+
+// checkRate charges the cost of receiving a message with header h to the session's
+// Limiter, if it has one. The caller must hold the lock.
+func (d *doubleRatchet) checkRate(h Header) error {
+	if d.limiter == nil || d.limiter.Allow(d.receiveCost(h)) {
+		return nil
+	}
+
+	return ErrRateLimited
+}
+
+// rateLimiter is the Limiter of NewRateLimiter.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  uint64
+	window time.Duration
+	now    func() time.Time
+
+	start time.Time
+	spent uint64
+}
+
+// NewRateLimiter returns a Limiter that allows a cost of at most limit per window. The
+// windows are fixed: the first charge starts one, and the cost spent is forgotten once it
+// has passed. A single message that costs more than limit is always refused.
+func NewRateLimiter(limit uint64, window time.Duration) (Limiter, error) {
+	if limit == 0 || window <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+
+	return &rateLimiter{limit: limit, window: window, now: time.Now}, nil
+}
+
+// Allow implements Limiter.
+func (l *rateLimiter) Allow(cost uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := l.now(); now.Sub(l.start) >= l.window {
+		l.start, l.spent = now, 0
+	}
+
+	if cost > l.limit-l.spent {
+		return false
+	}
+
+	l.spent += cost
+
+	return true
+}
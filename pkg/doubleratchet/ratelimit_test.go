@@ -0,0 +1,71 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWithLimiter verifies that Receive charges each message's key derivations to the
+// limiter, rejects messages beyond the limit with ErrRateLimited without advancing the
+// session, and accepts them again once the window has passed.
+func TestWithLimiter(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	limiter, err := NewRateLimiter(4, time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	limiter.(*rateLimiter).now = func() time.Time { return now }
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithLimiter(limiter))
+
+	var msgs []CipheredMessage
+
+	for range 6 {
+		msg, err := alice.Send([]byte("hello"), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if _, err := bob.Receive(msgs[4], nil); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited for a message costing 5, got %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[2], nil); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[0], nil); err != nil {
+		t.Errorf("Expected a skipped key not to be charged, got %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[3], nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+
+	if _, err := bob.Receive(msgs[4], nil); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited once the window is spent, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, err := bob.Receive(msgs[4], nil); err != nil {
+		t.Errorf("Expected the message to be received in the next window, got %v", err)
+	}
+
+	if _, err := NewRateLimiter(0, time.Minute); !errors.Is(err, ErrInvalidRateLimit) {
+		t.Errorf("Expected ErrInvalidRateLimit, got %v", err)
+	}
+}
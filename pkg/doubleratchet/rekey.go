@@ -0,0 +1,200 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// RekeyPolicy bounds how long the session keeps sending on one chain. Zero fields are not
+// enforced.
+//
+// A DH ratchet step is only safe on the session's turn; see Rekey. When a limit is reached
+// on the session's turn, the next Send takes the step. Otherwise the session keeps sending
+// on the chain and delivers EventRekeyDue, so that an application with a stream in which
+// the peer rarely replies can ask the peer for the reply that gives the session its turn,
+// for example with a MessageRekeyRequest.
+type RekeyPolicy struct {
+	// Messages is the number of messages sent on a chain before a new one is started.
+	Messages uint32
+
+	// Interval is the time since the first message on a chain after which a new one is
+	// started.
+	Interval time.Duration
+
+	// Bytes is the number of plaintext bytes sent on a chain before a new one is started.
+	Bytes uint64
+}
+
+// WithRekeyPolicy makes the session track how long it has been sending on one chain and
+// report with EventRekeyDue when policy's limits are reached before the peer's next
+// ratchet key has arrived. The policy is not part of the serialized state and must be
+// given again when a session is deserialized; the progress of the current chain is
+// serialized.
+func WithRekeyPolicy(policy RekeyPolicy) Option {
+	return func(d *doubleRatchet) error {
+		d.rekeyPolicy = policy
+		return nil
+	}
+}
+
+// Rekey starts a new sending chain under a fresh ratchet key, as a DH ratchet step does,
+// so that an application can refresh the session's keys on demand. The peer follows the
+// step when it receives the next message. Rekey fails with ErrNoSendingChain before the
+// session has a peer ratchet key.
+//
+// Both peers derive each step from the root key of the other's latest ratchet key, so a
+// step is only safe on the session's turn: after a new ratchet key of the peer has
+// arrived and before the session has answered it, or, in a session created with New
+// that has not stepped yet, on the side with the lesser public key. A step taken at any
+// other time may race with the peer's own step, and once both peers have stepped without
+// seeing each other's new key, they derive different root keys and no message decrypts
+// again. Rekey therefore steps at once only on the session's turn. Otherwise it returns
+// nil and the step is deferred to the first Send after the peer's next ratchet key
+// arrives; subscribers see EventRatchetStep when it is taken.
+func (d *doubleRatchet) Rekey() error {
+	return d.RekeyCtx(context.Background())
+}
+
+// RekeyCtx is like Rekey, but gives up with the context's error if ctx is done before the
+// session lock is acquired, and passes ctx on to the key operations of the DH function.
+func (d *doubleRatchet) RekeyCtx(ctx context.Context) error {
+	if err := d.lockContext(ctx); err != nil {
+		return err
+	}
+
+	defer d.unlock()
+
+	if d.closed {
+		return ErrSessionClosed
+	}
+
+	if d.archived {
+		return ErrArchived
+	}
+
+	if d.dh.remotePublicKey == nil {
+		return ErrNoSendingChain
+	}
+
+	if !d.hasTurn() {
+		return nil
+	}
+
+	published := false
+
+	defer func() { d.publish(published) }()
+
+	if err := d.stepSendingRatchet(ctx); err != nil {
+		return err
+	}
+
+	if err := d.save(ctx); err != nil {
+		return err
+	}
+
+	published = true
+
+	return nil
+}
+
+// rekeyDue reports whether a limit of the policy has been reached on the current sending
+// chain. It starts the chain's clock on its first message. The caller must hold the lock.
+func (d *doubleRatchet) rekeyDue() bool {
+	p := d.rekeyPolicy
+
+	if p == (RekeyPolicy{}) {
+		return false
+	}
+
+	if d.chainStarted.IsZero() {
+		d.chainStarted = d.clock()
+	}
+
+	return (p.Messages > 0 && d.sendN >= p.Messages) ||
+		(p.Bytes > 0 && d.chainBytes >= p.Bytes) ||
+		(p.Interval > 0 && d.clock().Sub(d.chainStarted) >= p.Interval)
+}
+
+// hasTurn reports whether a DH ratchet step taken by the session now is safe: a new
+// ratchet key of the peer is waiting to be answered, or the session was created with New,
+// has not stepped yet and holds the lesser of the two public keys. The caller must hold
+// the lock.
+func (d *doubleRatchet) hasTurn() bool {
+	if d.sendStepPending {
+		return true
+	}
+
+	return d.epoch == 0 && d.dh.remotePublicKey != nil &&
+		bytes.Compare(d.dh.localPrivateKey.PublicKey().Bytes(), d.dh.remotePublicKey.Bytes()) < 0
+}
+
+// deferRekey reports with EventRekeyDue, once per sending chain, that the policy's limits
+// were reached before the session's turn. The caller must hold the lock.
+func (d *doubleRatchet) deferRekey() {
+	if d.rekeyDeferred {
+		return
+	}
+
+	d.rekeyDeferred = true
+	d.record(EventRekeyDue, d.dh.localPrivateKey.PublicKey().Bytes())
+}
+
+// stepSendingRatchet starts a new sending chain under a fresh ratchet key: the sending
+// half of a DH ratchet step, taken on the session's turn or to start the initiator's
+// first chain. The caller must hold the lock.
+func (d *doubleRatchet) stepSendingRatchet(ctx context.Context) error {
+	prev := d.dh.localPrivateKey
+
+	if err := d.dh.refreshContext(ctx, d.rand); err != nil {
+		return err
+	}
+
+	dhOut, err := d.dh.exchangeContext(ctx, d.dh.remotePublicKey)
+
+	if err != nil {
+		return err
+	}
+
+	pqOut, err := d.hybridSendSecret()
+
+	if err != nil {
+		return err
+	}
+
+	rootKey, chainKey, err := d.deriveRK(d.rootKey, append(dhOut, pqOut...))
+
+	if err != nil {
+		return err
+	}
+
+	if !d.sharedKey {
+		if err := destroyKey(ctx, prev); err != nil {
+			return err
+		}
+	}
+
+	d.sharedKey = false
+
+	if !d.sendStepPending {
+		d.epoch++
+	}
+
+	d.sendStepPending = false
+	d.prevN = d.sendN
+	d.sendN = 0
+	d.rootKey, d.sendChainKey = rootKey, chainKey
+	d.resetChainProgress()
+
+	d.record(EventRatchetStep, d.dh.localPrivateKey.PublicKey().Bytes())
+	d.rekeyPending = true
+
+	return nil
+}
+
+// resetChainProgress restarts the policy's counters for a new sending chain.
+func (d *doubleRatchet) resetChainProgress() {
+	d.chainStarted = time.Time{}
+	d.chainBytes = 0
+	d.rekeyDeferred = false
+}
@@ -0,0 +1,324 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRekeyPolicyMessages verifies that a session whose message limit is reached before
+// its turn keeps sending on the chain and delivers EventRekeyDue once, that it starts a
+// new chain with the first message after the peer's reply, and that the peer decrypts
+// every message, including one delivered after the step.
+func TestRekeyPolicyMessages(t *testing.T) {
+	bobPri, alicePri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithRekeyPolicy(RekeyPolicy{Messages: 2}))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	events, cancel := alice.Subscribe(4)
+	defer cancel()
+
+	var msgs []CipheredMessage
+
+	for i := 0; i < 4; i++ {
+		msg, err := alice.Send([]byte{byte(i)}, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if !bytes.Equal(msgs[3].Header.DH, alicePri.PublicKey().Bytes()) || msgs[3].Header.N != 3 {
+		t.Errorf("Expected 4 messages on the first chain before the session's turn, got N=%d", msgs[3].Header.N)
+	}
+
+	if event := <-events; event.Kind != EventRekeyDue || len(events) != 0 {
+		t.Errorf("Expected a single EventRekeyDue, got %v and %d more events", event.Kind, len(events))
+	}
+
+	for _, i := range []int{0, 1, 2} {
+		if _, err := bob.Receive(msgs[i], nil); err != nil {
+			t.Fatalf("Receive of message %d failed: %v", i, err)
+		}
+	}
+
+	if err := bob.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := alice.Receive(reply, nil); err != nil {
+		t.Fatalf("Receive of the reply failed: %v", err)
+	}
+
+	msg, _ := alice.Send([]byte{4}, nil)
+	msgs = append(msgs, msg)
+
+	if bytes.Equal(msg.Header.DH, alicePri.PublicKey().Bytes()) || msg.Header.N != 0 || msg.Header.PN != 4 {
+		t.Errorf("Expected the message after the reply to start a new chain after 4 messages, got N=%d PN=%d", msg.Header.N, msg.Header.PN)
+	}
+
+	for _, i := range []int{4, 3} {
+		received, err := bob.Receive(msgs[i], nil)
+
+		if err != nil {
+			t.Fatalf("Receive of message %d failed: %v", i, err)
+		}
+
+		if !bytes.Equal(received.Plaintext, []byte{byte(i)}) {
+			t.Errorf("Expected message %d, got %v", i, received.Plaintext)
+		}
+	}
+}
+
+// TestRekeyPolicyBothPeers verifies that two sessions with the same policy keep stepping
+// in turn, and keep decrypting each other's messages, while both send before receiving.
+func TestRekeyPolicyBothPeers(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	policy := RekeyPolicy{Messages: 2}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithRekeyPolicy(policy))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithRekeyPolicy(policy))
+
+	keys := map[string]bool{}
+
+	for round := 0; round < 10; round++ {
+		var toAlice, toBob []CipheredMessage
+
+		for i := 0; i < 2; i++ {
+			msg, _ := alice.Send([]byte("to bob"), nil)
+			toBob = append(toBob, msg)
+
+			msg, _ = bob.Send([]byte("to alice"), nil)
+			toAlice = append(toAlice, msg)
+		}
+
+		for _, msg := range toBob {
+			if _, err := bob.Receive(msg, nil); err != nil {
+				t.Fatalf("Round %d: Bob failed to receive: %v", round, err)
+			}
+
+			keys[string(msg.Header.DH)] = true
+		}
+
+		for _, msg := range toAlice {
+			if _, err := alice.Receive(msg, nil); err != nil {
+				t.Fatalf("Round %d: Alice failed to receive: %v", round, err)
+			}
+
+			keys[string(msg.Header.DH)] = true
+		}
+	}
+
+	if len(keys) < 10 {
+		t.Errorf("Expected the sessions to keep stepping, got %d ratchet keys in 10 rounds", len(keys))
+	}
+}
+
+// TestRekeyPolicyBytesAndInterval verifies that the byte and time limits each start a
+// new sending chain on the session's turn, and that the progress of the current chain
+// survives serialization.
+func TestRekeyPolicyBytesAndInterval(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	policy := RekeyPolicy{Bytes: 100, Interval: time.Hour}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithRekeyPolicy(policy))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	clock := time.Now()
+	alice.now = func() time.Time { return clock }
+
+	first, _ := alice.Send(make([]byte, 60), nil)
+
+	state, _ := alice.Serialize()
+	restored, err := Deserialize(state, WithRekeyPolicy(policy))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored.now = func() time.Time { return clock }
+
+	second, _ := restored.Send(make([]byte, 60), nil)
+	third, _ := restored.Send(nil, nil)
+
+	if !bytes.Equal(second.Header.DH, first.Header.DH) || bytes.Equal(third.Header.DH, first.Header.DH) {
+		t.Error("Expected a new chain once 100 bytes were sent across the restore")
+	}
+
+	clock = clock.Add(time.Hour)
+
+	fourth, _ := restored.Send(nil, nil)
+
+	if !bytes.Equal(fourth.Header.DH, third.Header.DH) {
+		t.Error("Expected the chain's clock to start at its first message")
+	}
+
+	clock = clock.Add(time.Hour)
+
+	fifth, _ := restored.Send(nil, nil)
+
+	if !bytes.Equal(fifth.Header.DH, fourth.Header.DH) {
+		t.Error("Expected the chain to be kept until the session's turn")
+	}
+
+	for _, msg := range []CipheredMessage{first, second, third, fourth, fifth} {
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+
+	if _, err := restored.Receive(reply, nil); err != nil {
+		t.Fatalf("Receive of the reply failed: %v", err)
+	}
+
+	sixth, _ := restored.Send(nil, nil)
+
+	if bytes.Equal(sixth.Header.DH, fifth.Header.DH) {
+		t.Error("Expected a new chain with the first message after the peer's reply")
+	}
+}
+
+// TestRatchetStepCarriesPreviousChainLength verifies that a session answering a new peer
+// key reports the length of its previous sending chain as PN, so that the peer can still
+// decrypt a message sent on that chain and delivered after the answer.
+func TestRatchetStepCarriesPreviousChainLength(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithRekeyPolicy(RekeyPolicy{Messages: 1}))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	delayed, _ := bob.Send([]byte("delayed"), nil)
+
+	first, _ := alice.Send([]byte("first"), nil)
+	second, _ := alice.Send([]byte("second"), nil)
+
+	for _, msg := range []CipheredMessage{first, second} {
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+
+	answer, _ := bob.Send([]byte("answer"), nil)
+
+	if answer.Header.PN != 1 {
+		t.Errorf("Expected PN 1 for one message on the previous chain, got %d", answer.Header.PN)
+	}
+
+	for _, msg := range []CipheredMessage{answer, delayed} {
+		if _, err := alice.Receive(msg, nil); err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+	}
+}
+
+// TestRekey verifies that Rekey starts a new sending chain under a fresh ratchet key that
+// the peer follows and reports the step to subscribers, that it defers the step without
+// the session's turn, and that it fails without a peer key and on a closed session.
+func TestRekey(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	events, cancel := alice.Subscribe(4)
+	defer cancel()
+
+	if err := bob.Rekey(); err != nil || !bytes.Equal(bob.LocalRatchetKey(), bobPri.PublicKey().Bytes()) {
+		t.Errorf("Expected Rekey to defer the step without the session's turn, got %v", err)
+	}
+
+	first, _ := alice.Send([]byte("first"), nil)
+
+	if err := alice.Rekey(); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+
+	if event := <-events; event.Kind != EventRatchetStep || !bytes.Equal(event.Key, alice.LocalRatchetKey()) {
+		t.Errorf("Expected a ratchet step event for the new key, got %+v", event)
+	}
+
+	if err := alice.Rekey(); err != nil || len(events) != 0 {
+		t.Errorf("Expected a second Rekey to wait for the peer's next key, got %v", err)
+	}
+
+	second, _ := alice.Send([]byte("second"), nil)
+
+	if bytes.Equal(first.Header.DH, second.Header.DH) || second.Header.N != 0 || second.Header.PN != 1 {
+		t.Errorf("Expected a new chain under a new key, got N=%d PN=%d", second.Header.N, second.Header.PN)
+	}
+
+	for _, msg := range []CipheredMessage{second, first} {
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Errorf("Receive failed: %v", err)
+		}
+	}
+
+	responderPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	sk := make([]byte, 32)
+	rand.Read(sk)
+
+	responder, _ := InitBob(sk, responderPri.Bytes())
+
+	if err := responder.Rekey(); !errors.Is(err, ErrNoSendingChain) {
+		t.Errorf("Expected ErrNoSendingChain, got %v", err)
+	}
+
+	alice.Close()
+
+	if err := alice.Rekey(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed, got %v", err)
+	}
+}
+
+// TestRekeyBothPeers verifies that two sessions that both call Rekey before receiving each
+// other's messages take their steps in turn and keep decrypting each other's messages.
+func TestRekeyBothPeers(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	keys := map[string]bool{}
+
+	for round := 0; round < 10; round++ {
+		if err := alice.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bob.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+
+		toBob, _ := alice.Send([]byte("to bob"), nil)
+		toAlice, _ := bob.Send([]byte("to alice"), nil)
+
+		if _, err := bob.Receive(toBob, nil); err != nil {
+			t.Fatalf("Round %d: Bob failed to receive: %v", round, err)
+		}
+
+		if _, err := alice.Receive(toAlice, nil); err != nil {
+			t.Fatalf("Round %d: Alice failed to receive: %v", round, err)
+		}
+
+		keys[string(toBob.Header.DH)] = true
+		keys[string(toAlice.Header.DH)] = true
+	}
+
+	if len(keys) < 10 {
+		t.Errorf("Expected the sessions to keep stepping, got %d ratchet keys in 10 rounds", len(keys))
+	}
+}
@@ -0,0 +1,117 @@
+package doubleratchet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// replayIDSize is the size of a message ID in the replay window.
+const replayIDSize = 16
+
+var (
+	// ErrMalformedReplayWindow is returned by Deserialize for a state whose replay window
+	// is not a whole number of message IDs.
+	ErrMalformedReplayWindow = newError(ErrState, "double ratchet: malformed replay window")
+)
+
+// replayLabel domain-separates the message IDs of the replay window.
+var replayLabel = []byte("DoubleRatchet-Replay")
+
+// replayID identifies a received message by its ratchet key and message number.
+type replayID [replayIDSize]byte
+
+// WithReplayWindow makes the session remember the last n messages it decrypted, in its
+// serialized state, and refuse them with ErrDuplicateMessage before any key is derived.
+//
+// Without it, a session recognizes a message as a duplicate while its chain is current or
+// still holds skipped keys. A replayed message of an older chain is instead taken for a
+// message under a new ratchet key, and fails with another error, such as ErrOldMessage,
+// or ErrAuthFailed after a DH computation. The window recognizes such messages no matter
+// how old their chain is, at a cost of 16 bytes of state per message.
+//
+// The size of the window is not part of the serialized state and must be given again
+// when a session is deserialized; without it, the remembered messages are still refused,
+// but no new ones are added.
+func WithReplayWindow(n int) Option {
+	return func(d *doubleRatchet) error {
+		d.replayMax = max(n, 0)
+		return nil
+	}
+}
+
+// messageID returns the replay window ID of the message with header h.
+func messageID(h Header) replayID {
+	hash := sha256.New()
+	hash.Write(replayLabel)
+	hash.Write(binary.BigEndian.AppendUint16(nil, uint16(len(h.DH)))) // #nosec G115 -- public keys are far below 64 KiB
+	hash.Write(h.DH)
+	hash.Write(binary.BigEndian.AppendUint32(nil, h.N))
+
+	var id replayID
+
+	copy(id[:], hash.Sum(nil))
+
+	return id
+}
+
+// replayed reports whether the message with header h is in the replay window.
+func (d *doubleRatchet) replayed(h Header) bool {
+	if len(d.replay) == 0 {
+		return false
+	}
+
+	id := messageID(h)
+
+	for _, seen := range d.replay {
+		if seen == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remember adds the message with header h to the replay window, forgetting the oldest
+// messages beyond its size. The caller must hold the lock.
+func (d *doubleRatchet) remember(h Header) {
+	if d.replayMax == 0 {
+		return
+	}
+
+	d.replay = append(d.replay, messageID(h))
+
+	if extra := len(d.replay) - d.replayMax; extra > 0 {
+		d.replay = d.replay[extra:]
+	}
+}
+
+// replayState returns the replay window in its serialized form, the IDs concatenated from
+// the oldest.
+func (d *doubleRatchet) replayState() []byte {
+	if len(d.replay) == 0 {
+		return nil
+	}
+
+	out := make([]byte, 0, len(d.replay)*replayIDSize)
+
+	for _, id := range d.replay {
+		out = append(out, id[:]...)
+	}
+
+	return out
+}
+
+// restoreReplay restores the replay window of a serialized state.
+func (d *doubleRatchet) restoreReplay(window []byte) error {
+	if len(window)%replayIDSize != 0 {
+		return ErrMalformedReplayWindow
+	}
+
+	d.replay = make([]replayID, len(window)/replayIDSize)
+
+	for i := range d.replay {
+		copy(d.replay[i][:], window[i*replayIDSize:])
+	}
+
+	return nil
+}
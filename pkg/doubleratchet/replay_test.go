@@ -0,0 +1,109 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestReplayWindow verifies that a session with WithReplayWindow refuses a replayed
+// message of a finished chain as a duplicate after it was serialized and restored in
+// every built-in format, while a session without the window cannot tell it is one.
+func TestReplayWindow(t *testing.T) {
+	for _, s := range []Serializer{JSONSerializer{}, GobSerializer{}, BinarySerializer{}, ProtoSerializer{}, CBORSerializer{}} {
+		alicePri, bobPri := orderedKeys(ecdh.P256())
+
+		alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+		bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithSerializer(s), WithReplayWindow(8))
+
+		old, _ := alice.Send([]byte("old"), nil)
+
+		if _, err := bob.Receive(old, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := alice.Rekey(); err != nil {
+			t.Fatal(err)
+		}
+
+		next, _ := alice.Send([]byte("next"), nil)
+
+		if _, err := bob.Receive(next, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := bob.Serialize()
+
+		restored, err := Deserialize(data, WithReplayWindow(8))
+
+		if err != nil {
+			t.Fatalf("Expected format %q to deserialize, got %v", s.Format(), err)
+		}
+
+		if _, err := restored.Receive(old, nil); !errors.Is(err, ErrDuplicateMessage) {
+			t.Errorf("Expected format %q to refuse the replay with ErrDuplicateMessage, got %v", s.Format(), err)
+		}
+
+		if after, _ := restored.Serialize(); string(after) != string(data) {
+			t.Errorf("Expected format %q state to be unchanged by the replay", s.Format())
+		}
+
+		plain, _ := Deserialize(data)
+		plain.replay = nil
+
+		if _, err := plain.Receive(old, nil); err == nil || errors.Is(err, ErrDuplicateMessage) {
+			t.Errorf("Expected a session without the window to fail with another error, got %v", err)
+		}
+	}
+}
+
+// TestReplayWindowSize verifies that the window keeps only the most recent messages.
+func TestReplayWindowSize(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithReplayWindow(2))
+
+	var sent []CipheredMessage
+
+	for i := 0; i < 3; i++ {
+		msg, _ := alice.Send([]byte("hello"), nil)
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		sent = append(sent, msg)
+	}
+
+	if len(bob.replay) != 2 {
+		t.Fatalf("Expected 2 remembered messages, got %d", len(bob.replay))
+	}
+
+	if bob.replayed(sent[0].Header) || !bob.replayed(sent[1].Header) || !bob.replayed(sent[2].Header) {
+		t.Errorf("Expected the window to hold the last two messages")
+	}
+}
+
+// TestReplayWindowMalformed verifies that Deserialize refuses a replay window that is not
+// a whole number of message IDs.
+func TestReplayWindowMalformed(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	data, _ := alice.Serialize()
+
+	var state State
+
+	_ = json.Unmarshal(data, &state)
+	state.ReplayWindow = make([]byte, replayIDSize+1)
+	data, _ = json.Marshal(state)
+
+	if _, err := Deserialize(data); !errors.Is(err, ErrMalformedReplayWindow) {
+		t.Errorf("Expected ErrMalformedReplayWindow, got %v", err)
+	}
+}
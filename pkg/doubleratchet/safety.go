@@ -0,0 +1,65 @@
+package doubleratchet
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// safetyNumberIterations is the number of SHA-512 iterations per party, which makes
+	// searching for identity keys with a colliding safety number expensive.
+	safetyNumberIterations = 5200
+
+	// safetyNumberVersion is the version prefixed to the hashed input.
+	safetyNumberVersion = 0
+)
+
+// SafetyNumber returns a 60-digit number, in twelve groups of five digits, that two users
+// compare out of band, in person or by scanning it, to verify each other's identity keys.
+// Both users derive the same number: each passes their own identifier and identity public
+// key as local and the peer's as remote. The identifiers are stable names such as phone
+// numbers or user names, and may be empty.
+//
+// The number follows the construction of Signal's safety numbers: 30 digits per party
+// from 5200 iterations of SHA-512 over the party's key and identifier, the lower half
+// first. It is not interchangeable with Signal's, which encode keys differently.
+func SafetyNumber(localID, localKey, remoteID, remoteKey []byte) string {
+	local := safetyDigits(localID, localKey)
+	remote := safetyDigits(remoteID, remoteKey)
+
+	if local > remote {
+		local, remote = remote, local
+	}
+
+	digits := local + remote
+	groups := make([]string, 0, len(digits)/5)
+
+	for i := 0; i < len(digits); i += 5 {
+		groups = append(groups, digits[i:i+5])
+	}
+
+	return strings.Join(groups, " ")
+}
+
+// safetyDigits returns the 30 digits of one party's half of a safety number.
+func safetyDigits(id, key []byte) string {
+	hash := binary.BigEndian.AppendUint16(nil, safetyNumberVersion)
+	hash = append(hash, key...)
+	hash = append(hash, id...)
+
+	for i := 0; i < safetyNumberIterations; i++ {
+		sum := sha512.Sum512(append(hash, key...))
+		hash = sum[:]
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < 30; i += 5 {
+		chunk := binary.BigEndian.Uint64(append([]byte{0, 0, 0}, hash[i:i+5]...))
+		fmt.Fprintf(&b, "%05d", chunk%100000)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"regexp"
+	"testing"
+)
+
+// TestSafetyNumber verifies that both users derive the same 60-digit safety number from
+// their own and the peer's identity, and that a different key or identifier changes it.
+func TestSafetyNumber(t *testing.T) {
+	alice, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bob, _ := ecdh.P256().GenerateKey(rand.Reader)
+	mallory, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	aliceID, bobID := []byte("+14152222222"), []byte("+14153333333")
+
+	number := SafetyNumber(aliceID, alice.PublicKey().Bytes(), bobID, bob.PublicKey().Bytes())
+
+	if !regexp.MustCompile(`^\d{5}( \d{5}){11}$`).MatchString(number) {
+		t.Fatalf("Expected twelve groups of five digits, got %q", number)
+	}
+
+	if peer := SafetyNumber(bobID, bob.PublicKey().Bytes(), aliceID, alice.PublicKey().Bytes()); peer != number {
+		t.Errorf("Expected both users to derive the same number, got %q and %q", number, peer)
+	}
+
+	if SafetyNumber(aliceID, alice.PublicKey().Bytes(), bobID, mallory.PublicKey().Bytes()) == number {
+		t.Error("Expected a different key to change the number")
+	}
+
+	if SafetyNumber(aliceID, alice.PublicKey().Bytes(), []byte("+14154444444"), bob.PublicKey().Bytes()) == number {
+		t.Error("Expected a different identifier to change the number")
+	}
+}
@@ -0,0 +1,278 @@
+package doubleratchet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// sasNonceSize is the size of the nonce each side of a SASExchange contributes.
+const sasNonceSize = 32
+
+var (
+	// ErrSASNoSessionID is returned by NewSASExchange for a session restored from a state
+	// written before session IDs were recorded.
+	ErrSASNoSessionID = newError(ErrState, "double ratchet: short authentication string requires a session ID")
+
+	// ErrSASOutOfOrder is returned by the methods of a SASExchange called on the wrong
+	// side of the exchange, or out of order.
+	ErrSASOutOfOrder = newError(ErrProtocol, "double ratchet: short authentication string exchange out of order")
+
+	// ErrSASCommitment is returned by SASExchange.Finish when the initiator's nonce does not
+	// match the commitment it sent, or has the wrong size.
+	ErrSASCommitment = newError(ErrProtocol, "double ratchet: short authentication string commitment mismatch")
+)
+
+// sasLabel and sasCommitLabel domain-separate short authentication strings and the
+// commitments to their nonces from other uses of HKDF.
+var (
+	sasLabel       = []byte("DoubleRatchet-SAS")
+	sasCommitLabel = []byte("DoubleRatchet-SAS-Commit")
+)
+
+// sasSymbols are the emoji of a short authentication string with the words to read them
+// aloud. Each is chosen by 6 bits of the string.
+var sasSymbols = [64]struct{ Emoji, Word string }{
+	{"🐶", "dog"}, {"🐱", "cat"}, {"🦁", "lion"}, {"🐎", "horse"},
+	{"🦄", "unicorn"}, {"🐷", "pig"}, {"🐘", "elephant"}, {"🐰", "rabbit"},
+	{"🐼", "panda"}, {"🐓", "rooster"}, {"🐧", "penguin"}, {"🐢", "turtle"},
+	{"🐟", "fish"}, {"🐙", "octopus"}, {"🦋", "butterfly"}, {"🌷", "flower"},
+	{"🌳", "tree"}, {"🌵", "cactus"}, {"🍄", "mushroom"}, {"🌏", "globe"},
+	{"🌙", "moon"}, {"☁️", "cloud"}, {"🔥", "fire"}, {"🍌", "banana"},
+	{"🍎", "apple"}, {"🍓", "strawberry"}, {"🌽", "corn"}, {"🍕", "pizza"},
+	{"🎂", "cake"}, {"❤️", "heart"}, {"😀", "smiley"}, {"🤖", "robot"},
+	{"🎩", "hat"}, {"👓", "glasses"}, {"🔧", "spanner"}, {"🎅", "santa"},
+	{"👍", "thumbs up"}, {"☂️", "umbrella"}, {"⌛", "hourglass"}, {"⏰", "clock"},
+	{"🎁", "gift"}, {"💡", "light bulb"}, {"📕", "book"}, {"✏️", "pencil"},
+	{"📎", "paperclip"}, {"✂️", "scissors"}, {"🔒", "lock"}, {"🔑", "key"},
+	{"🔨", "hammer"}, {"☎️", "telephone"}, {"🏁", "flag"}, {"🚂", "train"},
+	{"🚲", "bicycle"}, {"✈️", "aeroplane"}, {"🚀", "rocket"}, {"🏆", "trophy"},
+	{"⚽", "ball"}, {"🎸", "guitar"}, {"🎺", "trumpet"}, {"🔔", "bell"},
+	{"⚓", "anchor"}, {"🎧", "headphones"}, {"📁", "folder"}, {"📌", "pin"},
+}
+
+// sasEmojiCount is the number of emoji in a short authentication string (42 bits).
+const sasEmojiCount = 7
+
+// SAS is a short authentication string: a few digits or emoji that two users compare
+// aloud, for example at the start of a voice or video call, to verify that they share
+// the same session and no one is relaying it between them. It is derived by a
+// SASExchange.
+type SAS struct {
+	bits [6]byte
+}
+
+// SASExchange is one side of the commit-then-reveal exchange that derives a short
+// authentication string from the session ID and a nonce of each peer. The peers send
+// three messages, over the session itself or any other channel:
+//
+//	initiator -> responder: Commitment()
+//	responder -> initiator: Respond(commitment)
+//	initiator -> responder: Reveal(nonce)
+//
+// after which the responder calls Finish with the initiator's nonce. Both then show the
+// SAS and the users compare it.
+//
+// A relaying attacker has a separate session, with a separate ID, with each user. The
+// initiator commits to its nonce before it learns the responder's, and the responder
+// reveals its nonce before it learns the initiator's, so the attacker must fix its own
+// nonce on each side before it can tell whether the strings would match. It cannot search
+// for a match: the strings match by chance only, with probability 2^-42 for emoji and
+// about 2^-39 for digits. An exchange must not be reused; run a new one to verify again.
+type SASExchange struct {
+	sessionID []byte
+	initiator bool
+	nonce     [sasNonceSize]byte
+
+	// step counts the methods called so far.
+	step int
+
+	// commitment is the initiator's commitment, as received by the responder.
+	commitment []byte
+}
+
+// NewSASExchange starts a short authentication string exchange for session. initiator
+// selects the side of the exchange; the peers must take opposite sides.
+func NewSASExchange(session DoubleRatchet, initiator bool) (*SASExchange, error) {
+	id := session.SessionID()
+
+	if len(id) == 0 {
+		return nil, ErrSASNoSessionID
+	}
+
+	x := &SASExchange{sessionID: id, initiator: initiator}
+
+	if _, err := io.ReadFull(rand.Reader, x.nonce[:]); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// Commitment returns the initiator's commitment to its nonce, the first message of the
+// exchange.
+func (x *SASExchange) Commitment() ([]byte, error) {
+	if !x.initiator || x.step != 0 {
+		return nil, ErrSASOutOfOrder
+	}
+
+	commitment, err := x.commit(x.nonce[:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	x.step++
+
+	return commitment, nil
+}
+
+// Respond takes the initiator's commitment and returns the responder's nonce, the second
+// message of the exchange.
+func (x *SASExchange) Respond(commitment []byte) ([]byte, error) {
+	if x.initiator || x.step != 0 {
+		return nil, ErrSASOutOfOrder
+	}
+
+	x.step++
+	x.commitment = append([]byte(nil), commitment...)
+
+	return append([]byte(nil), x.nonce[:]...), nil
+}
+
+// Reveal takes the responder's nonce and returns the initiator's nonce, the last message
+// of the exchange, with the short authentication string.
+func (x *SASExchange) Reveal(nonce []byte) ([]byte, SAS, error) {
+	if !x.initiator || x.step != 1 {
+		return nil, SAS{}, ErrSASOutOfOrder
+	}
+
+	if len(nonce) != sasNonceSize {
+		return nil, SAS{}, ErrSASCommitment
+	}
+
+	sas, err := x.derive(x.nonce[:], nonce)
+
+	if err != nil {
+		return nil, SAS{}, err
+	}
+
+	x.step++
+
+	return append([]byte(nil), x.nonce[:]...), sas, nil
+}
+
+// Finish takes the initiator's nonce, checks it against the initiator's commitment and
+// returns the short authentication string.
+func (x *SASExchange) Finish(nonce []byte) (SAS, error) {
+	if x.initiator || x.step != 1 {
+		return SAS{}, ErrSASOutOfOrder
+	}
+
+	if len(nonce) != sasNonceSize {
+		return SAS{}, ErrSASCommitment
+	}
+
+	commitment, err := x.commit(nonce)
+
+	if err != nil {
+		return SAS{}, err
+	}
+
+	if !hmac.Equal(commitment, x.commitment) {
+		return SAS{}, ErrSASCommitment
+	}
+
+	sas, err := x.derive(nonce, x.nonce[:])
+
+	if err != nil {
+		return SAS{}, err
+	}
+
+	x.step++
+
+	return sas, nil
+}
+
+// commit returns the commitment to the initiator's nonce, bound to the session ID.
+func (x *SASExchange) commit(nonce []byte) ([]byte, error) {
+	return crypto.DeriveHKDF(nonce, x.sessionID, sasCommitLabel, 32)
+}
+
+// derive returns the short authentication string of the initiator's and the responder's
+// nonces, bound to the session ID.
+func (x *SASExchange) derive(initiatorNonce, responderNonce []byte) (SAS, error) {
+	var sas SAS
+
+	ikm := append(append([]byte(nil), initiatorNonce...), responderNonce...)
+
+	bits, err := crypto.DeriveHKDF(ikm, x.sessionID, sasLabel, len(sas.bits))
+
+	if err != nil {
+		return sas, err
+	}
+
+	copy(sas.bits[:], bits)
+
+	return sas, nil
+}
+
+// Digits returns the string as three groups of four digits, such as "4822 1093 7741".
+func (s SAS) Digits() string {
+	n := binary.BigEndian.Uint64(append([]byte{0, 0}, s.bits[:]...)) >> 9 // 39 bits
+
+	return fmt.Sprintf("%04d %04d %04d", (n>>26)+1000, (n>>13&0x1fff)+1000, (n&0x1fff)+1000)
+}
+
+// Emoji returns the string as seven emoji.
+func (s SAS) Emoji() []string {
+	out := make([]string, sasEmojiCount)
+
+	for i, index := range s.indices() {
+		out[i] = sasSymbols[index].Emoji
+	}
+
+	return out
+}
+
+// Words returns the names of the emoji returned by Emoji, to read them aloud.
+func (s SAS) Words() []string {
+	out := make([]string, sasEmojiCount)
+
+	for i, index := range s.indices() {
+		out[i] = sasSymbols[index].Word
+	}
+
+	return out
+}
+
+// Indices returns the positions of the emoji returned by Emoji in the 64-symbol table,
+// for applications that render the symbols themselves. The table has the order of the
+// Matrix SAS emoji list, so its published translations can name the symbols.
+func (s SAS) Indices() []int {
+	idx := s.indices()
+
+	return idx[:]
+}
+
+// indices splits the first 42 bits of the string into seven 6-bit emoji indices.
+func (s SAS) indices() [sasEmojiCount]int {
+	n := binary.BigEndian.Uint64(append([]byte{0, 0}, s.bits[:]...)) >> 6 // 42 bits
+
+	var out [sasEmojiCount]int
+
+	for i := range out {
+		out[i] = int(n >> (6 * (sasEmojiCount - 1 - i)) & 0x3f)
+	}
+
+	return out
+}
+
+// String returns the digit form of the string.
+func (s SAS) String() string {
+	return s.Digits()
+}
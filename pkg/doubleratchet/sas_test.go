@@ -0,0 +1,141 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// runSASExchange runs a short authentication string exchange between an initiator on
+// session a and a responder on session b, and returns the strings both derive.
+func runSASExchange(t *testing.T, a, b DoubleRatchet) (SAS, SAS) {
+	t.Helper()
+
+	initiator, err := NewSASExchange(a, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder, _ := NewSASExchange(b, false)
+
+	commitment, err := initiator.Commitment()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responderNonce, err := responder.Respond(commitment)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiatorNonce, initiatorSAS, err := initiator.Reveal(responderNonce)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responderSAS, err := responder.Finish(initiatorNonce)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return initiatorSAS, responderSAS
+}
+
+// TestSASExchange verifies that both peers derive the same short authentication string,
+// that each exchange derives a new one, and that peers with an attacker relaying between
+// them derive different strings.
+func TestSASExchange(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	aliceSAS, bobSAS := runSASExchange(t, alice, bob)
+
+	if aliceSAS.Digits() != bobSAS.Digits() || !slices.Equal(aliceSAS.Emoji(), bobSAS.Emoji()) || !slices.Equal(aliceSAS.Words(), bobSAS.Words()) {
+		t.Errorf("Expected matching strings, got %s and %s", aliceSAS, bobSAS)
+	}
+
+	if len(aliceSAS.Digits()) != 14 || len(aliceSAS.Emoji()) != 7 {
+		t.Errorf("Unexpected string format: %q, %v", aliceSAS.Digits(), aliceSAS.Emoji())
+	}
+
+	for i, index := range aliceSAS.Indices() {
+		if index < 0 || index >= len(sasSymbols) || sasSymbols[index].Emoji != aliceSAS.Emoji()[i] {
+			t.Errorf("Expected index %d to select emoji %s, got %d", i, aliceSAS.Emoji()[i], index)
+		}
+	}
+
+	if again, _ := runSASExchange(t, bob, alice); again.Digits() == aliceSAS.Digits() {
+		t.Error("Expected a new exchange to derive a new string")
+	}
+
+	// Mallory relays between Alice and Bob with a session on each side, answering Alice
+	// and then starting an exchange with Bob.
+	malloryPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ = New(alicePri.Bytes(), malloryPri.PublicKey().Bytes(), nil)
+	bob, _ = New(bobPri.Bytes(), malloryPri.PublicKey().Bytes(), nil)
+	toAlice, _ := New(malloryPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+	toBob, _ := New(malloryPri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	aliceSAS, _ = runSASExchange(t, alice, toAlice)
+	_, bobSAS = runSASExchange(t, toBob, bob)
+
+	if aliceSAS.Digits() == bobSAS.Digits() {
+		t.Error("Expected different strings for a relayed session")
+	}
+}
+
+// TestSASExchangeCommitment verifies that the responder refuses a nonce other than the one
+// the initiator committed to, and that the steps of the exchange are refused out of order
+// or on the wrong side.
+func TestSASExchangeCommitment(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	initiator, _ := NewSASExchange(alice, true)
+	responder, _ := NewSASExchange(bob, false)
+
+	if _, _, err := initiator.Reveal(make([]byte, sasNonceSize)); !errors.Is(err, ErrSASOutOfOrder) {
+		t.Errorf("Expected ErrSASOutOfOrder before the commitment, got %v", err)
+	}
+
+	if _, err := responder.Commitment(); !errors.Is(err, ErrSASOutOfOrder) {
+		t.Errorf("Expected ErrSASOutOfOrder for a responder's commitment, got %v", err)
+	}
+
+	commitment, _ := initiator.Commitment()
+	nonce, _ := responder.Respond(commitment)
+	revealed, _, _ := initiator.Reveal(nonce)
+
+	forged := append([]byte(nil), revealed...)
+	forged[0] ^= 1
+
+	if _, err := responder.Finish(forged); !errors.Is(err, ErrSASCommitment) {
+		t.Errorf("Expected ErrSASCommitment for another nonce, got %v", err)
+	}
+
+	if _, err := responder.Finish(revealed[:8]); !errors.Is(err, ErrSASCommitment) {
+		t.Errorf("Expected ErrSASCommitment for a short nonce, got %v", err)
+	}
+
+	if _, err := responder.Finish(revealed); err != nil {
+		t.Errorf("Expected the committed nonce to be accepted, got %v", err)
+	}
+
+	if _, err := responder.Finish(revealed); !errors.Is(err, ErrSASOutOfOrder) {
+		t.Errorf("Expected ErrSASOutOfOrder for a finished exchange, got %v", err)
+	}
+}
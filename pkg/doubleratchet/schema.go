@@ -0,0 +1,98 @@
+package doubleratchet
+
+import (
+	"encoding/json"
+)
+
+var (
+	// ErrMalformedJSONKey is returned when decoding a JSON state whose root, chain or
+	// message key is not 32 bytes of standard base64.
+	ErrMalformedJSONKey = newError(ErrState, "double ratchet: malformed key in JSON state")
+)
+
+// jsonKey is the JSON encoding of a root, chain or skipped message key: a base64 string,
+// like every other byte field of the schema. States written before the encoding was fixed
+// hold an array of 32 numbers instead, which is still accepted.
+type jsonKey [32]byte
+
+// MarshalJSON encodes the key as a base64 string.
+func (k jsonKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k[:])
+}
+
+// UnmarshalJSON decodes a base64 string or a legacy array of numbers.
+func (k *jsonKey) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, (*[32]byte)(k))
+	}
+
+	var b []byte
+
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+
+	defer clear(b)
+
+	if len(b) != len(k) {
+		return ErrMalformedJSONKey
+	}
+
+	copy(k[:], b)
+
+	return nil
+}
+
+// MarshalJSON encodes the state as a JSON object with the keys documented on State.
+func (s State) MarshalJSON() ([]byte, error) {
+	type state State
+
+	// The key fields shadow those of the embedded state.
+	return json.Marshal(struct {
+		RootKey      jsonKey `json:"RootKey"`
+		SendChainKey jsonKey `json:"SendChainKey"`
+		RecvChainKey jsonKey `json:"RecvChainKey"`
+		state
+	}{jsonKey(s.RootKey), jsonKey(s.SendChainKey), jsonKey(s.RecvChainKey), state(s)})
+}
+
+// UnmarshalJSON decodes a state encoded by MarshalJSON, or by versions that encoded the
+// keys as arrays of numbers.
+func (s *State) UnmarshalJSON(data []byte) error {
+	type state State
+
+	v := struct {
+		RootKey      *jsonKey `json:"RootKey"`
+		SendChainKey *jsonKey `json:"SendChainKey"`
+		RecvChainKey *jsonKey `json:"RecvChainKey"`
+		*state
+	}{(*jsonKey)(&s.RootKey), (*jsonKey)(&s.SendChainKey), (*jsonKey)(&s.RecvChainKey), (*state)(s)}
+
+	return json.Unmarshal(data, &v)
+}
+
+// MarshalJSON encodes the skipped key with its key as a base64 string.
+func (sk SkippedMessageKey) MarshalJSON() ([]byte, error) {
+	type skipped SkippedMessageKey
+
+	return json.Marshal(struct {
+		skipped
+		Key jsonKey `json:"Key"`
+	}{skipped(sk), jsonKey(sk.Key)})
+}
+
+// UnmarshalJSON decodes a skipped key encoded by MarshalJSON or by earlier versions.
+func (sk *SkippedMessageKey) UnmarshalJSON(data []byte) error {
+	type skipped SkippedMessageKey
+
+	v := struct {
+		*skipped
+		Key *jsonKey `json:"Key"`
+	}{(*skipped)(sk), (*jsonKey)(&sk.Key)}
+
+	return json.Unmarshal(data, &v)
+}
@@ -0,0 +1,65 @@
+package doubleratchet
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestStateJSONSchema verifies that keys are encoded as base64 strings under their
+// documented names, that states with keys encoded as arrays of numbers still decode, and
+// that a key of the wrong size is rejected.
+func TestStateJSONSchema(t *testing.T) {
+	state := testState()
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"RootKey", "SendChainKey", "RecvChainKey", "SendN", "RecvN", "PrevN", "Epoch", "LocalPri", "Usage", "SkippedKeys"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("Expected the field %s to be present", name)
+		}
+	}
+
+	if fields["RootKey"][0] != '"' {
+		t.Errorf("Expected RootKey to be a base64 string, got %s", fields["RootKey"])
+	}
+
+	var decoded State
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.RootKey != state.RootKey || decoded.SkippedKeys[0].Key != state.SkippedKeys[0].Key {
+		t.Errorf("Expected the keys to survive a round trip")
+	}
+
+	legacy, _ := json.Marshal(state.RootKey)
+
+	var old State
+
+	if err := json.Unmarshal([]byte(`{"RootKey":`+string(legacy)+`,"SkippedKeys":[{"Key":`+string(legacy)+`}]}`), &old); err != nil {
+		t.Fatal(err)
+	}
+
+	if old.RootKey != state.RootKey || old.SkippedKeys[0].Key != state.RootKey {
+		t.Errorf("Expected keys encoded as arrays to decode")
+	}
+
+	short := strings.Replace(string(data), string(fields["RootKey"]), `"AAAA"`, 1)
+
+	if err := json.Unmarshal([]byte(short), new(State)); !errors.Is(err, ErrMalformedJSONKey) {
+		t.Errorf("Expected ErrMalformedJSONKey, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+const (
+	// KEKSize is the size of a key-encryption key for SerializeEncrypted.
+	KEKSize = 32
+
+	// sealedVersion is the first byte of every encrypted state.
+	sealedVersion = 1
+)
+
+var (
+	// ErrInvalidKEK is returned when a key-encryption key is not KEKSize bytes long.
+	ErrInvalidKEK = errors.New("double ratchet: key-encryption key must be 32 bytes")
+
+	// ErrMalformedSealedState is returned by DeserializeEncrypted when the encrypted state
+	// cannot be parsed or decrypted, for example under the wrong key.
+	ErrMalformedSealedState = newError(ErrState, "double ratchet: malformed encrypted state")
+
+	// ErrSessionMismatch is returned by DeserializeEncrypted when the encrypted state
+	// belongs to another session than the one asked for.
+	ErrSessionMismatch = newError(ErrState, "double ratchet: encrypted state belongs to another session")
+)
+
+// sealedLabel domain-separates the state encryption key from other uses of the KEK.
+var sealedLabel = []byte("DoubleRatchet-StateKEK")
+
+// SerializeEncrypted is like Serialize, but encrypts the state with AES-256-GCM under a
+// key derived from kek, a KEKSize-byte key-encryption key such as one kept in the
+// operating system's keystore.
+//
+// The session ID is stored in the clear in front of the ciphertext and authenticated as
+// associated data, so that a stored state cannot be swapped for the state of another
+// session encrypted under the same key without DeserializeEncrypted noticing.
+func (d *doubleRatchet) SerializeEncrypted(kek []byte) ([]byte, error) {
+	if len(kek) != KEKSize {
+		return nil, ErrInvalidKEK
+	}
+
+	d.lock()
+	defer d.unlock()
+
+	if d.closed {
+		return nil, ErrSessionClosed
+	}
+
+	state, err := d.serialize()
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := sealedHeader(d.sessionID)
+
+	mk, err := sealedKey(kek)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := crypto.Encrypt(mk, state, header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, sealed...), nil
+}
+
+// DeserializeEncrypted restores a session from a state encrypted by SerializeEncrypted.
+// sessionID is the ID of the session the caller expects, nil for sessions restored from
+// states written before IDs were recorded; the state of any other session is refused
+// with ErrSessionMismatch. opts configure the restored session as they do for
+// Deserialize.
+func DeserializeEncrypted(data, kek, sessionID []byte, opts ...Option) (*doubleRatchet, error) {
+	if len(kek) != KEKSize {
+		return nil, ErrInvalidKEK
+	}
+
+	if len(data) < 2 || data[0] != sealedVersion || len(data) < 2+int(data[1]) {
+		return nil, ErrMalformedSealedState
+	}
+
+	header := data[:2+int(data[1])]
+
+	if !bytes.Equal(header[2:], sessionID) {
+		return nil, ErrSessionMismatch
+	}
+
+	mk, err := sealedKey(kek)
+
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := crypto.Decrypt(mk, data[len(header):], header)
+
+	if err != nil {
+		return nil, ErrMalformedSealedState
+	}
+
+	defer clear(state)
+
+	d, err := Deserialize(state, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(d.sessionID, sessionID) {
+		return nil, ErrSessionMismatch
+	}
+
+	return d, nil
+}
+
+// sealedHeader returns the version byte, the session ID length and the session ID, which
+// SerializeEncrypted authenticates as associated data.
+func sealedHeader(sessionID []byte) []byte {
+	header := make([]byte, 0, 2+len(sessionID))
+	header = append(header, sealedVersion, byte(len(sessionID))) // #nosec G115 -- session IDs are SessionIDSize bytes
+
+	return append(header, sessionID...)
+}
+
+// sealedKey derives the state encryption key from the key-encryption key.
+func sealedKey(kek []byte) (crypto.MessageKey, error) {
+	var mk crypto.MessageKey
+
+	derived, err := crypto.DeriveHKDF(kek, nil, sealedLabel, crypto.MessageKeySize)
+
+	if err != nil {
+		return mk, err
+	}
+
+	copy(mk[:], derived)
+	clear(derived)
+
+	return mk, nil
+}
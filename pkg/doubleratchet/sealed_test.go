@@ -0,0 +1,72 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestSerializeEncrypted verifies that an encrypted state restores a working session, and
+// that it is refused under the wrong key, for the wrong session or after its session ID
+// was altered.
+func TestSerializeEncrypted(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	otherPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+	other, _ := New(otherPri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	kek := bytes.Repeat([]byte{7}, KEKSize)
+
+	if _, err := alice.SerializeEncrypted(kek[:16]); !errors.Is(err, ErrInvalidKEK) {
+		t.Errorf("Expected ErrInvalidKEK, got %v", err)
+	}
+
+	sealed, err := alice.SerializeEncrypted(kek)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, []byte("RootKey")) {
+		t.Error("Expected the state not to appear in the clear")
+	}
+
+	restored, err := DeserializeEncrypted(sealed, kek, alice.SessionID())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := restored.Send([]byte("hello"), nil)
+
+	if got, err := bob.Receive(msg, nil); err != nil || string(got.Plaintext) != "hello" {
+		t.Fatalf("Expected the restored session to work, got %q, %v", got.Plaintext, err)
+	}
+
+	wrongKEK := bytes.Repeat([]byte{8}, KEKSize)
+
+	if _, err := DeserializeEncrypted(sealed, wrongKEK, alice.SessionID()); !errors.Is(err, ErrMalformedSealedState) {
+		t.Errorf("Expected ErrMalformedSealedState under the wrong key, got %v", err)
+	}
+
+	if _, err := DeserializeEncrypted(sealed, kek, other.SessionID()); !errors.Is(err, ErrSessionMismatch) {
+		t.Errorf("Expected ErrSessionMismatch for another session, got %v", err)
+	}
+
+	// Relabel the state with the other session's ID: the ID is authenticated, so the
+	// swap is detected even though the caller asks for the other session.
+	swapped := append(sealedHeader(other.SessionID()), sealed[2+SessionIDSize:]...)
+
+	if _, err := DeserializeEncrypted(swapped, kek, other.SessionID()); !errors.Is(err, ErrMalformedSealedState) {
+		t.Errorf("Expected ErrMalformedSealedState for a relabeled state, got %v", err)
+	}
+
+	if _, err := DeserializeEncrypted(sealed[:1], kek, alice.SessionID()); !errors.Is(err, ErrMalformedSealedState) {
+		t.Errorf("Expected ErrMalformedSealedState for truncated data, got %v", err)
+	}
+}
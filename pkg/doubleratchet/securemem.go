@@ -0,0 +1,120 @@
+package doubleratchet
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// chainKeysSize is the size of chainKeys.
+const chainKeysSize = 3 * crypto.ChainKeySize
+
+var (
+	// ErrSecureMemory is returned by New, Deserialize and the other constructors when the
+	// memory of WithSecureMemory cannot be allocated, for example because the process may
+	// not lock more memory.
+	ErrSecureMemory = errors.New("double ratchet: secure memory unavailable")
+
+	// ErrSecureMemoryUnsupported is returned by the allocator of NewLockedMemory on
+	// platforms where it cannot lock memory.
+	ErrSecureMemoryUnsupported = errors.New("double ratchet: locked memory not supported on this platform")
+)
+
+// chainKeys holds the session's root and chain keys. It holds no pointers, so it can live
+// in memory the garbage collector does not manage.
+type chainKeys struct {
+	rootKey      crypto.ChainKey
+	sendChainKey crypto.ChainKey
+	recvChainKey crypto.ChainKey
+}
+
+// SecureMemory allocates memory for session keys outside the garbage-collected heap, such
+// as pages locked into RAM or an enclave mapped into the process. Implementations must be
+// safe for concurrent use.
+type SecureMemory interface {
+	// Alloc returns size bytes of zeroed memory that stays valid until it is passed to
+	// Free.
+	Alloc(size int) ([]byte, error)
+
+	// Free erases and releases memory returned by Alloc.
+	Free(b []byte) error
+}
+
+// WithSecureMemory keeps the session's root key and chain keys in memory allocated from
+// mem, for example NewLockedMemory's, so that they are not swapped to disk or written to
+// core dumps. The memory is released when the session is closed, or when it is garbage
+// collected if it never is.
+//
+// Message keys live only for the duration of a Send or Receive, but skipped and
+// pre-derived message keys, and copies of the session made with Snapshot, stay in
+// ordinary memory; copies made with Clone allocate their own secure memory. The ratchet private keys of crypto/ecdh cannot be placed in secure
+// memory; give WithDH a PrivateKey held in a token or enclave (see Destroyer) to keep them
+// out of process memory as well.
+func WithSecureMemory(mem SecureMemory) Option {
+	return func(d *doubleRatchet) error {
+		d.secureMemory = mem
+		return nil
+	}
+}
+
+// securedKeys is memory of a SecureMemory holding a session's chainKeys.
+type securedKeys struct {
+	mem SecureMemory
+	buf []byte
+}
+
+// free erases and releases the memory.
+func (s *securedKeys) free() {
+	runtime.SetFinalizer(s, nil)
+
+	_ = s.mem.Free(s.buf)
+}
+
+// secureKeys moves the session's root and chain keys into memory of the session's
+// SecureMemory, if it has one. The caller must own d.
+func (d *doubleRatchet) secureKeys() error {
+	if d.secureMemory == nil {
+		return nil
+	}
+
+	buf, err := d.secureMemory.Alloc(chainKeysSize)
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSecureMemory, err)
+	}
+
+	if len(buf) < chainKeysSize {
+		_ = d.secureMemory.Free(buf)
+		return ErrSecureMemory
+	}
+
+	// chainKeys holds only byte arrays, so any address is aligned for it.
+	keys := (*chainKeys)(unsafe.Pointer((*[chainKeysSize]byte)(buf)))
+
+	*keys = *d.chainKeys
+	*d.chainKeys = chainKeys{}
+
+	d.chainKeys = keys
+	d.secured = &securedKeys{mem: d.secureMemory, buf: buf}
+
+	runtime.SetFinalizer(d.secured, (*securedKeys).free)
+
+	return nil
+}
+
+// releaseKeys erases the root and chain keys and releases their secure memory, if any,
+// leaving the session with zero keys in ordinary memory. The caller must hold the lock.
+func (d *doubleRatchet) releaseKeys() {
+	*d.chainKeys = chainKeys{}
+
+	if d.secured == nil {
+		return
+	}
+
+	d.chainKeys = &chainKeys{}
+	d.secured.free()
+	d.secured = nil
+}
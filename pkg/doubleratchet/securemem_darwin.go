@@ -0,0 +1,6 @@
+package doubleratchet
+
+// excludeFromDumps does nothing: macOS has no way to exclude pages from core dumps.
+func excludeFromDumps([]byte) error {
+	return nil
+}
@@ -0,0 +1,11 @@
+package doubleratchet
+
+import "syscall"
+
+// madvDontDump is MADV_DONTDUMP, which the syscall package does not define.
+const madvDontDump = 0x10
+
+// excludeFromDumps keeps b out of core dumps.
+func excludeFromDumps(b []byte) error {
+	return syscall.Madvise(b, madvDontDump)
+}
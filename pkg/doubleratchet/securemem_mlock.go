@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+package doubleratchet
+
+import "syscall"
+
+// lockedMemory allocates anonymous pages locked into RAM.
+type lockedMemory struct{}
+
+// NewLockedMemory returns a SecureMemory that maps fresh pages for each allocation and
+// locks them into RAM with mlock, so they are never swapped to disk; on Linux they are
+// also excluded from core dumps. Each session uses a page of its own, which counts
+// against the process's limit on locked memory (RLIMIT_MEMLOCK). On platforms other than
+// Linux and macOS, every allocation fails with ErrSecureMemoryUnsupported.
+func NewLockedMemory() SecureMemory {
+	return lockedMemory{}
+}
+
+// Alloc maps and locks size bytes.
+func (lockedMemory) Alloc(size int) ([]byte, error) {
+	b, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Mlock(b); err != nil {
+		_ = syscall.Munmap(b)
+		return nil, err
+	}
+
+	if err := excludeFromDumps(b); err != nil {
+		_ = syscall.Munmap(b)
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Free erases, unlocks and unmaps b.
+func (lockedMemory) Free(b []byte) error {
+	clear(b)
+
+	if err := syscall.Munlock(b); err != nil {
+		return err
+	}
+
+	return syscall.Munmap(b)
+}
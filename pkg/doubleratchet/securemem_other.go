@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+
+package doubleratchet
+
+// lockedMemory fails every allocation on platforms where it cannot lock memory.
+type lockedMemory struct{}
+
+// NewLockedMemory returns a SecureMemory that maps fresh pages for each allocation and
+// locks them into RAM with mlock, so they are never swapped to disk; on Linux they are
+// also excluded from core dumps. On this platform, every allocation fails with
+// ErrSecureMemoryUnsupported.
+func NewLockedMemory() SecureMemory {
+	return lockedMemory{}
+}
+
+// Alloc fails with ErrSecureMemoryUnsupported.
+func (lockedMemory) Alloc(int) ([]byte, error) {
+	return nil, ErrSecureMemoryUnsupported
+}
+
+// Free does nothing.
+func (lockedMemory) Free([]byte) error {
+	return nil
+}
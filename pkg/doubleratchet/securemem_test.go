@@ -0,0 +1,206 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// countingMemory is a SecureMemory on the heap that counts live allocations.
+type countingMemory struct {
+	mu   sync.Mutex
+	live int
+	err  error
+}
+
+func (m *countingMemory) Alloc(size int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.live++
+
+	return make([]byte, size), nil
+}
+
+func (m *countingMemory) Free(b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(b)
+	m.live--
+
+	return nil
+}
+
+func (m *countingMemory) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.live
+}
+
+// TestSecureMemory verifies that a session with WithSecureMemory keeps its chain keys in
+// memory of the allocator, exchanges and restores messages as usual, and releases the
+// memory with Close.
+func TestSecureMemory(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	mem := &countingMemory{}
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSecureMemory(mem))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if mem.count() != 1 {
+		t.Fatalf("Expected 1 allocation, got %d", mem.count())
+	}
+
+	if &alice.secured.buf[0] != &alice.rootKey[0] {
+		t.Errorf("Expected the root key to live in secure memory")
+	}
+
+	for i := 0; i < 3; i++ {
+		msg, _ := alice.Send([]byte("ping"), nil)
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, _ := bob.Send([]byte("pong"), nil)
+
+		if _, err := alice.Receive(reply, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, _ := alice.Serialize()
+	restored, err := Deserialize(data, WithSecureMemory(mem))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.rootKey != alice.rootKey {
+		t.Errorf("Expected the restored root key to match")
+	}
+
+	if mem.count() != 2 {
+		t.Errorf("Expected 2 allocations, got %d", mem.count())
+	}
+
+	_ = alice.Close()
+	_ = restored.Close()
+
+	if mem.count() != 0 {
+		t.Errorf("Expected Close to free the memory, got %d live allocations", mem.count())
+	}
+
+	if snap := alice.Snapshot(); snap.state.rootKey != [32]byte{} {
+		t.Errorf("Expected a closed session to hold no keys")
+	}
+}
+
+// TestSecureMemoryFailure verifies that a session cannot be created when its secure
+// memory cannot be allocated.
+func TestSecureMemoryFailure(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	cause := errors.New("out of locked memory")
+
+	_, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSecureMemory(&countingMemory{err: cause}))
+
+	if !errors.Is(err, ErrSecureMemory) || !errors.Is(err, cause) {
+		t.Errorf("Expected ErrSecureMemory wrapping the cause, got %v", err)
+	}
+}
+
+// TestLockedMemory verifies that sessions work with NewLockedMemory where the platform
+// and the process's limits allow locking memory.
+func TestLockedMemory(t *testing.T) {
+	mem := NewLockedMemory()
+	probe, err := mem.Alloc(chainKeysSize)
+
+	if err != nil {
+		t.Skipf("Locked memory unavailable: %v", err)
+	}
+
+	_ = mem.Free(probe)
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, err := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSecureMemory(mem))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithSecureMemory(mem))
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if got, err := bob.Receive(msg, nil); err != nil || string(got.Plaintext) != "hello" {
+		t.Errorf("Expected hello, got %q, %v", got.Plaintext, err)
+	}
+
+	if err := alice.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err := bob.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSecureMemoryClone verifies that a copy of a session with WithSecureMemory keeps its
+// chain keys in its own memory of the allocator, and that a copy whose memory cannot be
+// allocated is closed.
+func TestSecureMemoryClone(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	mem := &countingMemory{}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSecureMemory(mem))
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	clone := alice.Clone().(*doubleRatchet)
+
+	if mem.count() != 2 {
+		t.Fatalf("Expected 2 allocations, got %d", mem.count())
+	}
+
+	if clone.secured == nil || &clone.secured.buf[0] != &clone.rootKey[0] || &clone.rootKey[0] == &alice.rootKey[0] {
+		t.Errorf("Expected the copy's root key to live in its own secure memory")
+	}
+
+	msg, _ := clone.Send([]byte("hello"), nil)
+
+	if got, err := bob.Receive(msg, nil); err != nil || string(got.Plaintext) != "hello" {
+		t.Errorf("Expected hello, got %q, %v", got.Plaintext, err)
+	}
+
+	_ = clone.Close()
+
+	if mem.count() != 1 {
+		t.Errorf("Expected Close to free the copy's memory, got %d live allocations", mem.count())
+	}
+
+	mem.err = errors.New("out of locked memory")
+
+	if _, err := alice.Clone().Send([]byte("hello"), nil); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// Format tags of the built-in serializers. The JSON tag is the opening brace of the JSON
+// object, so states written before serializers were pluggable are recognized as JSON.
+const (
+	FormatJSON   byte = '{'
+	FormatGob    byte = 'G'
+	FormatBinary byte = 'B'
+	FormatProto  byte = 'P'
+	FormatCBOR   byte = 'C'
+)
+
+var (
+	// ErrUnknownFormat is returned by Deserialize when the state's format tag does not
+	// match any registered serializer.
+	ErrUnknownFormat = newError(ErrState, "double ratchet: unknown state format")
+
+	// ErrFormatTaken is returned by RegisterSerializer when the format tag is already used.
+	ErrFormatTaken = errors.New("double ratchet: state format tag already registered")
+)
+
+// Serializer encodes session state for persistence. The first byte of every encoding
+// must be the serializer's Format tag, which Deserialize uses to pick the serializer, so
+// states written with different serializers can be read side by side.
+type Serializer interface {
+	// Format returns the tag that starts every encoding produced by Marshal.
+	Format() byte
+
+	// Marshal encodes state, starting with the format tag.
+	Marshal(state State) ([]byte, error)
+
+	// Unmarshal decodes data, including its format tag, into state. The byte slices of
+	// state must not share memory with data: Deserialize erases the decoded state after
+	// use.
+	Unmarshal(data []byte, state *State) error
+}
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = map[byte]Serializer{
+		FormatJSON:   JSONSerializer{},
+		FormatGob:    GobSerializer{},
+		FormatBinary: BinarySerializer{},
+		FormatProto:  ProtoSerializer{},
+		FormatCBOR:   CBORSerializer{},
+	}
+)
+
+// RegisterSerializer makes a serializer, such as an encoding of your own, available to
+// Deserialize. It is typically called from an init function.
+func RegisterSerializer(s Serializer) error {
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+
+	if _, ok := serializers[s.Format()]; ok {
+		return ErrFormatTaken
+	}
+
+	serializers[s.Format()] = s
+
+	return nil
+}
+
+// WithSerializer selects the encoding used by Serialize and Handoff. The default is JSON.
+// Passed to Deserialize, it migrates the restored session to the given encoding.
+func WithSerializer(s Serializer) Option {
+	return func(d *doubleRatchet) error {
+		d.serializer = s
+		return nil
+	}
+}
+
+// JSONSerializer encodes state as JSON. It is the default.
+type JSONSerializer struct{}
+
+// Format returns FormatJSON.
+func (JSONSerializer) Format() byte {
+	return FormatJSON
+}
+
+// Marshal encodes state as a JSON object.
+func (JSONSerializer) Marshal(state State) ([]byte, error) {
+	return json.Marshal(state)
+}
+
+// Unmarshal decodes a JSON object.
+func (JSONSerializer) Unmarshal(data []byte, state *State) error {
+	return json.Unmarshal(data, state)
+}
+
+// GobSerializer encodes state in the compact binary encoding/gob format, prefixed with
+// FormatGob.
+type GobSerializer struct{}
+
+// Format returns FormatGob.
+func (GobSerializer) Format() byte {
+	return FormatGob
+}
+
+// Marshal encodes state with encoding/gob.
+func (GobSerializer) Marshal(state State) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{FormatGob})
+
+	if err := gob.NewEncoder(buf).Encode(state); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a gob encoding.
+func (GobSerializer) Unmarshal(data []byte, state *State) error {
+	if len(data) == 0 || data[0] != FormatGob {
+		return ErrUnknownFormat
+	}
+
+	return gob.NewDecoder(bytes.NewReader(data[1:])).Decode(state)
+}
+
+// decodeState decodes a serialized state with the serializer named by its format tag.
+func decodeState(data []byte) (State, Serializer, error) {
+	var state State
+
+	if len(data) == 0 {
+		return state, nil, ErrUnknownFormat
+	}
+
+	serializersMu.RLock()
+	s, ok := serializers[data[0]]
+	serializersMu.RUnlock()
+
+	if !ok {
+		return state, nil, ErrUnknownFormat
+	}
+
+	if err := s.Unmarshal(data, &state); err != nil {
+		return state, nil, err
+	}
+
+	return state, s, nil
+}
@@ -0,0 +1,106 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestSerializerRoundTrip verifies that a session serialized with each built-in
+// serializer starts with its format tag, is restored by Deserialize without being told
+// the format, and keeps its encoding across further serializations.
+func TestSerializerRoundTrip(t *testing.T) {
+	for _, s := range []Serializer{JSONSerializer{}, GobSerializer{}, BinarySerializer{}, ProtoSerializer{}, CBORSerializer{}} {
+		alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+		bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+		alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSerializer(s))
+		bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+		for range 2 {
+			_, _ = bob.Send([]byte("skipped"), nil)
+		}
+
+		msg, _ := bob.Send([]byte("hello"), nil)
+
+		if _, err := alice.Receive(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := alice.Serialize()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data[0] != s.Format() {
+			t.Errorf("Expected format tag %q, got %q", s.Format(), data[0])
+		}
+
+		restored, err := Deserialize(data)
+
+		if err != nil {
+			t.Fatalf("Deserialize failed for format %q: %v", s.Format(), err)
+		}
+
+		if restored.SkippedKeys().Count != 2 {
+			t.Errorf("Expected 2 skipped keys after restoring format %q, got %d", s.Format(), restored.SkippedKeys().Count)
+		}
+
+		again, _ := restored.Serialize()
+
+		if again[0] != s.Format() {
+			t.Errorf("Expected the restored session to keep format %q, got %q", s.Format(), again[0])
+		}
+
+		reply, _ := restored.Send([]byte("reply"), nil)
+
+		if _, err := bob.Receive(reply, nil); err != nil {
+			t.Errorf("Expected the restored session to keep working, got %v", err)
+		}
+	}
+}
+
+// TestSerializerMigration verifies that passing WithSerializer to Deserialize re-encodes
+// the session in the new format.
+func TestSerializerMigration(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	data, _ := alice.Serialize()
+
+	restored, err := Deserialize(data, WithSerializer(GobSerializer{}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, _ := restored.Serialize()
+
+	if migrated[0] != FormatGob {
+		t.Errorf("Expected format tag %q after migration, got %q", FormatGob, migrated[0])
+	}
+
+	if _, err := CompareStates(data, migrated); err != nil {
+		t.Errorf("Expected states in different formats to be comparable, got %v", err)
+	}
+}
+
+// TestSerializerUnknownFormat verifies that states with an unregistered format tag are
+// rejected, and that a format tag cannot be registered twice.
+func TestSerializerUnknownFormat(t *testing.T) {
+	if _, err := Deserialize([]byte("X...")); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+
+	if _, err := Deserialize(nil); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat for empty state, got %v", err)
+	}
+
+	if err := RegisterSerializer(GobSerializer{}); !errors.Is(err, ErrFormatTaken) {
+		t.Errorf("Expected ErrFormatTaken, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package doubleratchet
+
+import (
+	"bytes"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// SessionIDSize is the size of a session ID in bytes.
+const SessionIDSize = 16
+
+// SessionID returns a stable identifier of the session that both peers derive from the
+// initial key material, so that stores, logs and metrics can refer to the session without
+// inventing their own IDs. It does not change with DH ratchet steps and is kept in the
+// serialized state.
+//
+// Sessions created with New derive it from both initial public keys, and sessions created
+// with InitAlice and InitBob from the shared secret. It is not secret. Sessions restored
+// from states written before IDs were recorded return nil.
+func (d *doubleRatchet) SessionID() []byte {
+	d.lock()
+	defer d.unlock()
+
+	return append([]byte(nil), d.sessionID...)
+}
+
+// deriveSessionID sets the session ID from the two initial public keys, ordered so that
+// both peers derive the same ID.
+func (d *doubleRatchet) deriveSessionID(localPub, remotePub []byte) error {
+	if bytes.Compare(localPub, remotePub) > 0 {
+		localPub, remotePub = remotePub, localPub
+	}
+
+	ikm := append(append([]byte(nil), localPub...), remotePub...)
+
+	id, err := crypto.DeriveHKDFWith(d.hash(), ikm, nil, d.info("-SessionID"), SessionIDSize)
+
+	if err != nil {
+		return err
+	}
+
+	d.sessionID = id
+
+	return nil
+}
+
+// deriveSessionIDFromSecret sets the session ID from the shared secret given to
+// InitAlice and InitBob.
+func (d *doubleRatchet) deriveSessionIDFromSecret(sk []byte) error {
+	id, err := crypto.DeriveHKDFWith(d.hash(), sk, nil, d.info("-SessionID"), SessionIDSize)
+
+	if err != nil {
+		return err
+	}
+
+	d.sessionID = id
+
+	return nil
+}
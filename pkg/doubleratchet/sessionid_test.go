@@ -0,0 +1,54 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// TestSessionID verifies that both peers derive the same session ID, whether they were
+// created with New or with InitAlice and InitBob, that it survives DH ratchet steps and
+// serialization, and that different sessions get different IDs.
+func TestSessionID(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	id := alice.SessionID()
+
+	if len(id) != SessionIDSize || !bytes.Equal(id, bob.SessionID()) {
+		t.Fatalf("Expected both peers to derive the same %d-byte ID, got %x and %x", SessionIDSize, id, bob.SessionID())
+	}
+
+	stepSendingRatchet(t, alice)
+
+	msg, _ := alice.Send([]byte("hello"), nil)
+	bob.Receive(msg, nil)
+
+	data, _ := bob.Serialize()
+	restored, err := Deserialize(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(alice.SessionID(), id) || !bytes.Equal(restored.SessionID(), id) {
+		t.Error("Expected the ID to survive ratchet steps and serialization")
+	}
+
+	sk := make([]byte, 32)
+	rand.Read(sk)
+
+	responder, _ := InitBob(sk, bobPri.Bytes())
+	initiator, _ := InitAlice(sk, bobPri.PublicKey().Bytes())
+
+	if !bytes.Equal(initiator.SessionID(), responder.SessionID()) {
+		t.Error("Expected InitAlice and InitBob to derive the same ID")
+	}
+
+	if bytes.Equal(initiator.SessionID(), id) {
+		t.Error("Expected different sessions to get different IDs")
+	}
+}
@@ -0,0 +1,246 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoSessionID is returned by Deserialize with WithSkippedKeyStore for a state
+	// written before session IDs were recorded, whose keys the store could not tell apart
+	// from other sessions' keys.
+	ErrNoSessionID = errors.New("double ratchet: skipped key store requires a session ID")
+)
+
+// SkippedKeyStore keeps the skipped message keys of sessions outside their serialized
+// state, so that deployments can persist them separately from the session record and
+// delete them independently, for example to expire old keys in bulk. Keys are identified
+// by the DH, N and PN fields of their Header; the other header fields are not set.
+// Implementations must be safe for concurrent use.
+type SkippedKeyStore interface {
+	// Put stores keys for the session sessionID, replacing keys with the same header.
+	Put(ctx context.Context, sessionID []byte, keys []SkippedMessageKey) error
+
+	// Delete removes the keys of the session with the given headers. Deleting a missing
+	// key is not an error.
+	Delete(ctx context.Context, sessionID []byte, headers []Header) error
+
+	// List returns all keys stored for the session, in any order.
+	List(ctx context.Context, sessionID []byte) ([]SkippedMessageKey, error)
+}
+
+// WithSkippedKeyStore keeps the session's skipped message keys in store instead of its
+// serialized state. The session still holds the keys in memory; every Receive writes the
+// keys it stored or consumed to the store before it returns, and a receive whose changes
+// cannot be written fails with ErrStoreFailed and leaves the session as it was.
+//
+// Deserialize loads the session's keys from the store, and moves any keys still recorded
+// in the state there, so existing sessions can be migrated by restoring them with this
+// option. Restore writes the keys it changes back to the store. Keys deleted from the
+// store while a session is in memory stay usable until it is restored again. Copies made
+// with Clone keep their keys in memory only.
+func WithSkippedKeyStore(store SkippedKeyStore) Option {
+	return func(d *doubleRatchet) error {
+		d.skippedStore = store
+		return nil
+	}
+}
+
+// flushSkipped writes the current values of the skipped keys ids to the skipped key
+// store: keys the session holds are put, the others deleted. The caller must hold the
+// lock.
+func (d *doubleRatchet) flushSkipped(ctx context.Context, ids []headerID) error {
+	if d.skippedStore == nil || len(ids) == 0 {
+		return nil
+	}
+
+	var puts []SkippedMessageKey
+	var deletes []Header
+
+	for _, id := range ids {
+		if sk, ok := d.skippedMessageKeys[id]; ok {
+			puts = append(puts, skippedEntry(id, sk))
+		} else {
+			deletes = append(deletes, Header{DH: []byte(id.dh), N: id.n, PN: id.pn})
+		}
+	}
+
+	if len(puts) != 0 {
+		if err := d.skippedStore.Put(ctx, d.sessionID, puts); err != nil {
+			return fmt.Errorf("%w: %w", ErrStoreFailed, err)
+		}
+	}
+
+	if len(deletes) != 0 {
+		if err := d.skippedStore.Delete(ctx, d.sessionID, deletes); err != nil {
+			return fmt.Errorf("%w: %w", ErrStoreFailed, err)
+		}
+	}
+
+	return nil
+}
+
+// loadSkipped moves the skipped keys restored from a state to the skipped key store and
+// then loads all of the session's keys from it. The caller must hold the lock or own d.
+func (d *doubleRatchet) loadSkipped(ctx context.Context) error {
+	if d.skippedStore == nil {
+		return nil
+	}
+
+	if len(d.sessionID) == 0 {
+		return ErrNoSessionID
+	}
+
+	ids := make([]headerID, 0, len(d.skippedMessageKeys))
+
+	for id := range d.skippedMessageKeys {
+		ids = append(ids, id)
+	}
+
+	if err := d.flushSkipped(ctx, ids); err != nil {
+		return err
+	}
+
+	keys, err := d.skippedStore.List(ctx, d.sessionID)
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreFailed, err)
+	}
+
+	for _, sk := range keys {
+		d.skippedMessageKeys[sk.Header.key()] = fromSkippedEntry(sk)
+	}
+
+	return nil
+}
+
+// skippedEntry returns the serialized form of a skipped key.
+func skippedEntry(id headerID, sk skippedKey) SkippedMessageKey {
+	entry := SkippedMessageKey{
+		Header: Header{DH: []byte(id.dh), N: id.n, PN: id.pn},
+		Key:    sk.key,
+		Epoch:  sk.epoch,
+	}
+
+	if !sk.stored.IsZero() {
+		entry.StoredAt = sk.stored.Unix()
+	}
+
+	return entry
+}
+
+// fromSkippedEntry returns the skipped key of a serialized entry.
+func fromSkippedEntry(entry SkippedMessageKey) skippedKey {
+	sk := skippedKey{key: entry.Key, epoch: entry.Epoch}
+
+	if entry.StoredAt != 0 {
+		sk.stored = time.Unix(entry.StoredAt, 0)
+	}
+
+	return sk
+}
+
+// MemorySkippedKeyStore is an in-memory SkippedKeyStore, for tests and for deployments
+// that keep skipped keys in a process separate from the session records.
+type MemorySkippedKeyStore struct {
+	mu       sync.Mutex
+	sessions map[string]map[headerID]SkippedMessageKey
+}
+
+// NewMemorySkippedKeyStore returns an empty in-memory skipped key store.
+func NewMemorySkippedKeyStore() *MemorySkippedKeyStore {
+	return &MemorySkippedKeyStore{sessions: make(map[string]map[headerID]SkippedMessageKey)}
+}
+
+// Put stores keys for the session.
+func (s *MemorySkippedKeyStore) Put(ctx context.Context, sessionID []byte, keys []SkippedMessageKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[string(sessionID)]
+
+	if !ok {
+		session = make(map[headerID]SkippedMessageKey)
+		s.sessions[string(sessionID)] = session
+	}
+
+	for _, sk := range keys {
+		sk.Header = Header{DH: bytes.Clone(sk.Header.DH), N: sk.Header.N, PN: sk.Header.PN}
+		session[sk.Header.key()] = sk
+	}
+
+	return nil
+}
+
+// Delete removes keys of the session.
+func (s *MemorySkippedKeyStore) Delete(ctx context.Context, sessionID []byte, headers []Header) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range headers {
+		delete(s.sessions[string(sessionID)], h.key())
+	}
+
+	return nil
+}
+
+// List returns the keys of the session, ordered by header.
+func (s *MemorySkippedKeyStore) List(ctx context.Context, sessionID []byte) ([]SkippedMessageKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]SkippedMessageKey, 0, len(s.sessions[string(sessionID)]))
+
+	for _, sk := range s.sessions[string(sessionID)] {
+		sk.Header.DH = bytes.Clone(sk.Header.DH)
+		keys = append(keys, sk)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].Header, keys[j].Header
+
+		if c := bytes.Compare(a.DH, b.DH); c != 0 {
+			return c < 0
+		}
+
+		if a.PN != b.PN {
+			return a.PN < b.PN
+		}
+
+		return a.N < b.N
+	})
+
+	return keys, nil
+}
+
+// DeleteSession removes all keys of the session, for example after the session record
+// was deleted.
+func (s *MemorySkippedKeyStore) DeleteSession(ctx context.Context, sessionID []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, string(sessionID))
+
+	return nil
+}
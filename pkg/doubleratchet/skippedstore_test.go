@@ -0,0 +1,99 @@
+package doubleratchet
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// failingSkippedKeyStore fails every Put.
+type failingSkippedKeyStore struct {
+	*MemorySkippedKeyStore
+}
+
+func (failingSkippedKeyStore) Put(context.Context, []byte, []SkippedMessageKey) error {
+	return errors.New("disk full")
+}
+
+// TestWithSkippedKeyStore verifies that skipped keys are kept in the store instead of the
+// serialized state, that a restored session finds them there, that existing keys are
+// moved to the store on restore, and that a failing store leaves the session unchanged.
+func TestWithSkippedKeyStore(t *testing.T) {
+	ctx := context.Background()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	store := NewMemorySkippedKeyStore()
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithSkippedKeyStore(store))
+
+	var msgs []CipheredMessage
+
+	for _, text := range []string{"one", "two", "three", "four"} {
+		msg, _ := alice.Send([]byte(text), nil)
+		msgs = append(msgs, msg)
+	}
+
+	if _, err := bob.Receive(msgs[2], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ := store.List(ctx, bob.SessionID()); len(keys) != 2 {
+		t.Fatalf("Expected 2 skipped keys in the store, got %d", len(keys))
+	}
+
+	data, _ := bob.Serialize()
+
+	var state State
+
+	if err := (JSONSerializer{}).Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.SkippedKeys) != 0 {
+		t.Errorf("Expected no skipped keys in the state, got %d", len(state.SkippedKeys))
+	}
+
+	restored, err := Deserialize(data, WithSkippedKeyStore(store))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := restored.Receive(msgs[0], nil); err != nil || string(got.Plaintext) != "one" {
+		t.Fatalf("Expected the restored session to find the skipped key, got %q, %v", got.Plaintext, err)
+	}
+
+	if keys, _ := store.List(ctx, bob.SessionID()); len(keys) != 1 {
+		t.Errorf("Expected the used key to be deleted from the store, got %d keys", len(keys))
+	}
+
+	// A session that kept its keys in the state moves them to the store on restore.
+	plain, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+	_, _ = plain.Receive(msgs[3], nil)
+	data, _ = plain.Serialize()
+
+	migrated := NewMemorySkippedKeyStore()
+
+	if _, err := Deserialize(data, WithSkippedKeyStore(migrated)); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ := migrated.List(ctx, plain.SessionID()); len(keys) != 3 {
+		t.Errorf("Expected 3 migrated keys, got %d", len(keys))
+	}
+
+	failing, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithSkippedKeyStore(failingSkippedKeyStore{NewMemorySkippedKeyStore()}))
+
+	if _, err := failing.Receive(msgs[3], nil); !errors.Is(err, ErrStoreFailed) {
+		t.Errorf("Expected ErrStoreFailed, got %v", err)
+	}
+
+	if info := failing.Info(); info.SkippedKeys != 0 || info.RecvN != 0 {
+		t.Errorf("Expected the failed receive to be rolled back, got %+v", info)
+	}
+}
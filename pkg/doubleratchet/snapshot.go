@@ -0,0 +1,135 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"context"
+)
+
+var (
+	// ErrInvalidSnapshot is returned by Restore for a zero Snapshot or a snapshot taken
+	// from a different session.
+	ErrInvalidSnapshot = newError(ErrState, "double ratchet: invalid snapshot")
+)
+
+// Snapshot is an in-memory copy of a session's ratchet state, taken with
+// DoubleRatchet.Snapshot and put back with Restore. It is much cheaper than Serialize and
+// Deserialize, but lives only as long as the process. Like the session, it holds secret
+// keys; drop it once it is no longer needed.
+type Snapshot struct {
+	state *doubleRatchet
+}
+
+// Snapshot captures the current ratchet state: keys, counters, skipped message keys,
+// usage counters and transcript. Options and subscribers are not part of it.
+//
+// The snapshot shares the ratchet private key with the session, as a Clone does. A key
+// destroyed by a DH ratchet step after the snapshot (see Destroyer) cannot be used after
+// restoring it.
+func (d *doubleRatchet) Snapshot() Snapshot {
+	d.lock()
+	defer d.unlock()
+
+	s := &doubleRatchet{sessionID: d.sessionID}
+	s.copyState(d)
+
+	return Snapshot{state: s}
+}
+
+// Restore puts the session back into the state captured by s, for example when a later
+// stage of a pipeline fails after a message was received. A snapshot can be restored any
+// number of times. Messages sent after the snapshot must be discarded: restoring reuses
+// their message keys for new messages.
+func (d *doubleRatchet) Restore(s Snapshot) error {
+	d.lock()
+	defer d.unlock()
+
+	if d.closed {
+		return ErrSessionClosed
+	}
+
+	if s.state == nil || !bytes.Equal(s.state.sessionID, d.sessionID) {
+		return ErrInvalidSnapshot
+	}
+
+	if err := d.restoreState(context.Background(), s.state); err != nil {
+		return err
+	}
+
+	return d.save(context.Background())
+}
+
+// restoreState copies the ratchet state of src into d and writes the skipped keys that
+// only one of them holds to the skipped key store. The caller must hold the lock.
+func (d *doubleRatchet) restoreState(ctx context.Context, src *doubleRatchet) error {
+	var ids []headerID
+
+	for id := range d.skippedMessageKeys {
+		if _, ok := src.skippedMessageKeys[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	for id := range src.skippedMessageKeys {
+		if _, ok := d.skippedMessageKeys[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	d.copyState(src)
+
+	return d.flushSkipped(ctx, ids)
+}
+
+// copyState deep-copies the ratchet state of src into d, leaving d's options alone. The
+// skipped and pre-derived keys d held before are erased.
+func (d *doubleRatchet) copyState(src *doubleRatchet) {
+	if d.chainKeys == nil {
+		d.chainKeys = &chainKeys{}
+	}
+
+	for id := range d.skippedMessageKeys {
+		d.eraseSkipped(id)
+	}
+
+	d.clearPrederived()
+
+	d.dh = src.dh
+	d.rootKey = src.rootKey
+	d.sendChainKey = src.sendChainKey
+	d.recvChainKey = src.recvChainKey
+	d.sendN = src.sendN
+	d.recvN = src.recvN
+	d.prevN = src.prevN
+	d.recvPN = src.recvPN
+	d.epoch = src.epoch
+	d.usage = src.usage
+	d.prederived = append([]prederivedKey(nil), src.prederived...)
+	d.rekeyPending = src.rekeyPending
+	d.archived = src.archived
+	d.chainStarted = src.chainStarted
+	d.chainBytes = src.chainBytes
+	d.rekeyDeferred = src.rekeyDeferred
+	d.sendStepPending = src.sendStepPending
+
+	d.replay = append([]replayID(nil), src.replay...)
+
+	d.skippedMessageKeys = make(map[headerID]skippedKey, len(src.skippedMessageKeys))
+
+	for id, sk := range src.skippedMessageKeys {
+		d.skippedMessageKeys[id] = sk
+	}
+
+	d.transcript = nil
+
+	if src.transcript != nil {
+		t := *src.transcript
+		d.transcript = &t
+	}
+
+	d.hybrid = nil
+
+	if src.hybrid != nil {
+		h := *src.hybrid
+		d.hybrid = &h
+	}
+}
@@ -0,0 +1,65 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestSnapshotRestore verifies that restoring a snapshot rolls back receiving, including a
+// DH ratchet step, so the same messages can be processed again, that a snapshot can be
+// restored more than once, and that snapshots of other sessions are refused.
+func TestSnapshotRestore(t *testing.T) {
+	alicePri, bobPri := orderedKeys(ecdh.P256())
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	first, _ := alice.Send([]byte("first"), nil)
+	bob.Receive(first, nil)
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+	alice.Receive(reply, nil)
+
+	if err := alice.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := alice.Send([]byte("second"), nil)
+
+	snapshot := bob.Snapshot()
+	before, _ := bob.Serialize()
+
+	for i := 0; i < 2; i++ {
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatalf("Receive %d failed: %v", i, err)
+		}
+
+		if err := bob.Restore(snapshot); err != nil {
+			t.Fatalf("Restore %d failed: %v", i, err)
+		}
+
+		if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+			t.Errorf("Restore %d: expected the state at the snapshot", i)
+		}
+	}
+
+	otherPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	other, _ := New(otherPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if err := other.Restore(snapshot); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot for another session's snapshot, got %v", err)
+	}
+
+	if err := bob.Restore(Snapshot{}); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot for a zero snapshot, got %v", err)
+	}
+
+	bob.Close()
+
+	if err := bob.Restore(snapshot); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected ErrSessionClosed, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package doubleratchet
+
+import (
+	"context"
+	"fmt"
+)
+
+var (
+	// ErrStoreFailed wraps the error of a Store that failed to save the session state.
+	ErrStoreFailed = newError(ErrState, "double ratchet: saving state failed")
+)
+
+// Store persists the state of a session created with WithStore.
+type Store interface {
+	// Save persists state, the session serialized as by Serialize, under sessionID. It
+	// is called with the session lock held, so it must not call back into the session.
+	Save(ctx context.Context, sessionID, state []byte) error
+}
+
+// StoreFunc adapts an OnStateChange-style callback to a Store.
+type StoreFunc func(ctx context.Context, sessionID, state []byte) error
+
+// Save calls f.
+func (f StoreFunc) Save(ctx context.Context, sessionID, state []byte) error {
+	return f(ctx, sessionID, state)
+}
+
+// WithStore saves the session state to store after every change: each Send and Receive,
+// Rekey, Archive and Restore. The state is saved before the call returns its result, so
+// a message is only handed to the caller once the state that follows it is persisted, and
+// a crash never leaves the store behind a message that was sent or delivered.
+//
+// If Save fails, the call returns the error wrapped in ErrStoreFailed and withholds its
+// result. The session has still advanced in memory, as when an AfterReceive hook fails;
+// the next change saves the current state again. Copies made with Clone do not save to
+// the store, so that trying a message on a copy leaves the saved state alone.
+//
+// Every save serializes the whole state; BinarySerializer keeps this cheap.
+func WithStore(store Store) Option {
+	return func(d *doubleRatchet) error {
+		d.store = store
+		return nil
+	}
+}
+
+// save saves the state to the session's store, if any. The caller must hold the lock.
+func (d *doubleRatchet) save(ctx context.Context) error {
+	if d.store == nil {
+		return nil
+	}
+
+	state, err := d.serialize()
+
+	if err != nil {
+		return err
+	}
+
+	if err := d.store.Save(ctx, d.sessionID, state); err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreFailed, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"testing"
+)
+
+// TestWithStore verifies that every change of the session is saved before the call
+// returns, that the saved state restores a session that continues where the original
+// stopped, that copies do not save, and that a failing store fails the call.
+func TestWithStore(t *testing.T) {
+	bobPri, alicePri := orderedKeys(ecdh.P256())
+
+	var saves int
+	var saved, savedID []byte
+
+	store := StoreFunc(func(_ context.Context, sessionID, state []byte) error {
+		saves++
+		saved, savedID = state, sessionID
+		return nil
+	})
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithStore(store))
+
+	first, _ := alice.Send([]byte("first"), nil)
+	second, _ := alice.Send([]byte("second"), nil)
+
+	if _, err := bob.Receive(second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if saves != 1 {
+		t.Fatalf("Expected 1 save after Receive, got %d", saves)
+	}
+
+	if !bytes.Equal(savedID, bob.SessionID()) {
+		t.Errorf("Expected the state to be saved under the session ID, got %x", savedID)
+	}
+
+	restored, err := Deserialize(saved)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := restored.Receive(first, nil); err != nil || string(got.Plaintext) != "first" {
+		t.Errorf("Expected the saved state to hold the skipped key, got %q, %v", got.Plaintext, err)
+	}
+
+	if _, err := bob.Send([]byte("reply"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bob.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	if saves != 3 {
+		t.Errorf("Expected 3 saves after Send and Rekey, got %d", saves)
+	}
+
+	if _, err := bob.Clone().Send([]byte("trial"), nil); err != nil || saves != 3 {
+		t.Errorf("Expected a copy not to save, got %d saves, %v", saves, err)
+	}
+
+	errDisk := errors.New("disk full")
+
+	failing, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithStore(StoreFunc(func(context.Context, []byte, []byte) error {
+		return errDisk
+	})))
+
+	msg, err := failing.Send([]byte("lost"), nil)
+
+	if !errors.Is(err, ErrStoreFailed) || !errors.Is(err, errDisk) || !errors.Is(err, ErrState) {
+		t.Errorf("Expected ErrStoreFailed wrapping the store's error, got %v", err)
+	}
+
+	if msg.Ciphertext != nil {
+		t.Error("Expected no message when the state could not be saved")
+	}
+}
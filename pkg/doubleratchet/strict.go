@@ -0,0 +1,64 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"fmt"
+)
+
+var (
+	// ErrInconsistentState is returned by Deserialize in strict mode when the restored
+	// state fails a consistency check. The wrapping error names the check.
+	ErrInconsistentState = newError(ErrState, "double ratchet: inconsistent state")
+)
+
+// WithStrictVerification makes Deserialize cross-check the restored state and refuse to
+// construct a session from inconsistent data: a stored local public key that does not
+// match the private key, a remote key equal to the local one, missing root or chain keys
+// (except in archived sessions), and skipped keys with malformed headers, empty keys or
+// counters that the session could never have produced. It has no effect on New.
+func WithStrictVerification() Option {
+	return func(d *doubleRatchet) error {
+		d.strict = true
+		return nil
+	}
+}
+
+// verifyState checks the invariants of a restored state.
+func verifyState(state State, dh DH, localPri PrivateKey, remotePub PublicKey) error {
+	localPub := localPri.PublicKey().Bytes()
+
+	if state.LocalPub != nil && !bytes.Equal(state.LocalPub, localPub) {
+		return fmt.Errorf("%w: local public key does not match the private key", ErrInconsistentState)
+	}
+
+	if remotePub != nil && bytes.Equal(remotePub.Bytes(), localPub) {
+		return fmt.Errorf("%w: remote key equals the local key", ErrInconsistentState)
+	}
+
+	var zero [32]byte
+
+	// Sessions created with InitAlice or InitBob have no receiving chain before their first
+	// message, and InitBob sessions no sending chain before their first Send.
+	missingSend := state.SendChainKey == zero && remotePub != nil && !state.StepPending
+	missingRecv := state.RecvChainKey == zero && (state.RecvN > 0 || len(state.SkippedKeys) > 0)
+
+	if !state.Archived && (state.RootKey == zero || missingSend || missingRecv) {
+		return fmt.Errorf("%w: missing root or chain key", ErrInconsistentState)
+	}
+
+	for i, sk := range state.SkippedKeys {
+		if _, err := dh.NewPublicKey(sk.Header.DH); err != nil {
+			return fmt.Errorf("%w: skipped key %d has an invalid header key", ErrInconsistentState, i)
+		}
+
+		if sk.Key == zero {
+			return fmt.Errorf("%w: skipped key %d is empty", ErrInconsistentState, i)
+		}
+
+		if remotePub != nil && bytes.Equal(sk.Header.DH, remotePub.Bytes()) && sk.Header.N >= state.RecvN {
+			return fmt.Errorf("%w: skipped key %d is ahead of the receiving chain", ErrInconsistentState, i)
+		}
+	}
+
+	return nil
+}
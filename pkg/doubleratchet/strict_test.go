@@ -0,0 +1,59 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestStrictVerificationRejectsInconsistentState verifies that strict mode accepts a
+// genuine state and refuses states whose keys, chain keys or skipped keys are
+// inconsistent, while the default mode does not check them.
+func TestStrictVerificationRejectsInconsistentState(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	otherPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	_, _ = alice.Send([]byte("skipped"), nil)
+	msg, _ := alice.Send([]byte("delivered"), nil)
+	_, _ = bob.Receive(msg, nil)
+
+	data, _ := bob.Serialize()
+
+	if _, err := Deserialize(data, WithStrictVerification()); err != nil {
+		t.Fatalf("Expected a genuine state to pass, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(*State)
+	}{
+		{"mismatched local public key", func(s *State) { s.LocalPub = otherPri.PublicKey().Bytes() }},
+		{"remote key equals local key", func(s *State) { s.RemotePub = bobPri.PublicKey().Bytes() }},
+		{"missing root key", func(s *State) { s.RootKey = [32]byte{} }},
+		{"skipped key ahead of chain", func(s *State) { s.SkippedKeys[0].Header.N = s.RecvN }},
+		{"invalid skipped header key", func(s *State) { s.SkippedKeys[0].Header.DH = []byte{4, 1, 2} }},
+		{"empty skipped key", func(s *State) { s.SkippedKeys[0].Key = [32]byte{} }},
+	} {
+		var state State
+
+		_ = json.Unmarshal(data, &state)
+		tc.tamper(&state)
+		tampered, _ := json.Marshal(state)
+
+		if _, err := Deserialize(tampered, WithStrictVerification()); !errors.Is(err, ErrInconsistentState) {
+			t.Errorf("%s: expected ErrInconsistentState, got %v", tc.name, err)
+		}
+
+		if tc.name == "missing root key" {
+			if _, err := Deserialize(tampered); err != nil {
+				t.Errorf("Expected the default mode not to verify, got %v", err)
+			}
+		}
+	}
+}
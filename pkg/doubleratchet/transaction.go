@@ -0,0 +1,168 @@
+package doubleratchet
+
+import (
+	"crypto/sha256"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// receiveTxn records the state a receive may change, so that a message that fails to
+// decrypt leaves the session exactly as it was. Chain keys and counters are saved up
+// front; changes to the skipped keys are journaled as they happen, so that a receive does
+// not copy the whole map.
+type receiveTxn struct {
+	rootKey      crypto.ChainKey
+	recvChainKey crypto.ChainKey
+
+	recvN  uint32
+	recvPN uint32
+	epoch  uint32
+
+	remote          PublicKey
+	sendStepPending bool
+	hybrid          *hybridRatchet
+
+	// The replay window, usage and received transcript change after the message
+	// decrypts, within transactions that span the whole receive, such as the one of
+	// ReceiveAndPersist.
+	replay     []replayID
+	usage      Usage
+	transcript [sha256.Size]byte
+
+	// skipped maps every skipped key changed in the transaction to its value before the
+	// first change, or to nil if it did not exist.
+	skipped map[headerID]*skippedKey
+}
+
+// begin starts a receive transaction. The caller must hold the lock and finish it with
+// commit or rollback.
+func (d *doubleRatchet) begin() {
+	txn := &receiveTxn{
+		rootKey:         d.rootKey,
+		recvChainKey:    d.recvChainKey,
+		recvN:           d.recvN,
+		recvPN:          d.recvPN,
+		epoch:           d.epoch,
+		remote:          d.dh.remotePublicKey,
+		sendStepPending: d.sendStepPending,
+		replay:          d.replay,
+		usage:           d.usage,
+	}
+
+	if d.hybrid != nil {
+		saved := *d.hybrid
+		txn.hybrid = &saved
+	}
+
+	if d.transcript != nil {
+		txn.transcript = d.transcript.received
+	}
+
+	d.txn = txn
+}
+
+// commit keeps the changes of the current receive transaction and erases the keys it
+// saved, which the session no longer holds.
+func (d *doubleRatchet) commit() {
+	d.txn.erase()
+	d.txn = nil
+}
+
+// rollback undoes the changes of the current receive transaction.
+func (d *doubleRatchet) rollback() {
+	txn := d.txn
+	d.txn = nil
+
+	d.rootKey = txn.rootKey
+	d.recvChainKey = txn.recvChainKey
+	d.recvN = txn.recvN
+	d.recvPN = txn.recvPN
+	d.epoch = txn.epoch
+	d.dh.remotePublicKey = txn.remote
+	d.sendStepPending = txn.sendStepPending
+	d.replay = txn.replay
+	d.usage = txn.usage
+
+	if txn.hybrid != nil {
+		*d.hybrid = *txn.hybrid
+	}
+
+	if d.transcript != nil {
+		d.transcript.received = txn.transcript
+	}
+
+	for id, sk := range txn.skipped {
+		if sk == nil {
+			d.eraseSkipped(id)
+		} else {
+			d.skippedMessageKeys[id] = *sk
+		}
+	}
+
+	txn.erase()
+}
+
+// erase overwrites the keys saved by the transaction with zeros.
+func (txn *receiveTxn) erase() {
+	clear(txn.rootKey[:])
+	clear(txn.recvChainKey[:])
+
+	for _, sk := range txn.skipped {
+		if sk != nil {
+			clear(sk.key[:])
+		}
+	}
+}
+
+// storeSkipped stores a skipped message key, journaling it in the current transaction.
+func (d *doubleRatchet) storeSkipped(id headerID, sk skippedKey) {
+	d.journal(id)
+	d.skippedMessageKeys[id] = sk
+}
+
+// deleteSkipped deletes a skipped message key, journaling it in the current transaction.
+func (d *doubleRatchet) deleteSkipped(id headerID) {
+	d.journal(id)
+	d.eraseSkipped(id)
+}
+
+// eraseSkipped overwrites a skipped message key with zeros and deletes it.
+func (d *doubleRatchet) eraseSkipped(id headerID) {
+	d.skippedMessageKeys[id] = skippedKey{}
+	delete(d.skippedMessageKeys, id)
+}
+
+// journal records the value a skipped key had before its first change in the current
+// transaction.
+func (d *doubleRatchet) journal(id headerID) {
+	if d.txn == nil {
+		return
+	}
+
+	if _, ok := d.txn.skipped[id]; ok {
+		return
+	}
+
+	if d.txn.skipped == nil {
+		d.txn.skipped = make(map[headerID]*skippedKey)
+	}
+
+	var saved *skippedKey
+
+	if sk, ok := d.skippedMessageKeys[id]; ok {
+		saved = &sk
+	}
+
+	d.txn.skipped[id] = saved
+}
+
+// changed returns the skipped keys changed in the transaction.
+func (txn *receiveTxn) changed() []headerID {
+	ids := make([]headerID, 0, len(txn.skipped))
+
+	for id := range txn.skipped {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
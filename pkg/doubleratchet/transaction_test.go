@@ -0,0 +1,113 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+)
+
+// TestReceiveRollsBackOnFailure verifies that a message that fails to decrypt, whether
+// it skips ahead in the current chain or starts a new one, leaves the serialized state
+// unchanged, so the genuine messages still decrypt afterwards.
+func TestReceiveRollsBackOnFailure(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	tamper := func(msg CipheredMessage) CipheredMessage {
+		msg.Ciphertext = append([]byte(nil), msg.Ciphertext...)
+		msg.Ciphertext[len(msg.Ciphertext)-1] ^= 0xFF
+
+		return msg
+	}
+
+	var sent []CipheredMessage
+
+	for i := 0; i < 3; i++ {
+		msg, _ := alice.Send([]byte("first chain"), nil)
+		sent = append(sent, msg)
+	}
+
+	bob.Receive(sent[0], nil)
+
+	before, _ := bob.Serialize()
+
+	if _, err := bob.Receive(tamper(sent[2]), nil); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+
+	if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+		t.Error("Expected a failed receive in the current chain to leave the state untouched")
+	}
+
+	if stats := bob.SkippedKeys(); stats.Count != 0 {
+		t.Errorf("Expected no skipped keys after the rollback, got %d", stats.Count)
+	}
+
+	reply, _ := bob.Send([]byte("reply"), nil)
+	alice.Receive(reply, nil)
+
+	next, _ := alice.Send([]byte("second chain"), nil)
+
+	before, _ = bob.Serialize()
+
+	if _, err := bob.Receive(tamper(next), nil); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+
+	if after, _ := bob.Serialize(); !bytes.Equal(before, after) {
+		t.Error("Expected a failed receive in a new chain to leave the state untouched")
+	}
+
+	for _, msg := range append(sent[1:], next) {
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Errorf("Expected the genuine message to decrypt, got %v", err)
+		}
+	}
+}
+
+// TestCommitErasesSavedKeys verifies that committing a receive transaction erases the
+// chain and skipped keys it saved for a rollback.
+func TestCommitErasesSavedKeys(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	first, _ := alice.Send([]byte("first"), nil)
+	second, _ := alice.Send([]byte("second"), nil)
+
+	if _, err := bob.Receive(second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bob.begin()
+	txn := bob.txn
+
+	if _, err := bob.trySkippedMessageKeys(nil, first.Header, first.Ciphertext, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bob.commit()
+
+	saved := txn.skipped[first.Header.key()]
+
+	if saved == nil || saved.key != (crypto.MessageKey{}) {
+		t.Errorf("Expected the consumed skipped key to be erased")
+	}
+
+	if txn.recvChainKey != (crypto.ChainKey{}) || txn.rootKey != (crypto.ChainKey{}) {
+		t.Errorf("Expected the saved chain keys to be erased")
+	}
+
+	if len(bob.skippedMessageKeys) != 0 {
+		t.Errorf("Expected no skipped keys, got %d", len(bob.skippedMessageKeys))
+	}
+}
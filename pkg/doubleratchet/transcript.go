@@ -0,0 +1,29 @@
+package doubleratchet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// transcriptLabel domain-separates transcript hashes from other uses of SHA-256.
+var transcriptLabel = []byte("DoubleRatchet-Transcript")
+
+// transcript holds running hashes over the messages sent and received by a session.
+// Each hash is chained as H' = SHA-256(label || H || header || ciphertext).
+type transcript struct {
+	sent     [sha256.Size]byte
+	received [sha256.Size]byte
+}
+
+// add folds msg into the running hash h.
+func (t *transcript) add(h *[sha256.Size]byte, msg CipheredMessage) {
+	digest := sha256.New()
+
+	digest.Write(transcriptLabel)
+	digest.Write(h[:])
+	digest.Write(msg.Header.encode())
+	digest.Write(binary.BigEndian.AppendUint32(nil, uint32(len(msg.Ciphertext)))) // #nosec G115 -- lengths are bounded by memory
+	digest.Write(msg.Ciphertext)
+
+	copy(h[:], digest.Sum(nil))
+}
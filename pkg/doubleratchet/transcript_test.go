@@ -0,0 +1,88 @@
+package doubleratchet
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// TestTranscriptHashesMatchAcrossParties verifies that each party's sent transcript
+// equals the peer's received transcript after an in-order exchange, and that the hashes
+// survive serialization.
+func TestTranscriptHashesMatchAcrossParties(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithTranscript())
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithTranscript())
+
+	for i := range 3 {
+		msg, _ := alice.Send([]byte{byte(i)}, nil)
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, _ := bob.Send([]byte{byte(i)}, nil)
+
+		if _, err := alice.Receive(reply, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliceSent, aliceReceived := alice.Transcript()
+	bobSent, bobReceived := bob.Transcript()
+
+	if !bytes.Equal(aliceSent, bobReceived) || !bytes.Equal(bobSent, aliceReceived) {
+		t.Fatal("Transcripts do not match after an in-order exchange")
+	}
+
+	data, err := alice.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := Deserialize(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent, received := restored.Transcript(); !bytes.Equal(sent, aliceSent) || !bytes.Equal(received, aliceReceived) {
+		t.Error("Transcript not preserved by serialization")
+	}
+}
+
+// TestTranscriptDetectsSuppressedMessage verifies that a message withheld by the
+// transport makes the transcripts diverge, and that sessions without the option report
+// no transcript.
+func TestTranscriptDetectsSuppressedMessage(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithTranscript())
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, WithTranscript())
+
+	alice.Send([]byte("suppressed"), nil)
+
+	msg, _ := alice.Send([]byte("delivered"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceSent, _ := alice.Transcript()
+	_, bobReceived := bob.Transcript()
+
+	if bytes.Equal(aliceSent, bobReceived) {
+		t.Error("Transcripts should differ when a message was suppressed")
+	}
+
+	plain, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+
+	if sent, received := plain.Transcript(); sent != nil || received != nil {
+		t.Error("Expected no transcript without WithTranscript")
+	}
+}
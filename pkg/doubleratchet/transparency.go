@@ -0,0 +1,20 @@
+package doubleratchet
+
+import (
+	"github.com/othonhugo/goratchet/pkg/transparency"
+)
+
+// VerifyIdentity checks the peer's identity public key against a key transparency log
+// with v, and records the outcome in the session, where Info reports it as
+// IdentityStatus. The outcome is not part of the serialized state; verify again after a
+// session is deserialized. The log is queried without holding the session's lock.
+func (d *doubleRatchet) VerifyIdentity(v *transparency.Verifier, identityKey []byte) (transparency.Status, error) {
+	status, err := v.Verify(identityKey)
+
+	d.lock()
+	defer d.unlock()
+
+	d.identityStatus = status
+
+	return status, err
+}
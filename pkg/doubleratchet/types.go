@@ -1,42 +1,277 @@
 // Package doubleratchet defines types and interfaces for implementing the Double Ratchet algorithm.
 package doubleratchet
 
-// DoubleRatchet defines the interface for managing a Double Ratchet session, enabling secure message exchange.
-type DoubleRatchet interface {
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/othonhugo/goratchet/pkg/transparency"
+)
+
+// Sender is the sending half of a session. Components that only send, such as an outbound
+// notifier, can depend on it instead of DoubleRatchet.
+type Sender interface {
 	// Send encrypts the given plaintext with associated data ad and returns a CipheredMessage.
 	Send(plaintext, ad []byte) (CipheredMessage, error)
 
+	// SendCtx is like Send, but gives up with the context's error if ctx is done while
+	// waiting for the session or before a key operation.
+	SendCtx(ctx context.Context, plaintext, ad []byte) (CipheredMessage, error)
+
+	// SendType is like Send, but marks the message with a MessageType that the receiver
+	// can dispatch on. SendTypeCtx is its context variant.
+	SendType(typ MessageType, plaintext, ad []byte) (CipheredMessage, error)
+	SendTypeCtx(ctx context.Context, typ MessageType, plaintext, ad []byte) (CipheredMessage, error)
+
+	// SendWithMetadata is like Send, but attaches an authenticated timestamp and message
+	// ID to the header.
+	SendWithMetadata(meta Metadata, plaintext, ad []byte) (CipheredMessage, error)
+
+	// EncryptInto is like Send, but writes the ciphertext into dst's storage when it is
+	// large enough, so that high-throughput callers can reuse buffers across messages.
+	EncryptInto(dst, plaintext, ad []byte) (CipheredMessage, error)
+
+	// SendAll sends a queue of messages in order under a single lock acquisition, stopping
+	// at the first failure. SendAllCtx is its context variant.
+	SendAll(plaintexts [][]byte, ad []byte) ([]CipheredMessage, error)
+	SendAllCtx(ctx context.Context, plaintexts [][]byte, ad []byte) ([]CipheredMessage, error)
+}
+
+// Receiver is the receiving half of a session. Components that only receive, such as an
+// inbound decryption worker, can depend on it instead of DoubleRatchet.
+type Receiver interface {
 	// Receive decrypts the given CipheredMessage with associated data ad and returns an UncipheredMessage.
 	Receive(msg CipheredMessage, ad []byte) (UncipheredMessage, error)
 
+	// ReceiveCtx is like Receive, but gives up with the context's error if ctx is done
+	// while waiting for the session or before a key operation.
+	ReceiveCtx(ctx context.Context, msg CipheredMessage, ad []byte) (UncipheredMessage, error)
+
+	// DecryptInto is like Receive, but writes the plaintext into dst's storage when it is
+	// large enough.
+	DecryptInto(dst []byte, msg CipheredMessage, ad []byte) (UncipheredMessage, error)
+
+	// ReceiveAll receives a queue of messages in order under a single lock acquisition,
+	// stopping at the first failure. ReceiveAllCtx is its context variant.
+	ReceiveAll(msgs []CipheredMessage, ad []byte) ([]UncipheredMessage, error)
+	ReceiveAllCtx(ctx context.Context, msgs []CipheredMessage, ad []byte) ([]UncipheredMessage, error)
+}
+
+// DoubleRatchet defines the interface for managing a Double Ratchet session, enabling secure message exchange.
+type DoubleRatchet interface {
+	Sender
+	Receiver
+
 	// Serialize marshals the session state to a byte slice.
 	Serialize() ([]byte, error)
+
+	// SerializeEncrypted is like Serialize, but encrypts the state under a key-encryption
+	// key and binds it to the session ID. See SerializeEncrypted for details.
+	SerializeEncrypted(kek []byte) ([]byte, error)
+
+	// ReceiveAndPersist is like Receive, but saves the resulting state to store before
+	// returning the plaintext, and leaves the session unchanged if it cannot. See
+	// ReceiveAndPersist for details.
+	ReceiveAndPersist(msg CipheredMessage, ad []byte, store Store) (UncipheredMessage, error)
+
+	// Transcript returns the running hashes over all messages sent and received, or nil
+	// slices if the session was not created with WithTranscript. A party's sent hash
+	// equals the peer's received hash when both have processed the same messages in the
+	// same order, so comparing them out of band detects injected or suppressed messages.
+	Transcript() (sent, received []byte)
+
+	// EscrowKey returns the escrow public key that every sent message key is wrapped to,
+	// or nil if the session was not created with WithEscrow.
+	EscrowKey() []byte
+
+	// Usage returns the number of messages and plaintext bytes sent and successfully
+	// received by the session.
+	Usage() Usage
+
+	// Handoff closes the session and returns its state encrypted under key, for transfer
+	// to another process with AcceptHandoff. See Handoff for details.
+	Handoff(key []byte) ([]byte, error)
+
+	// SkippedKeys reports the stored skipped message keys: their number, their
+	// distribution over epochs, and the age of the oldest one.
+	SkippedKeys() SkippedKeyStats
+
+	// Prederive derives sending message keys ahead of time, up to the number configured
+	// with WithPrederivation, so that later sends only encrypt. Call it when idle.
+	Prederive()
+
+	// Subscribe returns a channel of ratchet events and a function that ends the
+	// subscription. See Subscribe for the delivery guarantees.
+	Subscribe(buffer int) (<-chan Event, func())
+
+	// Archive freezes the session into a read-only archive that can only decrypt
+	// messages with retained skipped keys. See Archive for details.
+	Archive() error
+
+	// Snapshot captures the ratchet state in memory, and Restore puts it back, so that a
+	// failed processing step can roll the session back.
+	Snapshot() Snapshot
+	Restore(s Snapshot) error
+
+	// Clone returns an independent copy of the session. See Clone for details.
+	Clone() DoubleRatchet
+
+	// Close erases the session's secret keys and makes every later call fail with
+	// ErrSessionClosed. See Close for details.
+	Close() error
+
+	// Info returns non-secret facts about the session, such as its counters and the
+	// fingerprint of the peer's ratchet key.
+	Info() SessionInfo
+
+	// VerifyIdentity checks the peer's identity public key against a key transparency log
+	// and records the outcome, which Info reports.
+	VerifyIdentity(v *transparency.Verifier, identityKey []byte) (transparency.Status, error)
+
+	// LocalRatchetKey and RemoteRatchetKey return the encodings of the session's and the
+	// peer's current ratchet public keys.
+	LocalRatchetKey() []byte
+	RemoteRatchetKey() []byte
+
+	// Rekey starts a new sending chain under a fresh ratchet key on the session's turn,
+	// and otherwise defers it; see Rekey for why. RekeyCtx is like Rekey, but gives up
+	// with the context's error if ctx is done.
+	Rekey() error
+	RekeyCtx(ctx context.Context) error
+
+	// SessionID returns a stable identifier that both peers derive for the session.
+	SessionID() []byte
 }
 
 // State represents the serializable state of a Double Ratchet session.
+//
+// Its JSON encoding is a stable schema: each field is stored under its Go name, fixed by
+// its tag, and byte fields, including the 32-byte keys, are base64 strings. Fields tagged
+// omitempty are left out when empty. A field must never be renamed in JSON; new fields
+// must be optional, so that states written before them still decode.
 type State struct {
-	RootKey      [32]byte
-	SendChainKey [32]byte
-	RecvChainKey [32]byte
-	SendN        uint32
-	RecvN        uint32
-	PrevN        uint32
-	SkippedKeys  []SkippedMessageKey
-	LocalPri     []byte
-	RemotePub    []byte
+	RootKey      [32]byte `json:"RootKey"`
+	SendChainKey [32]byte `json:"SendChainKey"`
+	RecvChainKey [32]byte `json:"RecvChainKey"`
+	SendN        uint32   `json:"SendN"`
+	RecvN        uint32   `json:"RecvN"`
+	PrevN        uint32   `json:"PrevN"`
+
+	// RecvPN is the PN of the messages on the receiving chain. States written before it
+	// was recorded keep it in PrevN.
+	RecvPN *uint32 `json:"RecvPN,omitempty"`
+
+	// StepPending records that the session received a new peer key and starts a new
+	// sending chain with its next message.
+	StepPending bool                `json:"StepPending,omitempty"`
+	Epoch       uint32              `json:"Epoch"` // Number of DH ratchet steps performed
+	SkippedKeys []SkippedMessageKey `json:"SkippedKeys,omitempty"`
+	LocalPri    []byte              `json:"LocalPri"`
+	LocalPub    []byte              `json:"LocalPub,omitempty"`
+	RemotePub   []byte              `json:"RemotePub,omitempty"`
+
+	TranscriptSent     []byte `json:"TranscriptSent,omitempty"`
+	TranscriptReceived []byte `json:"TranscriptReceived,omitempty"`
+
+	// EscrowKey is the P-256 escrow public key of a session created with WithEscrow.
+	EscrowKey []byte `json:"EscrowKey,omitempty"`
+
+	// Usage holds the usage counters, and UsageMAC their HMAC if the session was
+	// created with WithUsageKey.
+	Usage    Usage  `json:"Usage"`
+	UsageMAC []byte `json:"UsageMAC,omitempty"`
+
+	// FIPS records that the session runs in FIPS mode; see WithFIPS.
+	FIPS bool `json:"FIPS,omitempty"`
+
+	// Archived records that the session was frozen with Archive.
+	Archived bool `json:"Archived,omitempty"`
+
+	// Curve names the curve of a session created with WithCurve. It is empty for P-256.
+	Curve string `json:"Curve,omitempty"`
+
+	// KDFHash names the hash of a session created with WithKDFHash. It is empty for SHA-256.
+	KDFHash KDFHash `json:"KDFHash,omitempty"`
+
+	// Label is the domain-separation label of a session created with WithLabel. It is
+	// empty for the default label.
+	Label string `json:"Label,omitempty"`
+
+	// ChainStartedAt is the Unix time of the first message on the current sending chain,
+	// and ChainBytes the plaintext bytes sent on it, for sessions with a RekeyPolicy.
+	ChainStartedAt int64  `json:"ChainStartedAt,omitempty"`
+	ChainBytes     uint64 `json:"ChainBytes,omitempty"`
+
+	// Hybrid holds the post-quantum keys of a session created with WithHybridPQ.
+	Hybrid *HybridState `json:"Hybrid,omitempty"`
+
+	// SessionID is the identifier returned by SessionID.
+	SessionID []byte `json:"SessionID,omitempty"`
+
+	// ReplayWindow holds the IDs of the last messages received by a session created with
+	// WithReplayWindow, 16 bytes each, oldest first.
+	ReplayWindow []byte `json:"ReplayWindow,omitempty"`
 }
 
 // SkippedMessageKey represents a single skipped message key for serialization.
 type SkippedMessageKey struct {
-	Header Header
-	Key    [32]byte
+	Header Header   `json:"Header"`
+	Key    [32]byte `json:"Key"`
+
+	// Epoch is the epoch of the chain the key was skipped in, and StoredAt the Unix time
+	// it was stored at, or zero if unknown.
+	Epoch    uint32 `json:"Epoch,omitempty"`
+	StoredAt int64  `json:"StoredAt,omitempty"`
 }
 
 // Header contains the message header information for Double Ratchet.
 type Header struct {
-	DH []byte // The sender's current public key
-	N  uint32 // The message number in the current chain
-	PN uint32 // The length of the previous sending chain
+	DH []byte `json:"DH"` // The sender's current public key
+	N  uint32 `json:"N"`  // The message number in the current chain
+	PN uint32 `json:"PN"` // The length of the previous sending chain
+
+	// Padding is random authenticated padding added by senders using WithHeaderPadding.
+	Padding []byte `json:"Padding,omitempty"`
+
+	// PQ holds the post-quantum fields added by senders using WithHybridPQ.
+	PQ *HybridHeader `json:"PQ,omitempty"`
+
+	// Version is the version of the message format: zero, or 1 for messages whose
+	// plaintext is padded. Receivers reject versions newer than HeaderVersion.
+	Version uint8 `json:"Version,omitempty"`
+
+	// Type tells the receiver how to dispatch the message; see MessageType.
+	Type MessageType `json:"Type,omitempty"`
+
+	// Timestamp is the sender's Unix time in milliseconds and MessageID an application
+	// message ID, both set by SendWithMetadata; see Metadata.
+	Timestamp int64  `json:"Timestamp,omitempty"`
+	MessageID []byte `json:"MessageID,omitempty"`
+}
+
+// encode returns a canonical byte encoding of the header: the DH key length, the DH key, N
+// and PN, followed by the padding length and the padding if the header is padded or has
+// post-quantum fields, and then the post-quantum fields.
+func (h Header) encode() []byte {
+	buf := make([]byte, 0, 2+len(h.DH)+8+2+len(h.Padding))
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(h.DH))) // #nosec G115 -- public keys are far below 64 KiB
+	buf = append(buf, h.DH...)
+	buf = binary.BigEndian.AppendUint32(buf, h.N)
+	buf = binary.BigEndian.AppendUint32(buf, h.PN)
+
+	if len(h.Padding) == 0 && h.PQ == nil {
+		return buf
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(h.Padding))) // #nosec G115 -- bounded by MaxHeaderPadding on receipt
+	buf = append(buf, h.Padding...)
+
+	if h.PQ == nil {
+		return buf
+	}
+
+	return append(buf, h.PQ.encode()...)
 }
 
 func (h Header) key() headerID {
@@ -49,13 +284,27 @@ func (h Header) key() headerID {
 
 // CipheredMessage represents an encrypted message with its header.
 type CipheredMessage struct {
-	Header     Header
-	Ciphertext []byte
+	Header     Header `json:"Header"`
+	Ciphertext []byte `json:"Ciphertext"`
+
+	// Escrow is the message key wrapped to the sender's escrow key, or nil if the sender
+	// does not use escrow. See WithEscrow.
+	Escrow []byte `json:"Escrow,omitempty"`
 }
 
 // UncipheredMessage represents a decrypted message.
 type UncipheredMessage struct {
 	Plaintext []byte
+
+	// Escrowed reports whether the sender wrapped this message's key to an escrow key, so
+	// that a third party can decrypt it. Applications should surface this to the user.
+	Escrowed bool
+
+	// Type is the authenticated message type the sender gave to SendType.
+	Type MessageType
+
+	// Metadata is the authenticated metadata the sender gave to SendWithMetadata.
+	Metadata Metadata
 }
 
 // headerID is a unique identifier for a message key based on the header information.
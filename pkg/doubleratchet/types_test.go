@@ -0,0 +1,41 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// TestSenderReceiver verifies that the two halves of a session can be handed to
+// one-directional components as a Sender and a Receiver.
+func TestSenderReceiver(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	notify := func(s Sender, text string) CipheredMessage {
+		msg, err := s.Send([]byte(text), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return msg
+	}
+
+	decrypt := func(r Receiver, msg CipheredMessage) string {
+		plain, err := r.Receive(msg, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(plain.Plaintext)
+	}
+
+	if got := decrypt(bob, notify(alice, "hello")); got != "hello" {
+		t.Errorf("Expected hello, got %q", got)
+	}
+}
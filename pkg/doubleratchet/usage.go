@@ -0,0 +1,67 @@
+package doubleratchet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+var (
+	// ErrUsageKeyRequired is returned by Deserialize when the state carries protected usage
+	// counters but no key was given with WithUsageKey.
+	ErrUsageKeyRequired = newError(ErrState, "double ratchet: usage counters are protected, WithUsageKey required")
+
+	// ErrUsageTampered is returned by Deserialize when the usage counters fail their integrity check.
+	ErrUsageTampered = newError(ErrState, "double ratchet: usage counters failed integrity check")
+)
+
+// usageLabel domain-separates usage MACs from other uses of HMAC-SHA256.
+var usageLabel = []byte("DoubleRatchet-Usage")
+
+// Usage counts the messages and plaintext bytes a session has sent and successfully
+// received. Messages that fail authentication are not counted, so the figures can be used
+// for quotas or billing without inspecting content.
+type Usage struct {
+	MessagesSent     uint64 `json:"MessagesSent"`
+	MessagesReceived uint64 `json:"MessagesReceived"`
+	BytesSent        uint64 `json:"BytesSent"`
+	BytesReceived    uint64 `json:"BytesReceived"`
+}
+
+// WithUsageKey protects the usage counters in serialized state with an HMAC under key,
+// which the platform enforcing quotas keeps separately from the state. A session
+// serialized with a usage key can only be deserialized with the same key, and edits to
+// its counters are detected.
+func WithUsageKey(key []byte) Option {
+	return func(d *doubleRatchet) error {
+		d.usageKey = append([]byte(nil), key...)
+		return nil
+	}
+}
+
+// Usage returns the session's usage counters.
+func (d *doubleRatchet) Usage() Usage {
+	d.lock()
+	defer d.unlock()
+
+	return d.usage
+}
+
+// usageMAC authenticates usage for the session between localPub and remotePub, so that
+// counters cannot be edited or moved to another session.
+func usageMAC(key []byte, usage Usage, localPub, remotePub []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+
+	mac.Write(usageLabel)
+
+	for _, pub := range [][]byte{localPub, remotePub} {
+		mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(pub)))) // #nosec G115 -- public keys are far below 64 KiB
+		mac.Write(pub)
+	}
+
+	for _, v := range []uint64{usage.MessagesSent, usage.MessagesReceived, usage.BytesSent, usage.BytesReceived} {
+		mac.Write(binary.BigEndian.AppendUint64(nil, v))
+	}
+
+	return mac.Sum(nil)
+}
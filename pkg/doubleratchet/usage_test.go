@@ -0,0 +1,86 @@
+package doubleratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestUsageCountsAuthenticatedTraffic verifies that usage counters track messages and
+// plaintext bytes in each direction, and that messages failing authentication are not
+// counted.
+func TestUsageCountsAuthenticatedTraffic(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	for _, plaintext := range []string{"hello", "world!"} {
+		msg, _ := alice.Send([]byte(plaintext), nil)
+
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forged, _ := alice.Send([]byte("forged"), nil)
+	forged.Ciphertext[len(forged.Ciphertext)-1] ^= 1
+
+	if _, err := bob.Receive(forged, nil); err == nil {
+		t.Fatal("Expected forged message to be rejected")
+	}
+
+	if got, want := alice.Usage(), (Usage{MessagesSent: 3, BytesSent: 17}); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	if got, want := bob.Usage(), (Usage{MessagesReceived: 2, BytesReceived: 11}); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+// TestUsageKeyProtectsSerializedCounters verifies that counters serialized with a usage
+// key survive a round trip, and that edited counters, a wrong key or a missing key are
+// rejected on deserialization.
+func TestUsageKeyProtectsSerializedCounters(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	key := []byte("platform usage key")
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithUsageKey(key))
+
+	_, _ = alice.Send([]byte("billable"), nil)
+
+	data, _ := alice.Serialize()
+
+	restored, err := Deserialize(data, WithUsageKey(key))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restored.Usage(); got.MessagesSent != 1 || got.BytesSent != 8 {
+		t.Errorf("Usage not preserved by serialization, got %+v", got)
+	}
+
+	if _, err := Deserialize(data); !errors.Is(err, ErrUsageKeyRequired) {
+		t.Errorf("Expected ErrUsageKeyRequired, got %v", err)
+	}
+
+	if _, err := Deserialize(data, WithUsageKey([]byte("wrong"))); !errors.Is(err, ErrUsageTampered) {
+		t.Errorf("Expected ErrUsageTampered for a wrong key, got %v", err)
+	}
+
+	var state State
+
+	_ = json.Unmarshal(data, &state)
+	state.Usage.MessagesSent = 0
+	tampered, _ := json.Marshal(state)
+
+	if _, err := Deserialize(tampered, WithUsageKey(key)); !errors.Is(err, ErrUsageTampered) {
+		t.Errorf("Expected ErrUsageTampered for edited counters, got %v", err)
+	}
+}
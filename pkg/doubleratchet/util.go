@@ -1,49 +1,172 @@
 package doubleratchet
 
 import (
+	"context"
 	"crypto/ecdh"
-	"encoding/json"
-
-	"github.com/othonhugo/goratchet/pkg/crypto"
+	"crypto/hmac"
+	"time"
 )
 
-// Deserialize restores a session from a byte slice.
-func Deserialize(data []byte) (*doubleRatchet, error) {
-	var state State
+// Deserialize restores a session from a byte slice. opts configure the restored session
+// like they do for New; state recorded by the session, such as its escrow key or
+// transcript, takes precedence.
+//
+// The decoded copies of the keys are erased before Deserialize returns; with
+// WithEraseInput, a successful Deserialize also overwrites data with zeros.
+func Deserialize(data []byte, opts ...Option) (*doubleRatchet, error) {
+	state, serializer, err := decodeState(data)
+
+	defer state.erase()
 
-	if err := json.Unmarshal(data, &state); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	localPri, err := ecdh.P256().NewPrivateKey(state.LocalPri)
+	d := &doubleRatchet{
+		chainKeys:          &chainKeys{rootKey: state.RootKey, sendChainKey: state.SendChainKey, recvChainKey: state.RecvChainKey},
+		sendN:              state.SendN,
+		recvN:              state.RecvN,
+		prevN:              state.PrevN,
+		recvPN:             state.PrevN,
+		sendStepPending:    state.StepPending,
+		epoch:              state.Epoch,
+		skippedMessageKeys: make(map[headerID]skippedKey),
+		usage:              state.Usage,
+		serializer:         serializer,
+		fips:               state.FIPS || fipsDefault,
+		archived:           state.Archived,
+	}
+
+	if state.RecvPN != nil {
+		d.recvPN = *state.RecvPN
+	}
 
-	if err != nil {
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.secureKeys(); err != nil {
+		return nil, err
+	}
+
+	if err := d.restoreCurve(state.Curve); err != nil {
+		return nil, err
+	}
+
+	if err := d.restoreKDFHash(state.KDFHash); err != nil {
+		return nil, err
+	}
+
+	if err := d.restoreLabel(state.Label); err != nil {
 		return nil, err
 	}
 
-	remotePub, err := ecdh.P256().NewPublicKey(state.RemotePub)
+	if err := d.restoreHybrid(state.Hybrid); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkFIPS(); err != nil {
+		return nil, err
+	}
+
+	localPri, err := d.dh.function().NewPrivateKey(state.LocalPri)
 
 	if err != nil {
 		return nil, err
 	}
 
-	d := &doubleRatchet{
-		rootKey:      state.RootKey,
-		sendChainKey: state.SendChainKey,
-		recvChainKey: state.RecvChainKey,
-		sendN:        state.SendN,
-		recvN:        state.RecvN,
-		prevN:        state.PrevN,
-		dh: diffieHellmanRatchet{
-			localPrivateKey: localPri,
-			remotePublicKey: remotePub,
-		},
-		skippedMessageKeys: make(map[headerID]crypto.MessageKey),
+	// A responder created with InitBob has no remote key until the first message.
+	var remotePub PublicKey
+
+	if len(state.RemotePub) != 0 {
+		if remotePub, err = d.dh.function().NewPublicKey(state.RemotePub); err != nil {
+			return nil, err
+		}
+	}
+
+	d.dh.localPrivateKey = localPri
+	d.dh.remotePublicKey = remotePub
+
+	if d.strict {
+		if err := verifyState(state, d.dh.function(), localPri, remotePub); err != nil {
+			return nil, err
+		}
+	}
+
+	if state.UsageMAC != nil && d.usageKey == nil {
+		return nil, ErrUsageKeyRequired
+	}
+
+	if d.usageKey != nil && !hmac.Equal(state.UsageMAC, usageMAC(d.usageKey, state.Usage, localPri.PublicKey().Bytes(), state.RemotePub)) {
+		return nil, ErrUsageTampered
+	}
+
+	if state.EscrowKey != nil {
+		escrow, err := ecdh.P256().NewPublicKey(state.EscrowKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		d.escrow = escrow
+	}
+
+	if state.ChainStartedAt != 0 {
+		d.chainStarted = time.Unix(state.ChainStartedAt, 0)
+	}
+
+	d.chainBytes = state.ChainBytes
+	d.sessionID = state.SessionID
+
+	if err := d.restoreReplay(state.ReplayWindow); err != nil {
+		return nil, err
+	}
+
+	if state.TranscriptSent != nil || state.TranscriptReceived != nil {
+		d.transcript = &transcript{}
+
+		copy(d.transcript.sent[:], state.TranscriptSent)
+		copy(d.transcript.received[:], state.TranscriptReceived)
 	}
 
 	for _, sk := range state.SkippedKeys {
-		d.skippedMessageKeys[sk.Header.key()] = sk.Key
+		d.skippedMessageKeys[sk.Header.key()] = fromSkippedEntry(sk)
+	}
+
+	if err := d.loadSkipped(context.Background()); err != nil {
+		return nil, err
+	}
+
+	d.prederive()
+
+	if d.eraseInput {
+		clear(data)
 	}
 
 	return d, nil
 }
+
+// WithEraseInput makes Deserialize overwrite the caller's serialized state with zeros once
+// the session has been restored from it, so the raw keys it holds do not linger in the
+// caller's buffer. The input is left alone when Deserialize fails, so that it can be
+// retried or inspected. It has no effect on New.
+func WithEraseInput() Option {
+	return func(d *doubleRatchet) error {
+		d.eraseInput = true
+		return nil
+	}
+}
+
+// erase overwrites the secret fields of a decoded state with zeros.
+func (s *State) erase() {
+	clear(s.RootKey[:])
+	clear(s.SendChainKey[:])
+	clear(s.RecvChainKey[:])
+	clear(s.LocalPri)
+
+	for i := range s.SkippedKeys {
+		clear(s.SkippedKeys[i].Key[:])
+	}
+}
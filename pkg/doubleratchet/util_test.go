@@ -1,6 +1,7 @@
 package doubleratchet
 
 import (
+	"bytes"
 	"crypto/ecdh"
 	"crypto/rand"
 	"testing"
@@ -39,3 +40,43 @@ func TestSerialization(t *testing.T) {
 		t.Errorf("Expected 'msg2', got '%s'", decrypted.Plaintext)
 	}
 }
+
+// TestDeserializeEraseInput verifies that WithEraseInput zeroes the serialized state after
+// a successful Deserialize in every built-in format, that the input is kept without the
+// option, and that a failed Deserialize leaves it alone.
+func TestDeserializeEraseInput(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	for _, s := range []Serializer{JSONSerializer{}, GobSerializer{}, BinarySerializer{}, ProtoSerializer{}, CBORSerializer{}} {
+		alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, WithSerializer(s))
+		data, _ := alice.Serialize()
+		kept := bytes.Clone(data)
+
+		if _, err := Deserialize(kept); err != nil || !bytes.Equal(kept, data) {
+			t.Errorf("Expected format %q to keep its input without the option, got %v", s.Format(), err)
+		}
+
+		erased := bytes.Clone(data)
+
+		if _, err := Deserialize(erased, WithEraseInput()); err != nil {
+			t.Fatalf("Expected format %q to deserialize, got %v", s.Format(), err)
+		}
+
+		if !bytes.Equal(erased, make([]byte, len(data))) {
+			t.Errorf("Expected format %q input to be erased", s.Format())
+		}
+	}
+
+	alice, _ := New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	data, _ := alice.Serialize()
+	failed := bytes.Clone(data)
+
+	if _, err := Deserialize(failed, WithEraseInput(), WithUsageKey([]byte("key"))); err == nil {
+		t.Fatal("Expected Deserialize to fail without a usage MAC")
+	}
+
+	if !bytes.Equal(failed, data) {
+		t.Errorf("Expected a failed Deserialize to keep its input")
+	}
+}
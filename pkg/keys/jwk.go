@@ -0,0 +1,158 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrInvalidJWK is returned for JSON Web Keys that are malformed, use an unsupported
+	// key type or curve, or whose private and public parts do not match.
+	ErrInvalidJWK = errors.New("keys: invalid JSON Web Key")
+)
+
+// jwk is a JSON Web Key of an elliptic curve (RFC 7518) or an X25519 (RFC 8037) key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// jwkCurves maps the "crv" names of JSON Web Keys to curves.
+var jwkCurves = map[string]ecdh.Curve{
+	"P-256":  ecdh.P256(),
+	"P-384":  ecdh.P384(),
+	"P-521":  ecdh.P521(),
+	"X25519": ecdh.X25519(),
+}
+
+// ParsePrivateKeyJWK parses a private key from a JSON Web Key with a "d" member.
+func ParsePrivateKeyJWK(data []byte) (*ecdh.PrivateKey, error) {
+	k, curve, pub, err := parseJWK(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(k.D)
+
+	if err != nil || len(d) == 0 {
+		return nil, ErrInvalidJWK
+	}
+
+	key, err := curve.NewPrivateKey(d)
+
+	if err != nil || !bytes.Equal(key.PublicKey().Bytes(), pub) {
+		return nil, ErrInvalidJWK
+	}
+
+	return key, nil
+}
+
+// ParsePublicKeyJWK parses a public key from a JSON Web Key. The private part of a
+// private key's JWK is ignored.
+func ParsePublicKeyJWK(data []byte) (*ecdh.PublicKey, error) {
+	_, curve, pub, err := parseJWK(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := curve.NewPublicKey(pub)
+
+	if err != nil {
+		return nil, ErrInvalidJWK
+	}
+
+	return key, nil
+}
+
+// MarshalPrivateKeyJWK encodes a private key as a JSON Web Key.
+func MarshalPrivateKeyJWK(key *ecdh.PrivateKey) ([]byte, error) {
+	k, err := publicJWK(key.PublicKey())
+
+	if err != nil {
+		return nil, err
+	}
+
+	k.D = base64.RawURLEncoding.EncodeToString(key.Bytes())
+
+	return json.Marshal(k)
+}
+
+// MarshalPublicKeyJWK encodes a public key as a JSON Web Key.
+func MarshalPublicKeyJWK(key *ecdh.PublicKey) ([]byte, error) {
+	k, err := publicJWK(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(k)
+}
+
+// publicJWK returns the public members of the JSON Web Key of key.
+func publicJWK(key *ecdh.PublicKey) (jwk, error) {
+	for name, curve := range jwkCurves {
+		if curve != key.Curve() {
+			continue
+		}
+
+		b := key.Bytes()
+
+		if curve == ecdh.X25519() {
+			return jwk{Kty: "OKP", Crv: name, X: base64.RawURLEncoding.EncodeToString(b)}, nil
+		}
+
+		// Uncompressed NIST points are 0x04 || X || Y.
+		size := (len(b) - 1) / 2
+
+		return jwk{
+			Kty: "EC",
+			Crv: name,
+			X:   base64.RawURLEncoding.EncodeToString(b[1 : 1+size]),
+			Y:   base64.RawURLEncoding.EncodeToString(b[1+size:]),
+		}, nil
+	}
+
+	return jwk{}, ErrUnsupportedKey
+}
+
+// parseJWK decodes a JSON Web Key and returns its curve and the encoding of its public
+// key in the format of crypto/ecdh.
+func parseJWK(data []byte) (jwk, ecdh.Curve, []byte, error) {
+	var k jwk
+
+	if err := json.Unmarshal(data, &k); err != nil {
+		return jwk{}, nil, nil, ErrInvalidJWK
+	}
+
+	curve, ok := jwkCurves[k.Crv]
+
+	if !ok || (k.Kty == "OKP") != (curve == ecdh.X25519()) || (k.Kty != "OKP" && k.Kty != "EC") {
+		return jwk{}, nil, nil, ErrInvalidJWK
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+
+	if err != nil {
+		return jwk{}, nil, nil, ErrInvalidJWK
+	}
+
+	if k.Kty == "OKP" {
+		return k, curve, x, nil
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+
+	if err != nil || len(x) != len(y) {
+		return jwk{}, nil, nil, ErrInvalidJWK
+	}
+
+	return k, curve, append(append([]byte{0x04}, x...), y...), nil
+}
@@ -0,0 +1,100 @@
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestJWKRoundTrip verifies that keys of every supported curve survive export to JSON Web
+// Keys and import again, with the key types and members RFC 7518 and RFC 8037 define.
+func TestJWKRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		curve ecdh.Curve
+		kty   string
+		crv   string
+	}{
+		{ecdh.P256(), "EC", "P-256"},
+		{ecdh.P384(), "EC", "P-384"},
+		{ecdh.P521(), "EC", "P-521"},
+		{ecdh.X25519(), "OKP", "X25519"},
+	} {
+		pri, _ := tc.curve.GenerateKey(rand.Reader)
+
+		data, err := MarshalPrivateKeyJWK(pri)
+
+		if err != nil {
+			t.Fatalf("%s: MarshalPrivateKeyJWK failed: %v", tc.crv, err)
+		}
+
+		var members map[string]string
+
+		json.Unmarshal(data, &members)
+
+		if members["kty"] != tc.kty || members["crv"] != tc.crv || members["d"] == "" {
+			t.Errorf("%s: Expected kty %s with a private part, got %s", tc.crv, tc.kty, data)
+		}
+
+		parsed, err := ParsePrivateKeyJWK(data)
+
+		if err != nil || !parsed.Equal(pri) {
+			t.Errorf("%s: Expected the private key to round-trip, got %v", tc.crv, err)
+		}
+
+		pubData, _ := MarshalPublicKeyJWK(pri.PublicKey())
+
+		for _, d := range [][]byte{data, pubData} {
+			pub, err := ParsePublicKeyJWK(d)
+
+			if err != nil || !pub.Equal(pri.PublicKey()) {
+				t.Errorf("%s: Expected the public key to round-trip, got %v", tc.crv, err)
+			}
+		}
+
+		if _, err := ParsePrivateKeyJWK(pubData); !errors.Is(err, ErrInvalidJWK) {
+			t.Errorf("%s: Expected ErrInvalidJWK for a public key, got %v", tc.crv, err)
+		}
+	}
+}
+
+// TestJWKRejectsInvalid verifies that malformed keys, unsupported curves and private
+// parts that do not match the public key are rejected with ErrInvalidJWK.
+func TestJWKRejectsInvalid(t *testing.T) {
+	pri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	other, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	data, _ := MarshalPrivateKeyJWK(pri)
+	otherData, _ := MarshalPrivateKeyJWK(other)
+
+	var mixed map[string]string
+
+	json.Unmarshal(data, &mixed)
+
+	var otherMembers map[string]string
+
+	json.Unmarshal(otherData, &otherMembers)
+
+	mixed["d"] = otherMembers["d"]
+	mismatched, _ := json.Marshal(mixed)
+
+	for name, data := range map[string][]byte{
+		"not JSON":           []byte("{"),
+		"unsupported curve":  []byte(`{"kty":"EC","crv":"secp256k1","x":"AA","y":"AA"}`),
+		"wrong key type":     []byte(`{"kty":"OKP","crv":"P-256","x":"AA"}`),
+		"invalid point":      []byte(`{"kty":"EC","crv":"P-256","x":"AA","y":"AA"}`),
+		"mismatched private": mismatched,
+		"invalid base64":     []byte(`{"kty":"OKP","crv":"X25519","x":"!!"}`),
+	} {
+		_, err := ParsePrivateKeyJWK(data)
+
+		if name != "mismatched private" {
+			_, err = ParsePublicKeyJWK(data)
+		}
+
+		if !errors.Is(err, ErrInvalidJWK) {
+			t.Errorf("%s: Expected ErrInvalidJWK, got %v", name, err)
+		}
+	}
+}
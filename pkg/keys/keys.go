@@ -0,0 +1,178 @@
+// Package keys imports and exports the crypto/ecdh keys used as ratchet and identity
+// keys in the standard formats of other tooling: PKCS#8 and SEC1 private keys, PKIX
+// public keys, their PEM armor, and JSON Web Keys. The parsed keys' Bytes feed directly
+// into doubleratchet.New, or the keys themselves into doubleratchet.NewECDH.
+//
+// P-256, P-384, P-521 and X25519 keys are supported. SEC1 has no encoding for X25519.
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// PEM block types.
+const (
+	pemPKCS8 = "PRIVATE KEY"
+	pemSEC1  = "EC PRIVATE KEY"
+	pemPKIX  = "PUBLIC KEY"
+)
+
+var (
+	// ErrNoPEMBlock is returned when PEM data holds no block of a supported type.
+	ErrNoPEMBlock = errors.New("keys: no supported PEM block found")
+
+	// ErrUnsupportedKey is returned for keys other than P-256, P-384, P-521 and X25519
+	// keys, and for X25519 keys in SEC1.
+	ErrUnsupportedKey = errors.New("keys: unsupported key type")
+)
+
+// ParsePrivateKeyDER parses a private key in PKCS#8 or SEC1 DER.
+func ParsePrivateKeyDER(der []byte) (*ecdh.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return privateKey(key)
+	}
+
+	key, err := x509.ParseECPrivateKey(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key.ECDH()
+}
+
+// ParsePrivateKeyPEM parses the first "PRIVATE KEY" (PKCS#8) or "EC PRIVATE KEY" (SEC1)
+// block of PEM data, skipping other blocks such as EC parameters.
+func ParsePrivateKeyPEM(data []byte) (*ecdh.PrivateKey, error) {
+	der, err := decodePEM(data, pemPKCS8, pemSEC1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePrivateKeyDER(der)
+}
+
+// ParsePublicKeyDER parses a public key in PKIX DER, as found in certificates.
+func ParsePublicKeyDER(der []byte) (*ecdh.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ecdh.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		return k.ECDH()
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// ParsePublicKeyPEM parses the first "PUBLIC KEY" block of PEM data.
+func ParsePublicKeyPEM(data []byte) (*ecdh.PublicKey, error) {
+	der, err := decodePEM(data, pemPKIX)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePublicKeyDER(der)
+}
+
+// MarshalPKCS8 encodes a private key in PKCS#8 DER.
+func MarshalPKCS8(key *ecdh.PrivateKey) ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// MarshalSEC1 encodes a NIST curve private key in SEC1 DER. It returns ErrUnsupportedKey
+// for X25519 keys.
+func MarshalSEC1(key *ecdh.PrivateKey) ([]byte, error) {
+	if key.Curve() == ecdh.X25519() {
+		return nil, ErrUnsupportedKey
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := parsed.(*ecdsa.PrivateKey)
+
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	return x509.MarshalECPrivateKey(ecKey)
+}
+
+// MarshalPrivateKeyPEM encodes a private key as a PKCS#8 "PRIVATE KEY" PEM block.
+func MarshalPrivateKeyPEM(key *ecdh.PrivateKey) ([]byte, error) {
+	der, err := MarshalPKCS8(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemPKCS8, Bytes: der}), nil
+}
+
+// MarshalPublicKeyDER encodes a public key in PKIX DER.
+func MarshalPublicKeyDER(key *ecdh.PublicKey) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(key)
+}
+
+// MarshalPublicKeyPEM encodes a public key as a PKIX "PUBLIC KEY" PEM block.
+func MarshalPublicKeyPEM(key *ecdh.PublicKey) ([]byte, error) {
+	der, err := MarshalPublicKeyDER(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemPKIX, Bytes: der}), nil
+}
+
+// privateKey converts a key returned by x509.ParsePKCS8PrivateKey.
+func privateKey(key any) (*ecdh.PrivateKey, error) {
+	switch k := key.(type) {
+	case *ecdh.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k.ECDH()
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// decodePEM returns the contents of the first block of data with one of types.
+func decodePEM(data []byte, types ...string) ([]byte, error) {
+	for {
+		block, rest := pem.Decode(data)
+
+		if block == nil {
+			return nil, ErrNoPEMBlock
+		}
+
+		for _, t := range types {
+			if block.Type == t {
+				return block.Bytes, nil
+			}
+		}
+
+		data = rest
+	}
+}
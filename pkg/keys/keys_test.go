@@ -0,0 +1,108 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// TestPEMRoundTrip verifies that private and public keys of every supported curve survive
+// export to PEM and import again, and that the imported keys create working sessions.
+func TestPEMRoundTrip(t *testing.T) {
+	for _, curve := range []ecdh.Curve{ecdh.P256(), ecdh.P384(), ecdh.P521(), ecdh.X25519()} {
+		pri, _ := curve.GenerateKey(rand.Reader)
+
+		priPEM, err := MarshalPrivateKeyPEM(pri)
+
+		if err != nil {
+			t.Fatalf("%v: MarshalPrivateKeyPEM failed: %v", curve, err)
+		}
+
+		pubPEM, err := MarshalPublicKeyPEM(pri.PublicKey())
+
+		if err != nil {
+			t.Fatalf("%v: MarshalPublicKeyPEM failed: %v", curve, err)
+		}
+
+		parsedPri, err := ParsePrivateKeyPEM(priPEM)
+
+		if err != nil || !parsedPri.Equal(pri) {
+			t.Errorf("%v: Expected the private key to round-trip, got %v", curve, err)
+		}
+
+		parsedPub, err := ParsePublicKeyPEM(pubPEM)
+
+		if err != nil || !parsedPub.Equal(pri.PublicKey()) {
+			t.Errorf("%v: Expected the public key to round-trip, got %v", curve, err)
+		}
+	}
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alicePEM, _ := MarshalPrivateKeyPEM(alicePri)
+	bobPubPEM, _ := MarshalPublicKeyPEM(bobPri.PublicKey())
+
+	localPri, _ := ParsePrivateKeyPEM(alicePEM)
+	remotePub, _ := ParsePublicKeyPEM(bobPubPEM)
+
+	alice, err := doubleratchet.New(localPri.Bytes(), remotePub.Bytes(), nil)
+
+	if err != nil {
+		t.Fatalf("New failed with imported keys: %v", err)
+	}
+
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+	msg, _ := alice.Send([]byte("hello"), nil)
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Receive failed: %v", err)
+	}
+}
+
+// TestSEC1 verifies that SEC1 keys, as written by OpenSSL after an EC parameters block,
+// are imported, that SEC1 export matches the ecdsa encoding, and that X25519 keys are
+// refused in SEC1.
+func TestSEC1(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(ecKey)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{0x06, 0x05, 0x2b, 0x81, 0x04, 0x00, 0x22}})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+
+	pri, err := ParsePrivateKeyPEM(data)
+
+	if err != nil {
+		t.Fatalf("ParsePrivateKeyPEM failed: %v", err)
+	}
+
+	want, _ := ecKey.ECDH()
+
+	if !pri.Equal(want) {
+		t.Error("Expected the SEC1 key to be imported unchanged")
+	}
+
+	exported, err := MarshalSEC1(pri)
+
+	if err != nil || !bytes.Equal(exported, der) {
+		t.Errorf("Expected MarshalSEC1 to match the ecdsa encoding, got %v", err)
+	}
+
+	x25519, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	if _, err := MarshalSEC1(x25519); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey for an X25519 key, got %v", err)
+	}
+
+	if _, err := ParsePublicKeyPEM(data); !errors.Is(err, ErrNoPEMBlock) {
+		t.Errorf("Expected ErrNoPEMBlock without a public key block, got %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package ratchetconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+	"github.com/othonhugo/goratchet/pkg/transparency"
+)
+
+// closeNotifyTimeout bounds how long Close waits to deliver the close record.
+const closeNotifyTimeout = 5 * time.Second
+
+// Config configures a ratchet channel or connection.
+type Config struct {
+	// Handshake establishes the Double Ratchet session when the connection is set up.
+	Handshake Handshake
+
+	// Transparency, if set, verifies the peer's identity key against a key transparency
+	// log after the handshake, with the session's VerifyIdentity. The handshake fails if
+	// the log publishes a different key or its tree head does not verify; other outcomes
+	// are reported by Channel.Verification and the session's Info.
+	Transparency *transparency.Verifier
+}
+
+// Channel is an encrypted, framed ratchet channel over any io.ReadWriteCloser, such as
+// a serial port, a Unix socket or an SSH channel.
+//
+// Every frame carries the message's full header and is bound to an implicit sequence
+// number, and the stream is terminated by an authenticated close record, so an attacker
+// between the transport and the ratchet layer cannot drop, reorder, splice or truncate
+// frames undetected.
+type Channel struct {
+	rwc io.ReadWriteCloser
+
+	config    *Config
+	initiator bool
+	interrupt func()
+
+	handshakeOnce     sync.Once
+	handshakeErr      error
+	handshakeComplete atomic.Bool
+
+	session      doubleratchet.DoubleRatchet
+	peerIdentity []byte
+	suite        string
+
+	readMu     sync.Mutex
+	readBuf    []byte
+	readSeq    uint64
+	readClosed bool
+	readErr    error
+
+	writeMu     sync.Mutex
+	writeSeq    uint64
+	writeClosed bool
+}
+
+// ClientChannel returns a new initiating ratchet channel using rwc as the transport.
+// The handshake is run on the first Read or Write, or by calling Handshake.
+func ClientChannel(rwc io.ReadWriteCloser, config *Config) *Channel {
+	return &Channel{rwc: rwc, config: config, initiator: true, interrupt: closeFunc(rwc)}
+}
+
+// ServerChannel returns a new responding ratchet channel using rwc as the transport.
+// The handshake is run on the first Read or Write, or by calling Handshake.
+func ServerChannel(rwc io.ReadWriteCloser, config *Config) *Channel {
+	return &Channel{rwc: rwc, config: config, interrupt: closeFunc(rwc)}
+}
+
+// closeFunc returns a function that interrupts a transport by closing it, for transports
+// that have no deadlines.
+func closeFunc(rwc io.ReadWriteCloser) func() {
+	return func() {
+		_ = rwc.Close()
+	}
+}
+
+// Handshake runs the configured handshake if it has not yet been run.
+func (c *Channel) Handshake() error {
+	return c.HandshakeContext(context.Background())
+}
+
+// HandshakeContext runs the configured handshake if it has not yet been run. If ctx is
+// canceled or its deadline passes before the handshake completes, the handshake is
+// aborted, the transport is interrupted and ctx's error is returned; the channel is then
+// unusable.
+func (c *Channel) HandshakeContext(ctx context.Context) error {
+	c.handshakeOnce.Do(func() {
+		c.handshakeErr = c.handshake(ctx)
+	})
+
+	return c.handshakeErr
+}
+
+// handshake runs the configured handshake, interrupting the transport when ctx is done.
+func (c *Channel) handshake(ctx context.Context) error {
+	if c.config == nil || c.config.Handshake == nil {
+		return ErrNoHandshake
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, c.interrupt)
+
+	result, err := c.config.Handshake.Handshake(c.rwc, c.initiator)
+
+	if !stop() {
+		// The transport was interrupted, even if the handshake happened to finish first.
+		return ctx.Err()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.config.Transparency != nil && result.PeerIdentity != nil {
+		status, err := result.Session.VerifyIdentity(c.config.Transparency, result.PeerIdentity)
+
+		if status == transparency.StatusMismatch {
+			_ = result.Session.Close()
+			return err
+		}
+	}
+
+	c.session = result.Session
+	c.peerIdentity = result.PeerIdentity
+	c.suite = result.Suite
+	c.handshakeComplete.Store(true)
+
+	return nil
+}
+
+// PeerIdentity returns the peer's identity public key authenticated during the handshake.
+func (c *Channel) PeerIdentity() []byte {
+	if err := c.Handshake(); err != nil {
+		return nil
+	}
+
+	return c.peerIdentity
+}
+
+// Suite returns the name of the cipher suite negotiated during the handshake, or "" if
+// none was negotiated.
+func (c *Channel) Suite() string {
+	if err := c.Handshake(); err != nil {
+		return ""
+	}
+
+	return c.suite
+}
+
+// Verification returns the outcome of checking the peer's identity key against the
+// configured transparency log, or StatusUnverified if none is configured.
+func (c *Channel) Verification() transparency.Status {
+	if err := c.Handshake(); err != nil {
+		return transparency.StatusUnverified
+	}
+
+	return c.session.Info().IdentityStatus
+}
+
+// Read reads decrypted application data from the connection. It returns io.EOF only
+// after the peer's authenticated close record, and ErrTruncated if the transport ends
+// before one arrives.
+func (c *Channel) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.readBuf) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+
+		if c.readClosed {
+			return 0, io.EOF
+		}
+
+		if err := c.readRecord(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.readBuf)
+
+	c.readBuf = c.readBuf[n:]
+
+	return n, nil
+}
+
+// readRecord reads, authenticates and dispatches the next frame.
+func (c *Channel) readRecord() error {
+	frame, err := readFrame(c.rwc)
+
+	if errors.Is(err, io.EOF) {
+		return ErrTruncated
+	}
+
+	if err != nil {
+		return err
+	}
+
+	msg, err := decodeMessage(frame)
+
+	if err != nil {
+		return err
+	}
+
+	unciphered, err := c.session.Receive(msg, frameAD(c.readSeq))
+
+	if err != nil {
+		return err
+	}
+
+	c.readSeq++
+
+	if len(unciphered.Plaintext) == 0 {
+		return ErrMalformedFrame
+	}
+
+	switch unciphered.Plaintext[0] {
+	case recordData:
+		c.readBuf = unciphered.Plaintext[1:]
+	case recordClose:
+		c.readClosed = true
+	default:
+		return ErrMalformedFrame
+	}
+
+	return nil
+}
+
+// Write encrypts b and writes it to the connection, splitting it into several frames if needed.
+func (c *Channel) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeClosed {
+		return 0, net.ErrClosed
+	}
+
+	var written int
+
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxPayloadSize)]
+
+		if err := c.writeRecord(recordData, chunk); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// writeRecord encrypts and writes a single frame of the given record type.
+func (c *Channel) writeRecord(typ byte, payload []byte) error {
+	plaintext := make([]byte, 1+len(payload))
+
+	plaintext[0] = typ
+	copy(plaintext[1:], payload)
+
+	ciphered, err := c.session.Send(plaintext, frameAD(c.writeSeq))
+
+	if err != nil {
+		return err
+	}
+
+	c.writeSeq++
+
+	data, err := encodeMessage(ciphered)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFrame(c.rwc, data)
+}
+
+// Close sends an authenticated close record, if the handshake completed, and closes
+// the underlying transport. If the transport supports write deadlines, delivery of the
+// close record is bounded by a timeout.
+func (c *Channel) Close() error {
+	var notifyErr error
+
+	if c.handshakeComplete.Load() {
+		c.writeMu.Lock()
+
+		if !c.writeClosed {
+			c.writeClosed = true
+
+			if d, ok := c.rwc.(interface{ SetWriteDeadline(time.Time) error }); ok {
+				_ = d.SetWriteDeadline(time.Now().Add(closeNotifyTimeout))
+			}
+
+			notifyErr = c.writeRecord(recordClose, nil)
+		}
+
+		c.writeMu.Unlock()
+	}
+
+	if err := c.rwc.Close(); err != nil {
+		return err
+	}
+
+	return notifyErr
+}
@@ -0,0 +1,104 @@
+package ratchetconn
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// pipeRWC joins the read end of one io.Pipe and the write end of another into an
+// io.ReadWriteCloser that is not a net.Conn.
+type pipeRWC struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeRWC) Close() error {
+	return errors.Join(p.PipeReader.Close(), p.PipeWriter.Close())
+}
+
+// TestChannelOverPlainReadWriteCloser verifies that a ratchet channel works over an
+// arbitrary io.ReadWriteCloser, including the X3DH handshake, data transfer and the
+// authenticated close record.
+func TestChannelOverPlainReadWriteCloser(t *testing.T) {
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+
+	client := ClientChannel(pipeRWC{clientRead, clientWrite}, &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+	server := ServerChannel(pipeRWC{serverRead, serverWrite}, &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+
+	go func() {
+		if _, err := client.Write([]byte("over a pipe")); err != nil {
+			t.Error(err)
+		}
+
+		client.Close()
+	}()
+
+	data, err := io.ReadAll(server)
+
+	if err != nil {
+		t.Fatalf("Expected clean EOF, got %v", err)
+	}
+
+	if string(data) != "over a pipe" {
+		t.Errorf("Expected 'over a pipe', got '%s'", data)
+	}
+}
+
+// TestFrameCarriesFullHeader verifies that a frame carries every field of the message
+// header and the escrow block, that a frame without an escrow block keeps the layout of
+// earlier versions, and that a frame whose header is cut short is rejected as malformed.
+func TestFrameCarriesFullHeader(t *testing.T) {
+	msg := doubleratchet.CipheredMessage{
+		Header: doubleratchet.Header{
+			DH:        []byte("ratchet key"),
+			N:         3,
+			PN:        2,
+			Padding:   []byte{0, 0, 0},
+			PQ:        &doubleratchet.HybridHeader{Key: []byte("encapsulation key")},
+			Version:   1,
+			Type:      doubleratchet.MessageRekeyRequest,
+			Timestamp: 1700000000,
+			MessageID: []byte("id"),
+		},
+		Ciphertext: []byte("ciphertext"),
+		Escrow:     []byte("escrow block"),
+	}
+
+	data, err := encodeMessage(msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeMessage(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("Expected %+v, got %+v", msg, decoded)
+	}
+
+	plain := msg
+	plain.Escrow = nil
+
+	data, _ = encodeMessage(plain)
+
+	if data[0]&0x80 != 0 {
+		t.Error("Expected no escrow flag in a frame without an escrow block")
+	}
+
+	if decoded, err := decodeMessage(data); err != nil || !reflect.DeepEqual(decoded, plain) {
+		t.Errorf("Expected %+v, got %+v, %v", plain, decoded, err)
+	}
+
+	if _, err := decodeMessage(data[:10]); !errors.Is(err, ErrMalformedFrame) {
+		t.Errorf("Expected ErrMalformedFrame for a truncated header, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Package ratchetconn wraps a net.Conn, or any io.ReadWriteCloser, so that everything
+// written to it is encrypted with a Double Ratchet session established during setup.
+package ratchetconn
+
+import (
+	"net"
+	"time"
+)
+
+// Conn is a net.Conn whose payloads are protected by a Double Ratchet session.
+type Conn struct {
+	*Channel
+
+	conn net.Conn
+}
+
+// Client returns a new client-side ratchet connection using conn as the transport.
+// The handshake is run on the first Read or Write, or by calling Handshake.
+func Client(conn net.Conn, config *Config) *Conn {
+	c := &Conn{Channel: ClientChannel(conn, config), conn: conn}
+	c.interrupt = c.expireDeadline
+
+	return c
+}
+
+// Server returns a new server-side ratchet connection using conn as the transport.
+// The handshake is run on the first Read or Write, or by calling Handshake.
+func Server(conn net.Conn, config *Config) *Conn {
+	c := &Conn{Channel: ServerChannel(conn, config), conn: conn}
+	c.interrupt = c.expireDeadline
+
+	return c
+}
+
+// expireDeadline interrupts pending I/O on the underlying connection by moving its
+// deadline into the past.
+func (c *Conn) expireDeadline() {
+	_ = c.conn.SetDeadline(time.Unix(1, 0))
+}
+
+// LocalAddr returns the local network address of the underlying connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address of the underlying connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,695 @@
+package ratchetconn
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+	"github.com/othonhugo/goratchet/pkg/transparency"
+)
+
+// pipe returns a client and server Conn connected through an in-memory net.Pipe.
+func pipe(t *testing.T, clientConfig, serverConfig *Config) (*Conn, *Conn) {
+	t.Helper()
+
+	a, b := net.Pipe()
+
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	return Client(a, clientConfig), Server(b, serverConfig)
+}
+
+// handshakeBoth runs both handshakes concurrently and returns their errors.
+func handshakeBoth(client, server *Conn) (clientErr, serverErr error) {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- server.Handshake()
+	}()
+
+	clientErr = client.Handshake()
+
+	if clientErr != nil {
+		client.Close()
+	}
+
+	serverErr = <-done
+
+	return clientErr, serverErr
+}
+
+func generateKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// TestX3DHHandshakeAndBidirectionalTraffic verifies that two connections configured with
+// the X3DH handshake authenticate each other's identity keys and can exchange data in
+// both directions, including writes larger than a single frame.
+func TestX3DHHandshakeAndBidirectionalTraffic(t *testing.T) {
+	aliceIK := generateKey(t)
+	bobIK := generateKey(t)
+
+	var seenByAlice, seenByBob []byte
+
+	client, server := pipe(t,
+		&Config{Handshake: X3DH{IdentityKey: aliceIK, VerifyPeer: func(id []byte) error {
+			seenByAlice = id
+			return nil
+		}}},
+		&Config{Handshake: X3DH{IdentityKey: bobIK, VerifyPeer: func(id []byte) error {
+			seenByBob = id
+			return nil
+		}}},
+	)
+
+	if clientErr, serverErr := handshakeBoth(client, server); clientErr != nil || serverErr != nil {
+		t.Fatalf("Handshake failed: client=%v server=%v", clientErr, serverErr)
+	}
+
+	if !bytes.Equal(seenByAlice, bobIK.PublicKey().Bytes()) || !bytes.Equal(client.PeerIdentity(), seenByAlice) {
+		t.Error("Client did not authenticate the server identity key")
+	}
+
+	if !bytes.Equal(seenByBob, aliceIK.PublicKey().Bytes()) || !bytes.Equal(server.PeerIdentity(), seenByBob) {
+		t.Error("Server did not authenticate the client identity key")
+	}
+
+	payload := bytes.Repeat([]byte("ratchet"), maxPayloadSize)
+
+	go func() {
+		client.Write(payload)
+	}()
+
+	received := make([]byte, len(payload))
+
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, payload) {
+		t.Fatal("Server received corrupted payload")
+	}
+
+	go func() {
+		server.Write([]byte("pong"))
+	}()
+
+	reply := make([]byte, 4)
+
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(reply) != "pong" {
+		t.Errorf("Expected 'pong', got '%s'", reply)
+	}
+}
+
+// TestX3DHHandshakeRejectedByVerifyCallback verifies that an error returned from the
+// identity verification callback aborts the handshake and is surfaced to the caller.
+func TestX3DHHandshakeRejectedByVerifyCallback(t *testing.T) {
+	errUntrusted := errors.New("untrusted identity")
+
+	client, server := pipe(t,
+		&Config{Handshake: X3DH{IdentityKey: generateKey(t), VerifyPeer: func([]byte) error {
+			return errUntrusted
+		}}},
+		&Config{Handshake: X3DH{IdentityKey: generateKey(t)}},
+	)
+
+	clientErr, _ := handshakeBoth(client, server)
+
+	if !errors.Is(clientErr, errUntrusted) {
+		t.Fatalf("Expected verification error, got %v", clientErr)
+	}
+
+	if _, err := client.Write([]byte("data")); !errors.Is(err, errUntrusted) {
+		t.Errorf("Expected Write to fail after rejected handshake, got %v", err)
+	}
+}
+
+// TestX3DHSuiteNegotiation verifies that X3DH peers with different suite preferences
+// agree on the responder's most preferred suite that the initiator offered, and that the
+// resulting sessions exchange data in both directions using it, including escrowed ones.
+func TestX3DHSuiteNegotiation(t *testing.T) {
+	sha256Suite := Suite{Name: "sha256"}
+	sha512Suite := Suite{Name: "sha512", Options: []doubleratchet.Option{doubleratchet.WithKDFHash(doubleratchet.KDFSHA512)}}
+	cbcSuite := Suite{Name: "cbc", Options: []doubleratchet.Option{doubleratchet.WithAEAD(doubleratchet.CBCHMAC{})}}
+	escrowSuite := Suite{Name: "escrow", Options: []doubleratchet.Option{doubleratchet.WithEscrow(generateKey(t).PublicKey(), doubleratchet.EscrowAcknowledgement)}}
+
+	for _, tc := range []struct {
+		client []Suite
+		want   string
+	}{
+		{[]Suite{sha256Suite, sha512Suite}, "sha512"},
+		{[]Suite{sha256Suite, cbcSuite}, "cbc"},
+		{[]Suite{escrowSuite}, "escrow"},
+	} {
+		client, server := pipe(t,
+			&Config{Handshake: X3DH{IdentityKey: generateKey(t), Suites: tc.client}},
+			&Config{Handshake: X3DH{IdentityKey: generateKey(t), Suites: []Suite{cbcSuite, sha512Suite, sha256Suite, escrowSuite}}},
+		)
+
+		if clientErr, serverErr := handshakeBoth(client, server); clientErr != nil || serverErr != nil {
+			t.Fatalf("%s: Handshake failed: client=%v server=%v", tc.want, clientErr, serverErr)
+		}
+
+		if client.Suite() != tc.want || server.Suite() != tc.want {
+			t.Errorf("Expected both sides to negotiate %s, got %q and %q", tc.want, client.Suite(), server.Suite())
+		}
+
+		for _, dir := range []struct {
+			from, to *Conn
+		}{{client, server}, {server, client}} {
+			go func() {
+				dir.from.Write([]byte("ping"))
+			}()
+
+			received := make([]byte, 4)
+
+			if _, err := io.ReadFull(dir.to, received); err != nil {
+				t.Fatalf("%s: %v", tc.want, err)
+			}
+
+			if string(received) != "ping" {
+				t.Errorf("%s: Expected 'ping', got '%s'", tc.want, received)
+			}
+		}
+	}
+}
+
+// TestX3DHNoCommonSuite verifies that the handshake fails with ErrNoCommonSuite on both
+// sides when the peers' suites do not overlap, or when only one peer configures suites.
+func TestX3DHNoCommonSuite(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		client, server []Suite
+	}{
+		{"disjoint", []Suite{{Name: "a"}}, []Suite{{Name: "b"}}},
+		{"client only", []Suite{{Name: "a"}}, nil},
+		{"server only", nil, []Suite{{Name: "a"}}},
+	} {
+		client, server := pipe(t,
+			&Config{Handshake: X3DH{IdentityKey: generateKey(t), Suites: tc.client}},
+			&Config{Handshake: X3DH{IdentityKey: generateKey(t), Suites: tc.server}},
+		)
+
+		clientErr, serverErr := handshakeBoth(client, server)
+
+		if !errors.Is(clientErr, ErrNoCommonSuite) {
+			t.Errorf("%s: expected ErrNoCommonSuite on the client, got %v", tc.name, clientErr)
+		}
+
+		if !errors.Is(serverErr, ErrNoCommonSuite) {
+			t.Errorf("%s: expected ErrNoCommonSuite on the server, got %v", tc.name, serverErr)
+		}
+	}
+}
+
+// TestTransparencyVerification verifies that the peer's identity key is checked against
+// the configured transparency log: a published key is reported as verified by the
+// connection and the session's Info, and a key that differs from the log's, or a log
+// whose tree head is not signed by the configured key, aborts the handshake.
+func TestTransparencyVerification(t *testing.T) {
+	bobIK := generateKey(t)
+	log := transparency.NewMemoryLog()
+
+	if _, err := log.Publish("bob", bobIK.PublicKey().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := pipe(t,
+		&Config{Handshake: X3DH{IdentityKey: generateKey(t)}, Transparency: &transparency.Verifier{Log: log, LogKey: log.PublicKey(), Identity: "bob"}},
+		&Config{Handshake: X3DH{IdentityKey: bobIK}},
+	)
+
+	if clientErr, serverErr := handshakeBoth(client, server); clientErr != nil || serverErr != nil {
+		t.Fatalf("Handshake failed: %v, %v", clientErr, serverErr)
+	}
+
+	if status := client.Verification(); status != transparency.StatusVerified {
+		t.Errorf("Expected %v, got %v", transparency.StatusVerified, status)
+	}
+
+	if status := client.session.Info().IdentityStatus; status != transparency.StatusVerified {
+		t.Errorf("Expected the session's Info to report %v, got %v", transparency.StatusVerified, status)
+	}
+
+	if status := server.Verification(); status != transparency.StatusUnverified {
+		t.Errorf("Expected %v, got %v", transparency.StatusUnverified, status)
+	}
+
+	client, server = pipe(t,
+		&Config{Handshake: X3DH{IdentityKey: generateKey(t)}, Transparency: &transparency.Verifier{Log: log, LogKey: log.PublicKey(), Identity: "bob"}},
+		&Config{Handshake: X3DH{IdentityKey: generateKey(t)}},
+	)
+
+	if clientErr, _ := handshakeBoth(client, server); !errors.Is(clientErr, transparency.ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", clientErr)
+	}
+
+	client, server = pipe(t,
+		&Config{Handshake: X3DH{IdentityKey: generateKey(t)}, Transparency: &transparency.Verifier{Log: log, LogKey: transparency.NewMemoryLog().PublicKey(), Identity: "bob"}},
+		&Config{Handshake: X3DH{IdentityKey: bobIK}},
+	)
+
+	if clientErr, _ := handshakeBoth(client, server); !errors.Is(clientErr, transparency.ErrInvalidTreeHead) {
+		t.Errorf("Expected ErrInvalidTreeHead, got %v", clientErr)
+	}
+}
+
+// TestStaticKeysHandshake verifies that connections can be built from pre-shared static
+// keys without any handshake traffic.
+func TestStaticKeysHandshake(t *testing.T) {
+	alice := generateKey(t)
+	bob := generateKey(t)
+
+	client, server := pipe(t,
+		&Config{Handshake: StaticKeys{PrivateKey: alice, PeerPublicKey: bob.PublicKey()}},
+		&Config{Handshake: StaticKeys{PrivateKey: bob, PeerPublicKey: alice.PublicKey()}},
+	)
+
+	go func() {
+		client.Write([]byte("hello"))
+	}()
+
+	buf := make([]byte, 5)
+
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", buf)
+	}
+}
+
+// TestX25519Handshakes verifies that the X3DH and StaticKeys handshakes build sessions on
+// the curve of their keys, so that X25519 peers exchange data.
+func TestX25519Handshakes(t *testing.T) {
+	keys := make([]*ecdh.PrivateKey, 2)
+
+	for i := range keys {
+		keys[i], _ = ecdh.X25519().GenerateKey(rand.Reader)
+	}
+
+	for name, handshakes := range map[string][2]Handshake{
+		"X3DH":       {X3DH{IdentityKey: keys[0]}, X3DH{IdentityKey: keys[1]}},
+		"StaticKeys": {StaticKeys{PrivateKey: keys[0], PeerPublicKey: keys[1].PublicKey()}, StaticKeys{PrivateKey: keys[1], PeerPublicKey: keys[0].PublicKey()}},
+	} {
+		client, server := pipe(t, &Config{Handshake: handshakes[0]}, &Config{Handshake: handshakes[1]})
+
+		if clientErr, serverErr := handshakeBoth(client, server); clientErr != nil || serverErr != nil {
+			t.Fatalf("%s: handshake failed: client=%v server=%v", name, clientErr, serverErr)
+		}
+
+		go func() {
+			client.Write([]byte("hello"))
+		}()
+
+		buf := make([]byte, 5)
+
+		if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("%s: expected 'hello', got '%s' (%v)", name, buf, err)
+		}
+	}
+}
+
+// TestSharedSecretHandshake verifies that connections bootstrapped from an externally
+// agreed secret and the responder's ratchet key exchange data in both directions once
+// the initiator has written, and that the responder cannot write first.
+func TestSharedSecretHandshake(t *testing.T) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	prekey := generateKey(t)
+
+	client, server := pipe(t,
+		&Config{Handshake: SharedSecret{Secret: secret, PeerRatchetKey: prekey.PublicKey()}},
+		&Config{Handshake: SharedSecret{Secret: secret, RatchetKey: prekey}},
+	)
+
+	if clientErr, serverErr := handshakeBoth(client, server); clientErr != nil || serverErr != nil {
+		t.Fatalf("Handshake failed: client=%v server=%v", clientErr, serverErr)
+	}
+
+	if _, err := server.Write([]byte("early")); !errors.Is(err, doubleratchet.ErrNoSendingChain) {
+		t.Errorf("Expected ErrNoSendingChain for a write before the first read, got %v", err)
+	}
+
+	go func() {
+		client.Write([]byte("hello"))
+	}()
+
+	buf := make([]byte, 5)
+
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Expected 'hello', got '%s' (%v)", buf, err)
+	}
+
+	go func() {
+		server.Write([]byte("howdy"))
+	}()
+
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "howdy" {
+		t.Errorf("Expected 'howdy', got '%s' (%v)", buf, err)
+	}
+
+	if _, err := (SharedSecret{Secret: secret}).Handshake(nil, true); !errors.Is(err, ErrNilRatchetKey) {
+		t.Errorf("Expected ErrNilRatchetKey, got %v", err)
+	}
+}
+
+// TestMissingHandshakeConfiguration verifies that a connection without a configured
+// handshake refuses to carry data.
+func TestMissingHandshakeConfiguration(t *testing.T) {
+	client, _ := pipe(t, &Config{}, &Config{})
+
+	if _, err := client.Write([]byte("data")); !errors.Is(err, ErrNoHandshake) {
+		t.Errorf("Expected ErrNoHandshake, got %v", err)
+	}
+}
+
+// TestDialAndListenerAccept verifies that connections from Dial and Listener.Accept
+// complete the handshake and exchange data.
+func TestDialAndListenerAccept(t *testing.T) {
+	listener, err := Listen("tcp", "127.0.0.1:0", &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			t.Error(err)
+		} else if err := conn.(*Conn).Handshake(); err != nil {
+			t.Error(err)
+		}
+
+		accepted <- conn
+	}()
+
+	client, err := Dial("tcp", listener.Addr().String(), &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	server := <-accepted
+
+	if server == nil {
+		t.Fatal("Accept returned no connection")
+	}
+
+	defer server.Close()
+
+	if _, err := client.Write([]byte("over tcp")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 8)
+
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "over tcp" {
+		t.Errorf("Expected 'over tcp', got '%s'", buf)
+	}
+}
+
+// TestListenerAcceptSilentPeer verifies that a peer that connects and never sends its
+// handshake does not hold up the next Accept, and that a deadline bounds the handshake of
+// its own connection.
+func TestListenerAcceptSilentPeer(t *testing.T) {
+	listener, err := Listen("tcp", "127.0.0.1:0", &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	silent, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer silent.Close()
+
+	stalled, err := listener.Accept()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stalled.Close()
+
+	go func() {
+		if client, err := Dial("tcp", listener.Addr().String(), &Config{Handshake: X3DH{IdentityKey: generateKey(t)}}); err == nil {
+			client.Write([]byte("next"))
+			client.Close()
+		}
+	}()
+
+	next, err := listener.Accept()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer next.Close()
+
+	buf := make([]byte, 4)
+
+	if _, err := io.ReadFull(next, buf); err != nil || string(buf) != "next" {
+		t.Errorf("Expected 'next', got '%s' (%v)", buf, err)
+	}
+
+	_ = stalled.SetDeadline(time.Now().Add(50 * time.Millisecond))
+
+	var netErr net.Error
+
+	if _, err := stalled.Read(buf); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Expected the silent peer's handshake to time out, got %v", err)
+	}
+}
+
+// bufferConn is a net.Conn backed by an in-memory buffer, used to capture and replay frames.
+type bufferConn struct {
+	net.Conn
+	bytes.Buffer
+}
+
+func (b *bufferConn) Read(p []byte) (int, error)       { return b.Buffer.Read(p) }
+func (b *bufferConn) Write(p []byte) (int, error)      { return b.Buffer.Write(p) }
+func (b *bufferConn) Close() error                     { return nil }
+func (b *bufferConn) SetWriteDeadline(time.Time) error { return nil }
+
+// staticPair returns a client writing into one buffer and a server reading from another.
+func staticPair(t *testing.T) (client *Conn, clientOut, serverIn *bufferConn, server *Conn) {
+	t.Helper()
+
+	alice := generateKey(t)
+	bob := generateKey(t)
+
+	clientOut = &bufferConn{}
+	serverIn = &bufferConn{}
+
+	client = Client(clientOut, &Config{Handshake: StaticKeys{PrivateKey: alice, PeerPublicKey: bob.PublicKey()}})
+	server = Server(serverIn, &Config{Handshake: StaticKeys{PrivateKey: bob, PeerPublicKey: alice.PublicKey()}})
+
+	return client, clientOut, serverIn, server
+}
+
+// splitFrames splits a captured stream into its raw length-prefixed frames.
+func splitFrames(t *testing.T, stream []byte) [][]byte {
+	t.Helper()
+
+	var frames [][]byte
+
+	r := bytes.NewReader(stream)
+
+	for r.Len() > 0 {
+		data, err := readFrame(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frame := make([]byte, frameLengthSize+len(data))
+
+		binary.BigEndian.PutUint32(frame, uint32(len(data)))
+		copy(frame[frameLengthSize:], data)
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+// TestCloseRecordSignalsCleanEOF verifies that the reader sees io.EOF only after the
+// peer's authenticated close record.
+func TestCloseRecordSignalsCleanEOF(t *testing.T) {
+	client, clientOut, serverIn, server := staticPair(t)
+
+	client.Write([]byte("bye"))
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	serverIn.Write(clientOut.Bytes())
+
+	data, err := io.ReadAll(server)
+
+	if err != nil {
+		t.Fatalf("Expected clean EOF, got %v", err)
+	}
+
+	if string(data) != "bye" {
+		t.Errorf("Expected 'bye', got '%s'", data)
+	}
+}
+
+// TestTruncatedStreamDetected verifies that a stream cut before the close record, or in
+// the middle of a frame, is reported as truncated instead of a clean EOF.
+func TestTruncatedStreamDetected(t *testing.T) {
+	client, clientOut, serverIn, server := staticPair(t)
+
+	client.Write([]byte("first"))
+	client.Write([]byte("second"))
+	client.Close()
+
+	frames := splitFrames(t, clientOut.Bytes())
+
+	// Drop the close record.
+	for _, frame := range frames[:len(frames)-1] {
+		serverIn.Write(frame)
+	}
+
+	if _, err := io.ReadAll(server); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+
+	client, clientOut, serverIn, server = staticPair(t)
+
+	client.Write([]byte("partial"))
+
+	serverIn.Write(clientOut.Bytes()[:clientOut.Len()-3])
+
+	if _, err := io.ReadAll(server); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// TestReorderedOrDroppedFramesRejected verifies that frames spliced out of their original
+// order, or with a frame removed, fail authentication.
+func TestReorderedOrDroppedFramesRejected(t *testing.T) {
+	client, clientOut, serverIn, server := staticPair(t)
+
+	client.Write([]byte("one"))
+	client.Write([]byte("two"))
+	client.Write([]byte("three"))
+
+	frames := splitFrames(t, clientOut.Bytes())
+
+	serverIn.Write(frames[1])
+	serverIn.Write(frames[0])
+
+	if _, err := server.Read(make([]byte, 16)); err == nil {
+		t.Error("Expected reordered frame to be rejected")
+	}
+
+	client, clientOut, serverIn, server = staticPair(t)
+
+	client.Write([]byte("one"))
+	client.Write([]byte("two"))
+
+	frames = splitFrames(t, clientOut.Bytes())
+
+	serverIn.Write(frames[1])
+
+	if _, err := server.Read(make([]byte, 16)); err == nil {
+		t.Error("Expected frame following a dropped frame to be rejected")
+	}
+}
+
+// TestHandshakeContextDeadline verifies that a handshake with an unresponsive peer is
+// aborted when the context expires, over both a net.Conn and a plain io.ReadWriteCloser.
+func TestHandshakeContextDeadline(t *testing.T) {
+	client, _ := pipe(t, &Config{Handshake: X3DH{IdentityKey: generateKey(t)}}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := client.HandshakeContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if _, err := client.Write([]byte("data")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Write to fail after an aborted handshake, got %v", err)
+	}
+
+	clientRead, _ := io.Pipe()
+	_, clientWrite := io.Pipe()
+
+	channel := ClientChannel(pipeRWC{clientRead, clientWrite}, &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+
+	ctx, cancel = context.WithCancel(context.Background())
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if err := channel.HandshakeContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// TestDialContextCanceled verifies that DialContext returns once its context is canceled
+// while the server never completes the handshake.
+func TestDialContextCanceled(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer inner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = DialContext(ctx, "tcp", inner.Addr().String(), &Config{Handshake: X3DH{IdentityKey: generateKey(t)}})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
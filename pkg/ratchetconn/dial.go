@@ -0,0 +1,69 @@
+package ratchetconn
+
+import (
+	"context"
+	"net"
+)
+
+// Dial connects to the given address and performs the configured handshake as the initiator.
+func Dial(network, address string, config *Config) (*Conn, error) {
+	return DialContext(context.Background(), network, address, config)
+}
+
+// DialContext is like Dial, but ctx bounds both connecting and the handshake. Once the
+// connection is returned, ctx no longer affects it.
+func DialContext(ctx context.Context, network, address string, config *Config) (*Conn, error) {
+	var dialer net.Dialer
+
+	raw, err := dialer.DialContext(ctx, network, address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn := Client(raw, config)
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Listener accepts connections that perform the configured handshake as the responder.
+type Listener struct {
+	net.Listener
+
+	config *Config
+}
+
+// NewListener wraps an existing net.Listener.
+func NewListener(inner net.Listener, config *Config) *Listener {
+	return &Listener{Listener: inner, config: config}
+}
+
+// Listen announces on the local network address and returns a ratchet Listener.
+func Listen(network, address string, config *Config) (*Listener, error) {
+	inner, err := net.Listen(network, address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewListener(inner, config), nil
+}
+
+// Accept waits for the next connection and returns it without running the handshake, so
+// that a peer that stays silent cannot hold up later connections. As with crypto/tls, the
+// handshake runs on the first Read or Write, or by calling Handshake or HandshakeContext;
+// a deadline set on the connection bounds it.
+func (l *Listener) Accept() (net.Conn, error) {
+	raw, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Server(raw, l.config), nil
+}
@@ -0,0 +1,172 @@
+package ratchetconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+const (
+	// MaxFrameSize is the maximum size in bytes of a single frame on the wire.
+	MaxFrameSize = 10 * 1024 * 1024
+
+	// maxPayloadSize is the maximum amount of plaintext carried by a single frame.
+	maxPayloadSize = 16 * 1024
+
+	// frameLengthSize is the size in bytes of the length prefix of every frame.
+	frameLengthSize = 4
+
+	// frameEscrowFlag is set in the header length of a message that carries an escrow
+	// block. Headers are far smaller, so the bit is otherwise never set.
+	frameEscrowFlag = 1 << 31
+)
+
+// Record types carried as the first plaintext byte of every frame.
+const (
+	recordData byte = iota
+	recordClose
+)
+
+// frameLabel prefixes the associated data bound into every frame.
+var frameLabel = []byte("goratchet-frame")
+
+var (
+	// ErrFrameTooLarge is returned when a frame exceeds MaxFrameSize.
+	ErrFrameTooLarge = errors.New("ratchetconn: frame too large")
+
+	// ErrMalformedFrame is returned when a frame cannot be decoded into a message.
+	ErrMalformedFrame = errors.New("ratchetconn: malformed frame")
+
+	// ErrTruncated is returned when the transport ends without an authenticated close record.
+	ErrTruncated = errors.New("ratchetconn: stream truncated before close notification")
+)
+
+// frameAD returns the associated data authenticating a frame. It binds the implicit
+// frame sequence number, so dropped, reordered or spliced frames fail decryption. The
+// ciphertext, and with it its length, is authenticated by the AEAD, so a tampered length
+// prefix fails decryption too.
+func frameAD(seq uint64) []byte {
+	ad := make([]byte, 0, len(frameLabel)+8)
+
+	ad = append(ad, frameLabel...)
+
+	return binary.BigEndian.AppendUint64(ad, seq)
+}
+
+// writeFrame writes a length-prefixed frame to w.
+func writeFrame(w io.Writer, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	frame := make([]byte, frameLengthSize+len(data))
+
+	binary.BigEndian.PutUint32(frame, uint32(len(data))) // #nosec G115 -- bounded by MaxFrameSize
+	copy(frame[frameLengthSize:], data)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// readFrame reads a length-prefixed frame from r.
+func readFrame(r io.Reader) ([]byte, error) {
+	var prefix [frameLengthSize]byte
+
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(prefix[:])
+
+	if length > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return data, nil
+}
+
+// encodeMessage encodes a ciphered message as the header length, the header in the
+// layout of doubleratchet.Header.MarshalBinary, and the ciphertext. If the message carries
+// an escrow block, frameEscrowFlag is set in the header length and the block follows the
+// header, prefixed with its uint32 length.
+func encodeMessage(msg doubleratchet.CipheredMessage) ([]byte, error) {
+	header, err := msg.Header.MarshalBinary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) >= frameEscrowFlag {
+		return nil, ErrFrameTooLarge
+	}
+
+	prefix := uint32(len(header)) // #nosec G115 -- checked against frameEscrowFlag
+
+	if len(msg.Escrow) > 0 {
+		prefix |= frameEscrowFlag
+	}
+
+	buf := make([]byte, 0, 8+len(header)+len(msg.Escrow)+len(msg.Ciphertext))
+
+	buf = binary.BigEndian.AppendUint32(buf, prefix)
+	buf = append(buf, header...)
+
+	if len(msg.Escrow) > 0 {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg.Escrow))) // #nosec G115 -- escrow blocks are far below 4 GiB
+		buf = append(buf, msg.Escrow...)
+	}
+
+	return append(buf, msg.Ciphertext...), nil
+}
+
+// decodeMessage is the inverse of encodeMessage.
+func decodeMessage(data []byte) (doubleratchet.CipheredMessage, error) {
+	if len(data) < 4 {
+		return doubleratchet.CipheredMessage{}, ErrMalformedFrame
+	}
+
+	prefix := binary.BigEndian.Uint32(data)
+	headerLen := prefix &^ frameEscrowFlag
+	data = data[4:]
+
+	if uint64(len(data)) < uint64(headerLen) {
+		return doubleratchet.CipheredMessage{}, ErrMalformedFrame
+	}
+
+	var msg doubleratchet.CipheredMessage
+
+	if err := msg.Header.UnmarshalBinary(data[:headerLen]); err != nil {
+		return doubleratchet.CipheredMessage{}, ErrMalformedFrame
+	}
+
+	data = data[headerLen:]
+
+	if prefix&frameEscrowFlag != 0 {
+		if len(data) < 4 {
+			return doubleratchet.CipheredMessage{}, ErrMalformedFrame
+		}
+
+		escrowLen := binary.BigEndian.Uint32(data)
+		data = data[4:]
+
+		if escrowLen == 0 || uint64(len(data)) < uint64(escrowLen) {
+			return doubleratchet.CipheredMessage{}, ErrMalformedFrame
+		}
+
+		msg.Escrow = append([]byte(nil), data[:escrowLen]...)
+		data = data[escrowLen:]
+	}
+
+	msg.Ciphertext = append([]byte(nil), data...)
+
+	return msg, nil
+}
@@ -0,0 +1,425 @@
+package ratchetconn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+var (
+	// ErrNoHandshake is returned when a connection is created without a Handshake.
+	ErrNoHandshake = errors.New("ratchetconn: no handshake configured")
+
+	// ErrNilIdentityKey is returned when the X3DH handshake has no local identity key.
+	ErrNilIdentityKey = errors.New("ratchetconn: identity key is nil")
+
+	// ErrMalformedHandshake is returned when the peer's handshake message cannot be parsed.
+	ErrMalformedHandshake = errors.New("ratchetconn: malformed handshake message")
+
+	// ErrNilRatchetKey is returned when the SharedSecret handshake lacks the ratchet key
+	// its side needs.
+	ErrNilRatchetKey = errors.New("ratchetconn: ratchet key is nil")
+
+	// ErrNoCommonSuite is returned when the X3DH peers configure suites but have none in
+	// common, or only one of them configures suites.
+	ErrNoCommonSuite = errors.New("ratchetconn: no common cipher suite")
+)
+
+// x3dhInfo is the HKDF info string used to derive the X3DH shared secret.
+var x3dhInfo = []byte("goratchet-X3DH")
+
+// Handshake establishes a Double Ratchet session over a freshly opened connection.
+type Handshake interface {
+	// Handshake runs the key agreement over rw. The initiator is the dialing side.
+	Handshake(rw io.ReadWriter, initiator bool) (HandshakeResult, error)
+}
+
+// HandshakeResult holds the outcome of a successful handshake.
+type HandshakeResult struct {
+	// Session is the established Double Ratchet session.
+	Session doubleratchet.DoubleRatchet
+
+	// PeerIdentity is the peer's authenticated identity public key, if the handshake provides one.
+	PeerIdentity []byte
+
+	// Suite is the name of the negotiated suite, if the handshake negotiated one.
+	Suite string
+}
+
+// Suite is a named set of session options, such as a KDF hash and an AEAD, that X3DH
+// peers negotiate. Both peers must map a name to the same options.
+type Suite struct {
+	Name    string
+	Options []doubleratchet.Option
+}
+
+// StaticKeys is a Handshake that performs no network exchange and builds the session
+// from keys both parties already know, as goratchet.NewECDH does. The session uses the
+// curve of the keys.
+type StaticKeys struct {
+	PrivateKey    *ecdh.PrivateKey
+	PeerPublicKey *ecdh.PublicKey
+}
+
+// Handshake implements the Handshake interface.
+func (s StaticKeys) Handshake(_ io.ReadWriter, _ bool) (HandshakeResult, error) {
+	if s.PrivateKey == nil || s.PeerPublicKey == nil {
+		return HandshakeResult{}, ErrNilIdentityKey
+	}
+
+	session, err := doubleratchet.NewECDH(s.PrivateKey, s.PeerPublicKey, nil)
+
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+
+	return HandshakeResult{Session: session, PeerIdentity: s.PeerPublicKey.Bytes()}, nil
+}
+
+// SharedSecret is a Handshake that performs no network exchange and bootstraps the session
+// from a secret the parties agreed on beforehand, such as the output of an X3DH exchange
+// run by the application, as the Double Ratchet specification does. The initiator starts
+// from the responder's ratchet public key, such as its signed prekey, and the responder
+// from the matching private key. The responder cannot write until it has read the
+// initiator's first record.
+type SharedSecret struct {
+	// Secret is the 32-byte shared secret.
+	Secret []byte
+
+	// RatchetKey is the responder's ratchet private key.
+	RatchetKey *ecdh.PrivateKey
+
+	// PeerRatchetKey is the responder's ratchet public key, used by the initiator.
+	PeerRatchetKey *ecdh.PublicKey
+
+	// Options configure the session, for example WithCurve for X25519 ratchet keys.
+	Options []doubleratchet.Option
+}
+
+// Handshake implements the Handshake interface.
+func (s SharedSecret) Handshake(_ io.ReadWriter, initiator bool) (HandshakeResult, error) {
+	var session doubleratchet.DoubleRatchet
+	var err error
+
+	switch {
+	case initiator && s.PeerRatchetKey != nil:
+		session, err = doubleratchet.InitAlice(s.Secret, s.PeerRatchetKey.Bytes(), s.Options...)
+	case !initiator && s.RatchetKey != nil:
+		session, err = doubleratchet.InitBob(s.Secret, s.RatchetKey.Bytes(), s.Options...)
+	default:
+		return HandshakeResult{}, ErrNilRatchetKey
+	}
+
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+
+	return HandshakeResult{Session: session}, nil
+}
+
+// X3DH is an interactive Extended Triple Diffie-Hellman handshake. Both parties exchange
+// their long-term identity key and a fresh ephemeral key, and the session is seeded with
+// the secret derived from DH(IKa, EKb), DH(EKa, IKb) and DH(EKa, EKb). The session uses
+// the curve of the identity key, which both parties must share.
+type X3DH struct {
+	// IdentityKey is the local long-term identity key.
+	IdentityKey *ecdh.PrivateKey
+
+	// VerifyPeer, if set, is called with the peer's identity public key before the session
+	// is derived. Returning an error aborts the handshake.
+	VerifyPeer func(peerIdentity []byte) error
+
+	// Suites lists the suites this side supports, most preferred first. The initiator
+	// offers their names, and the responder chooses the first of its own suites that was
+	// offered, so peers built with different defaults still agree on one. The offer and
+	// the choice are bound into the derived secret. If neither side sets Suites, sessions
+	// use the defaults; if only one side does, or none is common, the handshake fails with
+	// ErrNoCommonSuite.
+	Suites []Suite
+}
+
+// Handshake implements the Handshake interface.
+func (x X3DH) Handshake(rw io.ReadWriter, initiator bool) (HandshakeResult, error) {
+	if x.IdentityKey == nil {
+		return HandshakeResult{}, ErrNilIdentityKey
+	}
+
+	curve := x.IdentityKey.Curve()
+
+	ephemeral, err := curve.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+
+	var peerHello, offer, choice []byte
+	var suite *Suite
+
+	if initiator {
+		offer = x.offer()
+
+		if err := writeFrame(rw, encodeHello(x.IdentityKey.PublicKey().Bytes(), ephemeral.PublicKey().Bytes(), offer)); err != nil {
+			return HandshakeResult{}, err
+		}
+
+		if peerHello, err = readFrame(rw); err != nil {
+			return HandshakeResult{}, err
+		}
+	} else {
+		if peerHello, err = readFrame(rw); err != nil {
+			return HandshakeResult{}, err
+		}
+	}
+
+	peerIKBytes, peerEKBytes, peerSuites, err := decodeHello(peerHello)
+
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+
+	if initiator {
+		choice = peerSuites
+		suite, err = x.chosen(offer, choice)
+	} else {
+		offer = peerSuites
+		suite, choice = x.choose(offer)
+
+		if err := writeFrame(rw, encodeHello(x.IdentityKey.PublicKey().Bytes(), ephemeral.PublicKey().Bytes(), choice)); err != nil {
+			return HandshakeResult{}, err
+		}
+
+		if choice != nil && suite == nil {
+			err = ErrNoCommonSuite
+		}
+	}
+
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+
+	peerIK, err := curve.NewPublicKey(peerIKBytes)
+
+	if err != nil {
+		return HandshakeResult{}, fmt.Errorf("ratchetconn: invalid peer identity key: %w", err)
+	}
+
+	peerEK, err := curve.NewPublicKey(peerEKBytes)
+
+	if err != nil {
+		return HandshakeResult{}, fmt.Errorf("ratchetconn: invalid peer ephemeral key: %w", err)
+	}
+
+	if x.VerifyPeer != nil {
+		if err := x.VerifyPeer(peerIKBytes); err != nil {
+			return HandshakeResult{}, err
+		}
+	}
+
+	sk, err := x.sharedSecret(ephemeral, peerIK, peerEK, initiator, suiteInfo(offer, choice))
+
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+
+	result := HandshakeResult{PeerIdentity: peerIKBytes}
+
+	var opts []doubleratchet.Option
+
+	if suite != nil {
+		result.Suite = suite.Name
+		opts = suite.Options
+	}
+
+	if result.Session, err = doubleratchet.NewECDH(ephemeral, peerEK, sk, opts...); err != nil {
+		return HandshakeResult{}, err
+	}
+
+	return result, nil
+}
+
+// offer encodes the names of the configured suites, or returns nil if there are none.
+func (x X3DH) offer() []byte {
+	if len(x.Suites) == 0 {
+		return nil
+	}
+
+	buf := []byte{}
+
+	for _, s := range x.Suites {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s.Name))) // #nosec G115 -- suite names are short
+		buf = append(buf, s.Name...)
+	}
+
+	return buf
+}
+
+// choose picks the first configured suite named in offer. It returns the suite and the
+// encoded choice, which is empty but not nil if nothing was chosen, or nil, nil if neither
+// side uses suites.
+func (x X3DH) choose(offer []byte) (*Suite, []byte) {
+	if offer == nil && len(x.Suites) == 0 {
+		return nil, nil
+	}
+
+	offered := map[string]bool{}
+
+	for rest := offer; len(rest) > 0; {
+		name, next, ok := cutLengthPrefixed(rest)
+
+		if !ok {
+			return nil, []byte{}
+		}
+
+		offered[string(name)] = true
+		rest = next
+	}
+
+	for i, s := range x.Suites {
+		if offered[s.Name] {
+			return &x.Suites[i], append(binary.BigEndian.AppendUint16(nil, uint16(len(s.Name))), s.Name...) // #nosec G115 -- suite names are short
+		}
+	}
+
+	return nil, []byte{}
+}
+
+// chosen returns the suite the responder chose, verifying that it was offered.
+func (x X3DH) chosen(offer, choice []byte) (*Suite, error) {
+	if offer == nil && choice == nil {
+		return nil, nil
+	}
+
+	name, rest, ok := cutLengthPrefixed(choice)
+
+	if offer == nil || !ok || len(rest) != 0 {
+		return nil, ErrNoCommonSuite
+	}
+
+	for i, s := range x.Suites {
+		if s.Name == string(name) {
+			return &x.Suites[i], nil
+		}
+	}
+
+	return nil, ErrNoCommonSuite
+}
+
+// suiteInfo binds the suite offer and choice into the X3DH secret, so that a modified
+// offer yields different keys on both sides.
+func suiteInfo(offer, choice []byte) []byte {
+	if offer == nil && choice == nil {
+		return x3dhInfo
+	}
+
+	info := append([]byte(nil), x3dhInfo...)
+	info = binary.BigEndian.AppendUint16(info, uint16(len(offer))) // #nosec G115 -- bounded by the frame size
+	info = append(info, offer...)
+
+	return append(info, choice...)
+}
+
+// sharedSecret computes the X3DH secret. The DH outputs are always concatenated in
+// initiator-first order so both parties derive the same value.
+func (x X3DH) sharedSecret(ephemeral *ecdh.PrivateKey, peerIK, peerEK *ecdh.PublicKey, initiator bool, info []byte) ([]byte, error) {
+	dhIdentity, err := x.IdentityKey.ECDH(peerEK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dhEphemeral, err := ephemeral.ECDH(peerIK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dhBoth, err := ephemeral.ECDH(peerEK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ikm bytes.Buffer
+
+	ikm.Write(bytes.Repeat([]byte{0xFF}, 32))
+
+	if initiator {
+		ikm.Write(dhIdentity)
+		ikm.Write(dhEphemeral)
+	} else {
+		ikm.Write(dhEphemeral)
+		ikm.Write(dhIdentity)
+	}
+
+	ikm.Write(dhBoth)
+
+	return crypto.DeriveHKDF(ikm.Bytes(), nil, info, 32)
+}
+
+// encodeHello encodes the identity and ephemeral public keys of a handshake message,
+// followed by the suite offer or choice unless suites is nil.
+func encodeHello(identity, ephemeral, suites []byte) []byte {
+	buf := make([]byte, 0, 6+len(identity)+len(ephemeral)+len(suites))
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(identity))) // #nosec G115 -- public keys are far below 64 KiB
+	buf = append(buf, identity...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(ephemeral))) // #nosec G115 -- public keys are far below 64 KiB
+	buf = append(buf, ephemeral...)
+
+	if suites == nil {
+		return buf
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(suites))) // #nosec G115 -- bounded by the frame size
+
+	return append(buf, suites...)
+}
+
+// decodeHello is the inverse of encodeHello. suites is nil if the message carries none.
+func decodeHello(data []byte) (identity, ephemeral, suites []byte, err error) {
+	identity, data, ok := cutLengthPrefixed(data)
+
+	if !ok {
+		return nil, nil, nil, ErrMalformedHandshake
+	}
+
+	ephemeral, data, ok = cutLengthPrefixed(data)
+
+	if !ok {
+		return nil, nil, nil, ErrMalformedHandshake
+	}
+
+	if len(data) == 0 {
+		return identity, ephemeral, nil, nil
+	}
+
+	suites, data, ok = cutLengthPrefixed(data)
+
+	if !ok || len(data) != 0 {
+		return nil, nil, nil, ErrMalformedHandshake
+	}
+
+	return identity, ephemeral, suites, nil
+}
+
+// cutLengthPrefixed splits a uint16 length-prefixed field off the front of data.
+func cutLengthPrefixed(data []byte) (field, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+
+	n := int(binary.BigEndian.Uint16(data))
+
+	if len(data) < 2+n {
+		return nil, nil, false
+	}
+
+	return data[2 : 2+n], data[2+n:], true
+}
@@ -0,0 +1,71 @@
+// Package ratchettest builds reproducible Double Ratchet sessions and message sequences
+// from a seed, so that a failing scenario can be shared as "seed 42, ops [a b a:1 a:0]"
+// and replayed exactly, down to the ratchet keys and nonces.
+//
+// Everything in this package is predictable by design and must only be used in tests.
+package ratchettest
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// stream is a deterministic byte stream: SHA-256 in counter mode over a seed and label.
+type stream struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+// NewRand returns a deterministic reader whose output depends only on seed.
+func NewRand(seed int64) io.Reader {
+	return newStream(seed, "")
+}
+
+// newStream returns a deterministic reader for seed, domain-separated by label so that
+// each party of a scenario draws from an independent stream.
+func newStream(seed int64, label string) *stream {
+	s := binary.BigEndian.AppendUint64([]byte("goratchet-ratchettest"), uint64(seed)) // #nosec G115 -- reinterpreting the seed's bits
+	s = append(s, label...)
+
+	return &stream{seed: s}
+}
+
+// Read fills p with the next bytes of the stream. It never fails.
+func (s *stream) Read(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		if len(s.buf) == 0 {
+			block := sha256.Sum256(binary.BigEndian.AppendUint64(append([]byte(nil), s.seed...), s.counter))
+
+			s.buf = block[:]
+			s.counter++
+		}
+
+		copied := copy(p, s.buf)
+
+		p, s.buf = p[copied:], s.buf[copied:]
+	}
+
+	return n, nil
+}
+
+// GenerateKey derives a P-256 key pair from random. With a reader from NewRand the key
+// depends only on the seed.
+func GenerateKey(random io.Reader) (*ecdh.PrivateKey, error) {
+	buf := make([]byte, 32)
+
+	for {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return nil, err
+		}
+
+		// Scalars outside the curve order are rejected; draw again.
+		if pri, err := ecdh.P256().NewPrivateKey(buf); err == nil {
+			return pri, nil
+		}
+	}
+}
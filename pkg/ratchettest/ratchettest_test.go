@@ -0,0 +1,89 @@
+package ratchettest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestRunIsDeterministic verifies that running the same scenario twice with the same seed
+// produces byte-identical messages and session states, and that a different seed does not.
+func TestRunIsDeterministic(t *testing.T) {
+	ops := []string{"a", "a", "b", "a:1", "b:0", "a:0", "b", "b:*"}
+
+	first, err := Run(42, ops...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Run(42, ops...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, party := range []string{"a", "b"} {
+		for i := range first.Sent[party] {
+			if !bytes.Equal(first.Sent[party][i].Ciphertext, second.Sent[party][i].Ciphertext) {
+				t.Fatalf("Message %s%d differs between runs", party, i)
+			}
+		}
+	}
+
+	firstState, _ := first.Alice.Serialize()
+	secondState, _ := second.Alice.Serialize()
+
+	if !bytes.Equal(firstState, secondState) {
+		t.Error("Session state differs between runs with the same seed")
+	}
+
+	other, err := Run(43, ops...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(first.Sent["a"][0].Ciphertext, other.Sent["a"][0].Ciphertext) {
+		t.Error("Expected different seeds to produce different messages")
+	}
+}
+
+// TestRunDeliversOutOfOrder verifies that out-of-order deliveries are decrypted and
+// recorded in delivery order.
+func TestRunDeliversOutOfOrder(t *testing.T) {
+	trace, err := Run(7, "a", "a", "a", "a:2", "a:0", "a:*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+
+	for _, plaintext := range trace.Received["b"] {
+		got = append(got, string(plaintext))
+	}
+
+	if strings.Join(got, " ") != "a2 a0 a1" {
+		t.Errorf("Expected %q, got %q", "a2 a0 a1", got)
+	}
+}
+
+// TestRunErrorNamesSeedAndOp verifies that a failing operation is reported with the seed
+// and operation needed to reproduce it, and that malformed operations are rejected.
+func TestRunErrorNamesSeedAndOp(t *testing.T) {
+	_, err := Run(42, "a", "a:0", "a:0")
+
+	if err == nil || !strings.Contains(err.Error(), `seed 42, op 2 "a:0"`) {
+		t.Errorf("Expected a replay failure naming the seed and op, got %v", err)
+	}
+
+	if _, err := Run(42, "c"); !errors.Is(err, ErrInvalidOp) {
+		t.Errorf("Expected ErrInvalidOp, got %v", err)
+	}
+
+	if _, err := Run(42, "a:0"); !errors.Is(err, ErrInvalidOp) {
+		t.Errorf("Expected ErrInvalidOp for an unsent message, got %v", err)
+	}
+}
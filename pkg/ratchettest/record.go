@@ -0,0 +1,251 @@
+package ratchettest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// Operation kinds of a RecordedOp.
+const (
+	OpSend    = "send"
+	OpReceive = "receive"
+)
+
+var (
+	// ErrDiverged is returned by Replay when a replayed operation does not reproduce the
+	// recorded outcome.
+	ErrDiverged = errors.New("ratchettest: replay diverged from recording")
+
+	// ErrRecordingExhausted is returned by Replay when an operation draws more randomness
+	// than was recorded for it.
+	ErrRecordingExhausted = errors.New("ratchettest: replay drew more randomness than recorded")
+)
+
+// Recording is a replayable trace of the operations on one session: its state before the
+// first operation, followed by every Send and Receive with the randomness it consumed.
+//
+// A recording holds the session's secret state and the randomness its future ratchet keys
+// are derived from. Store and transfer it like serialized state.
+type Recording struct {
+	State []byte
+	Ops   []RecordedOp
+}
+
+// RecordedOp is one recorded operation.
+type RecordedOp struct {
+	// Kind is OpSend or OpReceive.
+	Kind string
+
+	// Header is the header of the sent or received message.
+	Header doubleratchet.Header
+
+	// PlaintextSize is the size of the sent plaintext. Plaintexts are not recorded;
+	// replays send zeros of the same size.
+	PlaintextSize int
+
+	// AD is the associated data of the operation.
+	AD []byte
+
+	// Message is the received message.
+	Message *doubleratchet.CipheredMessage `json:",omitempty"`
+
+	// Draws holds the bytes the operation read from the session's randomness.
+	Draws []byte `json:",omitempty"`
+
+	// Err is the error the operation returned, if any.
+	Err string `json:",omitempty"`
+}
+
+// Recorder is a session that records every Send and Receive, so that a production desync
+// can be captured and replayed offline with Replay. Other methods pass through to the
+// session unrecorded.
+type Recorder struct {
+	doubleratchet.DoubleRatchet
+
+	mu        sync.Mutex
+	recording Recording
+	random    *recordingReader
+}
+
+// Record restores a session from state and records the operations on it. opts are
+// applied like for doubleratchet.Deserialize; the session's randomness comes from
+// crypto/rand and is recorded.
+func Record(state []byte, opts ...doubleratchet.Option) (*Recorder, error) {
+	random := &recordingReader{r: rand.Reader}
+
+	session, err := doubleratchet.Deserialize(state, append(opts, doubleratchet.WithRand(random))...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		DoubleRatchet: session,
+		recording:     Recording{State: append([]byte(nil), state...)},
+		random:        random,
+	}, nil
+}
+
+// Recording returns a copy of the recording so far.
+func (r *Recorder) Recording() Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return Recording{
+		State: r.recording.State,
+		Ops:   append([]RecordedOp(nil), r.recording.Ops...),
+	}
+}
+
+// Send encrypts plaintext and records the operation.
+func (r *Recorder) Send(plaintext, ad []byte) (doubleratchet.CipheredMessage, error) {
+	return r.SendCtx(context.Background(), plaintext, ad)
+}
+
+// SendCtx encrypts plaintext and records the operation.
+func (r *Recorder) SendCtx(ctx context.Context, plaintext, ad []byte) (doubleratchet.CipheredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.random.draws = nil
+
+	msg, err := r.DoubleRatchet.SendCtx(ctx, plaintext, ad)
+
+	r.add(RecordedOp{Kind: OpSend, Header: msg.Header, PlaintextSize: len(plaintext), AD: ad}, err)
+
+	return msg, err
+}
+
+// Receive decrypts msg and records the operation.
+func (r *Recorder) Receive(msg doubleratchet.CipheredMessage, ad []byte) (doubleratchet.UncipheredMessage, error) {
+	return r.ReceiveCtx(context.Background(), msg, ad)
+}
+
+// ReceiveCtx decrypts msg and records the operation.
+func (r *Recorder) ReceiveCtx(ctx context.Context, msg doubleratchet.CipheredMessage, ad []byte) (doubleratchet.UncipheredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.random.draws = nil
+
+	unciphered, err := r.DoubleRatchet.ReceiveCtx(ctx, msg, ad)
+
+	r.add(RecordedOp{Kind: OpReceive, Header: msg.Header, AD: ad, Message: &msg}, err)
+
+	return unciphered, err
+}
+
+// add appends op with the randomness drawn for it. The caller must hold r.mu.
+func (r *Recorder) add(op RecordedOp, err error) {
+	op.AD = append([]byte(nil), op.AD...)
+	op.Draws = r.random.draws
+
+	if err != nil {
+		op.Err = err.Error()
+	}
+
+	r.recording.Ops = append(r.recording.Ops, op)
+}
+
+// Replay restores the recorded session and applies the recorded operations in order,
+// feeding each the randomness it drew when it was recorded. It returns an error naming
+// the first operation whose header or error differs from the recording. opts must
+// include any options that affect the session's behavior, such as WithDH or WithAEAD.
+func Replay(recording Recording, opts ...doubleratchet.Option) error {
+	random := &replayReader{}
+
+	session, err := doubleratchet.Deserialize(recording.State, append(opts, doubleratchet.WithRand(random))...)
+
+	if err != nil {
+		return err
+	}
+
+	for i, op := range recording.Ops {
+		random.draws = op.Draws
+
+		var header doubleratchet.Header
+
+		switch op.Kind {
+		case OpSend:
+			var msg doubleratchet.CipheredMessage
+
+			msg, err = session.Send(make([]byte, op.PlaintextSize), op.AD)
+			header = msg.Header
+		case OpReceive:
+			if op.Message == nil {
+				return fmt.Errorf("ratchettest: replay op %d: %w", i, ErrInvalidOp)
+			}
+
+			_, err = session.Receive(*op.Message, op.AD)
+			header = op.Message.Header
+		default:
+			return fmt.Errorf("ratchettest: replay op %d %q: %w", i, op.Kind, ErrInvalidOp)
+		}
+
+		if errors.Is(err, ErrRecordingExhausted) {
+			return fmt.Errorf("ratchettest: replay op %d (%s): %w", i, op.Kind, err)
+		}
+
+		if got := errorString(err); got != op.Err {
+			return fmt.Errorf("ratchettest: replay op %d (%s): error %q, recorded %q: %w", i, op.Kind, got, op.Err, ErrDiverged)
+		}
+
+		if op.Err == "" && !sameHeader(header, op.Header) {
+			return fmt.Errorf("ratchettest: replay op %d (%s): header N=%d PN=%d, recorded N=%d PN=%d: %w",
+				i, op.Kind, header.N, header.PN, op.Header.N, op.Header.PN, ErrDiverged)
+		}
+	}
+
+	return nil
+}
+
+// recordingReader reads from r and keeps a copy of the bytes read.
+type recordingReader struct {
+	r     io.Reader
+	draws []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	r.draws = append(r.draws, p[:n]...)
+
+	return n, err
+}
+
+// replayReader serves recorded randomness.
+type replayReader struct {
+	draws []byte
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	if len(r.draws) < len(p) {
+		return 0, ErrRecordingExhausted
+	}
+
+	n := copy(p, r.draws)
+	r.draws = r.draws[n:]
+
+	return n, nil
+}
+
+// sameHeader reports whether two headers are identical.
+func sameHeader(a, b doubleratchet.Header) bool {
+	return bytes.Equal(a.DH, b.DH) && a.N == b.N && a.PN == b.PN && bytes.Equal(a.Padding, b.Padding)
+}
+
+// errorString returns the message of err, or "" if err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
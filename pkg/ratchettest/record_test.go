@@ -0,0 +1,105 @@
+package ratchettest
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// TestRecordReplay verifies that a recorded exchange, including out-of-order delivery and
+// a failed receive, replays against the recorded state, also after a JSON round trip, and
+// that a tampered recording is reported as a divergence.
+func TestRecordReplay(t *testing.T) {
+	alice, bob, err := NewPair(1, doubleratchet.WithHeaderPadding(16))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := bob.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, err := Record(state, doubleratchet.WithHeaderPadding(16))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []doubleratchet.CipheredMessage
+
+	for _, text := range []string{"one", "two", "three"} {
+		msg, err := alice.Send([]byte(text), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sent = append(sent, msg)
+	}
+
+	for _, i := range []int{2, 0} {
+		if _, err := recorder.Receive(sent[i], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := recorder.Receive(sent[1], []byte("wrong")); err == nil {
+		t.Fatal("Expected a receive with the wrong associated data to fail")
+	}
+
+	if _, err := recorder.Send([]byte("reply"), []byte("ad")); err != nil {
+		t.Fatal(err)
+	}
+
+	recording := recorder.Recording()
+
+	if len(recording.Ops) != 4 {
+		t.Fatalf("Expected 4 recorded operations, got %d", len(recording.Ops))
+	}
+
+	if recording.Ops[2].Err == "" {
+		t.Error("Expected the failed receive to be recorded with its error")
+	}
+
+	if len(recording.Ops[3].Draws) == 0 {
+		t.Error("Expected the send to record its randomness")
+	}
+
+	if err := Replay(recording, doubleratchet.WithHeaderPadding(16)); err != nil {
+		t.Fatalf("Expected the recording to replay, got %v", err)
+	}
+
+	data, err := json.Marshal(recording)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Recording
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Replay(decoded, doubleratchet.WithHeaderPadding(16)); err != nil {
+		t.Errorf("Expected the decoded recording to replay, got %v", err)
+	}
+
+	decoded.Ops[3].Header.N++
+
+	if err := Replay(decoded, doubleratchet.WithHeaderPadding(16)); !errors.Is(err, ErrDiverged) {
+		t.Errorf("Expected ErrDiverged for a tampered header, got %v", err)
+	}
+
+	decoded.Ops[3].Header.N--
+	decoded.Ops[3].Draws = nil
+
+	if err := Replay(decoded, doubleratchet.WithHeaderPadding(16)); !errors.Is(err, ErrRecordingExhausted) {
+		t.Errorf("Expected ErrRecordingExhausted without recorded randomness, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package ratchettest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// ErrInvalidOp is returned by Run for operations it does not understand.
+var ErrInvalidOp = errors.New("ratchettest: invalid operation")
+
+// NewPair returns two sessions, Alice and Bob, whose identity keys, ratchet keys and
+// nonces are all derived from seed. opts are applied to both sessions after the
+// deterministic randomness option.
+func NewPair(seed int64, opts ...doubleratchet.Option) (alice, bob doubleratchet.DoubleRatchet, err error) {
+	aliceRand, bobRand := newStream(seed, "alice"), newStream(seed, "bob")
+
+	alicePri, err := GenerateKey(aliceRand)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bobPri, err := GenerateKey(bobRand)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	alice, err = doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, append([]doubleratchet.Option{doubleratchet.WithRand(aliceRand)}, opts...)...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bob, err = doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, append([]doubleratchet.Option{doubleratchet.WithRand(bobRand)}, opts...)...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return alice, bob, nil
+}
+
+// Trace records the outcome of a scenario run by Run.
+type Trace struct {
+	Alice, Bob doubleratchet.DoubleRatchet
+
+	// Sent holds the messages sent by each party, keyed by "a" or "b", in sending order.
+	Sent map[string][]doubleratchet.CipheredMessage
+
+	// Received holds the plaintexts received by each party, keyed by "a" or "b", in
+	// delivery order.
+	Received map[string][][]byte
+
+	delivered map[string][]bool
+}
+
+// Run builds a pair of sessions from seed and applies ops in order:
+//
+//	"a", "b"       Alice or Bob sends the next message; it is queued, not delivered.
+//	"a:N", "b:N"   the N-th message (from zero) sent by Alice or Bob is delivered to the
+//	               peer. Messages may be delivered in any order, and more than once.
+//	"a:*", "b:*"   every not yet delivered message of Alice or Bob is delivered in order.
+//
+// Message i of a party has plaintext "a<i>" or "b<i>" and associated data "ad". A failing
+// operation stops the run; the error names the seed and operation, so it can be pasted
+// into a bug report and replayed with the same arguments.
+func Run(seed int64, ops ...string) (*Trace, error) {
+	alice, bob, err := NewPair(seed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &Trace{
+		Alice:     alice,
+		Bob:       bob,
+		Sent:      make(map[string][]doubleratchet.CipheredMessage),
+		Received:  make(map[string][][]byte),
+		delivered: make(map[string][]bool),
+	}
+
+	for i, op := range ops {
+		if err := trace.apply(op); err != nil {
+			return trace, fmt.Errorf("ratchettest: seed %d, op %d %q: %w", seed, i, op, err)
+		}
+	}
+
+	return trace, nil
+}
+
+// apply runs a single operation.
+func (t *Trace) apply(op string) error {
+	party, index, found := strings.Cut(op, ":")
+
+	if party != "a" && party != "b" {
+		return ErrInvalidOp
+	}
+
+	if !found {
+		return t.send(party)
+	}
+
+	if index == "*" {
+		for n, done := range t.delivered[party] {
+			if !done {
+				if err := t.deliver(party, n); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	n, err := strconv.Atoi(index)
+
+	if err != nil || n < 0 || n >= len(t.Sent[party]) {
+		return ErrInvalidOp
+	}
+
+	return t.deliver(party, n)
+}
+
+// send makes party send its next message.
+func (t *Trace) send(party string) error {
+	plaintext := fmt.Sprintf("%s%d", party, len(t.Sent[party]))
+
+	msg, err := t.session(party).Send([]byte(plaintext), []byte("ad"))
+
+	if err != nil {
+		return err
+	}
+
+	t.Sent[party] = append(t.Sent[party], msg)
+	t.delivered[party] = append(t.delivered[party], false)
+
+	return nil
+}
+
+// deliver hands the n-th message sent by party to the peer.
+func (t *Trace) deliver(party string, n int) error {
+	peer := "b"
+
+	if party == "b" {
+		peer = "a"
+	}
+
+	unciphered, err := t.session(peer).Receive(t.Sent[party][n], []byte("ad"))
+
+	if err != nil {
+		return err
+	}
+
+	t.delivered[party][n] = true
+	t.Received[peer] = append(t.Received[peer], unciphered.Plaintext)
+
+	return nil
+}
+
+// session returns the session of party.
+func (t *Trace) session(party string) doubleratchet.DoubleRatchet {
+	if party == "a" {
+		return t.Alice
+	}
+
+	return t.Bob
+}
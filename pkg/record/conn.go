@@ -0,0 +1,76 @@
+package record
+
+import (
+	"io"
+	"net"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// maxDatagramSize is the largest datagram Conn reads.
+const maxDatagramSize = 64 * 1024
+
+// Conn exchanges records with a single peer over a net.PacketConn. Like DTLS, records
+// that fail authentication or replay checks are silently discarded.
+type Conn struct {
+	pc    net.PacketConn
+	peer  net.Addr
+	layer *Layer
+}
+
+// NewConn returns a record connection to peer over pc, protected by session.
+func NewConn(pc net.PacketConn, peer net.Addr, session doubleratchet.DoubleRatchet) *Conn {
+	return &Conn{pc: pc, peer: peer, layer: NewLayer(session)}
+}
+
+// Write seals b into a single record and sends it as one datagram.
+func (c *Conn) Write(b []byte) (int, error) {
+	rec, err := c.layer.Seal(b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.pc.WriteTo(rec, c.peer); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Read returns the plaintext of the next valid record from the peer. If b is too small
+// for the record, the plaintext is truncated and io.ErrShortBuffer is returned.
+func (c *Conn) Read(b []byte) (int, error) {
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if addr.String() != c.peer.String() {
+			continue
+		}
+
+		plaintext, err := c.layer.Open(buf[:n])
+
+		if err != nil {
+			continue
+		}
+
+		copied := copy(b, plaintext)
+
+		if copied < len(plaintext) {
+			return copied, io.ErrShortBuffer
+		}
+
+		return copied, nil
+	}
+}
+
+// Close closes the underlying packet connection.
+func (c *Conn) Close() error {
+	return c.pc.Close()
+}
@@ -0,0 +1,284 @@
+// Package record implements a DTLS-style record layer that runs the Double Ratchet
+// directly over an unreliable datagram transport such as UDP.
+//
+// Each record carries an epoch and a sequence number. The epoch identifies the sender's
+// ratchet chain (it advances whenever the sender's ratchet key changes) and the sequence
+// number is the message number within that chain. Receivers keep a sliding replay window
+// per epoch, so duplicated or stale datagrams are rejected before any key derivation.
+//
+// The record carries the message header in the layout of doubleratchet.Header.MarshalBinary
+// and the message's escrow block, so sessions with padding, message types, metadata,
+// escrow or post-quantum fields work over it. The whole record header is authenticated:
+// the ratchet binds the message header, whose N and PN must match the record's sequence
+// number and PN, and the content type and epoch are bound as associated data.
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+const (
+	// ContentApplicationData is the content type of records carrying application data.
+	ContentApplicationData byte = 23
+
+	// headerSize is the size of the fixed record header: type, epoch, sequence number and
+	// PN. It is followed by the uint16-length-prefixed message header, the
+	// uint16-length-prefixed escrow block and the ciphertext.
+	headerSize = 1 + 2 + 6 + 4
+
+	// maxEpochs is the number of most recent peer epochs a Layer accepts records for.
+	maxEpochs = 4
+)
+
+var (
+	// ErrMalformedRecord is returned when a record cannot be parsed.
+	ErrMalformedRecord = errors.New("record: malformed record")
+
+	// ErrReplay is returned when a record's sequence number was already received or is
+	// older than the replay window.
+	ErrReplay = errors.New("record: replayed or stale record")
+
+	// ErrStaleEpoch is returned when a record belongs to an epoch that is no longer accepted.
+	ErrStaleEpoch = errors.New("record: stale epoch")
+
+	// ErrEpochMismatch is returned when a record's epoch does not match its ratchet key.
+	ErrEpochMismatch = errors.New("record: epoch does not match ratchet key")
+
+	// ErrEpochsExhausted is returned by Seal when the session starts a new sending chain
+	// after the last epoch. The session must be replaced.
+	ErrEpochsExhausted = errors.New("record: epochs exhausted")
+)
+
+// Layer seals plaintexts into records and opens received records using a Double Ratchet
+// session. A Layer must be the only user of its session.
+//
+// Seal calls the session's Rekey before every record, so that a DH ratchet step is taken
+// before the epoch, which is bound into the record's associated data, is chosen. Rekey
+// steps only on the session's turn: when the next Send would step anyway, or, in a
+// session created with New that has not stepped yet, on the side with the lesser public
+// key, whose first record therefore starts a new chain. Seal holds the receive lock while
+// doing so, so that a record opened meanwhile cannot make the session step again.
+type Layer struct {
+	session doubleratchet.DoubleRatchet
+
+	sendMu    sync.Mutex
+	sendEpoch uint16
+	sendDH    []byte
+
+	recvMu     sync.Mutex
+	recvEpochs []*epochState
+}
+
+// epochState tracks a peer epoch: its ratchet key and replay window.
+type epochState struct {
+	epoch  uint16
+	dh     []byte
+	window replayWindow
+}
+
+// NewLayer returns a record layer backed by session.
+func NewLayer(session doubleratchet.DoubleRatchet) *Layer {
+	return &Layer{session: session}
+}
+
+// Seal encrypts plaintext and returns a single record.
+func (l *Layer) Seal(plaintext []byte) ([]byte, error) {
+	l.sendMu.Lock()
+	defer l.sendMu.Unlock()
+
+	l.recvMu.Lock()
+	defer l.recvMu.Unlock()
+
+	if err := l.session.Rekey(); err != nil {
+		return nil, err
+	}
+
+	epoch := l.sendEpoch
+
+	if dh := l.session.LocalRatchetKey(); l.sendDH != nil && !bytes.Equal(l.sendDH, dh) {
+		if epoch == math.MaxUint16 {
+			return nil, ErrEpochsExhausted
+		}
+
+		epoch++
+	}
+
+	msg, err := l.session.Send(plaintext, recordAD(ContentApplicationData, epoch))
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := msg.Header.MarshalBinary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg.Escrow) > math.MaxUint16 {
+		return nil, ErrMalformedRecord
+	}
+
+	l.sendEpoch = epoch
+	l.sendDH = msg.Header.DH
+
+	rec := make([]byte, 0, headerSize+4+len(header)+len(msg.Escrow)+len(msg.Ciphertext))
+
+	rec = append(rec, ContentApplicationData)
+	rec = binary.BigEndian.AppendUint16(rec, epoch)
+	rec = appendUint48(rec, uint64(msg.Header.N))
+	rec = binary.BigEndian.AppendUint32(rec, msg.Header.PN)
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(header))) // #nosec G115 -- binary headers are far below 64 KiB
+	rec = append(rec, header...)
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(msg.Escrow))) // #nosec G115 -- checked above
+	rec = append(rec, msg.Escrow...)
+
+	return append(rec, msg.Ciphertext...), nil
+}
+
+// Open authenticates and decrypts a record. Replayed records are rejected with ErrReplay
+// without touching the ratchet session.
+func (l *Layer) Open(rec []byte) ([]byte, error) {
+	if len(rec) < headerSize || rec[0] != ContentApplicationData {
+		return nil, ErrMalformedRecord
+	}
+
+	epoch := binary.BigEndian.Uint16(rec[1:])
+	seq := uint48(rec[3:])
+	pn := binary.BigEndian.Uint32(rec[9:])
+
+	// Message numbers are 32 bits wide, so a larger sequence number cannot belong to a
+	// message of the session.
+	if seq > math.MaxUint32 {
+		return nil, ErrMalformedRecord
+	}
+
+	header, rest, ok := cutUint16Prefixed(rec[headerSize:])
+
+	if !ok {
+		return nil, ErrMalformedRecord
+	}
+
+	escrow, ciphertext, ok := cutUint16Prefixed(rest)
+
+	if !ok {
+		return nil, ErrMalformedRecord
+	}
+
+	msg := doubleratchet.CipheredMessage{Ciphertext: ciphertext}
+
+	if err := msg.Header.UnmarshalBinary(header); err != nil || msg.Header.N != uint32(seq) || msg.Header.PN != pn {
+		return nil, ErrMalformedRecord
+	}
+
+	if len(escrow) > 0 {
+		msg.Escrow = append([]byte(nil), escrow...)
+	}
+
+	dh := msg.Header.DH
+
+	l.recvMu.Lock()
+	defer l.recvMu.Unlock()
+
+	state, err := l.epochFor(epoch, dh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if state != nil && !state.window.check(seq) {
+		return nil, ErrReplay
+	}
+
+	unciphered, err := l.session.Receive(msg, recordAD(rec[0], epoch))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The record header is now authenticated: the ratchet rejects a forged message header,
+	// and with it a forged sequence number or PN, and the associated data a forged content
+	// type or epoch.
+	if state == nil {
+		state = l.addEpoch(epoch, dh)
+	}
+
+	state.window.mark(seq)
+
+	return unciphered.Plaintext, nil
+}
+
+// epochFor returns the state of a known epoch, or nil if the epoch is new and acceptable.
+func (l *Layer) epochFor(epoch uint16, dh []byte) (*epochState, error) {
+	for _, state := range l.recvEpochs {
+		if state.epoch == epoch {
+			if !bytes.Equal(state.dh, dh) {
+				return nil, ErrEpochMismatch
+			}
+
+			return state, nil
+		}
+	}
+
+	if len(l.recvEpochs) == maxEpochs && epoch < l.recvEpochs[0].epoch {
+		return nil, ErrStaleEpoch
+	}
+
+	return nil, nil
+}
+
+// addEpoch starts tracking a new epoch, forgetting the oldest one if needed.
+func (l *Layer) addEpoch(epoch uint16, dh []byte) *epochState {
+	state := &epochState{epoch: epoch, dh: append([]byte(nil), dh...)}
+
+	i := len(l.recvEpochs)
+
+	for i > 0 && l.recvEpochs[i-1].epoch > epoch {
+		i--
+	}
+
+	l.recvEpochs = append(l.recvEpochs[:i], append([]*epochState{state}, l.recvEpochs[i:]...)...)
+
+	if len(l.recvEpochs) > maxEpochs {
+		l.recvEpochs = l.recvEpochs[1:]
+	}
+
+	return state
+}
+
+// recordAD returns the associated data of a record: the fields of its header that the
+// ratchet does not authenticate itself.
+func recordAD(typ byte, epoch uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte{typ}, epoch)
+}
+
+// cutUint16Prefixed splits a uint16 length-prefixed field off the front of data.
+func cutUint16Prefixed(data []byte) (field, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+
+	n := int(binary.BigEndian.Uint16(data))
+
+	if len(data) < 2+n {
+		return nil, nil, false
+	}
+
+	return data[2 : 2+n], data[2+n:], true
+}
+
+// appendUint48 appends the low 48 bits of v in big-endian order.
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// uint48 decodes a big-endian 48-bit integer.
+func uint48(b []byte) uint64 {
+	return uint64(b[0])<<40 | uint64(b[1])<<32 | uint64(b[2])<<24 | uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5])
+}
@@ -0,0 +1,307 @@
+package record
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+func newLayers(t *testing.T, opts ...doubleratchet.Option) (alice, bob *Layer) {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	a, err := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil, opts...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, opts...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewLayer(a), NewLayer(b)
+}
+
+// recordKey returns the ratchet key in the message header of rec.
+func recordKey(t *testing.T, rec []byte) []byte {
+	t.Helper()
+
+	header, _, ok := cutUint16Prefixed(rec[headerSize:])
+
+	if !ok {
+		t.Fatal("Malformed record")
+	}
+
+	var h doubleratchet.Header
+
+	if err := h.UnmarshalBinary(header); err != nil {
+		t.Fatal(err)
+	}
+
+	return h.DH
+}
+
+// TestRecordEpochsFollowRatchetChains verifies that records round-trip in both directions
+// and that the sender's epoch advances exactly when its ratchet key changes.
+func TestRecordEpochsFollowRatchetChains(t *testing.T) {
+	alice, bob := newLayers(t)
+
+	var (
+		lastKey       string
+		expectedEpoch = -1
+	)
+
+	for round := range 3 {
+		rec, err := alice.Seal([]byte("ping"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := bob.Open(rec); err != nil {
+			t.Fatalf("Round %d: Bob failed to open record: %v", round, err)
+		}
+
+		rec, err = bob.Seal([]byte("pong"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key := string(recordKey(t, rec)); key != lastKey {
+			lastKey = key
+			expectedEpoch++
+		}
+
+		if epoch := uint16(rec[1])<<8 | uint16(rec[2]); epoch != uint16(expectedEpoch) {
+			t.Errorf("Round %d: expected Bob's epoch %d, got %d", round, expectedEpoch, epoch)
+		}
+
+		plaintext, err := alice.Open(rec)
+
+		if err != nil {
+			t.Fatalf("Round %d: Alice failed to open record: %v", round, err)
+		}
+
+		if string(plaintext) != "pong" {
+			t.Errorf("Expected 'pong', got '%s'", plaintext)
+		}
+	}
+}
+
+// TestRecordReplayWindow verifies that reordered records inside the window are accepted
+// once each, while duplicates are rejected with ErrReplay before reaching the session.
+func TestRecordReplayWindow(t *testing.T) {
+	alice, bob := newLayers(t)
+
+	records := make([][]byte, 5)
+
+	for i := range records {
+		records[i], _ = alice.Seal([]byte{byte(i)})
+	}
+
+	for _, i := range []int{2, 0, 4, 1, 3} {
+		plaintext, err := bob.Open(records[i])
+
+		if err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+
+		if plaintext[0] != byte(i) {
+			t.Errorf("Record %d: wrong plaintext %v", i, plaintext)
+		}
+	}
+
+	for i := range records {
+		if _, err := bob.Open(records[i]); !errors.Is(err, ErrReplay) {
+			t.Errorf("Record %d: expected ErrReplay, got %v", i, err)
+		}
+	}
+}
+
+// TestRecordRejectsForgedHeaders verifies that malformed records, records whose epoch is
+// re-bound to a different ratchet key, and records with a forged epoch or content type
+// are rejected, and that a forged epoch is not tracked.
+func TestRecordRejectsForgedHeaders(t *testing.T) {
+	alice, bob := newLayers(t)
+
+	first, _ := alice.Seal([]byte("first"))
+	second, _ := alice.Seal([]byte("second"))
+
+	if _, err := bob.Open(first); err != nil {
+		t.Fatal(err)
+	}
+
+	forged := append([]byte(nil), second...)
+	forged[headerSize+2+doubleratchet.HeaderPrefixSize] ^= 0xFF
+
+	if _, err := bob.Open(forged); !errors.Is(err, ErrEpochMismatch) {
+		t.Errorf("Expected ErrEpochMismatch, got %v", err)
+	}
+
+	if _, err := bob.Open(second[:headerSize]); !errors.Is(err, ErrMalformedRecord) {
+		t.Errorf("Expected ErrMalformedRecord, got %v", err)
+	}
+
+	forged = append([]byte(nil), second...)
+	forged[8] ^= 1
+
+	if _, err := bob.Open(forged); !errors.Is(err, ErrMalformedRecord) {
+		t.Errorf("Expected ErrMalformedRecord for a sequence number that does not match N, got %v", err)
+	}
+
+	forged = append([]byte(nil), second...)
+	forged[3] = 1
+
+	if _, err := bob.Open(forged); !errors.Is(err, ErrMalformedRecord) {
+		t.Errorf("Expected ErrMalformedRecord for a sequence number above 32 bits, got %v", err)
+	}
+
+	forged = append([]byte(nil), second...)
+	forged[2] ^= 1
+
+	if _, err := bob.Open(forged); err == nil {
+		t.Error("Expected a record with a forged epoch to fail authentication")
+	}
+
+	if len(bob.recvEpochs) != 1 {
+		t.Errorf("Expected only the genuine epoch to be tracked, got %d epochs", len(bob.recvEpochs))
+	}
+
+	if _, err := bob.Open(second); err != nil {
+		t.Errorf("Genuine record rejected after forgeries: %v", err)
+	}
+}
+
+// TestRecordCarriesFullHeader verifies that records of sessions with plaintext and header
+// padding and escrow round-trip, since the record carries the full message header and the
+// escrow block.
+func TestRecordCarriesFullHeader(t *testing.T) {
+	escrowPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, bob := newLayers(t,
+		doubleratchet.WithPlaintextPadding(doubleratchet.Padme{}),
+		doubleratchet.WithHeaderPadding(16),
+		doubleratchet.WithEscrow(escrowPri.PublicKey(), doubleratchet.EscrowAcknowledgement),
+	)
+
+	for i, pair := range [][2]*Layer{{alice, bob}, {bob, alice}, {alice, bob}} {
+		rec, err := pair[0].Seal([]byte("padded"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := pair[1].Open(rec); err != nil || string(got) != "padded" {
+			t.Errorf("Record %d: expected %q, got %q, %v", i, "padded", got, err)
+		}
+	}
+}
+
+// TestConnOverUDP verifies the record layer end to end over real UDP sockets.
+func TestConnOverUDP(t *testing.T) {
+	alice, bob := newLayers(t)
+
+	pcA, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pcA.Close()
+
+	pcB, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pcB.Close()
+
+	connA := &Conn{pc: pcA, peer: pcB.LocalAddr(), layer: alice}
+	connB := &Conn{pc: pcB, peer: pcA.LocalAddr(), layer: bob}
+
+	if _, err := connA.Write([]byte("datagram")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+
+	n, err := connB.Read(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "datagram" {
+		t.Errorf("Expected 'datagram', got '%s'", buf[:n])
+	}
+}
+
+// rotatingSession is a fake session whose Rekey starts a new ratchet key every two
+// messages.
+type rotatingSession struct {
+	doubleratchet.DoubleRatchet
+
+	key  byte
+	sent uint32
+}
+
+func (r *rotatingSession) Rekey() error {
+	if r.sent > 0 && r.sent%2 == 0 {
+		r.key++
+	}
+
+	return nil
+}
+
+func (r *rotatingSession) LocalRatchetKey() []byte {
+	return []byte{r.key}
+}
+
+func (r *rotatingSession) Send(plaintext, _ []byte) (doubleratchet.CipheredMessage, error) {
+	r.sent++
+
+	return doubleratchet.CipheredMessage{
+		Header:     doubleratchet.Header{DH: []byte{r.key}, N: (r.sent - 1) % 2},
+		Ciphertext: plaintext,
+	}, nil
+}
+
+// TestSealAdvancesEpochOnKeyChange verifies that the sending epoch advances exactly when
+// the session's ratchet key changes, that the sequence number follows N, and that Seal
+// refuses to wrap the epoch.
+func TestSealAdvancesEpochOnKeyChange(t *testing.T) {
+	layer := NewLayer(&rotatingSession{})
+
+	for i := range 6 {
+		rec, err := layer.Seal([]byte("x"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		epoch := uint16(rec[1])<<8 | uint16(rec[2])
+		seq := uint48(rec[3:])
+
+		if epoch != uint16(i/2) || seq != uint64(i%2) {
+			t.Errorf("Record %d: expected epoch %d seq %d, got epoch %d seq %d", i, i/2, i%2, epoch, seq)
+		}
+	}
+
+	layer.sendEpoch = math.MaxUint16
+
+	if _, err := layer.Seal([]byte("x")); !errors.Is(err, ErrEpochsExhausted) {
+		t.Errorf("Expected ErrEpochsExhausted, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package record
+
+// windowSize is the number of sequence numbers tracked below the highest one received.
+const windowSize = 64
+
+// replayWindow is a DTLS-style sliding anti-replay window (RFC 6347, Section 4.1.2.6).
+type replayWindow struct {
+	top    uint64
+	bitmap uint64
+	seen   bool
+}
+
+// check reports whether seq is acceptable: newer than the window, or inside it and not yet marked.
+func (w *replayWindow) check(seq uint64) bool {
+	if !w.seen || seq > w.top {
+		return true
+	}
+
+	offset := w.top - seq
+
+	if offset >= windowSize {
+		return false
+	}
+
+	return w.bitmap&(1<<offset) == 0
+}
+
+// mark records seq as received, sliding the window forward if needed.
+func (w *replayWindow) mark(seq uint64) {
+	if !w.seen {
+		w.top, w.bitmap, w.seen = seq, 1, true
+		return
+	}
+
+	if seq > w.top {
+		shift := seq - w.top
+
+		if shift >= windowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+
+		w.top = seq
+		w.bitmap |= 1
+
+		return
+	}
+
+	w.bitmap |= 1 << (w.top - seq)
+}
@@ -0,0 +1,29 @@
+package record
+
+import "testing"
+
+// TestReplayWindowSlidesAndRejects verifies that the window accepts new and in-window
+// unseen sequence numbers, and rejects duplicates and numbers that fell behind it.
+func TestReplayWindowSlidesAndRejects(t *testing.T) {
+	var w replayWindow
+
+	for _, seq := range []uint64{0, 5, 3, 100} {
+		if !w.check(seq) {
+			t.Fatalf("Sequence %d should be accepted", seq)
+		}
+
+		w.mark(seq)
+	}
+
+	for _, seq := range []uint64{100, 5, 3, 0, 36} {
+		if w.check(seq) {
+			t.Errorf("Sequence %d should be rejected", seq)
+		}
+	}
+
+	for _, seq := range []uint64{37, 99, 101} {
+		if !w.check(seq) {
+			t.Errorf("Sequence %d should be accepted", seq)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Package sealedsender wraps Double Ratchet messages in envelopes that hide their sender
+// from the transport, in the manner of Signal's sealed sender. An envelope is encrypted to
+// the recipient's identity key under a fresh ephemeral key, so the server relaying it
+// sees only the recipient it is addressed to: the sender's identity, the message header
+// with its ratchet key and counters, and the ciphertext are all inside.
+//
+// An envelope is laid out as follows, with integers in big-endian order:
+//
+//	version (1 byte) | ephemeral key size (1 byte) | ephemeral public key | sealed content
+//
+// The content is encrypted with AES-256-GCM under a key derived with HKDF from the DH of
+// the ephemeral key and the recipient's identity key, and holds:
+//
+//	sender size (2 bytes) | sender | header size (2 bytes) | header |
+//	escrow size (2 bytes) | escrow block | ciphertext
+//
+// The header is in the layout of doubleratchet.Header.MarshalBinary.
+//
+// The envelope itself does not authenticate the sender: anyone who knows the recipient's
+// identity key can seal a message under any sender. The sender is authenticated by the
+// message inside, which only decrypts with the session the recipient shares with that
+// sender. Look the session up by Envelope.Sender and treat a message that fails to
+// decrypt as forged.
+package sealedsender
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/othonhugo/goratchet/pkg/crypto"
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// Version is the version of the envelope format written by Seal.
+const Version = 1
+
+// label domain-separates the envelope key from other uses of HKDF.
+var label = []byte("goratchet-sealed-sender")
+
+var (
+	// ErrInvalidKey is returned by Seal and Open for a nil identity key.
+	ErrInvalidKey = errors.New("sealedsender: invalid identity key")
+
+	// ErrEmptySender is returned by Seal for an empty sender.
+	ErrEmptySender = errors.New("sealedsender: empty sender")
+
+	// ErrTooLarge is returned by Seal for a sender, header or escrow block of more than
+	// 65535 bytes.
+	ErrTooLarge = errors.New("sealedsender: field too large for an envelope")
+
+	// ErrUnsupportedVersion is returned by Open for an envelope of an unknown version.
+	ErrUnsupportedVersion = errors.New("sealedsender: unsupported envelope version")
+
+	// ErrMalformed is returned by Open for an envelope that cannot be parsed, or whose
+	// content does not decrypt under the identity key, because it was altered or is
+	// addressed to another recipient.
+	ErrMalformed = errors.New("sealedsender: malformed envelope")
+)
+
+// Envelope is the content of an opened envelope.
+type Envelope struct {
+	// Sender identifies the sender, as given to Seal. It is not authenticated by the
+	// envelope; see the package documentation.
+	Sender []byte
+
+	// Message is the sealed Double Ratchet message.
+	Message doubleratchet.CipheredMessage
+}
+
+// Seal wraps msg, sent by sender, in an envelope that only the holder of the private key
+// of recipient can open. sender is whatever identifies the sender to the recipient, such
+// as its identity public key or a user ID.
+func Seal(recipient *ecdh.PublicKey, sender []byte, msg doubleratchet.CipheredMessage) ([]byte, error) {
+	return SealWithRand(rand.Reader, recipient, sender, msg)
+}
+
+// SealWithRand is like Seal, but reads the ephemeral key and the nonce from random.
+func SealWithRand(random io.Reader, recipient *ecdh.PublicKey, sender []byte, msg doubleratchet.CipheredMessage) ([]byte, error) {
+	if recipient == nil {
+		return nil, ErrInvalidKey
+	}
+
+	if len(sender) == 0 {
+		return nil, ErrEmptySender
+	}
+
+	header, err := msg.Header.MarshalBinary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range [][]byte{sender, header, msg.Escrow} {
+		if len(field) > math.MaxUint16 {
+			return nil, ErrTooLarge
+		}
+	}
+
+	eph, err := recipient.Curve().GenerateKey(random)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := eph.ECDH(recipient)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ephBytes := eph.PublicKey().Bytes()
+	key, err := envelopeKey(shared, ephBytes, recipient.Bytes())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer clear(key[:])
+
+	content := make([]byte, 0, 6+len(sender)+len(header)+len(msg.Escrow)+len(msg.Ciphertext))
+	content = appendField(content, sender)
+	content = appendField(content, header)
+	content = appendField(content, msg.Escrow)
+	content = append(content, msg.Ciphertext...)
+
+	prefix := append([]byte{Version, byte(len(ephBytes))}, ephBytes...) // #nosec G115 -- public keys are far below 256 bytes
+
+	sealed, err := crypto.EncryptWithRand(random, key, content, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, sealed...), nil
+}
+
+// Open decrypts an envelope with the recipient's identity private key and returns its
+// content. It fails with ErrMalformed for an envelope that was altered or sealed to
+// another key.
+func Open(identity *ecdh.PrivateKey, data []byte) (Envelope, error) {
+	if identity == nil {
+		return Envelope{}, ErrInvalidKey
+	}
+
+	if len(data) < 2 {
+		return Envelope{}, ErrMalformed
+	}
+
+	if data[0] != Version {
+		return Envelope{}, ErrUnsupportedVersion
+	}
+
+	ephSize := int(data[1])
+
+	if len(data) < 2+ephSize {
+		return Envelope{}, ErrMalformed
+	}
+
+	prefix := data[:2+ephSize]
+
+	eph, err := identity.Curve().NewPublicKey(prefix[2:])
+
+	if err != nil {
+		return Envelope{}, ErrMalformed
+	}
+
+	shared, err := identity.ECDH(eph)
+
+	if err != nil {
+		return Envelope{}, ErrMalformed
+	}
+
+	key, err := envelopeKey(shared, prefix[2:], identity.PublicKey().Bytes())
+
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	defer clear(key[:])
+
+	content, err := crypto.Decrypt(key, data[len(prefix):], prefix)
+
+	if err != nil {
+		return Envelope{}, ErrMalformed
+	}
+
+	var fields [3][]byte
+
+	for i := range fields {
+		if fields[i], content, err = readField(content); err != nil {
+			return Envelope{}, err
+		}
+	}
+
+	var header doubleratchet.Header
+
+	if err := header.UnmarshalBinary(fields[1]); err != nil {
+		return Envelope{}, ErrMalformed
+	}
+
+	if len(fields[0]) == 0 {
+		return Envelope{}, ErrMalformed
+	}
+
+	msg := doubleratchet.CipheredMessage{Header: header, Ciphertext: content}
+
+	if len(fields[2]) > 0 {
+		msg.Escrow = fields[2]
+	}
+
+	return Envelope{Sender: fields[0], Message: msg}, nil
+}
+
+// envelopeKey derives the key of an envelope from the DH of its ephemeral key and the
+// recipient's identity key, binding both public keys into the derivation.
+func envelopeKey(shared, eph, recipient []byte) (crypto.MessageKey, error) {
+	var key crypto.MessageKey
+
+	info := make([]byte, 0, len(label)+len(eph)+len(recipient))
+	info = append(info, label...)
+	info = append(info, eph...)
+	info = append(info, recipient...)
+
+	derived, err := crypto.DeriveHKDF(shared, nil, info, crypto.MessageKeySize)
+
+	clear(shared)
+
+	if err != nil {
+		return key, err
+	}
+
+	copy(key[:], derived)
+	clear(derived)
+
+	return key, nil
+}
+
+// appendField appends field to buf, prefixed with its size.
+func appendField(buf, field []byte) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(field))) // #nosec G115 -- checked by SealWithRand
+	return append(buf, field...)
+}
+
+// readField reads a field written by appendField from data, returning it and the rest.
+func readField(data []byte) (field, rest []byte, err error) {
+	if len(data) < 2 {
+		return nil, nil, ErrMalformed
+	}
+
+	size := int(binary.BigEndian.Uint16(data))
+
+	if len(data) < 2+size {
+		return nil, nil, ErrMalformed
+	}
+
+	return data[2 : 2+size], data[2+size:], nil
+}
@@ -0,0 +1,78 @@
+package sealedsender
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// TestSealOpen verifies that an envelope hides the sender and header from its bytes, that
+// the recipient recovers a message its session decrypts, and that altered envelopes or
+// envelopes opened with another key are refused.
+func TestSealOpen(t *testing.T) {
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobIdentity, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	otherIdentity, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	msg, err := alice.Send([]byte("hello"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Seal(bobIdentity.PublicKey(), []byte("alice"), msg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("alice")) || bytes.Contains(data, msg.Header.DH) {
+		t.Error("Expected the envelope to hide the sender and the ratchet key")
+	}
+
+	env, err := Open(bobIdentity, data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(env.Sender) != "alice" {
+		t.Errorf("Expected sender alice, got %q", env.Sender)
+	}
+
+	received, err := bob.Receive(env.Message, nil)
+
+	if err != nil || string(received.Plaintext) != "hello" {
+		t.Errorf("Expected the sealed message to decrypt, got %q, %v", received.Plaintext, err)
+	}
+
+	if _, err := Open(otherIdentity, data); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed under another key, got %v", err)
+	}
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 1
+
+	if _, err := Open(bobIdentity, tampered); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed for a tampered envelope, got %v", err)
+	}
+
+	tampered = append([]byte(nil), data...)
+	tampered[0] = Version + 1
+
+	if _, err := Open(bobIdentity, tampered); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	if _, err := Seal(bobIdentity.PublicKey(), nil, msg); !errors.Is(err, ErrEmptySender) {
+		t.Errorf("Expected ErrEmptySender, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrListUnsupported is returned by CacheStore.List when its backend does not
+	// implement Lister.
+	ErrListUnsupported = errors.New("session: backend cannot list sessions")
+)
+
+// CacheConfig configures a CacheStore.
+type CacheConfig struct {
+	// TTL expires each session that has not been saved or loaded for longer than TTL.
+	// Zero means no expiry.
+	TTL time.Duration
+
+	// MaxEntries bounds the number of sessions kept in memory; the least recently used
+	// are evicted first. Zero means no bound.
+	MaxEntries int
+
+	// Backend, if set, is the durable store behind the cache. Load reads sessions that
+	// are not cached from it and caches them, and Save and Delete write through to it
+	// before changing the cache, so an evicted session is never lost.
+	Backend Store
+}
+
+// CacheStore is a concurrency-safe in-memory Store that bounds its size with a per-session
+// TTL and a maximum number of entries. With a Backend it is a write-through cache in front
+// of a database, suitable for stateless frontends that rehydrate sessions on a miss;
+// without one, evicted sessions are gone and Load reports them as ErrNotFound.
+type CacheStore struct {
+	config CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // *cacheEntry, least recently used first
+	gen     uint64     // incremented by every Save and Delete
+
+	now func() time.Time
+}
+
+// cacheEntry is a cached session.
+type cacheEntry struct {
+	peer     string
+	state    []byte
+	lastUsed time.Time
+}
+
+var _ Lister = (*CacheStore)(nil)
+
+// NewCacheStore returns an empty CacheStore. A nil config uses the defaults.
+func NewCacheStore(config *CacheConfig) *CacheStore {
+	s := &CacheStore{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+
+	if config != nil {
+		s.config = *config
+	}
+
+	return s
+}
+
+// Load returns the serialized session of peer from the cache, or from the backend on a
+// miss. It returns ErrNotFound if neither has it.
+func (s *CacheStore) Load(ctx context.Context, peer string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	if state, ok := s.get(peer); ok {
+		s.mu.Unlock()
+		return state, nil
+	}
+
+	gen := s.gen
+	s.mu.Unlock()
+
+	if s.config.Backend == nil {
+		return nil, ErrNotFound
+	}
+
+	state, err := s.config.Backend.Load(ctx, peer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A Save or Delete while the backend was read may have made state outdated.
+	if s.gen == gen {
+		s.put(peer, state)
+	}
+
+	return append([]byte(nil), state...), nil
+}
+
+// Save stores the serialized session of peer in the backend, if any, and then in the
+// cache.
+func (s *CacheStore) Save(ctx context.Context, peer string, state []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.config.Backend != nil {
+		if err := s.config.Backend.Save(ctx, peer, state); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gen++
+	s.put(peer, state)
+
+	return nil
+}
+
+// Delete removes the session of peer from the backend, if any, and from the cache.
+func (s *CacheStore) Delete(ctx context.Context, peer string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.config.Backend != nil {
+		if err := s.config.Backend.Delete(ctx, peer); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gen++
+
+	if elem, ok := s.entries[peer]; ok {
+		s.remove(elem)
+	}
+
+	return nil
+}
+
+// List returns the peer IDs of all sessions in ascending order: those of the backend if
+// there is one, or else those in the cache.
+func (s *CacheStore) List(ctx context.Context) ([]string, error) {
+	if s.config.Backend != nil {
+		lister, ok := s.config.Backend.(Lister)
+
+		if !ok {
+			return nil, ErrListUnsupported
+		}
+
+		return lister.List(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]string, 0, len(s.entries))
+
+	for peer, elem := range s.entries {
+		if !s.expired(elem.Value.(*cacheEntry)) {
+			peers = append(peers, peer)
+		}
+	}
+
+	sort.Strings(peers)
+
+	return peers, nil
+}
+
+// Len returns the number of sessions in the cache, including expired ones that were not
+// removed yet.
+func (s *CacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+// get returns a copy of the cached state of peer and marks it as used, removing it
+// instead if it has expired. The caller must hold s.mu.
+func (s *CacheStore) get(peer string) ([]byte, bool) {
+	elem, ok := s.entries[peer]
+
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*cacheEntry)
+
+	if s.expired(e) {
+		s.remove(elem)
+		return nil, false
+	}
+
+	e.lastUsed = s.now()
+	s.lru.MoveToBack(elem)
+
+	return append([]byte(nil), e.state...), true
+}
+
+// put caches a copy of state for peer as the most recently used entry and evicts the
+// least recently used entries beyond MaxEntries. The caller must hold s.mu.
+func (s *CacheStore) put(peer string, state []byte) {
+	e := &cacheEntry{peer: peer, state: append([]byte(nil), state...), lastUsed: s.now()}
+
+	if elem, ok := s.entries[peer]; ok {
+		elem.Value = e
+		s.lru.MoveToBack(elem)
+	} else {
+		s.entries[peer] = s.lru.PushBack(e)
+	}
+
+	// Expired entries are at the front, since entries only move to the back when used.
+	for front := s.lru.Front(); front != nil && s.expired(front.Value.(*cacheEntry)); front = s.lru.Front() {
+		s.remove(front)
+	}
+
+	for s.config.MaxEntries > 0 && s.lru.Len() > s.config.MaxEntries {
+		s.remove(s.lru.Front())
+	}
+}
+
+// expired reports whether e has outlived the TTL.
+func (s *CacheStore) expired(e *cacheEntry) bool {
+	return s.config.TTL > 0 && s.now().Sub(e.lastUsed) > s.config.TTL
+}
+
+// remove forgets a cached entry. The caller must hold s.mu.
+func (s *CacheStore) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*cacheEntry).peer)
+}
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCacheStoreEviction verifies that sessions expire after the TTL, that the least
+// recently used session is evicted beyond MaxEntries, and that evicted sessions are
+// reported as ErrNotFound without a backend.
+func TestCacheStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+
+	s := NewCacheStore(&CacheConfig{TTL: time.Minute, MaxEntries: 2})
+	s.now = func() time.Time { return now }
+
+	_ = s.Save(ctx, "alice", []byte("a"))
+	_ = s.Save(ctx, "bob", []byte("b"))
+
+	now = now.Add(30 * time.Second)
+
+	// Using alice makes bob the least recently used.
+	if state, err := s.Load(ctx, "alice"); err != nil || string(state) != "a" {
+		t.Fatalf("Expected alice's state, got %q, %v", state, err)
+	}
+
+	_ = s.Save(ctx, "carol", []byte("c"))
+
+	if _, err := s.Load(ctx, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected bob to be evicted, got %v", err)
+	}
+
+	now = now.Add(45 * time.Second)
+
+	if _, err := s.Load(ctx, "alice"); err != nil {
+		t.Errorf("Expected alice to be kept within the TTL, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if peers, _ := s.List(ctx); len(peers) != 0 {
+		t.Errorf("Expected every session to have expired, got %v", peers)
+	}
+
+	if _, err := s.Load(ctx, "carol"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected carol to have expired, got %v", err)
+	}
+}
+
+// TestCacheStoreBackend verifies that a CacheStore writes through to its backend, and
+// rehydrates evicted sessions from it on a miss.
+func TestCacheStoreBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{MemoryStore: NewMemoryStore()}
+
+	s := NewCacheStore(&CacheConfig{MaxEntries: 1, Backend: backend})
+
+	_ = s.Save(ctx, "alice", []byte("a"))
+	_ = s.Save(ctx, "bob", []byte("b"))
+
+	if state, _ := backend.MemoryStore.Load(ctx, "alice"); string(state) != "a" {
+		t.Errorf("Expected Save to write through, got %q", state)
+	}
+
+	for i := 0; i < 2; i++ {
+		if state, err := s.Load(ctx, "alice"); err != nil || string(state) != "a" {
+			t.Fatalf("Expected alice's state, got %q, %v", state, err)
+		}
+	}
+
+	if loads := backend.loads.Load(); loads != 1 {
+		t.Errorf("Expected a single backend load for the evicted session, got %d", loads)
+	}
+
+	if s.Len() != 1 {
+		t.Errorf("Expected 1 cached session, got %d", s.Len())
+	}
+
+	if peers, err := s.List(ctx); err != nil || !reflect.DeepEqual(peers, []string{"alice", "bob"}) {
+		t.Errorf("Expected the backend's sessions, got %v, %v", peers, err)
+	}
+
+	_ = s.Delete(ctx, "alice")
+
+	if _, err := s.Load(ctx, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected Delete to write through, got %v", err)
+	}
+}
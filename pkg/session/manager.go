@@ -0,0 +1,377 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// errReplaced marks a cached session that was replaced by Put, and the entry Put holds
+// while it saves a session no entry existed for.
+var errReplaced = errors.New("session: replaced")
+
+// Config configures a Manager.
+type Config struct {
+	// MaxCached bounds the number of idle sessions kept in memory; the least recently
+	// used are evicted first. Zero means no bound, and a negative value disables caching,
+	// so that every use reads the session from the store.
+	MaxCached int
+
+	// TTL evicts idle sessions that have not been used for longer than TTL. Zero means
+	// no expiry.
+	TTL time.Duration
+
+	// Options are applied to sessions when they are loaded from the store.
+	Options []doubleratchet.Option
+
+	// PeerOptions, if set, is called when the session of a peer is loaded from the store,
+	// and the options it returns are applied after Options. It lets deployments configure
+	// peers differently, for example to enable transcripts only for some of them or to
+	// move peers to a new setting one at a time.
+	PeerOptions func(peer string) []doubleratchet.Option
+}
+
+// Manager resolves sessions from a Store on first use per peer and keeps them in a
+// read-through cache. Concurrent first uses of the same peer share a single load, and
+// its outcome: if the load fails, all of them see the error. The load is not canceled
+// with the context of the use that started it; each use stops waiting for it when its
+// own context is done.
+//
+// Every operation on a peer's session runs under a per-peer lock and is written back to
+// the store before it returns, so the store always holds the latest state and two
+// operations never advance the same state concurrently.
+type Manager struct {
+	store  Store
+	config Config
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	idle    *list.List // idle entries, least recently used first
+
+	now func() time.Time
+}
+
+// entry is a peer's session, loaded or being loaded.
+type entry struct {
+	peer string
+
+	loaded  chan struct{} // closed when the load finishes
+	session doubleratchet.DoubleRatchet
+	err     error
+
+	op sync.Mutex // serializes operations on the session
+
+	// The following fields are guarded by Manager.mu.
+	staleErr error // set when the session advanced but could not be saved
+	refs     int
+	lastUsed time.Time
+	elem     *list.Element // position in Manager.idle while refs is zero
+}
+
+// NewManager returns a Manager backed by store. A nil config uses the defaults.
+func NewManager(store Store, config *Config) *Manager {
+	m := &Manager{
+		store:   store,
+		entries: make(map[string]*entry),
+		idle:    list.New(),
+		now:     time.Now,
+	}
+
+	if config != nil {
+		m.config = *config
+	}
+
+	return m
+}
+
+// Put stores a new session for peer, replacing any existing one. Operations in progress
+// on the old session finish first; later ones use the new session.
+func (m *Manager) Put(ctx context.Context, peer string, session doubleratchet.DoubleRatchet) error {
+	state, err := session.Serialize()
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+
+	e, ok := m.entries[peer]
+
+	if !ok {
+		// Uses that start while the session is saved wait for this entry instead of
+		// loading the state it replaces, and then load the session again.
+		e = &entry{peer: peer, loaded: make(chan struct{}), err: errReplaced}
+		m.entries[peer] = e
+	}
+
+	m.hold(e)
+	m.mu.Unlock()
+
+	defer m.release(e)
+
+	if ok {
+		select {
+		case <-e.loaded:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	e.op.Lock()
+	defer e.op.Unlock()
+
+	err = m.store.Save(ctx, peer, state)
+
+	m.markStale(e, errReplaced)
+
+	if !ok {
+		close(e.loaded)
+	}
+
+	return err
+}
+
+// Do runs fn with the session of peer, loading it from the store on first use, and then
+// writes the session back to the store. fn must not retain the session.
+func (m *Manager) Do(ctx context.Context, peer string, fn func(doubleratchet.DoubleRatchet) error) error {
+	for {
+		e, err := m.acquire(ctx, peer)
+
+		if err != nil {
+			return err
+		}
+
+		e.op.Lock()
+
+		if m.stale(e) != nil {
+			// The session was replaced or failed to save while we waited; retry with a
+			// fresh copy from the store.
+			e.op.Unlock()
+			m.release(e)
+
+			continue
+		}
+
+		err = m.run(ctx, e, fn)
+
+		e.op.Unlock()
+		m.release(e)
+
+		return err
+	}
+}
+
+// run applies fn to the session of e and saves it. The caller must hold e.op.
+func (m *Manager) run(ctx context.Context, e *entry, fn func(doubleratchet.DoubleRatchet) error) error {
+	if err := fn(e.session); err != nil {
+		return err
+	}
+
+	state, err := e.session.Serialize()
+
+	if err == nil {
+		err = m.store.Save(ctx, e.peer, state)
+	}
+
+	if err != nil {
+		// The session advanced in memory but not in the store; reload it next time
+		// rather than serve a state the store does not know about.
+		m.markStale(e, err)
+	}
+
+	return err
+}
+
+// markStale marks e as no longer reflecting the store and forgets it.
+func (m *Manager) markStale(e *entry, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.staleErr = err
+	m.remove(e)
+}
+
+// stale returns the error that made e stale, if any.
+func (m *Manager) stale(e *entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return e.staleErr
+}
+
+// Send encrypts plaintext for peer.
+func (m *Manager) Send(ctx context.Context, peer string, plaintext, ad []byte) (doubleratchet.CipheredMessage, error) {
+	var msg doubleratchet.CipheredMessage
+
+	err := m.Do(ctx, peer, func(session doubleratchet.DoubleRatchet) error {
+		var err error
+
+		msg, err = session.SendCtx(ctx, plaintext, ad)
+
+		return err
+	})
+
+	return msg, err
+}
+
+// Receive decrypts a message from peer.
+func (m *Manager) Receive(ctx context.Context, peer string, msg doubleratchet.CipheredMessage, ad []byte) (doubleratchet.UncipheredMessage, error) {
+	var unciphered doubleratchet.UncipheredMessage
+
+	err := m.Do(ctx, peer, func(session doubleratchet.DoubleRatchet) error {
+		var err error
+
+		unciphered, err = session.ReceiveCtx(ctx, msg, ad)
+
+		return err
+	})
+
+	return unciphered, err
+}
+
+// Evict drops the cached session of peer, if it is idle. The next use reloads it.
+func (m *Manager) Evict(peer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[peer]; ok && e.refs == 0 {
+		m.remove(e)
+	}
+}
+
+// acquire returns the entry of peer with a reference held, loading the session if needed.
+func (m *Manager) acquire(ctx context.Context, peer string) (*entry, error) {
+	for {
+		m.mu.Lock()
+
+		e, ok := m.entries[peer]
+
+		if ok && e.refs == 0 && m.expired(e) {
+			m.remove(e)
+			ok = false
+		}
+
+		if !ok {
+			e = &entry{peer: peer, loaded: make(chan struct{})}
+			m.entries[peer] = e
+
+			m.hold(e) // for the load
+		}
+
+		m.hold(e)
+		m.mu.Unlock()
+
+		if !ok {
+			// The load is shared with every use that finds the entry, so it must outlive
+			// the context of this one.
+			go m.fill(context.WithoutCancel(ctx), e)
+		}
+
+		select {
+		case <-e.loaded:
+		case <-ctx.Done():
+			m.release(e)
+			return nil, ctx.Err()
+		}
+
+		if e.err == errReplaced {
+			// Put saved a session while we waited; load it.
+			m.release(e)
+			continue
+		}
+
+		if e.err != nil {
+			m.release(e)
+			return nil, e.err
+		}
+
+		return e, nil
+	}
+}
+
+// fill loads the session of e and drops the reference held for the load.
+func (m *Manager) fill(ctx context.Context, e *entry) {
+	defer m.release(e)
+
+	e.session, e.err = m.load(ctx, e.peer)
+
+	if e.err != nil {
+		m.markStale(e, e.err)
+	}
+
+	close(e.loaded)
+}
+
+// hold takes a reference to e, taking it off the idle list. The caller must hold m.mu.
+func (m *Manager) hold(e *entry) {
+	if e.elem != nil {
+		m.idle.Remove(e.elem)
+		e.elem = nil
+	}
+
+	e.refs++
+}
+
+// load reads and restores the session of peer from the store.
+func (m *Manager) load(ctx context.Context, peer string) (doubleratchet.DoubleRatchet, error) {
+	state, err := m.store.Load(ctx, peer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := m.config.Options
+
+	if m.config.PeerOptions != nil {
+		opts = append(slices.Clip(opts), m.config.PeerOptions(peer)...)
+	}
+
+	return doubleratchet.Deserialize(state, opts...)
+}
+
+// release drops a reference to e, caching it as idle or evicting it.
+func (m *Manager) release(e *entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.refs--
+	e.lastUsed = m.now()
+
+	if e.refs > 0 || m.entries[e.peer] != e {
+		return
+	}
+
+	if m.config.MaxCached < 0 {
+		m.remove(e)
+		return
+	}
+
+	e.elem = m.idle.PushBack(e)
+
+	for m.config.MaxCached > 0 && m.idle.Len() > m.config.MaxCached {
+		m.remove(m.idle.Front().Value.(*entry))
+	}
+}
+
+// expired reports whether an idle entry has outlived the TTL.
+func (m *Manager) expired(e *entry) bool {
+	return m.config.TTL > 0 && m.now().Sub(e.lastUsed) > m.config.TTL
+}
+
+// remove forgets an entry; holders of a reference may keep using it. The caller must
+// hold m.mu.
+func (m *Manager) remove(e *entry) {
+	if e.elem != nil {
+		m.idle.Remove(e.elem)
+		e.elem = nil
+	}
+
+	if m.entries[e.peer] == e {
+		delete(m.entries, e.peer)
+	}
+}
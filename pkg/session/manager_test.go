@@ -0,0 +1,370 @@
+package session
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// countingStore wraps a MemoryStore, counting loads and optionally delaying them.
+type countingStore struct {
+	*MemoryStore
+
+	loads atomic.Int32
+	delay time.Duration
+}
+
+func (s *countingStore) Load(ctx context.Context, peer string) ([]byte, error) {
+	s.loads.Add(1)
+	time.Sleep(s.delay)
+
+	return s.MemoryStore.Load(ctx, peer)
+}
+
+// gatedStore wraps a MemoryStore and holds its first load or save, as selected by gate,
+// until unblock is closed, signalling on entered when it is held.
+type gatedStore struct {
+	*MemoryStore
+
+	gate    string
+	once    sync.Once
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func newGatedStore(gate string) *gatedStore {
+	return &gatedStore{MemoryStore: NewMemoryStore(), gate: gate, entered: make(chan struct{}), unblock: make(chan struct{})}
+}
+
+func (s *gatedStore) hold(op string) {
+	if op == s.gate {
+		s.once.Do(func() {
+			close(s.entered)
+			<-s.unblock
+		})
+	}
+}
+
+func (s *gatedStore) Load(ctx context.Context, peer string) ([]byte, error) {
+	s.hold("load")
+
+	return s.MemoryStore.Load(ctx, peer)
+}
+
+func (s *gatedStore) Save(ctx context.Context, peer string, state []byte) error {
+	s.hold("save")
+
+	return s.MemoryStore.Save(ctx, peer, state)
+}
+
+// newPeers stores Alice's side of a new session under "bob" and returns Bob's side.
+func newPeers(t *testing.T, m *Manager) doubleratchet.DoubleRatchet {
+	t.Helper()
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	if err := m.Put(context.Background(), "bob", alice); err != nil {
+		t.Fatal(err)
+	}
+
+	return bob
+}
+
+// TestManagerLoadsLazilyWithSingleflight verifies that sessions are loaded on first use,
+// that concurrent first uses share one load, and that every operation is persisted.
+func TestManagerLoadsLazilyWithSingleflight(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore(), delay: 20 * time.Millisecond}
+	m := NewManager(store, nil)
+	bob := newPeers(t, m)
+
+	if store.loads.Load() != 0 {
+		t.Fatal("Expected no load before first use")
+	}
+
+	var wg sync.WaitGroup
+
+	msgs := make([]doubleratchet.CipheredMessage, 8)
+
+	for i := range msgs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			msg, err := m.Send(context.Background(), "bob", []byte("hello"), nil)
+
+			if err != nil {
+				t.Error(err)
+			}
+
+			msgs[i] = msg
+		}()
+	}
+
+	wg.Wait()
+
+	if loads := store.loads.Load(); loads != 1 {
+		t.Errorf("Expected a single load, got %d", loads)
+	}
+
+	for _, msg := range msgs {
+		if _, err := bob.Receive(msg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, _ := store.MemoryStore.Load(context.Background(), "bob")
+	restored, _ := doubleratchet.Deserialize(state)
+
+	if sent := restored.Usage().MessagesSent; sent != 8 {
+		t.Errorf("Expected the store to hold the latest state, got %d messages sent", sent)
+	}
+}
+
+// TestManagerCachePolicies verifies that MaxCached, TTL and disabled caching control when
+// sessions are read from the store again.
+func TestManagerCachePolicies(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		config Config
+		loads  int32
+	}{
+		{"unbounded", Config{}, 1},
+		{"disabled", Config{MaxCached: -1}, 3},
+		{"ttl", Config{TTL: 30 * time.Second}, 3},
+		{"ttl not reached", Config{TTL: time.Minute}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &countingStore{MemoryStore: NewMemoryStore()}
+			m := NewManager(store, &tc.config)
+			clock := time.Now()
+			m.now = func() time.Time { return clock }
+
+			newPeers(t, m)
+
+			for range 3 {
+				if _, err := m.Send(ctx, "bob", []byte("hi"), nil); err != nil {
+					t.Fatal(err)
+				}
+
+				clock = clock.Add(40 * time.Second)
+			}
+
+			if loads := store.loads.Load(); loads != tc.loads {
+				t.Errorf("Expected %d loads, got %d", tc.loads, loads)
+			}
+		})
+	}
+
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	m := NewManager(store, &Config{MaxCached: 1})
+
+	newPeers(t, m)
+
+	state, _ := store.MemoryStore.Load(ctx, "bob")
+	_ = store.MemoryStore.Save(ctx, "carol", state)
+
+	for _, peer := range []string{"bob", "carol", "bob"} {
+		if _, err := m.Send(ctx, peer, []byte("hi"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if loads := store.loads.Load(); loads != 3 {
+		t.Errorf("Expected LRU eviction to force 3 loads, got %d", loads)
+	}
+}
+
+// TestManagerMissingSession verifies that a missing session is reported with ErrNotFound
+// and is not cached.
+func TestManagerMissingSession(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	m := NewManager(store, nil)
+
+	for range 2 {
+		if _, err := m.Send(context.Background(), "nobody", nil, nil); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	}
+
+	if loads := store.loads.Load(); loads != 2 {
+		t.Errorf("Expected failed loads not to be cached, got %d loads", loads)
+	}
+}
+
+// TestManagerPeerOptions verifies that the options returned by PeerOptions are applied
+// only to the sessions of the peer they were returned for, and that an invalid option
+// fails the load.
+func TestManagerPeerOptions(t *testing.T) {
+	m := NewManager(NewMemoryStore(), &Config{
+		PeerOptions: func(peer string) []doubleratchet.Option {
+			switch peer {
+			case "bob":
+				return []doubleratchet.Option{doubleratchet.WithTranscript()}
+			case "mallory":
+				return []doubleratchet.Option{doubleratchet.WithEscrow(nil, "")}
+			}
+
+			return nil
+		},
+	})
+
+	newPeers(t, m)
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	carolPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	carol, _ := doubleratchet.New(alicePri.Bytes(), carolPri.PublicKey().Bytes(), nil)
+
+	for _, peer := range []string{"carol", "mallory"} {
+		if err := m.Put(context.Background(), peer, carol); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transcripts := map[string]bool{}
+
+	for _, peer := range []string{"bob", "carol"} {
+		err := m.Do(context.Background(), peer, func(session doubleratchet.DoubleRatchet) error {
+			sent, _ := session.Transcript()
+			transcripts[peer] = sent != nil
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !transcripts["bob"] || transcripts["carol"] {
+		t.Errorf("Expected a transcript only for bob, got %v", transcripts)
+	}
+
+	if _, err := m.Send(context.Background(), "mallory", []byte("hello"), nil); !errors.Is(err, doubleratchet.ErrEscrowNotAcknowledged) {
+		t.Errorf("Expected the option error, got %v", err)
+	}
+}
+
+// TestManagerPutBeforeUse verifies that a use of a peer that starts while Put saves a new
+// session for it waits for the save and uses the new session, instead of loading the
+// state Put replaces and overwriting the new one.
+func TestManagerPutBeforeUse(t *testing.T) {
+	ctx := context.Background()
+	store := newGatedStore("save")
+	m := NewManager(store, nil)
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	carolPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	old, _ := doubleratchet.New(alicePri.Bytes(), carolPri.PublicKey().Bytes(), nil)
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil)
+
+	state, _ := old.Serialize()
+	_ = store.MemoryStore.Save(ctx, "bob", state)
+
+	put := make(chan error)
+
+	go func() { put <- m.Put(ctx, "bob", alice) }()
+
+	<-store.entered
+
+	sent := make(chan error)
+
+	var msg doubleratchet.CipheredMessage
+
+	go func() {
+		var err error
+
+		msg, err = m.Send(ctx, "bob", []byte("hello"), nil)
+		sent <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(store.unblock)
+
+	if err := <-put; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.Receive(msg, nil); err != nil {
+		t.Errorf("Expected the message to be sent with the new session, got %v", err)
+	}
+
+	state, _ = store.MemoryStore.Load(ctx, "bob")
+	restored, _ := doubleratchet.Deserialize(state)
+
+	if sent := restored.Usage().MessagesSent; sent != 1 {
+		t.Errorf("Expected the store to hold the new session after the send, got %d messages sent", sent)
+	}
+}
+
+// TestManagerLoadOutlivesCaller verifies that a shared load is not canceled with the
+// context of the use that started it: that use returns its context's error, and the other
+// uses waiting for the load get the session.
+func TestManagerLoadOutlivesCaller(t *testing.T) {
+	store := newGatedStore("load")
+	m := NewManager(store, nil)
+	bob := newPeers(t, m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+
+	go func() {
+		_, err := m.Send(ctx, "bob", []byte("first"), nil)
+		first <- err
+	}()
+
+	<-store.entered
+
+	second := make(chan error)
+
+	var msg doubleratchet.CipheredMessage
+
+	go func() {
+		var err error
+
+		msg, err = m.Send(context.Background(), "bob", []byte("second"), nil)
+		second <- err
+	}()
+
+	cancel()
+
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled for the canceled use, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the canceled use to return before the load finished")
+	}
+
+	close(store.unblock)
+
+	if err := <-second; err != nil {
+		t.Fatalf("Expected the waiting use to get the session, got %v", err)
+	}
+
+	if got, err := bob.Receive(msg, nil); err != nil || string(got.Plaintext) != "second" {
+		t.Errorf("Expected the message to decrypt, got %q, %v", got.Plaintext, err)
+	}
+}
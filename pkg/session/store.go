@@ -0,0 +1,112 @@
+// Package session manages many Double Ratchet sessions, one per peer, on top of a
+// pluggable persistent store.
+package session
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrNotFound is returned by a Store when no session is stored for a peer.
+	ErrNotFound = errors.New("session: not found")
+)
+
+// Store persists serialized sessions by peer ID. Implementations must be safe for
+// concurrent use and should honor context cancellation.
+type Store interface {
+	// Load returns the serialized session of peer, or ErrNotFound.
+	Load(ctx context.Context, peer string) ([]byte, error)
+
+	// Save stores the serialized session of peer, replacing any previous one.
+	Save(ctx context.Context, peer string, state []byte) error
+
+	// Delete removes the session of peer. Deleting a missing session is not an error.
+	Delete(ctx context.Context, peer string) error
+}
+
+// Lister is implemented by stores that can enumerate their sessions, for example to
+// migrate or expire them in bulk.
+type Lister interface {
+	// List returns the peer IDs of all stored sessions in ascending order.
+	List(ctx context.Context) ([]string, error)
+}
+
+// MemoryStore is an in-memory Store.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string][]byte)}
+}
+
+// Load returns the serialized session of peer.
+func (s *MemoryStore) Load(ctx context.Context, peer string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[peer]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), state...), nil
+}
+
+// Save stores the serialized session of peer.
+func (s *MemoryStore) Save(ctx context.Context, peer string, state []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[peer] = append([]byte(nil), state...)
+
+	return nil
+}
+
+// Delete removes the session of peer.
+func (s *MemoryStore) Delete(ctx context.Context, peer string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, peer)
+
+	return nil
+}
+
+// List returns the peer IDs of all stored sessions in ascending order.
+func (s *MemoryStore) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]string, 0, len(s.sessions))
+
+	for peer := range s.sessions {
+		peers = append(peers, peer)
+	}
+
+	sort.Strings(peers)
+
+	return peers, nil
+}
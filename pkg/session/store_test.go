@@ -0,0 +1,31 @@
+package session
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestMemoryStoreList verifies that List returns the stored peers in ascending order.
+func TestMemoryStoreList(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	for _, peer := range []string{"carol", "alice", "bob"} {
+		_ = s.Save(ctx, peer, []byte(peer))
+	}
+
+	_ = s.Delete(ctx, "bob")
+
+	var lister Lister = s
+
+	peers, err := lister.List(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(peers, []string{"alice", "carol"}) {
+		t.Errorf("Expected [alice carol], got %v", peers)
+	}
+}
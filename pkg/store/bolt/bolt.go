@@ -0,0 +1,160 @@
+// Package bolt stores Double Ratchet sessions in an embedded bbolt database, as a durable
+// session.Store for applications that cannot depend on cgo.
+//
+// All data lives under a single top-level bucket, "goratchet" by default, so the database
+// can be shared with the application's own buckets:
+//
+//	goratchet/
+//	    sessions/    peer ID -> serialized session
+//	    skipped/
+//	        <session ID>/    PN, N, DH -> message key, epoch, storage time
+//
+// The skipped bucket is used by SkippedKeyStore; see doubleratchet.WithSkippedKeyStore.
+package bolt
+
+import (
+	"context"
+	"errors"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/othonhugo/goratchet/pkg/session"
+)
+
+// DefaultBucket is the name of the top-level bucket unless Config.Bucket says otherwise.
+const DefaultBucket = "goratchet"
+
+// sessionsBucket is the nested bucket holding the serialized sessions.
+var sessionsBucket = []byte("sessions")
+
+var (
+	// ErrEmptyPeer is returned by Save for an empty peer ID, which bbolt cannot use as
+	// a key.
+	ErrEmptyPeer = errors.New("bolt: empty peer ID")
+)
+
+// Config configures a Store.
+type Config struct {
+	// Bucket is the name of the top-level bucket. It defaults to DefaultBucket.
+	Bucket string
+}
+
+// Store is a session.Store and session.Lister backed by a bbolt database. It is safe
+// for concurrent use.
+type Store struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+var (
+	_ session.Store  = (*Store)(nil)
+	_ session.Lister = (*Store)(nil)
+)
+
+// New returns a Store that keeps sessions in db, creating its buckets if they do not
+// exist. A nil config uses the defaults. The caller opens and closes db.
+func New(db *bbolt.DB, config *Config) (*Store, error) {
+	bucket := DefaultBucket
+
+	if config != nil && config.Bucket != "" {
+		bucket = config.Bucket
+	}
+
+	s := &Store{db: db, bucket: []byte(bucket)}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(s.bucket)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = root.CreateBucketIfNotExists(sessionsBucket)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// sessions returns the sessions bucket of tx.
+func (s *Store) sessions(tx *bbolt.Tx) *bbolt.Bucket {
+	return tx.Bucket(s.bucket).Bucket(sessionsBucket)
+}
+
+// Load returns the serialized session of peer, or session.ErrNotFound.
+func (s *Store) Load(ctx context.Context, peer string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var state []byte
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := s.sessions(tx).Get([]byte(peer))
+
+		if v == nil {
+			return session.ErrNotFound
+		}
+
+		// Values are only valid during the transaction.
+		state = append([]byte(nil), v...)
+
+		return nil
+	})
+
+	return state, err
+}
+
+// Save stores the serialized session of peer, replacing any previous one. The write is
+// synced to disk before Save returns.
+func (s *Store) Save(ctx context.Context, peer string, state []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if peer == "" {
+		return ErrEmptyPeer
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return s.sessions(tx).Put([]byte(peer), state)
+	})
+}
+
+// Delete removes the session of peer. Deleting a missing session is not an error.
+func (s *Store) Delete(ctx context.Context, peer string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if peer == "" {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return s.sessions(tx).Delete([]byte(peer))
+	})
+}
+
+// List returns the peer IDs of all stored sessions in ascending order.
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var peers []string
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return s.sessions(tx).ForEach(func(k, _ []byte) error {
+			peers = append(peers, string(k))
+			return nil
+		})
+	})
+
+	return peers, err
+}
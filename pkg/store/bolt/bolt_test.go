@@ -0,0 +1,79 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/othonhugo/goratchet/pkg/session"
+)
+
+// TestStore verifies that sessions are saved, replaced, listed and deleted, that missing
+// sessions are reported as session.ErrNotFound, and that the data survives reopening the
+// database.
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	db, err := bbolt.Open(path, 0o600, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(db, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Load(ctx, "alice"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("Expected session.ErrNotFound, got %v", err)
+	}
+
+	for _, save := range []struct{ peer, state string }{{"bob", "old"}, {"alice", "a"}, {"bob", "new"}} {
+		if err := s.Save(ctx, save.peer, []byte(save.state)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Save(ctx, "", nil); !errors.Is(err, ErrEmptyPeer) {
+		t.Errorf("Expected ErrEmptyPeer, got %v", err)
+	}
+
+	db.Close()
+
+	if db, err = bbolt.Open(path, 0o600, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if s, err = New(db, &Config{Bucket: DefaultBucket}); err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := s.Load(ctx, "bob"); err != nil || string(state) != "new" {
+		t.Errorf("Expected the replaced state, got %q, %v", state, err)
+	}
+
+	if peers, err := s.List(ctx); err != nil || !reflect.DeepEqual(peers, []string{"alice", "bob"}) {
+		t.Errorf("Expected [alice bob], got %v, %v", peers, err)
+	}
+
+	if err := s.Delete(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "bob"); err != nil {
+		t.Errorf("Expected deleting a missing session to succeed, got %v", err)
+	}
+
+	if _, err := s.Load(ctx, "bob"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("Expected session.ErrNotFound after Delete, got %v", err)
+	}
+}
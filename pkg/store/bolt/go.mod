@@ -0,0 +1,12 @@
+module github.com/othonhugo/goratchet/pkg/store/bolt
+
+go 1.22.0
+
+require (
+	github.com/othonhugo/goratchet v0.0.0
+	go.etcd.io/bbolt v1.3.11
+)
+
+require golang.org/x/sys v0.4.0 // indirect
+
+replace github.com/othonhugo/goratchet => ../../..
@@ -0,0 +1,207 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// skippedBucket is the nested bucket holding a bucket of skipped keys per session.
+var skippedBucket = []byte("skipped")
+
+var (
+	// ErrEmptySession is returned by SkippedKeyStore.Put for an empty session ID, which
+	// bbolt cannot use as a bucket name.
+	ErrEmptySession = errors.New("bolt: empty session ID")
+
+	// ErrMalformedKey is returned by SkippedKeyStore.List for an entry it did not write.
+	ErrMalformedKey = errors.New("bolt: malformed skipped key entry")
+)
+
+// skippedValueSize is the size of a skipped key value: the message key, the epoch and
+// the storage time.
+const skippedValueSize = 32 + 4 + 8
+
+// SkippedKeyStore is a doubleratchet.SkippedKeyStore backed by a bbolt database. Each
+// session's keys live in a bucket of their own, so DeleteSession drops them at once. It
+// is safe for concurrent use.
+type SkippedKeyStore struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+var _ doubleratchet.SkippedKeyStore = (*SkippedKeyStore)(nil)
+
+// NewSkippedKeyStore returns a SkippedKeyStore that keeps keys in db, creating its
+// buckets if they do not exist. A nil config uses the defaults; use the same config as
+// for New to share the top-level bucket with the sessions.
+func NewSkippedKeyStore(db *bbolt.DB, config *Config) (*SkippedKeyStore, error) {
+	bucket := DefaultBucket
+
+	if config != nil && config.Bucket != "" {
+		bucket = config.Bucket
+	}
+
+	s := &SkippedKeyStore{db: db, bucket: []byte(bucket)}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(s.bucket)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = root.CreateBucketIfNotExists(skippedBucket)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// skipped returns the skipped bucket of tx.
+func (s *SkippedKeyStore) skipped(tx *bbolt.Tx) *bbolt.Bucket {
+	return tx.Bucket(s.bucket).Bucket(skippedBucket)
+}
+
+// Put stores keys for the session in one transaction.
+func (s *SkippedKeyStore) Put(ctx context.Context, sessionID []byte, keys []doubleratchet.SkippedMessageKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(sessionID) == 0 {
+		return ErrEmptySession
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		session, err := s.skipped(tx).CreateBucketIfNotExists(sessionID)
+
+		if err != nil {
+			return err
+		}
+
+		for _, sk := range keys {
+			value := make([]byte, 0, skippedValueSize)
+			value = append(value, sk.Key[:]...)
+			value = binary.BigEndian.AppendUint32(value, sk.Epoch)
+			value = binary.BigEndian.AppendUint64(value, uint64(sk.StoredAt)) // #nosec G115 -- round-trips through the same conversion
+
+			if err := session.Put(skippedID(sk.Header), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete removes keys of the session in one transaction.
+func (s *SkippedKeyStore) Delete(ctx context.Context, sessionID []byte, headers []doubleratchet.Header) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(sessionID) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		session := s.skipped(tx).Bucket(sessionID)
+
+		if session == nil {
+			return nil
+		}
+
+		for _, h := range headers {
+			if err := session.Delete(skippedID(h)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// List returns the keys of the session, ordered by PN, N and DH.
+func (s *SkippedKeyStore) List(ctx context.Context, sessionID []byte) ([]doubleratchet.SkippedMessageKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sessionID) == 0 {
+		return nil, nil
+	}
+
+	var keys []doubleratchet.SkippedMessageKey
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		session := s.skipped(tx).Bucket(sessionID)
+
+		if session == nil {
+			return nil
+		}
+
+		return session.ForEach(func(k, v []byte) error {
+			if len(k) < 8 || len(v) != skippedValueSize {
+				return ErrMalformedKey
+			}
+
+			var sk doubleratchet.SkippedMessageKey
+
+			sk.Header.PN = binary.BigEndian.Uint32(k)
+			sk.Header.N = binary.BigEndian.Uint32(k[4:])
+			sk.Header.DH = append([]byte(nil), k[8:]...)
+
+			copy(sk.Key[:], v)
+			sk.Epoch = binary.BigEndian.Uint32(v[32:])
+			sk.StoredAt = int64(binary.BigEndian.Uint64(v[36:])) // #nosec G115 -- inverse of Put
+
+			keys = append(keys, sk)
+
+			return nil
+		})
+	})
+
+	return keys, err
+}
+
+// DeleteSession removes all keys of the session, for example after its record was
+// deleted.
+func (s *SkippedKeyStore) DeleteSession(ctx context.Context, sessionID []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(sessionID) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		err := s.skipped(tx).DeleteBucket(sessionID)
+
+		if errors.Is(err, bbolt.ErrBucketNotFound) {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// skippedID returns the bucket key of a skipped key: PN and N in big-endian order, so
+// that keys sort by chain and message number, followed by the DH key.
+func skippedID(h doubleratchet.Header) []byte {
+	id := make([]byte, 0, 8+len(h.DH))
+	id = binary.BigEndian.AppendUint32(id, h.PN)
+	id = binary.BigEndian.AppendUint32(id, h.N)
+
+	return append(id, h.DH...)
+}
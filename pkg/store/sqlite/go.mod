@@ -0,0 +1,10 @@
+module github.com/othonhugo/goratchet/pkg/store/sqlite
+
+go 1.22.0
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/othonhugo/goratchet v0.0.0
+)
+
+replace github.com/othonhugo/goratchet => ../../..
@@ -0,0 +1,158 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// DefaultSkippedTable is the name of the skipped keys table unless
+// SkippedConfig.Table says otherwise.
+const DefaultSkippedTable = "goratchet_skipped_keys"
+
+// SkippedConfig configures a SkippedKeyStore.
+type SkippedConfig struct {
+	// Table is the name of the skipped keys table. It defaults to DefaultSkippedTable.
+	Table string
+}
+
+// SkippedKeyStore is a doubleratchet.SkippedKeyStore backed by an SQLite table with one
+// row per key, so that skipped keys can be kept apart from the session records and
+// expired with plain SQL on their stored_at column.
+type SkippedKeyStore struct {
+	db *sql.DB
+
+	put, remove, list, removeSession string
+}
+
+var _ doubleratchet.SkippedKeyStore = (*SkippedKeyStore)(nil)
+
+// NewSkippedKeyStore returns a SkippedKeyStore that keeps keys in db, creating the
+// skipped keys table if it does not exist. A nil config uses the defaults.
+func NewSkippedKeyStore(ctx context.Context, db *sql.DB, config *SkippedConfig) (*SkippedKeyStore, error) {
+	table := DefaultSkippedTable
+
+	if config != nil && config.Table != "" {
+		table = config.Table
+	}
+
+	if !tableName.MatchString(table) {
+		return nil, ErrInvalidTable
+	}
+
+	schema := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	session_id BLOB NOT NULL,
+	dh BLOB NOT NULL,
+	n INTEGER NOT NULL,
+	pn INTEGER NOT NULL,
+	message_key BLOB NOT NULL,
+	epoch INTEGER NOT NULL,
+	stored_at INTEGER NOT NULL,
+	PRIMARY KEY (session_id, dh, n, pn)
+)`, table)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, err
+	}
+
+	return &SkippedKeyStore{
+		db:            db,
+		put:           fmt.Sprintf("INSERT OR REPLACE INTO %s (session_id, dh, n, pn, message_key, epoch, stored_at) VALUES (?, ?, ?, ?, ?, ?, ?)", table),
+		remove:        fmt.Sprintf("DELETE FROM %s WHERE session_id = ? AND dh = ? AND n = ? AND pn = ?", table),
+		list:          fmt.Sprintf("SELECT dh, n, pn, message_key, epoch, stored_at FROM %s WHERE session_id = ?", table),
+		removeSession: fmt.Sprintf("DELETE FROM %s WHERE session_id = ?", table),
+	}, nil
+}
+
+// Put stores keys for the session in one transaction.
+func (s *SkippedKeyStore) Put(ctx context.Context, sessionID []byte, keys []doubleratchet.SkippedMessageKey) error {
+	return s.inTx(ctx, s.put, func(stmt *sql.Stmt) error {
+		for _, sk := range keys {
+			if _, err := stmt.ExecContext(ctx, sessionID, sk.Header.DH, sk.Header.N, sk.Header.PN, sk.Key[:], sk.Epoch, sk.StoredAt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete removes keys of the session in one transaction.
+func (s *SkippedKeyStore) Delete(ctx context.Context, sessionID []byte, headers []doubleratchet.Header) error {
+	return s.inTx(ctx, s.remove, func(stmt *sql.Stmt) error {
+		for _, h := range headers {
+			if _, err := stmt.ExecContext(ctx, sessionID, h.DH, h.N, h.PN); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// List returns the keys of the session.
+func (s *SkippedKeyStore) List(ctx context.Context, sessionID []byte) ([]doubleratchet.SkippedMessageKey, error) {
+	rows, err := s.db.QueryContext(ctx, s.list, sessionID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var keys []doubleratchet.SkippedMessageKey
+
+	for rows.Next() {
+		var sk doubleratchet.SkippedMessageKey
+		var key []byte
+
+		if err := rows.Scan(&sk.Header.DH, &sk.Header.N, &sk.Header.PN, &key, &sk.Epoch, &sk.StoredAt); err != nil {
+			return nil, err
+		}
+
+		if len(key) != len(sk.Key) {
+			return nil, fmt.Errorf("sqlite: skipped key of %d bytes", len(key))
+		}
+
+		copy(sk.Key[:], key)
+
+		keys = append(keys, sk)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteSession removes all keys of the session, for example after its record was
+// deleted.
+func (s *SkippedKeyStore) DeleteSession(ctx context.Context, sessionID []byte) error {
+	_, err := s.db.ExecContext(ctx, s.removeSession, sessionID)
+
+	return err
+}
+
+// inTx runs fn with query prepared in a transaction, committing it if fn succeeds.
+func (s *SkippedKeyStore) inTx(ctx context.Context, query string, fn func(*sql.Stmt) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = tx.Rollback() }() // a no-op after Commit
+
+	stmt, err := tx.PrepareContext(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	if err := fn(stmt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/othonhugo/goratchet/pkg/doubleratchet"
+)
+
+// TestSkippedKeyStore verifies that a session keeps its skipped keys in the table, and
+// that a session restored from its record finds them there.
+func TestSkippedKeyStore(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	store, err := NewSkippedKeyStore(ctx, db, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alicePri, _ := ecdh.P256().GenerateKey(rand.Reader)
+	bobPri, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	alice, _ := doubleratchet.New(alicePri.Bytes(), bobPri.PublicKey().Bytes(), nil)
+	bob, _ := doubleratchet.New(bobPri.Bytes(), alicePri.PublicKey().Bytes(), nil, doubleratchet.WithSkippedKeyStore(store))
+
+	first, _ := alice.Send([]byte("first"), nil)
+	second, _ := alice.Send([]byte("second"), nil)
+
+	if _, err := bob.Receive(second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List(ctx, bob.SessionID())
+
+	if err != nil || len(keys) != 1 || !bytes.Equal(keys[0].Header.DH, first.Header.DH) || keys[0].Header.N != 0 {
+		t.Fatalf("Expected the skipped key of the first message, got %+v, %v", keys, err)
+	}
+
+	record, _ := bob.Serialize()
+
+	restored, err := doubleratchet.Deserialize(record, doubleratchet.WithSkippedKeyStore(store))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := restored.Receive(first, nil); err != nil || string(got.Plaintext) != "first" {
+		t.Fatalf("Expected the restored session to find the skipped key, got %q, %v", got.Plaintext, err)
+	}
+
+	if keys, _ := store.List(ctx, bob.SessionID()); len(keys) != 0 {
+		t.Errorf("Expected the used key to be deleted, got %d keys", len(keys))
+	}
+
+	_ = store.Put(ctx, []byte("other"), []doubleratchet.SkippedMessageKey{{Header: doubleratchet.Header{DH: []byte("dh")}}})
+
+	if err := store.DeleteSession(ctx, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ := store.List(ctx, []byte("other")); len(keys) != 0 {
+		t.Errorf("Expected DeleteSession to remove every key, got %d", len(keys))
+	}
+}
@@ -0,0 +1,138 @@
+// Package sqlite stores Double Ratchet sessions in an SQLite database, as a durable
+// session.Store for desktop and server applications. SkippedKeyStore keeps skipped
+// message keys in a table of their own; see doubleratchet.WithSkippedKeyStore.
+//
+// The package uses database/sql and works with any SQLite driver: open the database with
+// github.com/mattn/go-sqlite3 (driver "sqlite3", cgo) or modernc.org/sqlite (driver
+// "sqlite", pure Go) and pass it to New.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/othonhugo/goratchet/pkg/session"
+)
+
+// DefaultTable is the name of the sessions table unless Config.Table says otherwise.
+const DefaultTable = "goratchet_sessions"
+
+var (
+	// ErrInvalidTable is returned by New for a table name that is not a plain SQL
+	// identifier.
+	ErrInvalidTable = errors.New("sqlite: invalid table name")
+)
+
+// tableName matches the table names New accepts, which are interpolated into queries.
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Config configures a Store.
+type Config struct {
+	// Table is the name of the sessions table. It defaults to DefaultTable.
+	Table string
+}
+
+// Store is a session.Store and session.Lister backed by an SQLite table with one row per
+// peer. It is safe for concurrent use as far as the database handle is.
+type Store struct {
+	db *sql.DB
+
+	load, save, remove, list string
+}
+
+var (
+	_ session.Store  = (*Store)(nil)
+	_ session.Lister = (*Store)(nil)
+)
+
+// New returns a Store that keeps sessions in db, creating the sessions table if it does
+// not exist. A nil config uses the defaults.
+func New(ctx context.Context, db *sql.DB, config *Config) (*Store, error) {
+	table := DefaultTable
+
+	if config != nil && config.Table != "" {
+		table = config.Table
+	}
+
+	if !tableName.MatchString(table) {
+		return nil, ErrInvalidTable
+	}
+
+	schema := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	peer TEXT PRIMARY KEY NOT NULL,
+	state BLOB NOT NULL,
+	updated_at INTEGER NOT NULL
+)`, table)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db:     db,
+		load:   fmt.Sprintf("SELECT state FROM %s WHERE peer = ?", table),
+		save:   fmt.Sprintf("INSERT INTO %s (peer, state, updated_at) VALUES (?, ?, ?) ON CONFLICT (peer) DO UPDATE SET state = excluded.state, updated_at = excluded.updated_at", table),
+		remove: fmt.Sprintf("DELETE FROM %s WHERE peer = ?", table),
+		list:   fmt.Sprintf("SELECT peer FROM %s ORDER BY peer", table),
+	}, nil
+}
+
+// Load returns the serialized session of peer, or session.ErrNotFound.
+func (s *Store) Load(ctx context.Context, peer string) ([]byte, error) {
+	var state []byte
+
+	err := s.db.QueryRowContext(ctx, s.load, peer).Scan(&state)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, session.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Save stores the serialized session of peer, replacing any previous one.
+func (s *Store) Save(ctx context.Context, peer string, state []byte) error {
+	_, err := s.db.ExecContext(ctx, s.save, peer, state, time.Now().Unix())
+
+	return err
+}
+
+// Delete removes the session of peer. Deleting a missing session is not an error.
+func (s *Store) Delete(ctx context.Context, peer string) error {
+	_, err := s.db.ExecContext(ctx, s.remove, peer)
+
+	return err
+}
+
+// List returns the peer IDs of all stored sessions in ascending order.
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.list)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var peers []string
+
+	for rows.Next() {
+		var peer string
+
+		if err := rows.Scan(&peer); err != nil {
+			return nil, err
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers, rows.Err()
+}
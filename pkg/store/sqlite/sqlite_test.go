@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/othonhugo/goratchet/pkg/session"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "sessions.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// TestStore verifies that sessions are saved, replaced, listed and deleted, that missing
+// sessions are reported as session.ErrNotFound, and that the data survives reopening the
+// store.
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	s, err := New(ctx, db, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Load(ctx, "alice"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("Expected session.ErrNotFound, got %v", err)
+	}
+
+	for _, save := range []struct{ peer, state string }{{"bob", "old"}, {"alice", "a"}, {"bob", "new"}} {
+		if err := s.Save(ctx, save.peer, []byte(save.state)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A second store on the same database sees the same sessions.
+	reopened, err := New(ctx, db, &Config{Table: DefaultTable})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := reopened.Load(ctx, "bob"); err != nil || string(state) != "new" {
+		t.Errorf("Expected the replaced state, got %q, %v", state, err)
+	}
+
+	if peers, err := reopened.List(ctx); err != nil || !reflect.DeepEqual(peers, []string{"alice", "bob"}) {
+		t.Errorf("Expected [alice bob], got %v, %v", peers, err)
+	}
+
+	if err := s.Delete(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "bob"); err != nil {
+		t.Errorf("Expected deleting a missing session to succeed, got %v", err)
+	}
+
+	if _, err := s.Load(ctx, "bob"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("Expected session.ErrNotFound after Delete, got %v", err)
+	}
+
+	if _, err := New(ctx, db, &Config{Table: "sessions; DROP TABLE x"}); !errors.Is(err, ErrInvalidTable) {
+		t.Errorf("Expected ErrInvalidTable, got %v", err)
+	}
+}
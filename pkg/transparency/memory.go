@@ -0,0 +1,95 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"sync"
+)
+
+// ErrInvalidTreeSize is returned by MemoryLog.Consistency for tree sizes the log has
+// never had.
+var ErrInvalidTreeSize = errors.New("transparency: invalid tree size")
+
+// MemoryLog is an in-memory Log, useful for tests and as a reference for implementations
+// backed by a real transparency service. It signs its tree heads with a key of its own.
+type MemoryLog struct {
+	mu      sync.Mutex
+	key     ed25519.PrivateKey
+	leaves  [][]byte
+	entries []Entry
+	latest  map[string]int
+}
+
+// NewMemoryLog returns an empty in-memory log with a fresh signing key.
+func NewMemoryLog() *MemoryLog {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return &MemoryLog{key: key, latest: make(map[string]int)}
+}
+
+// PublicKey returns the public key the log signs its tree heads with, for Verifier.LogKey.
+func (l *MemoryLog) PublicKey() ed25519.PublicKey {
+	return l.key.Public().(ed25519.PublicKey)
+}
+
+// Publish appends a binding of identity to key.
+func (l *MemoryLog) Publish(identity string, key []byte) (Entry, error) {
+	if identity == "" || len(identity) > maxIdentityLen {
+		return Entry{}, ErrInvalidIdentity
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key = append([]byte(nil), key...)
+
+	l.leaves = append(l.leaves, leafHash(encodeLeaf(identity, key)))
+	l.entries = append(l.entries, Entry{Identity: identity, Key: key})
+	l.latest[identity] = len(l.entries) - 1
+
+	return l.entry(len(l.entries) - 1), nil
+}
+
+// Lookup returns the most recent binding for identity with a proof against the current tree.
+func (l *MemoryLog) Lookup(identity string) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index, ok := l.latest[identity]
+
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	return l.entry(index), nil
+}
+
+// Consistency returns the proof that the tree of size from is a prefix of the tree of
+// size to.
+func (l *MemoryLog) Consistency(from, to uint64) ([][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if from > to || to > uint64(len(l.leaves)) {
+		return nil, ErrInvalidTreeSize
+	}
+
+	return consistencyProof(int(from), l.leaves[:to]), nil // #nosec G115 -- bounded by the number of leaves
+}
+
+// entry returns the entry at index with a proof against the current tree.
+func (l *MemoryLog) entry(index int) Entry {
+	e := l.entries[index]
+
+	e.Key = append([]byte(nil), e.Key...)
+	e.Index = uint64(index)
+	e.Proof = inclusionProof(index, l.leaves)
+	e.Head = SignTreeHead(l.key, uint64(len(l.leaves)), rootHash(l.leaves))
+
+	return e
+}
@@ -0,0 +1,194 @@
+package transparency
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// leafHash returns the RFC 6962 hash of a leaf.
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+
+	return h.Sum(nil)
+}
+
+// nodeHash returns the RFC 6962 hash of an interior node.
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+
+	return h.Sum(nil)
+}
+
+// encodeLeaf returns the leaf binding identity to key: a uint16 identity length, the
+// identity and the key.
+func encodeLeaf(identity string, key []byte) []byte {
+	leaf := make([]byte, 0, 2+len(identity)+len(key))
+	leaf = binary.BigEndian.AppendUint16(leaf, uint16(len(identity))) // #nosec G115 -- identities are checked against maxIdentityLen
+	leaf = append(leaf, identity...)
+
+	return append(leaf, key...)
+}
+
+// rootHash computes the Merkle tree hash of the given leaf hashes.
+func rootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := splitPoint(len(leaves))
+
+	return nodeHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+// inclusionProof returns the audit path of leaf index in the tree of the given leaf hashes.
+func inclusionProof(index int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+
+	k := splitPoint(len(leaves))
+
+	if index < k {
+		return append(inclusionProof(index, leaves[:k]), rootHash(leaves[k:]))
+	}
+
+	return append(inclusionProof(index-k, leaves[k:]), rootHash(leaves[:k]))
+}
+
+// verifyInclusion checks that leaf is at index in a tree of the given size with the given root.
+func verifyInclusion(leaf []byte, index, size uint64, proof [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+
+	fn, sn := index, size-1
+	r := leafHash(leaf)
+
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && string(r) == string(root)
+}
+
+// consistencyProof returns the RFC 6962 proof that the tree of the first m leaf hashes is
+// a prefix of the tree of all of them.
+func consistencyProof(m int, leaves [][]byte) [][]byte {
+	if m <= 0 || m >= len(leaves) {
+		return nil
+	}
+
+	return subproof(m, leaves, true)
+}
+
+// subproof is the SUBPROOF function of RFC 6962, section 2.1.2.
+func subproof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+
+	if m == n {
+		if complete {
+			return nil
+		}
+
+		return [][]byte{rootHash(leaves)}
+	}
+
+	k := splitPoint(n)
+
+	if m <= k {
+		return append(subproof(m, leaves[:k], complete), rootHash(leaves[k:]))
+	}
+
+	return append(subproof(m-k, leaves[k:], false), rootHash(leaves[:k]))
+}
+
+// verifyConsistency checks that the tree of size first with root firstRoot is a prefix of
+// the tree of size second with root secondRoot, following RFC 9162, section 2.1.4.2.
+func verifyConsistency(first, second uint64, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	switch {
+	case first > second:
+		return false
+	case first == second:
+		return len(proof) == 0 && string(firstRoot) == string(secondRoot)
+	case first == 0:
+		return len(proof) == 0
+	}
+
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+
+	if len(proof) == 0 {
+		return false
+	}
+
+	fn, sn := first-1, second-1
+
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && string(fr) == string(firstRoot) && string(sr) == string(secondRoot)
+}
+
+// splitPoint returns the largest power of two smaller than n.
+func splitPoint(n int) int {
+	k := 1
+
+	for k<<1 < n {
+		k <<= 1
+	}
+
+	return k
+}
@@ -0,0 +1,303 @@
+// Package transparency verifies identity keys against a key transparency log, in the
+// style of CONIKS or Key Transparency. A log is an append-only Merkle tree of
+// (identity, key) bindings; clients check that the identity key a peer presents is the
+// one the log publishes for that peer, and key owners monitor the log for bindings they
+// did not publish. A server that silently swaps an identity key must then either show
+// the swap in the log, where its owner sees it, or serve a key that fails verification.
+//
+// The log signs every tree head it serves with its Ed25519 key, and a Verifier accepts a
+// new tree head only with a proof that it extends the last one it accepted. A log that
+// shows a client a tree of its own making, such as a one-leaf tree binding the identity
+// to a key of the log's choice, therefore fails verification unless it also forks the
+// history it has signed, which clients that compare tree heads detect.
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// maxIdentityLen bounds the length of an identity string.
+const maxIdentityLen = 1<<16 - 1
+
+// treeHeadLabel domain-separates tree head signatures from other uses of the log key.
+var treeHeadLabel = []byte("goratchet-transparency-tree-head")
+
+var (
+	// ErrNotFound is returned by Log.Lookup when an identity has no published key.
+	ErrNotFound = errors.New("transparency: identity not found")
+
+	// ErrInvalidIdentity is returned when an identity is empty or too long.
+	ErrInvalidIdentity = errors.New("transparency: invalid identity")
+
+	// ErrInvalidProof is returned when a log entry's inclusion proof does not verify.
+	ErrInvalidProof = errors.New("transparency: invalid inclusion proof")
+
+	// ErrKeyMismatch is returned when a presented or owned key differs from the key the log publishes.
+	ErrKeyMismatch = errors.New("transparency: identity key does not match the log")
+
+	// ErrInvalidTreeHead is returned when a tree head is not signed by the log's key.
+	ErrInvalidTreeHead = errors.New("transparency: invalid tree head signature")
+
+	// ErrInconsistentLog is returned when a tree head does not extend the last tree head
+	// the Verifier accepted: the log rolled back or forked its history.
+	ErrInconsistentLog = errors.New("transparency: tree head is not consistent with the last accepted one")
+
+	// ErrNoLogKey is returned when a Verifier has no valid log public key.
+	ErrNoLogKey = errors.New("transparency: no log public key configured")
+)
+
+// Status is the outcome of verifying an identity key against a transparency log.
+type Status int
+
+const (
+	// StatusUnverified means no verification was attempted.
+	StatusUnverified Status = iota
+
+	// StatusVerified means the key matches the key the log publishes for the identity.
+	StatusVerified
+
+	// StatusKeyChanged means the key matches the log, but differs from the key verified
+	// previously for the same identity. This is expected after a legitimate key rotation
+	// and should be shown to the user.
+	StatusKeyChanged
+
+	// StatusMismatch means the key differs from the key the log publishes, or the log's
+	// proofs or tree head signature are invalid, or its tree head does not extend the last
+	// one accepted. The key must not be trusted.
+	StatusMismatch
+
+	// StatusUnavailable means the log could not be queried.
+	StatusUnavailable
+)
+
+// String returns a human-readable name for the status.
+func (s Status) String() string {
+	switch s {
+	case StatusVerified:
+		return "verified"
+	case StatusKeyChanged:
+		return "key changed"
+	case StatusMismatch:
+		return "mismatch"
+	case StatusUnavailable:
+		return "unavailable"
+	default:
+		return "unverified"
+	}
+}
+
+// TreeHead is the size and root hash of the log's tree, signed with the log's key.
+type TreeHead struct {
+	TreeSize  uint64
+	Root      []byte
+	Signature []byte
+}
+
+// SignTreeHead signs the tree head of a tree of size leaves with root hash root. Log
+// implementations serve the result with every Entry.
+func SignTreeHead(key ed25519.PrivateKey, size uint64, root []byte) TreeHead {
+	return TreeHead{
+		TreeSize:  size,
+		Root:      append([]byte(nil), root...),
+		Signature: ed25519.Sign(key, treeHeadMessage(size, root)),
+	}
+}
+
+// Verify checks the tree head's signature with the log's public key.
+func (h TreeHead) Verify(logKey ed25519.PublicKey) error {
+	if len(logKey) != ed25519.PublicKeySize {
+		return ErrNoLogKey
+	}
+
+	if !ed25519.Verify(logKey, treeHeadMessage(h.TreeSize, h.Root), h.Signature) {
+		return ErrInvalidTreeHead
+	}
+
+	return nil
+}
+
+// treeHeadMessage returns the message a tree head signature covers: the label, the
+// uint64 tree size and the root hash.
+func treeHeadMessage(size uint64, root []byte) []byte {
+	msg := make([]byte, 0, len(treeHeadLabel)+8+len(root))
+	msg = append(msg, treeHeadLabel...)
+	msg = binary.BigEndian.AppendUint64(msg, size)
+
+	return append(msg, root...)
+}
+
+// Entry is a binding of an identity to a key, together with the proof that it is
+// included in the log.
+type Entry struct {
+	Identity string
+	Key      []byte
+
+	// Index is the position of the binding in the log.
+	Index uint64
+
+	// Proof is the RFC 6962 inclusion proof of the binding in the tree of Head.
+	Proof [][]byte
+
+	// Head is the signed tree head the proof was computed against.
+	Head TreeHead
+}
+
+// Verify checks the signature of the entry's tree head with the log's public key and the
+// entry's inclusion proof against it.
+func (e Entry) Verify(logKey ed25519.PublicKey) error {
+	if err := e.Head.Verify(logKey); err != nil {
+		return err
+	}
+
+	if !verifyInclusion(encodeLeaf(e.Identity, e.Key), e.Index, e.Head.TreeSize, e.Proof, e.Head.Root) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// Log is a key transparency log.
+type Log interface {
+	// Publish appends a binding of identity to key and returns its entry.
+	Publish(identity string, key []byte) (Entry, error)
+
+	// Lookup returns the most recent binding for identity, or ErrNotFound.
+	Lookup(identity string) (Entry, error)
+
+	// Consistency returns the RFC 6962 proof that the tree of size from is a prefix of
+	// the tree of size to.
+	Consistency(from, to uint64) ([][]byte, error)
+}
+
+// Verifier checks the identity keys presented by a single peer against a log. It accepts
+// only tree heads signed with LogKey, remembers the last one it accepted and requires
+// every later one to extend it, and remembers the last verified key, so that key changes
+// are reported.
+type Verifier struct {
+	// Log is the transparency log to query.
+	Log Log
+
+	// LogKey is the log's Ed25519 public key, obtained out of band.
+	LogKey ed25519.PublicKey
+
+	// Identity is the peer's identity in the log, such as a user name or address.
+	Identity string
+
+	// TrustedHead, if set, is the tree head the Verifier starts from, such as one saved
+	// with Head by an earlier run or obtained by gossip with other clients. Without it,
+	// the first signed tree head is accepted as is.
+	TrustedHead TreeHead
+
+	mu     sync.Mutex
+	head   TreeHead
+	pinned []byte
+}
+
+// Verify checks key against the log. A StatusMismatch is accompanied by ErrKeyMismatch,
+// ErrInvalidProof, ErrInvalidTreeHead, ErrInconsistentLog or ErrNoLogKey; a
+// StatusUnavailable by the log's error.
+func (v *Verifier) Verify(key []byte) (Status, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, status, err := v.lookup()
+
+	if err != nil {
+		return status, err
+	}
+
+	if !bytes.Equal(entry.Key, key) {
+		return StatusMismatch, ErrKeyMismatch
+	}
+
+	status = StatusVerified
+
+	if v.pinned != nil && !bytes.Equal(v.pinned, key) {
+		status = StatusKeyChanged
+	}
+
+	v.pinned = append([]byte(nil), key...)
+
+	return status, nil
+}
+
+// Monitor checks that the log still binds the Verifier's identity to the owner's key,
+// detecting a key published on the owner's behalf without their knowledge. Key owners
+// should call it periodically, with a Verifier for their own identity.
+func (v *Verifier) Monitor(ownKey []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, _, err := v.lookup()
+
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(entry.Key, ownKey) {
+		return ErrKeyMismatch
+	}
+
+	return nil
+}
+
+// Head returns the last tree head the Verifier accepted, for the application to save and
+// pass back as TrustedHead, or to compare with other clients.
+func (v *Verifier) Head() TreeHead {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.current()
+}
+
+// current returns the last accepted tree head, or TrustedHead before the first. The
+// caller must hold the lock.
+func (v *Verifier) current() TreeHead {
+	if v.head.Root == nil {
+		return v.TrustedHead
+	}
+
+	return v.head
+}
+
+// lookup fetches and verifies the entry of the Verifier's identity, and accepts its tree
+// head if it extends the last accepted one. The caller must hold the lock.
+func (v *Verifier) lookup() (Entry, Status, error) {
+	entry, err := v.Log.Lookup(v.Identity)
+
+	if err != nil {
+		return Entry{}, StatusUnavailable, err
+	}
+
+	if entry.Identity != v.Identity {
+		return Entry{}, StatusMismatch, ErrKeyMismatch
+	}
+
+	if err := entry.Verify(v.LogKey); err != nil {
+		return Entry{}, StatusMismatch, err
+	}
+
+	prev := v.current()
+
+	if prev.Root != nil && entry.Head.TreeSize > prev.TreeSize {
+		proof, err := v.Log.Consistency(prev.TreeSize, entry.Head.TreeSize)
+
+		if err != nil {
+			return Entry{}, StatusUnavailable, err
+		}
+
+		if !verifyConsistency(prev.TreeSize, entry.Head.TreeSize, prev.Root, entry.Head.Root, proof) {
+			return Entry{}, StatusMismatch, ErrInconsistentLog
+		}
+	} else if prev.Root != nil && (entry.Head.TreeSize < prev.TreeSize || !bytes.Equal(entry.Head.Root, prev.Root)) {
+		return Entry{}, StatusMismatch, ErrInconsistentLog
+	}
+
+	v.head = entry.Head
+
+	return entry, StatusVerified, nil
+}
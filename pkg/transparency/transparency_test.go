@@ -0,0 +1,178 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// TestInclusionProofs verifies that every entry of logs of various sizes carries a valid
+// inclusion proof and tree head signature, and that tampering with the key, the proof or
+// the tree head is detected.
+func TestInclusionProofs(t *testing.T) {
+	log := NewMemoryLog()
+	logKey := log.PublicKey()
+
+	for size := 1; size <= 17; size++ {
+		if _, err := log.Publish(string(rune('a'+size)), []byte{byte(size)}); err != nil {
+			t.Fatal(err)
+		}
+
+		for index := range size {
+			entry := log.entry(index)
+
+			if err := entry.Verify(logKey); err != nil {
+				t.Fatalf("Entry %d of %d: %v", index, size, err)
+			}
+
+			tampered := entry
+			tampered.Key = []byte{0xFF}
+
+			if tampered.Verify(logKey) == nil {
+				t.Fatalf("Entry %d of %d: tampered key verified", index, size)
+			}
+
+			tampered = entry
+			tampered.Head.TreeSize++
+
+			if !errors.Is(tampered.Verify(logKey), ErrInvalidTreeHead) {
+				t.Fatalf("Entry %d of %d: tampered tree head verified", index, size)
+			}
+
+			if len(entry.Proof) > 0 {
+				entry.Proof[0] = make([]byte, len(entry.Proof[0]))
+
+				if entry.Verify(logKey) == nil {
+					t.Fatalf("Entry %d of %d: tampered proof verified", index, size)
+				}
+			}
+		}
+	}
+}
+
+// TestConsistencyProofs verifies that consistency proofs between every pair of tree sizes
+// up to 17 verify, and that they do not verify against a different earlier root.
+func TestConsistencyProofs(t *testing.T) {
+	var leaves [][]byte
+
+	for i := range 17 {
+		leaves = append(leaves, leafHash([]byte{byte(i)}))
+	}
+
+	for n := 1; n <= len(leaves); n++ {
+		for m := 0; m <= n; m++ {
+			proof := consistencyProof(m, leaves[:n])
+			first, second := rootHash(leaves[:m]), rootHash(leaves[:n])
+
+			if !verifyConsistency(uint64(m), uint64(n), first, second, proof) {
+				t.Fatalf("Proof from %d to %d did not verify", m, n)
+			}
+
+			if m > 0 && m < n && verifyConsistency(uint64(m), uint64(n), leafHash([]byte("forged")), second, proof) {
+				t.Fatalf("Proof from %d to %d verified against a forged root", m, n)
+			}
+		}
+	}
+}
+
+// TestVerifierStatus verifies that the Verifier reports verified, changed and mismatched
+// keys, and an unavailable status for unknown identities.
+func TestVerifierStatus(t *testing.T) {
+	log := NewMemoryLog()
+	v := &Verifier{Log: log, LogKey: log.PublicKey(), Identity: "bob"}
+
+	if status, _ := v.Verify([]byte("key1")); status != StatusUnavailable {
+		t.Errorf("Expected %v, got %v", StatusUnavailable, status)
+	}
+
+	_, _ = log.Publish("bob", []byte("key1"))
+
+	if status, err := v.Verify([]byte("key1")); status != StatusVerified || err != nil {
+		t.Errorf("Expected %v, got %v (%v)", StatusVerified, status, err)
+	}
+
+	if status, err := v.Verify([]byte("rogue")); status != StatusMismatch || !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected %v, got %v (%v)", StatusMismatch, status, err)
+	}
+
+	_, _ = log.Publish("alice", []byte("alice-key"))
+	_, _ = log.Publish("bob", []byte("key2"))
+
+	if status, err := v.Verify([]byte("key2")); status != StatusKeyChanged {
+		t.Errorf("Expected %v, got %v (%v)", StatusKeyChanged, status, err)
+	}
+
+	if head := v.Head(); head.TreeSize != 3 {
+		t.Errorf("Expected the Verifier to accept the tree head of size 3, got %d", head.TreeSize)
+	}
+}
+
+// TestVerifierRejectsForgedLog verifies that a log serving a tree it did not sign, or a
+// signed tree that does not extend the last accepted one, such as a one-leaf tree binding
+// the identity to a key of the log's choice, fails verification.
+func TestVerifierRejectsForgedLog(t *testing.T) {
+	honest := NewMemoryLog()
+
+	_, _ = honest.Publish("bob", []byte("bob-key"))
+	_, _ = honest.Publish("carol", []byte("carol-key"))
+
+	v := &Verifier{Log: honest, LogKey: honest.PublicKey(), Identity: "bob"}
+
+	if status, err := v.Verify([]byte("bob-key")); status != StatusVerified {
+		t.Fatalf("Expected %v, got %v (%v)", StatusVerified, status, err)
+	}
+
+	unsigned := NewMemoryLog()
+	_, _ = unsigned.Publish("bob", []byte("rogue"))
+
+	if status, err := (&Verifier{Log: unsigned, LogKey: honest.PublicKey(), Identity: "bob"}).Verify([]byte("rogue")); status != StatusMismatch || !errors.Is(err, ErrInvalidTreeHead) {
+		t.Errorf("Expected ErrInvalidTreeHead, got %v (%v)", status, err)
+	}
+
+	for _, size := range []int{1, 2, 4} {
+		forked := NewMemoryLog()
+		forked.key = honest.key
+
+		for i := 1; i < size; i++ {
+			_, _ = forked.Publish("filler", []byte{byte(i)})
+		}
+
+		_, _ = forked.Publish("bob", []byte("rogue"))
+
+		v.Log = forked
+
+		if status, err := v.Verify([]byte("rogue")); status != StatusMismatch || !errors.Is(err, ErrInconsistentLog) {
+			t.Errorf("Forked tree of size %d: expected ErrInconsistentLog, got %v (%v)", size, status, err)
+		}
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	if status, err := (&Verifier{Log: honest, Identity: "bob"}).Verify([]byte("bob-key")); status != StatusMismatch || !errors.Is(err, ErrNoLogKey) {
+		t.Errorf("Expected ErrNoLogKey, got %v (%v)", status, err)
+	}
+
+	if status, err := (&Verifier{Log: honest, LogKey: other, Identity: "bob"}).Verify([]byte("bob-key")); status != StatusMismatch {
+		t.Errorf("Expected %v with the wrong log key, got %v (%v)", StatusMismatch, status, err)
+	}
+}
+
+// TestMonitorDetectsRogueKey verifies that a key owner monitoring the log notices a key
+// published on their behalf.
+func TestMonitorDetectsRogueKey(t *testing.T) {
+	log := NewMemoryLog()
+	v := &Verifier{Log: log, LogKey: log.PublicKey(), Identity: "alice"}
+
+	_, _ = log.Publish("alice", []byte("alice-key"))
+
+	if err := v.Monitor([]byte("alice-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = log.Publish("alice", []byte("server-key"))
+
+	if err := v.Monitor([]byte("alice-key")); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+}